@@ -2,79 +2,137 @@ package ast
 
 import (
 	"github.com/ruistola/cooper/lexer"
+	"strings"
 )
 
+// Node is any node of the AST: a statement, an expression, a type expression, or one of the
+// helper nodes (like TypedIdent) that only appear as a part of those.
+// Position returns the location in the source of the first token of the node.
+type Node interface {
+	Position() lexer.SrcPos
+}
+
 type TypeExpr interface {
+	Node
 	typeExpr()
 }
 
 type Expr interface {
+	Node
 	expr()
 }
 
 type Stmt interface {
+	Node
 	stmt()
 }
 
 type NamedTypeExpr struct {
+	Pos      lexer.SrcPos
 	TypeName string
 }
 
 func (t *NamedTypeExpr) typeExpr() {}
 
+func (t *NamedTypeExpr) Position() lexer.SrcPos { return t.Pos }
+
 type ArrayTypeExpr struct {
+	Pos            lexer.SrcPos
 	UnderlyingType TypeExpr
+	Size           Expr // The constant number of elements, e.g. 4 in i32[4], or nil for a dynamic array
 }
 
 func (t *ArrayTypeExpr) typeExpr() {}
 
+func (t *ArrayTypeExpr) Position() lexer.SrcPos { return t.Pos }
+
 type FuncTypeExpr struct {
+	Pos        lexer.SrcPos
 	ReturnType TypeExpr
 	ParamTypes []TypeExpr
 }
 
 func (t *FuncTypeExpr) typeExpr() {}
 
-type UnitTypeExpr struct{}
+func (t *FuncTypeExpr) Position() lexer.SrcPos { return t.Pos }
+
+// TupleTypeExpr is the type of a tuple of two or more values, e.g. `(i32, bool)`.
+type TupleTypeExpr struct {
+	Pos       lexer.SrcPos
+	ElemTypes []TypeExpr
+}
+
+func (t *TupleTypeExpr) typeExpr() {}
+
+func (t *TupleTypeExpr) Position() lexer.SrcPos { return t.Pos }
+
+type UnitTypeExpr struct {
+	Pos lexer.SrcPos
+}
 
 func (t *UnitTypeExpr) typeExpr() {}
 
-type UnitExpr struct{}
+func (t *UnitTypeExpr) Position() lexer.SrcPos { return t.Pos }
+
+type UnitExpr struct {
+	Pos lexer.SrcPos
+}
 
 func (e *UnitExpr) expr() {}
 
+func (e *UnitExpr) Position() lexer.SrcPos { return e.Pos }
+
 type BoolLiteralExpr struct {
+	Pos   lexer.SrcPos
 	Value bool
 }
 
 func (e *BoolLiteralExpr) expr() {}
 
+func (e *BoolLiteralExpr) Position() lexer.SrcPos { return e.Pos }
+
 type StringLiteralExpr struct {
+	Pos   lexer.SrcPos
 	Value string
 }
 
 func (e *StringLiteralExpr) expr() {}
 
+func (e *StringLiteralExpr) Position() lexer.SrcPos { return e.Pos }
+
 type IdentExpr struct {
+	Pos   lexer.SrcPos
 	Value string
 }
 
 func (e *IdentExpr) expr() {}
 
+func (e *IdentExpr) Position() lexer.SrcPos { return e.Pos }
+
 type NumberLiteralExpr struct {
+	Pos   lexer.SrcPos
 	Value string
 }
 
 func (e *NumberLiteralExpr) expr() {}
 
+func (e *NumberLiteralExpr) Position() lexer.SrcPos { return e.Pos }
+
 type UnaryExpr struct {
+	Pos      lexer.SrcPos
 	Operator lexer.Token
 	Rhs      Expr
 }
 
 func (e *UnaryExpr) expr() {}
 
+func (e *UnaryExpr) Position() lexer.SrcPos { return e.Pos }
+
+// BinaryExpr is an operation on two operands. The logical operators `and` and `or` short-circuit: the right
+// hand side is evaluated only if the left hand side doesn't determine the result, e.g. `b` isn't evaluated in
+// `a and b` if `a` is false.
 type BinaryExpr struct {
+	Pos      lexer.SrcPos
 	Lhs      Expr
 	Operator lexer.Token
 	Rhs      Expr
@@ -82,45 +140,116 @@ type BinaryExpr struct {
 
 func (e *BinaryExpr) expr() {}
 
+func (e *BinaryExpr) Position() lexer.SrcPos { return e.Pos }
+
 type BlockExpr struct {
+	Pos        lexer.SrcPos
 	Statements []Stmt
 	ResultExpr Expr
 }
 
 func (e *BlockExpr) expr() {}
 
+func (e *BlockExpr) Position() lexer.SrcPos { return e.Pos }
+
 type BlockStmt struct {
+	Pos        lexer.SrcPos
 	Statements []Stmt
 }
 
 func (s *BlockStmt) stmt() {}
 
+func (s *BlockStmt) Position() lexer.SrcPos { return s.Pos }
+
 type ExpressionStmt struct {
+	Pos               lexer.SrcPos
 	Expr              Expr
 	ExplicitSemicolon bool
 }
 
 func (s *ExpressionStmt) stmt() {}
 
+func (s *ExpressionStmt) Position() lexer.SrcPos { return s.Pos }
+
 type GroupExpr struct {
+	Pos  lexer.SrcPos
 	Expr Expr
 }
 
 func (e *GroupExpr) expr() {}
 
+func (e *GroupExpr) Position() lexer.SrcPos { return e.Pos }
+
+// AscriptionExpr asserts the type of a parenthesized expression, e.g. `(5: i64)`, without converting it.
+type AscriptionExpr struct {
+	Pos  lexer.SrcPos
+	Expr Expr
+	Type TypeExpr
+}
+
+func (e *AscriptionExpr) expr() {}
+
+func (e *AscriptionExpr) Position() lexer.SrcPos { return e.Pos }
+
+// CastExpr converts a value to another type, e.g. `n as i8`.
+type CastExpr struct {
+	Pos        lexer.SrcPos
+	Expr       Expr
+	TargetType TypeExpr
+}
+
+func (e *CastExpr) expr() {}
+
+func (e *CastExpr) Position() lexer.SrcPos { return e.Pos }
+
+// TupleExpr is a parenthesized, comma separated list of two or more values, e.g. `(q, r)`.
+type TupleExpr struct {
+	Pos   lexer.SrcPos
+	Elems []Expr
+}
+
+func (e *TupleExpr) expr() {}
+
+func (e *TupleExpr) Position() lexer.SrcPos { return e.Pos }
+
 type VarDeclStmt struct {
+	Pos     lexer.SrcPos
 	Var     TypedIdent
 	InitVal Expr
+	Const   bool // Declared with the const keyword, so the variable can't be assigned to
 }
 
 func (s *VarDeclStmt) stmt() {}
 
+func (s *VarDeclStmt) Position() lexer.SrcPos { return s.Pos }
+
+// DestructuringVarDeclStmt declares a variable for each element of a tuple, e.g. `let (q, r) = divmod(a, b)`.
+type DestructuringVarDeclStmt struct {
+	Pos     lexer.SrcPos
+	Vars    []*TypedIdent
+	InitVal Expr
+	Const   bool // Declared with the const keyword, so none of the variables can be assigned to
+}
+
+func (s *DestructuringVarDeclStmt) stmt() {}
+
+func (s *DestructuringVarDeclStmt) Position() lexer.SrcPos { return s.Pos }
+
 type TypedIdent struct {
-	Name string
-	Type TypeExpr
+	Pos      lexer.SrcPos
+	Name     string
+	Type     TypeExpr
+	Default  Expr // The default value of a function parameter, nil for a parameter without one
+	Variadic bool // A variadic function parameter, e.g. `xs: i32...`, takes any number of trailing arguments
 }
 
+func (t *TypedIdent) Position() lexer.SrcPos { return t.Pos }
+
+// FuncDeclStmt declares a function, or a method of a struct if it has a receiver, e.g.
+// `func (self: Point) length(): f64 { ... }`. Receiver is nil for a plain function.
 type FuncDeclStmt struct {
+	Pos        lexer.SrcPos
+	Receiver   *TypedIdent
 	Name       string
 	Parameters []*TypedIdent
 	ReturnType TypeExpr
@@ -129,42 +258,84 @@ type FuncDeclStmt struct {
 
 func (s *FuncDeclStmt) stmt() {}
 
+func (s *FuncDeclStmt) Position() lexer.SrcPos { return s.Pos }
+
 type FuncCallExpr struct {
-	Func Expr
-	Args []Expr
+	Pos        lexer.SrcPos
+	Func       Expr
+	Args       []Expr
+	CloseParen lexer.SrcPos // Position of the closing parenthesis of the argument list
 }
 
 func (e *FuncCallExpr) expr() {}
 
+func (e *FuncCallExpr) Position() lexer.SrcPos { return e.Pos }
+
 type StructDeclStmt struct {
+	Pos     lexer.SrcPos
 	Name    string
 	Members []*TypedIdent
 }
 
 func (s *StructDeclStmt) stmt() {}
 
+func (s *StructDeclStmt) Position() lexer.SrcPos { return s.Pos }
+
+// EnumDeclStmt declares an enum type with the named variants as its only values.
+type EnumDeclStmt struct {
+	Pos      lexer.SrcPos
+	Name     string
+	Variants []string
+}
+
+func (s *EnumDeclStmt) stmt() {}
+
+func (s *EnumDeclStmt) Position() lexer.SrcPos { return s.Pos }
+
+// InterfaceDeclStmt declares a set of method signatures. Each method is a TypedIdent
+// with the method name, and a FuncTypeExpr as its type.
+type InterfaceDeclStmt struct {
+	Pos     lexer.SrcPos
+	Name    string
+	Methods []*TypedIdent
+}
+
+func (s *InterfaceDeclStmt) stmt() {}
+
+func (s *InterfaceDeclStmt) Position() lexer.SrcPos { return s.Pos }
+
 type StructLiteralExpr struct {
+	Pos     lexer.SrcPos
 	Struct  Expr
 	Members []*MemberAssignExpr
 }
 
 func (e *StructLiteralExpr) expr() {}
 
+func (e *StructLiteralExpr) Position() lexer.SrcPos { return e.Pos }
+
 type StructMemberExpr struct {
+	Pos    lexer.SrcPos
 	Struct Expr
 	Member *IdentExpr
 }
 
 func (e *StructMemberExpr) expr() {}
 
+func (e *StructMemberExpr) Position() lexer.SrcPos { return e.Pos }
+
 type ArrayIndexExpr struct {
+	Pos   lexer.SrcPos
 	Array Expr
 	Index Expr
 }
 
 func (e *ArrayIndexExpr) expr() {}
 
+func (e *ArrayIndexExpr) Position() lexer.SrcPos { return e.Pos }
+
 type IfExpr struct {
+	Pos  lexer.SrcPos
 	Cond Expr
 	Then Expr
 	Else Expr
@@ -172,7 +343,10 @@ type IfExpr struct {
 
 func (e *IfExpr) expr() {}
 
+func (e *IfExpr) Position() lexer.SrcPos { return e.Pos }
+
 type IfStmt struct {
+	Pos  lexer.SrcPos
 	Cond Expr
 	Then Stmt
 	Else Stmt
@@ -180,7 +354,14 @@ type IfStmt struct {
 
 func (s *IfStmt) stmt() {}
 
+func (s *IfStmt) Position() lexer.SrcPos { return s.Pos }
+
+// ForStmt is a C-style loop. Init is executed once, before anything else. Cond is evaluated before each
+// iteration, including the first one, and the loop ends as soon as it evaluates to false. Iter is evaluated
+// after each execution of Body, before the condition is evaluated again. Side effects in Cond and Iter
+// (like function calls) therefore happen once per iteration, in that order, and backends must preserve it.
 type ForStmt struct {
+	Pos  lexer.SrcPos
 	Init Stmt
 	Cond Expr
 	Iter *ExpressionStmt
@@ -189,7 +370,63 @@ type ForStmt struct {
 
 func (s *ForStmt) stmt() {}
 
+func (s *ForStmt) Position() lexer.SrcPos { return s.Pos }
+
+// LoopExpr repeats its body until a break statement within it ends the loop. The value of the break
+// statement becomes the value of the loop expression, e.g. `loop { break 5 }` evaluates to 5.
+type LoopExpr struct {
+	Pos  lexer.SrcPos
+	Body *BlockStmt
+}
+
+func (e *LoopExpr) expr() {}
+
+func (e *LoopExpr) Position() lexer.SrcPos { return e.Pos }
+
+// MatchExpr evaluates the body of the first arm whose pattern matches the subject, e.g.
+// `match n { 0 => "zero", _ => "other", }`.
+type MatchExpr struct {
+	Pos     lexer.SrcPos
+	Subject Expr
+	Arms    []*MatchArm
+}
+
+func (e *MatchExpr) expr() {}
+
+func (e *MatchExpr) Position() lexer.SrcPos { return e.Pos }
+
+// MatchArm is a single `pattern => body` arm of a match expression. The pattern is a literal, a
+// WildcardExpr, or an IdentExpr that binds the subject to a new variable within the body.
+type MatchArm struct {
+	Pos     lexer.SrcPos
+	Pattern Expr
+	Body    Expr
+}
+
+func (e *MatchArm) Position() lexer.SrcPos { return e.Pos }
+
+// WildcardExpr is the `_` pattern, which matches any value.
+type WildcardExpr struct {
+	Pos lexer.SrcPos
+}
+
+func (e *WildcardExpr) expr() {}
+
+func (e *WildcardExpr) Position() lexer.SrcPos { return e.Pos }
+
+// BreakStmt ends the innermost loop. Value is nil for a break without a value, which is the only kind
+// allowed in a for- loop.
+type BreakStmt struct {
+	Pos   lexer.SrcPos
+	Value Expr
+}
+
+func (s *BreakStmt) stmt() {}
+
+func (s *BreakStmt) Position() lexer.SrcPos { return s.Pos }
+
 type AssignExpr struct {
+	Pos           lexer.SrcPos
 	Assigne       Expr
 	Operator      lexer.Token
 	AssignedValue Expr
@@ -197,35 +434,65 @@ type AssignExpr struct {
 
 func (e *AssignExpr) expr() {}
 
+func (e *AssignExpr) Position() lexer.SrcPos { return e.Pos }
+
 type MemberAssignExpr struct {
+	Pos   lexer.SrcPos
 	Name  string
 	Value Expr
 }
 
 func (e *MemberAssignExpr) expr() {}
 
+func (e *MemberAssignExpr) Position() lexer.SrcPos { return e.Pos }
+
 type VarDeclAssignExpr struct {
+	Pos           lexer.SrcPos
 	Name          string
 	AssignedValue Expr
 }
 
 func (e *VarDeclAssignExpr) expr() {}
 
+func (e *VarDeclAssignExpr) Position() lexer.SrcPos { return e.Pos }
+
 type ReturnStmt struct {
+	Pos  lexer.SrcPos
 	Expr Expr
 }
 
 func (s *ReturnStmt) stmt() {}
 
+func (s *ReturnStmt) Position() lexer.SrcPos { return s.Pos }
+
 type UseDeclStmt struct {
+	Pos      lexer.SrcPos
 	UseSpecs []*UseSpecExpr
 }
 
 func (s *UseDeclStmt) stmt() {}
 
+func (s *UseDeclStmt) Position() lexer.SrcPos { return s.Pos }
+
+// UseSpecExpr binds the alias Name to a used module, e.g. `io: "std/io"`.
 type UseSpecExpr struct {
+	Pos    lexer.SrcPos
 	Name   string
-	Module string //TODO: should this be more structural, like a ModulePath or something?
+	Module ModulePath
 }
 
 func (e *UseSpecExpr) expr() {}
+
+func (e *UseSpecExpr) Position() lexer.SrcPos { return e.Pos }
+
+// ModulePath names a module by the segments of its path, e.g. `"std/io"` by std and io. A module may also
+// be named by a bare identifier, which is a path of a single segment.
+type ModulePath struct {
+	Segments []string
+	Quoted   bool // Whether the path was written as a string literal
+}
+
+// String joins the segments of the path with slashes, e.g. std/io.
+func (p ModulePath) String() string {
+	return strings.Join(p.Segments, "/")
+}
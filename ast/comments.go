@@ -0,0 +1,28 @@
+package ast
+
+import (
+	"github.com/ruistola/cooper/lexer"
+	"strings"
+)
+
+// Comments are the comments attached to a statement by the parser: the comments on the lines directly
+// above the statement, like a doc comment, and the comment following the start of the statement on the
+// same line, if there is one.
+type Comments struct {
+	Leading  []lexer.Token
+	Trailing *lexer.Token
+}
+
+// Doc returns the text of the leading comments without the comment markers, one line per comment.
+func (c *Comments) Doc() string {
+	lines := make([]string, len(c.Leading))
+	for i, comment := range c.Leading {
+		text := strings.TrimPrefix(comment.Value, "//")
+		lines[i] = strings.TrimPrefix(text, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CommentMap is a side table of the comments of the statements of an AST, which leaves the comments out.
+// Statements without any comments are not in the map.
+type CommentMap map[Stmt]*Comments
@@ -0,0 +1,110 @@
+package ast
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/lexer"
+	"reflect"
+)
+
+var srcPosType = reflect.TypeFor[lexer.SrcPos]()
+
+// Equal reports whether two ASTs are structurally identical. Source positions are ignored, so that
+// the trees parsed from two differently formatted versions of the same program compare equal.
+// A nil slice is considered equal to an empty one.
+func Equal(a, b Node) bool {
+	return Diff(a, b) == ""
+}
+
+// Diff compares two ASTs like Equal, and describes the first difference found, along with the path
+// to it from the root, e.g. `Statements[2].Expr.Rhs: expected NumberLiteralExpr(5), got NumberLiteralExpr(6)`.
+// Returns an empty string if the ASTs are equal.
+func Diff(expected, actual Node) string {
+	return diffValues("", reflect.ValueOf(expected), reflect.ValueOf(actual))
+}
+
+func difference(path string, format string, args ...any) string {
+	if path == "" {
+		return fmt.Sprintf(format, args...)
+	}
+	return path + ": " + fmt.Sprintf(format, args...)
+}
+
+// describe formats a value for a difference message. Nodes are described by their type, and by their
+// name or value if they have one.
+func describe(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return "nil"
+		}
+		return describe(v.Elem())
+	case reflect.Struct:
+		for _, field := range []string{"Value", "Name", "TypeName"} {
+			if f := v.FieldByName(field); f.IsValid() && f.Kind() != reflect.Interface && f.Kind() != reflect.Pointer {
+				return fmt.Sprintf("%s(%v)", v.Type().Name(), f)
+			}
+		}
+		return v.Type().Name()
+	case reflect.String:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func diffValues(path string, a, b reflect.Value) string {
+	if !a.IsValid() || !b.IsValid() {
+		if a.IsValid() != b.IsValid() {
+			return difference(path, "expected %s, got %s", describe(a), describe(b))
+		}
+		return ""
+	}
+	if a.Type() != b.Type() {
+		return difference(path, "expected %s, got %s", describe(a), describe(b))
+	}
+	switch a.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				return difference(path, "expected %s, got %s", describe(a), describe(b))
+			}
+			return ""
+		}
+		return diffValues(path, a.Elem(), b.Elem())
+	case reflect.Struct:
+		if a.Type() == srcPosType {
+			return ""
+		}
+		if descA, descB := describe(a), describe(b); descA != descB {
+			return difference(path, "expected %s, got %s", descA, descB)
+		}
+		for i := range a.NumField() {
+			fieldPath := a.Type().Field(i).Name
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			if diff := diffValues(fieldPath, a.Field(i), b.Field(i)); diff != "" {
+				return diff
+			}
+		}
+		return ""
+	case reflect.Slice:
+		for i := range min(a.Len(), b.Len()) {
+			if diff := diffValues(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i)); diff != "" {
+				return diff
+			}
+		}
+		if a.Len() != b.Len() {
+			return difference(path, "expected %d elements, got %d", a.Len(), b.Len())
+		}
+		return ""
+	default:
+		if !a.Equal(b) {
+			return difference(path, "expected %s, got %s", describe(a), describe(b))
+		}
+		return ""
+	}
+}
@@ -0,0 +1,64 @@
+package ast_test
+
+import (
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"github.com/ruistola/cooper/parser"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	parse := func(src string) *ast.BlockStmt {
+		return parser.Parse(lexer.Tokenize(src))
+	}
+	testCases := []struct {
+		name     string
+		expected string
+		actual   string
+		diff     string
+	}{
+		{
+			"equal apart from formatting",
+			"let x: i32 = 1 + 2",
+			"let   x:i32=1+2",
+			"",
+		},
+		{
+			"different literal",
+			"a := 1\nb := 2\nc := 4 + 5",
+			"a := 1\nb := 2\nc := 4 + 6",
+			"Statements[2].Expr.AssignedValue.Rhs: expected NumberLiteralExpr(5), got NumberLiteralExpr(6)",
+		},
+		{
+			"different node type",
+			"f(x, y)",
+			"f(x, -y)",
+			"Statements[0].Expr.Args[1]: expected IdentExpr(y), got UnaryExpr",
+		},
+		{
+			"different operator",
+			"x = a + b",
+			"x = a - b",
+			"Statements[0].Expr.AssignedValue.Operator: expected Token(+), got Token(-)",
+		},
+		{
+			"missing statement",
+			"a()\nb()",
+			"a()",
+			"Statements: expected 2 elements, got 1",
+		},
+		{
+			"missing optional child",
+			"let x: i32 = 1",
+			"let x: i32",
+			"Statements[0].InitVal: expected NumberLiteralExpr(1), got nil",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := ast.Diff(parse(tc.expected), parse(tc.actual)); diff != tc.diff {
+				t.Errorf("Expected diff %q, got %q", tc.diff, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ruistola/cooper/lexer"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+var tokenType = reflect.TypeFor[lexer.Token]()
+
+// ToJSON encodes the AST rooted at node as indented JSON, for tools that need a machine-readable parse tree.
+// Each node is an object with its type name as "kind", followed by its fields in declaration order, named in
+// lower camel case, e.g. `{"kind": "IdentExpr", "pos": {...}, "value": "x"}`. Source positions are objects
+// of the line, column and byte offset, and tokens like operators are encoded by the name of their type,
+// e.g. "plus". Missing optional children are null, and missing lists empty.
+func ToJSON(node Node) []byte {
+	var buf bytes.Buffer
+	encodeJSON(&buf, reflect.ValueOf(node))
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buf.Bytes(), "", "  "); err != nil {
+		panic("ast.ToJSON: " + err.Error())
+	}
+	indented.WriteString("\n")
+	return indented.Bytes()
+}
+
+// jsonFieldName converts the name of a node field to lower camel case, e.g. AssignedValue to assignedValue.
+func jsonFieldName(name string) string {
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(first)) + name[size:]
+}
+
+// writeJSON writes a scalar value, which the standard encoder never fails to encode.
+func writeJSON(buf *bytes.Buffer, value any) {
+	encoded, _ := json.Marshal(value)
+	buf.Write(encoded)
+}
+
+func encodeJSON(buf *bytes.Buffer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return
+		}
+		encodeJSON(buf, v.Elem())
+	case reflect.Struct:
+		switch v.Type() {
+		case srcPosType:
+			pos := v.Interface().(lexer.SrcPos)
+			buf.WriteString(`{"line":`)
+			writeJSON(buf, pos.Line)
+			buf.WriteString(`,"column":`)
+			writeJSON(buf, pos.Column)
+			buf.WriteString(`,"offset":`)
+			writeJSON(buf, pos.Offset)
+			buf.WriteString("}")
+			return
+		case tokenType:
+			writeJSON(buf, v.Interface().(lexer.Token).Type.String())
+			return
+		}
+		buf.WriteString(`{"kind":`)
+		writeJSON(buf, v.Type().Name())
+		for i := range v.NumField() {
+			buf.WriteString(",")
+			writeJSON(buf, jsonFieldName(v.Type().Field(i).Name))
+			buf.WriteString(":")
+			encodeJSON(buf, v.Field(i))
+		}
+		buf.WriteString("}")
+	case reflect.Slice:
+		buf.WriteString("[")
+		for i := range v.Len() {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			encodeJSON(buf, v.Index(i))
+		}
+		buf.WriteString("]")
+	default:
+		writeJSON(buf, v.Interface())
+	}
+}
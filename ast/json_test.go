@@ -0,0 +1,39 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"github.com/ruistola/cooper/parser"
+	"os"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	src := `struct Point {
+  x: i32,
+}
+func norm(p: Point): i32 {
+  if p.x < 0 then return -p.x
+  return p.x
+}
+`
+	encoded := ast.ToJSON(parser.Parse(lexer.Tokenize(src)))
+	if !json.Valid(encoded) {
+		t.Fatalf("Invalid JSON:\n%s", encoded)
+	}
+
+	const goldenPath = "testdata/program.json"
+	if *update {
+		if err := os.WriteFile(goldenPath, encoded, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != string(golden) {
+		t.Errorf("JSON does not match %s:\n%s", goldenPath, encoded)
+	}
+}
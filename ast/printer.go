@@ -0,0 +1,507 @@
+package ast
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/lexer"
+	"strings"
+)
+
+// Print renders the AST rooted at node as canonical Cooper source: one statement per line,
+// blocks indented with tabs, and a trailing comma after every member in struct, interface and
+// use blocks. Parentheses are added where needed to preserve the operator precedence of the tree.
+//
+// A BlockStmt passed as the root node is printed as a module, i.e. its statements without the
+// enclosing curly braces.
+func Print(node Node) string {
+	return Format(node, FormatOptions{})
+}
+
+// FormatOptions adjusts the layout of the source printed by Format.
+type FormatOptions struct {
+	// The number of spaces per indentation level, or 0 for indenting with a tab like Print
+	IndentSpaces int
+}
+
+// Format renders the AST rooted at node like Print, indenting nested blocks as given by the options.
+func Format(node Node, options FormatOptions) string {
+	pr := &printer{indentUnit: "\t"}
+	if options.IndentSpaces > 0 {
+		pr.indentUnit = strings.Repeat(" ", options.IndentSpaces)
+	}
+	if module, ok := node.(*BlockStmt); ok {
+		pr.printModule(module)
+	} else {
+		pr.print(node)
+	}
+	return pr.buf.String()
+}
+
+type printer struct {
+	buf        strings.Builder
+	indent     int
+	indentUnit string // Printed once per indentation level
+}
+
+func (pr *printer) write(format string, args ...any) {
+	fmt.Fprintf(&pr.buf, format, args...)
+}
+
+// newline ends the current line and indents the next one to the current depth.
+func (pr *printer) newline() {
+	pr.buf.WriteString("\n")
+	pr.buf.WriteString(strings.Repeat(pr.indentUnit, pr.indent))
+}
+
+func isDecl(stmt Stmt) bool {
+	switch stmt.(type) {
+	case *FuncDeclStmt, *StructDeclStmt, *EnumDeclStmt, *InterfaceDeclStmt, *UseDeclStmt:
+		return true
+	}
+	return false
+}
+
+// startsWithPrefixOperator reports whether the printed expression begins with a unary plus or minus.
+// On a line of its own, it would continue the expression on the previous line as a binary operator.
+func startsWithPrefixOperator(expr Expr) bool {
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		return true
+	case *BinaryExpr:
+		return startsWithPrefixOperator(e.Lhs)
+	case *CastExpr:
+		return startsWithPrefixOperator(e.Expr)
+	case *FuncCallExpr:
+		return startsWithPrefixOperator(e.Func)
+	case *StructLiteralExpr:
+		return startsWithPrefixOperator(e.Struct)
+	case *StructMemberExpr:
+		return startsWithPrefixOperator(e.Struct)
+	case *ArrayIndexExpr:
+		return startsWithPrefixOperator(e.Array)
+	case *AssignExpr:
+		return startsWithPrefixOperator(e.Assigne)
+	default:
+		return false
+	}
+}
+
+// printStmtSeparator separates an expression statement beginning with a unary plus or minus from the
+// statement before it with an empty statement, as in `;-x`, keeping it from continuing that statement.
+func (pr *printer) printStmtSeparator(stmt Stmt) {
+	if exprStmt, ok := stmt.(*ExpressionStmt); ok && startsWithPrefixOperator(exprStmt.Expr) {
+		pr.write(";")
+	}
+}
+
+// printModule prints top-level statements, separating declarations from their neighbours with an empty line.
+func (pr *printer) printModule(module *BlockStmt) {
+	for i, stmt := range module.Statements {
+		if i > 0 && (isDecl(stmt) || isDecl(module.Statements[i-1])) {
+			pr.write("\n")
+		}
+		if i > 0 {
+			pr.printStmtSeparator(stmt)
+		}
+		pr.print(stmt)
+		pr.write("\n")
+	}
+}
+
+// printBlock prints the statements (and the optional result expression) of a block within curly braces.
+func (pr *printer) printBlock(statements []Stmt, result Expr) {
+	if _, isUnit := result.(*UnitExpr); isUnit {
+		result = nil
+	}
+	if len(statements) == 0 && result == nil {
+		pr.write("{}")
+		return
+	}
+	pr.write("{")
+	pr.indent++
+	for i, stmt := range statements {
+		pr.newline()
+		if i > 0 {
+			pr.printStmtSeparator(stmt)
+		}
+		pr.print(stmt)
+	}
+	if result != nil {
+		pr.newline()
+		if len(statements) > 0 && startsWithPrefixOperator(result) {
+			pr.write(";")
+		}
+		pr.print(result)
+	}
+	pr.indent--
+	pr.newline()
+	pr.write("}")
+}
+
+// printMembers prints the members of a struct, interface or use block, or the arms of a match,
+// each on its own line with a trailing comma.
+func (pr *printer) printMembers(count int, printMember func(i int)) {
+	if count == 0 {
+		pr.write("{}")
+		return
+	}
+	pr.write("{")
+	pr.indent++
+	for i := range count {
+		pr.newline()
+		printMember(i)
+		pr.write(",")
+	}
+	pr.indent--
+	pr.newline()
+	pr.write("}")
+}
+
+// declKeyword returns the keyword of a variable declaration.
+func declKeyword(isConst bool) string {
+	if isConst {
+		return "const"
+	}
+	return "let"
+}
+
+func printList[T Node](pr *printer, nodes []T) {
+	for i, node := range nodes {
+		if i > 0 {
+			pr.write(", ")
+		}
+		pr.print(node)
+	}
+}
+
+// printSignature prints the parameter types and the return type of a function type,
+// i.e. everything following the `func` keyword or an interface method name.
+func (pr *printer) printSignature(funcType *FuncTypeExpr) {
+	pr.write("(")
+	printList(pr, funcType.ParamTypes)
+	pr.write(")")
+	if _, isUnit := funcType.ReturnType.(*UnitTypeExpr); funcType.ReturnType != nil && !isUnit {
+		pr.write(": ")
+		pr.print(funcType.ReturnType)
+	}
+}
+
+// Binding powers of the operators, mirroring the left binding powers in the parser.
+// An operand binding less tightly than its operator must be enclosed in parentheses.
+const (
+	lowestPrecedence  = 0 // if- expressions extend as far right as possible
+	assignPrecedence  = 2
+	unaryPrecedence   = 11
+	castPrecedence    = 14
+	postfixPrecedence = 17
+	primaryPrecedence = 19
+)
+
+func binaryPrecedence(tokenType lexer.TokenType) int {
+	switch tokenType {
+	case lexer.OR, lexer.AND:
+		return 4
+	case lexer.DOUBLE_EQUALS, lexer.NOT_EQUALS:
+		return 6
+	case lexer.LESS, lexer.LESS_EQUALS, lexer.GREATER, lexer.GREATER_EQUALS:
+		return 8
+	case lexer.PLUS, lexer.DASH:
+		return 10
+	case lexer.STAR, lexer.SLASH, lexer.PERCENT:
+		return 12
+	case lexer.CHEVRON:
+		return 15
+	default:
+		panic(fmt.Sprintf("ast.Print: unexpected binary operator %s", tokenType))
+	}
+}
+
+func precedence(expr Expr) int {
+	switch e := expr.(type) {
+	case *BinaryExpr:
+		return binaryPrecedence(e.Operator.Type)
+	case *UnaryExpr:
+		return unaryPrecedence
+	case *CastExpr:
+		return castPrecedence
+	case *AssignExpr, *VarDeclAssignExpr:
+		return assignPrecedence
+	case *IfExpr:
+		return lowestPrecedence
+	case *FuncCallExpr, *StructLiteralExpr, *StructMemberExpr, *ArrayIndexExpr:
+		return postfixPrecedence
+	default:
+		return primaryPrecedence
+	}
+}
+
+// endsWithPrefixOperand reports whether the printed expression ends with the operand of a prefix operator
+// binding less tightly than the given precedence, i.e. one that would capture an operator printed after it.
+func endsWithPrefixOperand(expr Expr, prec int) bool {
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		return unaryPrecedence < prec || endsWithPrefixOperand(e.Rhs, prec)
+	case *BinaryExpr:
+		return endsWithPrefixOperand(e.Rhs, prec)
+	default:
+		return false
+	}
+}
+
+// printOperand prints an operand of an operator of the given precedence, in parentheses if needed.
+// Binary operators are left associative (except for exponentiation), so an operand of equal precedence
+// on the side the operator doesn't associate to also needs them. A prefix operator on the right hand side
+// never does, as it can't capture anything to its left, but a left hand side ending in one might need them
+// to keep it from capturing the operator. A cast on the left hand side never needs them, as its type ends it.
+func (pr *printer) printOperand(operand Expr, prec int, isRhs bool) {
+	_, isUnary := operand.(*UnaryExpr)
+	operandPrec := precedence(operand)
+	isRightAssoc := prec == binaryPrecedence(lexer.CHEVRON)
+	needsParens := operandPrec < prec || (isRhs != isRightAssoc && operandPrec == prec)
+	_, isCast := operand.(*CastExpr)
+	if isRhs {
+		needsParens = needsParens && !isUnary
+	} else {
+		needsParens = (needsParens && !isCast) || endsWithPrefixOperand(operand, prec)
+	}
+	if needsParens {
+		pr.write("(")
+		pr.print(operand)
+		pr.write(")")
+	} else {
+		pr.print(operand)
+	}
+}
+
+func (pr *printer) print(node Node) {
+	switch n := node.(type) {
+	// Type expressions
+	case *NamedTypeExpr:
+		pr.write("%s", n.TypeName)
+	case *ArrayTypeExpr:
+		if _, isFunc := n.UnderlyingType.(*FuncTypeExpr); isFunc {
+			pr.write("(")
+			pr.print(n.UnderlyingType)
+			pr.write(")")
+		} else {
+			pr.print(n.UnderlyingType)
+		}
+		pr.write("[")
+		if n.Size != nil {
+			pr.print(n.Size)
+		}
+		pr.write("]")
+	case *FuncTypeExpr:
+		pr.write("func")
+		pr.printSignature(n)
+	case *TupleTypeExpr:
+		pr.write("(")
+		printList(pr, n.ElemTypes)
+		pr.write(")")
+	case *UnitTypeExpr:
+		pr.write("()")
+
+	// Expressions
+	case *UnitExpr:
+		pr.write("()")
+	case *BoolLiteralExpr:
+		pr.write("%t", n.Value)
+	case *StringLiteralExpr:
+		pr.write("%s", n.Value)
+	case *IdentExpr:
+		pr.write("%s", n.Value)
+	case *NumberLiteralExpr:
+		pr.write("%s", n.Value)
+	case *UnaryExpr:
+		pr.write("%s", n.Operator.Value)
+		pr.printOperand(n.Rhs, unaryPrecedence, true)
+	case *BinaryExpr:
+		prec := binaryPrecedence(n.Operator.Type)
+		pr.printOperand(n.Lhs, prec, false)
+		pr.write(" %s ", n.Operator.Value)
+		pr.printOperand(n.Rhs, prec, true)
+	case *BlockExpr:
+		pr.printBlock(n.Statements, n.ResultExpr)
+	case *GroupExpr:
+		pr.write("(")
+		pr.print(n.Expr)
+		pr.write(")")
+	case *AscriptionExpr:
+		pr.write("(")
+		pr.print(n.Expr)
+		pr.write(": ")
+		pr.print(n.Type)
+		pr.write(")")
+	case *CastExpr:
+		pr.printOperand(n.Expr, castPrecedence, false)
+		pr.write(" as ")
+		pr.print(n.TargetType)
+	case *TupleExpr:
+		pr.write("(")
+		printList(pr, n.Elems)
+		pr.write(")")
+	case *FuncCallExpr:
+		pr.printOperand(n.Func, postfixPrecedence, false)
+		pr.write("(")
+		printList(pr, n.Args)
+		pr.write(")")
+	case *StructLiteralExpr:
+		pr.printOperand(n.Struct, postfixPrecedence, false)
+		pr.printMembers(len(n.Members), func(i int) { pr.print(n.Members[i]) })
+	case *StructMemberExpr:
+		pr.printOperand(n.Struct, postfixPrecedence, false)
+		// A dot right after an integer literal would be lexed as its decimal point
+		if _, isNumber := n.Struct.(*NumberLiteralExpr); isNumber {
+			pr.write(" ")
+		}
+		pr.write(".%s", n.Member.Value)
+	case *ArrayIndexExpr:
+		pr.printOperand(n.Array, postfixPrecedence, false)
+		pr.write("[")
+		pr.print(n.Index)
+		pr.write("]")
+	case *IfExpr:
+		pr.write("if ")
+		pr.print(n.Cond)
+		pr.write(" then ")
+		pr.print(n.Then)
+		pr.write(" else ")
+		pr.print(n.Else)
+	case *LoopExpr:
+		pr.write("loop ")
+		pr.print(n.Body)
+	case *MatchExpr:
+		pr.write("match ")
+		pr.print(n.Subject)
+		pr.write(" ")
+		pr.printMembers(len(n.Arms), func(i int) { pr.print(n.Arms[i]) })
+	case *MatchArm:
+		pr.print(n.Pattern)
+		pr.write(" => ")
+		pr.print(n.Body)
+	case *WildcardExpr:
+		pr.write("_")
+	case *AssignExpr:
+		// Assignment is right associative, so an assignment as the assignee needs parentheses
+		pr.printOperand(n.Assigne, assignPrecedence+1, false)
+		pr.write(" %s ", n.Operator.Value)
+		pr.print(n.AssignedValue)
+	case *MemberAssignExpr:
+		pr.write("%s: ", n.Name)
+		pr.print(n.Value)
+	case *VarDeclAssignExpr:
+		pr.write("%s := ", n.Name)
+		pr.print(n.AssignedValue)
+	case *UseSpecExpr:
+		if n.Module.Quoted {
+			pr.write("%s: %q", n.Name, n.Module)
+		} else {
+			pr.write("%s: %s", n.Name, n.Module)
+		}
+
+	// Statements
+	case *BlockStmt:
+		pr.printBlock(n.Statements, nil)
+	case *ExpressionStmt:
+		pr.print(n.Expr)
+		if n.ExplicitSemicolon {
+			pr.write(";")
+		}
+	case *VarDeclStmt:
+		pr.write("%s ", declKeyword(n.Const))
+		pr.print(&n.Var)
+		if n.InitVal != nil {
+			pr.write(" = ")
+			pr.print(n.InitVal)
+		}
+	case *DestructuringVarDeclStmt:
+		pr.write("%s (", declKeyword(n.Const))
+		printList(pr, n.Vars)
+		pr.write(") = ")
+		pr.print(n.InitVal)
+	case *FuncDeclStmt:
+		pr.write("func ")
+		if n.Receiver != nil {
+			pr.write("(")
+			pr.print(n.Receiver)
+			pr.write(") ")
+		}
+		pr.write("%s(", n.Name)
+		printList(pr, n.Parameters)
+		pr.write(")")
+		if n.ReturnType != nil {
+			pr.write(": ")
+			pr.print(n.ReturnType)
+		}
+		pr.write(" ")
+		pr.print(n.Body)
+	case *StructDeclStmt:
+		pr.write("struct %s ", n.Name)
+		pr.printMembers(len(n.Members), func(i int) { pr.print(n.Members[i]) })
+	case *EnumDeclStmt:
+		pr.write("enum %s ", n.Name)
+		pr.printMembers(len(n.Variants), func(i int) { pr.write("%s", n.Variants[i]) })
+	case *InterfaceDeclStmt:
+		pr.write("interface %s ", n.Name)
+		pr.printMembers(len(n.Methods), func(i int) {
+			pr.write("%s", n.Methods[i].Name)
+			pr.printSignature(n.Methods[i].Type.(*FuncTypeExpr))
+		})
+	case *IfStmt:
+		pr.write("if ")
+		pr.print(n.Cond)
+		pr.write(" then ")
+		pr.print(n.Then)
+		if n.Else != nil {
+			pr.write(" else ")
+			pr.print(n.Else)
+		}
+	case *ForStmt:
+		pr.write("for (")
+		// The init statement is always followed by a semicolon, so don't print it twice
+		if exprStmt, ok := n.Init.(*ExpressionStmt); ok {
+			pr.print(exprStmt.Expr)
+		} else {
+			pr.print(n.Init)
+		}
+		pr.write("; ")
+		pr.print(n.Cond)
+		pr.write("; ")
+		pr.print(n.Iter.Expr)
+		pr.write(") ")
+		pr.print(n.Body)
+	case *ReturnStmt:
+		pr.write("return")
+		if n.Expr != nil {
+			pr.write(" ")
+			pr.print(n.Expr)
+		}
+	case *BreakStmt:
+		pr.write("break")
+		if n.Value != nil {
+			pr.write(" ")
+			pr.print(n.Value)
+		}
+	case *UseDeclStmt:
+		pr.write("use ")
+		pr.printMembers(len(n.UseSpecs), func(i int) { pr.print(n.UseSpecs[i]) })
+
+	// Helper nodes
+	case *TypedIdent:
+		if n.Type != nil {
+			pr.write("%s: ", n.Name)
+			pr.print(n.Type)
+		} else {
+			pr.write("%s", n.Name)
+		}
+		if n.Variadic {
+			pr.write("...")
+		}
+		if n.Default != nil {
+			pr.write(" = ")
+			pr.print(n.Default)
+		}
+
+	default:
+		panic(fmt.Sprintf("ast.Print: unexpected node type %T", node))
+	}
+}
@@ -0,0 +1,218 @@
+package ast_test
+
+import (
+	"flag"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"github.com/ruistola/cooper/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Overwrite the golden files with the current output")
+
+// Each testdata/*.coo source is parsed and printed, and the result compared to the matching .golden file.
+// Parsing the printed source again must produce an identical AST, which must print identically.
+func TestPrintGolden(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/*.coo")
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("No test inputs found: %v", err)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".coo")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			module := parser.Parse(lexer.Tokenize(string(src)))
+			printed := ast.Print(module)
+
+			goldenPath := strings.TrimSuffix(input, ".coo") + ".golden"
+			if *update {
+				if err := os.WriteFile(goldenPath, []byte(printed), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			golden, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if printed != string(golden) {
+				t.Errorf("Printed source does not match %s:\n%s", goldenPath, printed)
+			}
+
+			reparsed := parser.Parse(lexer.Tokenize(printed))
+			if diff := ast.Diff(module, reparsed); diff != "" {
+				t.Errorf("AST of the printed source differs from the original: %s", diff)
+			}
+			if reprinted := ast.Print(reparsed); reprinted != printed {
+				t.Errorf("Printing the reparsed AST is not idempotent:\n%s", reprinted)
+			}
+		})
+	}
+}
+
+func ident(name string) ast.Expr {
+	return &ast.IdentExpr{Value: name}
+}
+
+func binary(lhs ast.Expr, tokenType lexer.TokenType, operator string, rhs ast.Expr) ast.Expr {
+	return &ast.BinaryExpr{
+		Lhs:      lhs,
+		Operator: lexer.Token{Type: tokenType, Value: operator},
+		Rhs:      rhs,
+	}
+}
+
+func negate(rhs ast.Expr) ast.Expr {
+	return &ast.UnaryExpr{
+		Operator: lexer.Token{Type: lexer.DASH, Value: "-"},
+		Rhs:      rhs,
+	}
+}
+
+// Trees constructed without GroupExpr nodes get parentheses only where the precedence requires them
+func TestPrintPrecedence(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expr     ast.Expr
+		expected string
+	}{
+		{
+			"lower precedence operand",
+			binary(binary(ident("a"), lexer.PLUS, "+", ident("b")), lexer.STAR, "*", ident("c")),
+			"(a + b) * c",
+		},
+		{
+			"higher precedence operand",
+			binary(ident("a"), lexer.PLUS, "+", binary(ident("b"), lexer.STAR, "*", ident("c"))),
+			"a + b * c",
+		},
+		{
+			"left associative chain",
+			binary(binary(ident("a"), lexer.DASH, "-", ident("b")), lexer.DASH, "-", ident("c")),
+			"a - b - c",
+		},
+		{
+			"right-nested chain",
+			binary(ident("a"), lexer.DASH, "-", binary(ident("b"), lexer.DASH, "-", ident("c"))),
+			"a - (b - c)",
+		},
+		{
+			"negated sum",
+			negate(binary(ident("a"), lexer.PLUS, "+", ident("b"))),
+			"-(a + b)",
+		},
+		{
+			"negated operand on the right",
+			binary(ident("a"), lexer.STAR, "*", negate(ident("b"))),
+			"a * -b",
+		},
+		{
+			// Without the parentheses, the negation would capture the whole product `b * c`
+			"negated operand on the right of the left hand side",
+			binary(binary(ident("a"), lexer.STAR, "*", negate(ident("b"))), lexer.STAR, "*", ident("c")),
+			"(a * -b) * c",
+		},
+		{
+			"double negation",
+			negate(negate(ident("a"))),
+			"--a",
+		},
+		{
+			"right associative exponentiation",
+			binary(ident("a"), lexer.CHEVRON, "^", binary(ident("b"), lexer.CHEVRON, "^", ident("c"))),
+			"a ^ b ^ c",
+		},
+		{
+			"left-nested exponentiation",
+			binary(binary(ident("a"), lexer.CHEVRON, "^", ident("b")), lexer.CHEVRON, "^", ident("c")),
+			"(a ^ b) ^ c",
+		},
+		{
+			"negated power",
+			negate(binary(ident("a"), lexer.CHEVRON, "^", ident("b"))),
+			"-a ^ b",
+		},
+		{
+			"power of a negation",
+			binary(negate(ident("a")), lexer.CHEVRON, "^", ident("b")),
+			"(-a) ^ b",
+		},
+		{
+			"negated operand on the left",
+			binary(negate(ident("a")), lexer.STAR, "*", ident("b")),
+			"(-a) * b",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if printed := ast.Print(tc.expr); printed != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, printed)
+			}
+		})
+	}
+}
+
+// The same nested source indents consistently at every depth with either indentation unit
+func TestFormatIndentation(t *testing.T) {
+	src := `struct Point { x: i32, y: i32 }
+func f(p: Point): i32 { let total: i32 = 0
+  for (let i: i32 = 0; i < p.x; i += 1) { if i > 2 then { total += match i { 3 => 1, _ => 2, } } else { total -= 1 } }
+  return total }`
+	testCases := []struct {
+		name     string
+		options  ast.FormatOptions
+		expected string
+	}{
+		{"tabs", ast.FormatOptions{}, "struct Point {\n\tx: i32,\n\ty: i32,\n}\n\n" +
+			"func f(p: Point): i32 {\n" +
+			"\tlet total: i32 = 0\n" +
+			"\tfor (let i: i32 = 0; i < p.x; i += 1) {\n" +
+			"\t\tif i > 2 then {\n" +
+			"\t\t\ttotal += match i {\n" +
+			"\t\t\t\t3 => 1,\n" +
+			"\t\t\t\t_ => 2,\n" +
+			"\t\t\t}\n" +
+			"\t\t} else {\n" +
+			"\t\t\ttotal -= 1\n" +
+			"\t\t}\n" +
+			"\t}\n" +
+			"\treturn total\n" +
+			"}\n"},
+		{"four spaces", ast.FormatOptions{IndentSpaces: 4}, `struct Point {
+    x: i32,
+    y: i32,
+}
+
+func f(p: Point): i32 {
+    let total: i32 = 0
+    for (let i: i32 = 0; i < p.x; i += 1) {
+        if i > 2 then {
+            total += match i {
+                3 => 1,
+                _ => 2,
+            }
+        } else {
+            total -= 1
+        }
+    }
+    return total
+}
+`},
+	}
+	module := parser.Parse(lexer.Tokenize(src))
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if formatted := ast.Format(module, tc.options); formatted != tc.expected {
+				t.Errorf("Expected\n%s\ngot\n%s", tc.expected, formatted)
+			}
+		})
+	}
+	if ast.Print(module) != ast.Format(module, ast.FormatOptions{}) {
+		t.Errorf("Expected Print to indent with tabs like Format by default")
+	}
+}
@@ -0,0 +1,167 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// Visitor is implemented by the passes that traverse the AST with Walk.
+//
+// Visit is called before the children of a node are walked. If it returns false, the children
+// are skipped and Leave is not called for the node. Otherwise Leave is called after all the
+// children of the node have been walked.
+type Visitor interface {
+	Visit(node Node) bool
+	Leave(node Node)
+}
+
+// Walk traverses the AST rooted at node depth-first, in source order, calling the hooks of the
+// visitor for the node itself and for every descendant node. Optional children that the parser
+// left nil (like the type of a variable declared without one) are skipped.
+func Walk(node Node, v Visitor) {
+	if node == nil || !v.Visit(node) {
+		return
+	}
+	switch n := node.(type) {
+	// Type expressions
+	case *NamedTypeExpr, *UnitTypeExpr:
+		// Leaf nodes
+	case *ArrayTypeExpr:
+		Walk(n.UnderlyingType, v)
+		Walk(n.Size, v)
+	case *FuncTypeExpr:
+		for _, paramType := range n.ParamTypes {
+			Walk(paramType, v)
+		}
+		Walk(n.ReturnType, v)
+	case *TupleTypeExpr:
+		for _, elemType := range n.ElemTypes {
+			Walk(elemType, v)
+		}
+
+	// Expressions
+	case *UnitExpr, *BoolLiteralExpr, *StringLiteralExpr, *IdentExpr, *NumberLiteralExpr, *WildcardExpr:
+		// Leaf nodes
+	case *UnaryExpr:
+		Walk(n.Rhs, v)
+	case *BinaryExpr:
+		Walk(n.Lhs, v)
+		Walk(n.Rhs, v)
+	case *BlockExpr:
+		for _, stmt := range n.Statements {
+			Walk(stmt, v)
+		}
+		Walk(n.ResultExpr, v)
+	case *GroupExpr:
+		Walk(n.Expr, v)
+	case *AscriptionExpr:
+		Walk(n.Expr, v)
+		Walk(n.Type, v)
+	case *CastExpr:
+		Walk(n.Expr, v)
+		Walk(n.TargetType, v)
+	case *TupleExpr:
+		for _, elem := range n.Elems {
+			Walk(elem, v)
+		}
+	case *FuncCallExpr:
+		Walk(n.Func, v)
+		for _, arg := range n.Args {
+			Walk(arg, v)
+		}
+	case *StructLiteralExpr:
+		Walk(n.Struct, v)
+		for _, member := range n.Members {
+			Walk(member, v)
+		}
+	case *StructMemberExpr:
+		Walk(n.Struct, v)
+		Walk(n.Member, v)
+	case *ArrayIndexExpr:
+		Walk(n.Array, v)
+		Walk(n.Index, v)
+	case *IfExpr:
+		Walk(n.Cond, v)
+		Walk(n.Then, v)
+		Walk(n.Else, v)
+	case *LoopExpr:
+		Walk(n.Body, v)
+	case *MatchExpr:
+		Walk(n.Subject, v)
+		for _, arm := range n.Arms {
+			Walk(arm, v)
+		}
+	case *MatchArm:
+		Walk(n.Pattern, v)
+		Walk(n.Body, v)
+	case *AssignExpr:
+		Walk(n.Assigne, v)
+		Walk(n.AssignedValue, v)
+	case *MemberAssignExpr:
+		Walk(n.Value, v)
+	case *VarDeclAssignExpr:
+		Walk(n.AssignedValue, v)
+	case *UseSpecExpr:
+		// Leaf node
+
+	// Statements
+	case *BlockStmt:
+		for _, stmt := range n.Statements {
+			Walk(stmt, v)
+		}
+	case *ExpressionStmt:
+		Walk(n.Expr, v)
+	case *VarDeclStmt:
+		Walk(&n.Var, v)
+		Walk(n.InitVal, v)
+	case *DestructuringVarDeclStmt:
+		for _, variable := range n.Vars {
+			Walk(variable, v)
+		}
+		Walk(n.InitVal, v)
+	case *FuncDeclStmt:
+		if n.Receiver != nil {
+			Walk(n.Receiver, v)
+		}
+		for _, param := range n.Parameters {
+			Walk(param, v)
+		}
+		Walk(n.ReturnType, v)
+		Walk(n.Body, v)
+	case *StructDeclStmt:
+		for _, member := range n.Members {
+			Walk(member, v)
+		}
+	case *EnumDeclStmt:
+		// Leaf node
+	case *InterfaceDeclStmt:
+		for _, method := range n.Methods {
+			Walk(method, v)
+		}
+	case *IfStmt:
+		Walk(n.Cond, v)
+		Walk(n.Then, v)
+		Walk(n.Else, v)
+	case *ForStmt:
+		Walk(n.Init, v)
+		Walk(n.Cond, v)
+		Walk(n.Iter, v)
+		Walk(n.Body, v)
+	case *ReturnStmt:
+		Walk(n.Expr, v)
+	case *BreakStmt:
+		Walk(n.Value, v)
+	case *UseDeclStmt:
+		for _, spec := range n.UseSpecs {
+			Walk(spec, v)
+		}
+
+	// Helper nodes
+	case *TypedIdent:
+		Walk(n.Type, v)
+		Walk(n.Default, v)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", node))
+	}
+	v.Leave(node)
+}
@@ -0,0 +1,173 @@
+package ast_test
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"github.com/ruistola/cooper/parser"
+	goast "go/ast"
+	goparser "go/parser"
+	"go/token"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// countingVisitor counts the visited nodes by their type, optionally skipping the children of some node types
+type countingVisitor struct {
+	visits map[string]int
+	leaves int
+	skip   map[string]bool
+}
+
+func newCountingVisitor(skip ...string) *countingVisitor {
+	v := &countingVisitor{
+		visits: map[string]int{},
+		skip:   map[string]bool{},
+	}
+	for _, s := range skip {
+		v.skip[s] = true
+	}
+	return v
+}
+
+func (v *countingVisitor) Visit(node ast.Node) bool {
+	nodeType := fmt.Sprintf("%T", node)
+	v.visits[nodeType]++
+	return !v.skip[nodeType]
+}
+
+func (v *countingVisitor) Leave(node ast.Node) {
+	v.leaves++
+}
+
+const walkTestSrc = `struct Point {
+  x: i32,
+  y: i32,
+}
+
+func scaled(p: Point, factors: i32[]): i32 {
+  if p.x < 0 then {
+    return -p.x * factors[0]
+  }
+  for (i := 0; i < 10; i += 1) {
+    p = Point{ x: i, y: 2, }
+  }
+  return if p.y > 0 then { p.y } else { (0) }
+}`
+
+func TestWalkVisitsEveryNode(t *testing.T) {
+	module := parser.Parse(lexer.Tokenize(walkTestSrc))
+	v := newCountingVisitor()
+	ast.Walk(module, v)
+
+	expected := map[string]int{
+		"*ast.BlockStmt":         4,
+		"*ast.StructDeclStmt":    1,
+		"*ast.TypedIdent":        4,
+		"*ast.NamedTypeExpr":     5,
+		"*ast.ArrayTypeExpr":     1,
+		"*ast.FuncDeclStmt":      1,
+		"*ast.IfStmt":            1,
+		"*ast.BinaryExpr":        4,
+		"*ast.StructMemberExpr":  4,
+		"*ast.IdentExpr":         14,
+		"*ast.NumberLiteralExpr": 8,
+		"*ast.ReturnStmt":        2,
+		"*ast.UnaryExpr":         1,
+		"*ast.ArrayIndexExpr":    1,
+		"*ast.ForStmt":           1,
+		"*ast.ExpressionStmt":    3,
+		"*ast.VarDeclAssignExpr": 1,
+		"*ast.AssignExpr":        2,
+		"*ast.StructLiteralExpr": 1,
+		"*ast.MemberAssignExpr":  2,
+		"*ast.IfExpr":            1,
+		"*ast.BlockExpr":         2,
+		"*ast.GroupExpr":         1,
+	}
+	if !maps.Equal(v.visits, expected) {
+		t.Errorf("Expected visits %v, got %v", expected, v.visits)
+	}
+
+	total := 0
+	for _, count := range v.visits {
+		total += count
+	}
+	if v.leaves != total {
+		t.Errorf("Expected %d leaves (one per visit), got %d", total, v.leaves)
+	}
+}
+
+// Every node type must have a case in Walk, which panics on an unexpected one. The node types are found in the
+// sources of the package as the types with a Position method, so that adding a node type without a case in
+// Walk fails this test rather than a pass walking a tree with the node.
+func TestWalkHandlesEveryNodeType(t *testing.T) {
+	paths, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	nodeTypes := []string{}
+	handled := []string{}
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := goparser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*goast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if funcDecl.Name.Name == "Position" && funcDecl.Recv != nil {
+				if receiver, ok := funcDecl.Recv.List[0].Type.(*goast.StarExpr); ok {
+					nodeTypes = append(nodeTypes, receiver.X.(*goast.Ident).Name)
+				}
+			}
+			if funcDecl.Name.Name == "Walk" && funcDecl.Recv == nil {
+				goast.Inspect(funcDecl.Body, func(n goast.Node) bool {
+					if clause, ok := n.(*goast.CaseClause); ok {
+						for _, caseType := range clause.List {
+							if star, ok := caseType.(*goast.StarExpr); ok {
+								handled = append(handled, star.X.(*goast.Ident).Name)
+							}
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+	slices.Sort(nodeTypes)
+	slices.Sort(handled)
+	if len(nodeTypes) == 0 || !slices.Equal(nodeTypes, handled) {
+		t.Errorf("Expected Walk to handle the node types %v, got %v", nodeTypes, handled)
+	}
+}
+
+func TestWalkSkipsChildren(t *testing.T) {
+	module := parser.Parse(lexer.Tokenize(walkTestSrc))
+	v := newCountingVisitor("*ast.FuncDeclStmt")
+	ast.Walk(module, v)
+
+	expected := map[string]int{
+		"*ast.BlockStmt":      1,
+		"*ast.StructDeclStmt": 1,
+		"*ast.TypedIdent":     2,
+		"*ast.NamedTypeExpr":  2,
+		"*ast.FuncDeclStmt":   1,
+	}
+	if !maps.Equal(v.visits, expected) {
+		t.Errorf("Expected visits %v, got %v", expected, v.visits)
+	}
+	// The skipped function declaration is visited but not left
+	if v.leaves != 6 {
+		t.Errorf("Expected 6 leaves, got %d", v.leaves)
+	}
+}
@@ -0,0 +1,202 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/lexer"
+)
+
+// amd64 emits instructions for x86-64 Linux, in AT&T syntax. The accumulator is rax, and the right hand
+// side operand of a binary operation is held in rcx. Arguments are passed as in the System V ABI, and
+// the return value in rax. Values are saved on the stack 8 bytes at a time.
+// Local variables are addressed relative to rbp, which points to the top of the frame.
+type amd64 struct {
+	g         *Generator
+	frameSize int
+}
+
+// The registers of the first six integer arguments in the System V ABI; the rest are passed on the stack.
+var amd64ArgRegisters = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+
+// entryPoint emits _start, which calls main and exits the process with the value it returns.
+func (a *amd64) entryPoint() {
+	// Mark the stack as non-executable
+	a.g.emit(`.section .note.GNU-stack,"",@progbits`)
+	a.g.emit(".text")
+	a.g.emit(".globl _start")
+	a.g.emit("")
+	a.g.emit("_start:")
+	a.g.emit("  call main")
+	a.g.emit("  movl %%eax, %%edi")
+	a.g.emit("  movl $60, %%eax") // exit
+	a.g.emit("  syscall")
+	a.g.emit("")
+}
+
+func (a *amd64) functionStart(name string, frameSize int) {
+	a.frameSize = frameSize
+	a.g.emit(".globl %s", name)
+	a.g.emit("")
+	a.g.emit("%s:", name)
+	a.g.emit("  pushq %%rbp")
+	a.g.emit("  movq %%rsp, %%rbp")
+	if frameSize > 0 {
+		a.g.emit("  subq $%d, %%rsp", frameSize)
+	}
+}
+
+func (a *amd64) ret() {
+	a.g.emit("  movq %%rbp, %%rsp")
+	a.g.emit("  popq %%rbp")
+	a.g.emit("  ret")
+}
+
+// address returns the operand addressing a stack slot.
+func (a *amd64) address(slot frameSlot) string {
+	return fmt.Sprintf("-%d(%%rbp)", a.frameSize-slot.offset)
+}
+
+func (a *amd64) load(slot frameSlot) {
+	switch slot.size {
+	case 1:
+		a.g.emit("  movsbq %s, %%rax", a.address(slot))
+	case 4:
+		a.g.emit("  movslq %s, %%rax", a.address(slot))
+	default:
+		a.g.emit("  movq %s, %%rax", a.address(slot))
+	}
+}
+
+func (a *amd64) store(slot frameSlot) {
+	switch slot.size {
+	case 1:
+		a.g.emit("  movb %%al, %s", a.address(slot))
+	case 4:
+		a.g.emit("  movl %%eax, %s", a.address(slot))
+	default:
+		a.g.emit("  movq %%rax, %s", a.address(slot))
+	}
+}
+
+func (a *amd64) loadInt(value int32) {
+	// The 32-bit immediate is sign extended
+	a.g.emit("  movq $%d, %%rax", value)
+}
+
+func (a *amd64) negate() {
+	a.g.emit("  negq %%rax")
+}
+
+func (a *amd64) push() {
+	a.g.emit("  pushq %%rax")
+}
+
+func (a *amd64) popOperands() {
+	a.g.emit("  movq %%rax, %%rcx")
+	a.g.emit("  popq %%rax")
+}
+
+func (a *amd64) binaryOp(operator lexer.TokenType) {
+	switch operator {
+	case lexer.PLUS:
+		a.g.emit("  addq %%rcx, %%rax")
+	case lexer.DASH:
+		a.g.emit("  subq %%rcx, %%rax")
+	case lexer.STAR:
+		a.g.emit("  imulq %%rcx, %%rax")
+	case lexer.SLASH:
+		// Sign extend rax into rdx:rax, the dividend of idivq
+		a.g.emit("  cqto")
+		a.g.emit("  idivq %%rcx")
+	case lexer.PERCENT:
+		a.g.emit("  cqto")
+		a.g.emit("  idivq %%rcx")
+		a.g.emit("  movq %%rdx, %%rax")
+	default:
+		condition, ok := amd64Conditions[operator]
+		if !ok {
+			panic(fmt.Sprintf("unhandled binary operator: %s", operator))
+		}
+		a.g.emit("  cmpq %%rcx, %%rax")
+		a.g.emit("  set%s %%al", condition)
+		a.g.emit("  movzbq %%al, %%rax")
+	}
+}
+
+// The condition codes of the comparison operators, for the set instructions
+var amd64Conditions = map[lexer.TokenType]string{
+	lexer.DOUBLE_EQUALS:  "e",
+	lexer.NOT_EQUALS:     "ne",
+	lexer.LESS:           "l",
+	lexer.LESS_EQUALS:    "le",
+	lexer.GREATER:        "g",
+	lexer.GREATER_EQUALS: "ge",
+}
+
+func (a *amd64) branchIfZero(label string) {
+	a.g.emit("  testq %%rax, %%rax")
+	a.g.emit("  jz %s", label)
+}
+
+func (a *amd64) jump(label string) {
+	a.g.emit("  jmp %s", label)
+}
+
+// storeParam copies the argument through rax, which doesn't hold an argument. The arguments passed on
+// the stack are above the return address and the saved rbp.
+func (a *amd64) storeParam(index int, slot frameSlot) {
+	if index < len(amd64ArgRegisters) {
+		a.g.emit("  movq %%%s, %%rax", amd64ArgRegisters[index])
+	} else {
+		a.g.emit("  movq %d(%%rbp), %%rax", 16+(index-len(amd64ArgRegisters))*8)
+	}
+	a.store(slot)
+}
+
+// call moves the first six arguments from the stack into registers. The rest are pushed again, so that
+// the first of them ends up on top as the ABI requires; the arguments were pushed in the opposite order.
+func (a *amd64) call(name string, argCount int) {
+	stackArgCount := max(argCount-len(amd64ArgRegisters), 0)
+	for i := argCount - 1; i >= len(amd64ArgRegisters); i-- {
+		// Each push so far has moved the argument one slot further from the top
+		pushed := argCount - 1 - i
+		a.g.emit("  pushq %d(%%rsp)", (argCount-1-i+pushed)*8)
+	}
+	for i := range min(argCount, len(amd64ArgRegisters)) {
+		a.g.emit("  movq %d(%%rsp), %%%s", (stackArgCount+argCount-1-i)*8, amd64ArgRegisters[i])
+	}
+	a.g.emit("  call %s", name)
+	if argCount > 0 {
+		a.g.emit("  addq $%d, %%rsp", (argCount+stackArgCount)*8)
+	}
+}
+
+// localLabel returns a label prefixed with .L, which keeps it out of the symbol table.
+func (a *amd64) localLabel(name string) string {
+	return ".L." + name
+}
+
+func (a *amd64) loadAddress(label string) {
+	a.g.emit("  leaq %s(%%rip), %%rax", label)
+}
+
+func (a *amd64) stringSection() {
+	a.g.emit(".section .rodata")
+}
+
+// printFunction counts the length of the string at the address in rdi, and writes it with the write system call.
+func (a *amd64) printFunction(name string) {
+	labels := a.g.newLabels("strlen", "write")
+	a.g.emit("%s:", name)
+	a.g.emit("  movq %%rdi, %%rsi")
+	a.g.emit("  xorl %%edx, %%edx")
+	a.g.label(labels[0])
+	a.g.emit("  cmpb $0, (%%rsi,%%rdx)")
+	a.g.emit("  je %s", labels[1])
+	a.g.emit("  incq %%rdx")
+	a.g.emit("  jmp %s", labels[0])
+	a.g.label(labels[1])
+	a.g.emit("  movl $1, %%edi") // stdout
+	a.g.emit("  movl $1, %%eax") // write
+	a.g.emit("  syscall")
+	a.g.emit("  ret")
+}
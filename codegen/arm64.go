@@ -0,0 +1,232 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/lexer"
+)
+
+// arm64 emits instructions for arm64 macOS or Linux. The accumulator is x0, and the right hand side operand
+// of a binary operation is held in x1. Arguments are passed in x0-x7, and the return value in x0. Values
+// are saved on the stack 16 bytes at a time to keep sp aligned.
+// Local variables are addressed relative to x29, which points to the bottom of the frame.
+type arm64 struct {
+	g         *Generator
+	linux     bool
+	frameSize int
+}
+
+// symbol returns the name of the symbol of a function; macOS requires underscore prefix for symbols.
+func (a *arm64) symbol(name string) string {
+	if a.linux {
+		return name
+	}
+	return "_" + name
+}
+
+// entryPoint emits _start on Linux, which calls main and exits the process with the value it returns.
+// On macOS, the linker is pointed to _main directly, and returning from it exits the process.
+func (a *arm64) entryPoint() {
+	if !a.linux {
+		return
+	}
+	// Mark the stack as non-executable
+	a.g.emit(`.section .note.GNU-stack,"",%%progbits`)
+	a.g.emit(".text")
+	a.g.emit(".global _start")
+	a.g.emit("")
+	a.g.emit("_start:")
+	a.g.emit("  bl main")
+	a.g.emit("  mov x8, #93") // exit
+	a.g.emit("  svc #0")
+	a.g.emit("")
+}
+
+func (a *arm64) functionStart(name string, frameSize int) {
+	a.frameSize = frameSize
+	a.g.emit(".global %s", a.symbol(name))
+	a.g.emit(".align 4")
+	a.g.emit("")
+	a.g.emit("%s:", a.symbol(name))
+	a.g.emit("  stp x29, x30, [sp, #-16]!")
+	if frameSize > 0 {
+		a.g.emit("  sub sp, sp, #%d", frameSize)
+	}
+	a.g.emit("  mov x29, sp")
+}
+
+func (a *arm64) ret() {
+	if a.frameSize > 0 {
+		a.g.emit("  add sp, x29, #%d", a.frameSize)
+	} else {
+		a.g.emit("  mov sp, x29")
+	}
+	a.g.emit("  ldp x29, x30, [sp], #16")
+	a.g.emit("ret")
+}
+
+func (a *arm64) load(slot frameSlot) {
+	switch slot.size {
+	case 1:
+		a.g.emit("  ldrsb x0, [x29, #%d]", slot.offset)
+	case 4:
+		a.g.emit("  ldrsw x0, [x29, #%d]", slot.offset)
+	default:
+		a.g.emit("  ldr x0, [x29, #%d]", slot.offset)
+	}
+}
+
+func (a *arm64) store(slot frameSlot) {
+	switch slot.size {
+	case 1:
+		a.g.emit("  strb w0, [x29, #%d]", slot.offset)
+	case 4:
+		a.g.emit("  str w0, [x29, #%d]", slot.offset)
+	default:
+		a.g.emit("  str x0, [x29, #%d]", slot.offset)
+	}
+}
+
+// loadInt moves a 32-bit integer into x0, 16 bits at a time as that is the largest immediate
+// a single move can take, and sign extends it.
+func (a *arm64) loadInt(value int32) {
+	bits := uint32(value)
+	a.g.emit("  movz w0, #%d", bits&0xFFFF)
+	if high := bits >> 16; high != 0 {
+		a.g.emit("  movk w0, #%d, lsl #16", high)
+	}
+	if value < 0 {
+		a.g.emit("  sxtw x0, w0")
+	}
+}
+
+func (a *arm64) negate() {
+	a.g.emit("  neg x0, x0")
+}
+
+func (a *arm64) push() {
+	a.g.emit("  str x0, [sp, #-16]!")
+}
+
+func (a *arm64) popOperands() {
+	a.g.emit("  mov x1, x0")
+	a.g.emit("  ldr x0, [sp], #16")
+}
+
+func (a *arm64) binaryOp(operator lexer.TokenType) {
+	switch operator {
+	case lexer.PLUS:
+		a.g.emit("  add x0, x0, x1")
+	case lexer.DASH:
+		a.g.emit("  sub x0, x0, x1")
+	case lexer.STAR:
+		a.g.emit("  mul x0, x0, x1")
+	case lexer.SLASH:
+		a.g.emit("  sdiv x0, x0, x1")
+	case lexer.PERCENT:
+		a.g.emit("  sdiv x2, x0, x1")
+		a.g.emit("  msub x0, x2, x1, x0")
+	default:
+		condition, ok := arm64Conditions[operator]
+		if !ok {
+			panic(fmt.Sprintf("unhandled binary operator: %s", operator))
+		}
+		a.g.emit("  cmp x0, x1")
+		a.g.emit("  cset x0, %s", condition)
+	}
+}
+
+// The condition codes of the comparison operators
+var arm64Conditions = map[lexer.TokenType]string{
+	lexer.DOUBLE_EQUALS:  "eq",
+	lexer.NOT_EQUALS:     "ne",
+	lexer.LESS:           "lt",
+	lexer.LESS_EQUALS:    "le",
+	lexer.GREATER:        "gt",
+	lexer.GREATER_EQUALS: "ge",
+}
+
+func (a *arm64) branchIfZero(label string) {
+	a.g.emit("  cbz x0, %s", label)
+}
+
+func (a *arm64) jump(label string) {
+	a.g.emit("  b %s", label)
+}
+
+func (a *arm64) storeParam(index int, slot frameSlot) {
+	switch slot.size {
+	case 1:
+		a.g.emit("  strb w%d, [x29, #%d]", index, slot.offset)
+	case 4:
+		a.g.emit("  str w%d, [x29, #%d]", index, slot.offset)
+	default:
+		a.g.emit("  str x%d, [x29, #%d]", index, slot.offset)
+	}
+}
+
+// call moves the arguments from the stack into x0-x7. Each was saved in 16 bytes, the last one on top.
+func (a *arm64) call(name string, argCount int) {
+	for i := range argCount {
+		a.g.emit("  ldr x%d, [sp, #%d]", i, (argCount-1-i)*16)
+	}
+	if argCount > 0 {
+		a.g.emit("  add sp, sp, #%d", argCount*16)
+	}
+	a.g.emit("  bl %s", a.symbol(name))
+}
+
+// localLabel returns a label prefixed with L on macOS, and .L on Linux, which keeps it out of the symbol table.
+func (a *arm64) localLabel(name string) string {
+	if a.linux {
+		return ".L." + name
+	}
+	return "L_." + name
+}
+
+// loadAddress computes the address relative to the program counter: adrp gives the 4KB page of the
+// label, and the offset within the page is added to it.
+func (a *arm64) loadAddress(label string) {
+	if a.linux {
+		a.g.emit("  adrp x0, %s", label)
+		a.g.emit("  add x0, x0, :lo12:%s", label)
+	} else {
+		a.g.emit("  adrp x0, %s@PAGE", label)
+		a.g.emit("  add x0, x0, %s@PAGEOFF", label)
+	}
+}
+
+// printFunction counts the length of the string at the address in x0, and writes it with the write system
+// call on Linux, or with the write function of the system library on macOS.
+func (a *arm64) printFunction(name string) {
+	labels := a.g.newLabels("strlen", "write")
+	a.g.emit(".align 4")
+	a.g.emit("%s:", a.symbol(name))
+	a.g.emit("  mov x1, x0")
+	a.g.emit("  mov x2, #0")
+	a.g.label(labels[0])
+	a.g.emit("  ldrb w3, [x1, x2]")
+	a.g.emit("  cbz w3, %s", labels[1])
+	a.g.emit("  add x2, x2, #1")
+	a.g.emit("  b %s", labels[0])
+	a.g.label(labels[1])
+	a.g.emit("  mov x0, #1") // stdout
+	if a.linux {
+		a.g.emit("  mov x8, #64") // write
+		a.g.emit("  svc #0")
+		a.g.emit("  ret")
+		return
+	}
+	a.g.emit("  stp x29, x30, [sp, #-16]!")
+	a.g.emit("  mov x29, sp")
+	a.g.emit("  bl _write")
+	a.g.emit("  ldp x29, x30, [sp], #16")
+	a.g.emit("  ret")
+}
+
+func (a *arm64) stringSection() {
+	if a.linux {
+		a.g.emit(".section .rodata")
+	} else {
+		a.g.emit(".section __TEXT,__cstring,cstring_literals")
+	}
+}
@@ -3,15 +3,85 @@ package codegen
 import (
 	"fmt"
 	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"maps"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 type Generator struct {
-	buf strings.Builder
+	buf            strings.Builder
+	isa            instructionSet
+	frame          *frameLayout                     // Stack frame layout of the current function
+	locals         map[string]frameSlot             // Stack slots of the variables in scope, by name
+	stringLiterals []string                         // The distinct string literals of the module, in order of first use
+	stringLabels   map[string]string                // Labels of the string literals, by value
+	labelCount     int                              // The number of the local labels generated for branches so far
+	funcs          map[string]bool                  // The functions declared by the module, shadowing the built-in ones
+	usesPrint      bool                             // Whether the module calls a built-in print function
+	callDefaults   map[*ast.FuncCallExpr][]ast.Expr // The arguments filled in for the parameters omitted from calls
 }
 
+// printSymbol is the symbol of the function writing a string for the built-in print functions, emitted if
+// the module calls any of them. The dot keeps it apart from the functions declared by the module.
+const printSymbol = "cooper.print"
+
+// instructionSet emits the target specific instructions for the operations of the generator.
+// Expressions are evaluated into a 64-bit accumulator register, saving intermediate results on the stack.
+// Values of narrower integer types are sign extended when loaded, and truncated when stored.
+type instructionSet interface {
+	// entryPoint emits the entry point of the program, if the target requires one in addition to main
+	entryPoint()
+	// functionStart emits the label of a function, and the prologue reserving its stack frame
+	functionStart(name string, frameSize int)
+	// ret emits the epilogue releasing the stack frame, and returns from the function with the
+	// accumulator as the return value
+	ret()
+	// load loads the value of a local variable into the accumulator
+	load(slot frameSlot)
+	// store stores the accumulator into a local variable
+	store(slot frameSlot)
+	// loadInt moves an integer into the accumulator
+	loadInt(value int32)
+	// negate negates the accumulator
+	negate()
+	// push saves the accumulator on the stack
+	push()
+	// popOperands moves the accumulator into the right hand side register, and restores the
+	// accumulator from the stack
+	popOperands()
+	// binaryOp applies the operator on the accumulator and the right hand side register,
+	// leaving the result in the accumulator. Comparisons result in 1 if true, and 0 if false.
+	binaryOp(operator lexer.TokenType)
+	// branchIfZero jumps to the label if the accumulator is zero, i.e. false
+	branchIfZero(label string)
+	// jump jumps to the label unconditionally
+	jump(label string)
+	// storeParam stores the argument passed to the current function as the parameter of the given
+	// index into its stack slot
+	storeParam(index int, slot frameSlot)
+	// call calls a function with the arguments saved on the stack, the first one deepest, releasing
+	// them and leaving the return value in the accumulator
+	call(name string, argCount int)
+	// localLabel returns a label of the name that is local to the object file
+	localLabel(name string) string
+	// loadAddress moves the address of a label into the accumulator
+	loadAddress(label string)
+	// stringSection emits the directive switching to the read-only section for string literals
+	stringSection()
+	// printFunction emits a function writing the NUL terminated string passed as its argument to the
+	// standard output
+	printFunction(name string)
+}
+
+// maxParams is the number of parameters a function can have: all of them are passed in
+// registers on arm64, and the ones beyond the sixth on the stack on x86-64.
+const maxParams = 8
+
 func (g *Generator) emit(format string, args ...any) {
 	fmt.Fprintf(&g.buf, format, args...)
 	g.buf.WriteString("\n")
@@ -22,48 +92,327 @@ func (g *Generator) String() string {
 }
 
 func (g *Generator) generateFunction(fn *ast.FuncDeclStmt) {
-	// macOS requires underscore prefix for symbols
-	g.emit(".global _%s", fn.Name)
-	g.emit(".align 4")
-	g.emit("")
-	g.emit("_%s:", fn.Name)
-
-	// TODO: Prologue
+	if fn.Receiver != nil {
+		panic(fmt.Sprintf("unhandled method: %s", fn.Name))
+	}
+	if len(fn.Parameters) > 0 && fn.Parameters[len(fn.Parameters)-1].Variadic {
+		panic(fmt.Sprintf("unhandled variadic function: %s", fn.Name))
+	}
+	if len(fn.Parameters) > maxParams {
+		panic(fmt.Sprintf("unhandled function with more than %d parameters: %s", maxParams, fn.Name))
+	}
+	g.frame = newFrameLayout(fn)
+	g.locals = map[string]frameSlot{}
+	g.isa.functionStart(fn.Name, g.frame.size)
+	for i, param := range fn.Parameters {
+		slot := g.frame.slots[param]
+		g.isa.storeParam(i, slot)
+		g.locals[param.Name] = slot
+	}
 
 	// Generate function body
 	for _, stmt := range fn.Body.Statements {
 		g.generateStmt(stmt)
 	}
 
-	// TODO: Epilogue
-
-	g.emit("ret")
+	// A function without a trailing return statement returns after its last statement
+	statements := fn.Body.Statements
+	if len(statements) == 0 {
+		g.isa.ret()
+	} else if _, isReturn := statements[len(statements)-1].(*ast.ReturnStmt); !isReturn {
+		g.isa.ret()
+	}
 }
 
 func (g *Generator) generateStmt(stmt ast.Stmt) {
 	switch s := stmt.(type) {
 	case *ast.ReturnStmt:
-		// Evaluate the expression, result in w0
+		// Evaluate the expression into the accumulator
+		g.generateExpr(s.Expr)
+		g.isa.ret()
+	case *ast.BlockStmt:
+		outerLocals := maps.Clone(g.locals)
+		for _, stmt := range s.Statements {
+			g.generateStmt(stmt)
+		}
+		g.locals = outerLocals
+	case *ast.ExpressionStmt:
 		g.generateExpr(s.Expr)
-		// ret is emitted by generateFunction epilogue
+	case *ast.VarDeclStmt:
+		// Variables declared without a value are zeroed
+		if s.InitVal != nil {
+			g.generateExpr(s.InitVal)
+		} else {
+			g.isa.loadInt(0)
+		}
+		slot := g.frame.slots[s]
+		g.isa.store(slot)
+		g.locals[s.Var.Name] = slot
+	case *ast.IfStmt:
+		// Only the branch taken is emitted if the condition has been folded into a literal. The dead one
+		// has been type checked all the same.
+		if cond, ok := s.Cond.(*ast.BoolLiteralExpr); ok {
+			if cond.Value {
+				g.generateStmt(s.Then)
+			} else if s.Else != nil {
+				g.generateStmt(s.Else)
+			}
+			return
+		}
+		labels := g.newLabels("else", "end")
+		g.generateExpr(s.Cond)
+		g.isa.branchIfZero(labels[0])
+		g.generateStmt(s.Then)
+		g.isa.jump(labels[1])
+		g.label(labels[0])
+		if s.Else != nil {
+			g.generateStmt(s.Else)
+		}
+		g.label(labels[1])
 	default:
 		panic(fmt.Sprintf("unhandled statement type: %T", stmt))
 	}
 }
 
-func (g *Generator) generateExpr(expr ast.Expr) string {
+// newLabels returns a new local label for each of the kinds, numbered alike, e.g. else.3 and end.3.
+func (g *Generator) newLabels(kinds ...string) []string {
+	labels := []string{}
+	for _, kind := range kinds {
+		labels = append(labels, g.isa.localLabel(fmt.Sprintf("%s.%d", kind, g.labelCount)))
+	}
+	g.labelCount++
+	return labels
+}
+
+func (g *Generator) label(label string) {
+	g.emit("%s:", label)
+}
+
+// internString returns the label of a string literal, the same for each occurrence of the same value.
+func (g *Generator) internString(value string) string {
+	if label, ok := g.stringLabels[value]; ok {
+		return label
+	}
+	label := g.isa.localLabel(fmt.Sprintf("str.%d", len(g.stringLiterals)))
+	g.stringLiterals = append(g.stringLiterals, value)
+	g.stringLabels[value] = label
+	return label
+}
+
+// generateStrings emits the interned string literals, each as a NUL terminated string under its label.
+func (g *Generator) generateStrings() {
+	if len(g.stringLiterals) == 0 {
+		return
+	}
+	g.emit("")
+	g.isa.stringSection()
+	for _, value := range g.stringLiterals {
+		g.emit("%s:", g.stringLabels[value])
+		g.emit("  .asciz %s", asmString(value))
+	}
+}
+
+// asmString quotes a string for an assembler directive. Printable ASCII characters are written as is,
+// and the rest of the bytes as escapes, so that the directive is the same regardless of the assembler.
+func asmString(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, b := range []byte(value) {
+		switch {
+		case b == '"' || b == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b == '\n':
+			sb.WriteString(`\n`)
+		case b == '\t':
+			sb.WriteString(`\t`)
+		case b >= 0x20 && b < 0x7F:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "\\%03o", b)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// The arithmetic operators applied by the compound assignment operators
+var compoundOperators = map[lexer.TokenType]lexer.TokenType{
+	lexer.PLUS_EQUALS:    lexer.PLUS,
+	lexer.DASH_EQUALS:    lexer.DASH,
+	lexer.STAR_EQUALS:    lexer.STAR,
+	lexer.SLASH_EQUALS:   lexer.SLASH,
+	lexer.PERCENT_EQUALS: lexer.PERCENT,
+}
+
+// generateExpr emits the instructions evaluating an integer expression into the accumulator.
+func (g *Generator) generateExpr(expr ast.Expr) {
 	switch e := expr.(type) {
 	case *ast.NumberLiteralExpr:
-		g.emit("  mov w0, #%s", e.Value)
-		return "w0"
+		value, err := strconv.ParseInt(e.Value, 0, 32)
+		if err != nil {
+			panic(fmt.Sprintf("unhandled integer literal: %s", e.Value))
+		}
+		g.isa.loadInt(int32(value))
+	case *ast.BoolLiteralExpr:
+		if e.Value {
+			g.isa.loadInt(1)
+		} else {
+			g.isa.loadInt(0)
+		}
+	case *ast.UnitExpr:
+		// The unit value has no representation, so there is nothing to evaluate
+	case *ast.BlockExpr:
+		// The values of the statements are discarded, and the result expression is the value of the block
+		outerLocals := maps.Clone(g.locals)
+		for _, stmt := range e.Statements {
+			g.generateStmt(stmt)
+		}
+		g.generateExpr(e.ResultExpr)
+		g.locals = outerLocals
+	case *ast.IfExpr:
+		if cond, ok := e.Cond.(*ast.BoolLiteralExpr); ok {
+			if cond.Value {
+				g.generateExpr(e.Then)
+			} else {
+				g.generateExpr(e.Else)
+			}
+			return
+		}
+		labels := g.newLabels("else", "end")
+		g.generateExpr(e.Cond)
+		g.isa.branchIfZero(labels[0])
+		g.generateExpr(e.Then)
+		g.isa.jump(labels[1])
+		g.label(labels[0])
+		g.generateExpr(e.Else)
+		g.label(labels[1])
+	case *ast.StringLiteralExpr:
+		// The lexer keeps the literal as written in the source, in quotes and with the escapes
+		value, err := strconv.Unquote(e.Value)
+		if err != nil {
+			panic(fmt.Sprintf("unhandled string literal: %s", e.Value))
+		}
+		g.isa.loadAddress(g.internString(value))
+	case *ast.GroupExpr:
+		g.generateExpr(e.Expr)
+	case *ast.AscriptionExpr:
+		g.generateExpr(e.Expr)
+	case *ast.UnaryExpr:
+		g.generateExpr(e.Rhs)
+		switch e.Operator.Type {
+		case lexer.PLUS:
+			// No-op
+		case lexer.DASH:
+			g.isa.negate()
+		default:
+			panic(fmt.Sprintf("unhandled unary operator: %s", e.Operator.Value))
+		}
+	case *ast.BinaryExpr:
+		if e.Operator.Type == lexer.AND || e.Operator.Type == lexer.OR {
+			g.generateLogical(e)
+			return
+		}
+		g.generateExpr(e.Lhs)
+		g.isa.push()
+		g.generateExpr(e.Rhs)
+		g.isa.popOperands()
+		g.isa.binaryOp(e.Operator.Type)
+	case *ast.IdentExpr:
+		slot, ok := g.locals[e.Value]
+		if !ok {
+			panic(fmt.Sprintf("unhandled identifier: %s", e.Value))
+		}
+		g.isa.load(slot)
+	case *ast.AssignExpr:
+		assigne, ok := e.Assigne.(*ast.IdentExpr)
+		if !ok {
+			panic(fmt.Sprintf("unhandled assignee: %T", e.Assigne))
+		}
+		if operator, isCompound := compoundOperators[e.Operator.Type]; isCompound {
+			g.generateExpr(assigne)
+			g.isa.push()
+			g.generateExpr(e.AssignedValue)
+			g.isa.popOperands()
+			g.isa.binaryOp(operator)
+		} else {
+			g.generateExpr(e.AssignedValue)
+		}
+		g.isa.store(g.locals[assigne.Value])
+	case *ast.VarDeclAssignExpr:
+		g.generateExpr(e.AssignedValue)
+		slot := g.frame.slots[e]
+		g.isa.store(slot)
+		g.locals[e.Name] = slot
+	case *ast.FuncCallExpr:
+		callee, ok := e.Func.(*ast.IdentExpr)
+		if !ok {
+			panic(fmt.Sprintf("unhandled callee: %s", ast.Print(e.Func)))
+		}
+		if (callee.Value == "print" || callee.Value == "println") && !g.funcs[callee.Value] {
+			g.generatePrint(e.Args[0], callee.Value == "println")
+			return
+		}
+		// The omitted arguments take their default values, after the ones given
+		args := slices.Concat(e.Args, g.callDefaults[e])
+		if len(args) > maxParams {
+			panic(fmt.Sprintf("unhandled call with more than %d arguments: %s", maxParams, callee.Value))
+		}
+		// The arguments are evaluated from left to right, and saved on the stack until the call
+		for _, arg := range args {
+			g.generateExpr(arg)
+			g.isa.push()
+		}
+		g.isa.call(callee.Value, len(args))
 	default:
 		panic(fmt.Sprintf("unhandled expression type: %T", expr))
 	}
 }
 
-func GenerateModuleAsm(module *ast.BlockStmt) string {
-	g := &Generator{}
+// generateLogical emits a logical operator as a branch, which skips evaluating the right hand side if the
+// left hand side determines the result: false for `and`, and true for `or`.
+func (g *Generator) generateLogical(expr *ast.BinaryExpr) {
+	labels := g.newLabels("rhs", "end")
+	g.generateExpr(expr.Lhs)
+	if expr.Operator.Type == lexer.AND {
+		// The false left hand side in the accumulator is the result
+		g.isa.branchIfZero(labels[1])
+	} else {
+		g.isa.branchIfZero(labels[0])
+		g.isa.loadInt(1)
+		g.isa.jump(labels[1])
+		g.label(labels[0])
+	}
+	g.generateExpr(expr.Rhs)
+	g.label(labels[1])
+}
+
+// generatePrint emits a call of a built-in print function, which writes the string argument, followed by
+// a newline for println.
+func (g *Generator) generatePrint(arg ast.Expr, newline bool) {
+	g.usesPrint = true
+	g.generateExpr(arg)
+	g.isa.push()
+	g.isa.call(printSymbol, 1)
+	if newline {
+		g.isa.loadAddress(g.internString("\n"))
+		g.isa.push()
+		g.isa.call(printSymbol, 1)
+	}
+}
+
+// GenerateModuleAsm generates the assembly of the module for the target. The call defaults are the arguments
+// the type checker filled in for the parameters omitted from calls (see typechecker.CheckModule).
+func GenerateModuleAsm(module *ast.BlockStmt, callDefaults map[*ast.FuncCallExpr][]ast.Expr, target Target) string {
+	g := &Generator{stringLabels: map[string]string{}, funcs: map[string]bool{}, callDefaults: callDefaults}
+	g.isa = target.instructionSet(g)
 
+	for _, stmt := range module.Statements {
+		if fn, ok := stmt.(*ast.FuncDeclStmt); ok {
+			g.funcs[fn.Name] = true
+		}
+	}
+	g.isa.entryPoint()
 	for _, stmt := range module.Statements {
 		switch s := stmt.(type) {
 		case *ast.FuncDeclStmt:
@@ -72,55 +421,79 @@ func GenerateModuleAsm(module *ast.BlockStmt) string {
 			// TODO: other top-level statements
 		}
 	}
+	if g.usesPrint {
+		g.emit("")
+		g.isa.printFunction(printSymbol)
+	}
+	g.generateStrings()
 
 	return g.String()
 }
 
-func CompileAsm(assembly string, workingDir string, outputPath string) (err error) {
-	if workingDir == "" {
-		workingDir = "./"
+// runTool runs an external tool, including its combined output in the error if it fails.
+func runTool(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w\n%s", name, err, out)
 	}
+	return nil
+}
 
-	// Create a temporary `build/` directory (defer cleanup)
-	cmd := exec.Command("mkdir", "-p", fmt.Sprintf("%s/build", workingDir))
-	out, err := cmd.Output()
+// requireTools checks that the external tools needed for the purpose are found on PATH.
+func requireTools(purpose string, tools ...string) error {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%s is required for %s, but it was not found on PATH", tool, purpose)
+		}
+	}
+	return nil
+}
+
+// withBuildDir calls build with a temporary directory for the intermediate files, created within workingDir
+// (or the system temporary directory if empty), and removes the directory afterwards.
+func withBuildDir(workingDir string, build func(buildDir string) error) (err error) {
+	buildDir, err := os.MkdirTemp(workingDir, "cooper-build-")
 	if err != nil {
 		return fmt.Errorf("failed to create a temporary build directory: %w", err)
 	}
 	defer func() {
-		cmd = exec.Command("rm", "-rf", fmt.Sprintf("%s/build", workingDir))
-		out, err = cmd.Output()
-		if err != nil {
-			err = fmt.Errorf("failed to clean up temporary build directory: %s", err)
+		if removeErr := os.RemoveAll(buildDir); removeErr != nil && err == nil {
+			err = fmt.Errorf("failed to clean up the temporary build directory: %w", removeErr)
 		}
-		fmt.Printf("Cleaned up: %s", out)
 	}()
-	fmt.Printf("Created temporary build directory: %s", out)
-
-	// Write the assembly into a file (no unique name needed when it goes into an empty directory just created
-	os.WriteFile(fmt.Sprintf("%s/build/generated.s", workingDir), []byte(assembly), 0644)
+	return build(buildDir)
+}
 
-	// Generate the object file from the assembly
-	cmd = exec.Command("as", "-o", fmt.Sprintf("%s/build/generated.o", workingDir), fmt.Sprintf("%s/build/generated.s", workingDir))
-	out, err = cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to generate the object file: %w", err)
+// CompileAsm assembles and links the assembly for the target into an executable at outputPath (./main by default).
+// The intermediate files are written into a temporary directory created within workingDir (or the
+// system temporary directory if empty), which is removed afterwards.
+func CompileAsm(assembly string, target Target, workingDir string, outputPath string) error {
+	if err := requireTools("compiling for "+target.String(), target.tools()...); err != nil {
+		return err
 	}
-	fmt.Printf("Created object file: %s", out)
-
 	if outputPath == "" {
 		outputPath = "./main"
 	}
+	return withBuildDir(workingDir, func(buildDir string) error {
+		asmPath := filepath.Join(buildDir, "generated.s")
+		if err := os.WriteFile(asmPath, []byte(assembly), 0644); err != nil {
+			return fmt.Errorf("failed to write the assembly: %w", err)
+		}
 
-	// Link the executable (just using shell expansion here for this PoC impl)
-	shellCmd := fmt.Sprintf("ld -o %s %s/build/generated.o -lSystem -syslibroot `xcrun --show-sdk-path` -e _main -arch arm64",
-		outputPath, workingDir)
-	cmd = exec.Command("sh", "-c", shellCmd)
-	out, err = cmd.Output()
-	if err != nil {
-		return fmt.Errorf("linker error: %w", err)
-	}
-	fmt.Printf("Compiled: %s", out)
+		// Generate the object file from the assembly
+		objPath := filepath.Join(buildDir, "generated.o")
+		if err := runTool("as", "-o", objPath, asmPath); err != nil {
+			return fmt.Errorf("failed to generate the object file: %w", err)
+		}
 
-	return nil
+		// Link the executable
+		linkArgs, err := target.linkArgs(objPath, outputPath)
+		if err != nil {
+			return err
+		}
+		if err := runTool("ld", linkArgs...); err != nil {
+			return fmt.Errorf("linker error: %w", err)
+		}
+		return nil
+	})
 }
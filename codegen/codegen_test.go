@@ -1,36 +1,496 @@
 package codegen
 
 import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/ruistola/cooper/ast"
 	"github.com/ruistola/cooper/lexer"
 	"github.com/ruistola/cooper/parser"
 	"github.com/ruistola/cooper/typechecker"
 )
 
 func TestCodeGen(t *testing.T) {
+	target, ok := HostTarget()
+	if !ok {
+		t.Skipf("No code generation target for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
 	src := "func main(): i32 { return 69 }"
 
 	tokens := lexer.Tokenize(src)
 	module := parser.Parse(tokens)
 	errors := typechecker.Check(module)
 
-	if len(errors) > 0 {
+	if typechecker.HasErrors(errors) {
 		for _, err := range errors {
 			t.Log(err)
 		}
 		t.Fatal("typechecking failed")
 	}
 
-	asm := GenerateModuleAsm(module)
+	asm := GenerateModuleAsm(module, nil, target)
 	if asm == "" {
 		t.Fatal("GenerateProgram failed")
 	} else {
 		t.Logf("Generated assembly:\n%s", asm)
 	}
 
-	err := CompileAsm(asm, "./", "./test")
+	err := CompileAsm(asm, target, t.TempDir(), filepath.Join(t.TempDir(), "test"))
 	if err != nil {
 		t.Error("compile failed:", err)
 	}
 }
+
+// Compiles each program for the host target, runs it, and checks its exit code, which main returns
+func TestArithmetic(t *testing.T) {
+	exprs := []struct {
+		expr     string
+		expected int
+	}{
+		{"2 + 3 * 4", 14},
+		{"(2 + 3) * 4", 20},
+		{"-3 + 10", 7},
+		{"100 / 7 - 7 % 4", 11},
+		{"-7 / 2 + 10", 7},
+		{"-7 % 4 + 10", 7},
+		{"(-7) % 3 + 10", 9},
+		{"7 % (-3) + 10", 11},
+		{"0x10 + 0b11", 19},
+		{"70000 / 1000", 70},
+	}
+	testCases := []programCase{}
+	for _, tc := range exprs {
+		testCases = append(testCases, programCase{tc.expr, fmt.Sprintf("func main(): i32 { return %s }", tc.expr), tc.expected})
+	}
+	runCases(t, testCases)
+}
+
+// runProgram compiles the module for the host target and runs it, returning its exit code.
+func runProgram(t *testing.T, module *ast.BlockStmt, callDefaults typechecker.CallDefaults) int {
+	t.Helper()
+	target, ok := HostTarget()
+	if !ok {
+		t.Skipf("No code generation target for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	outputPath := filepath.Join(t.TempDir(), "main")
+	if err := CompileAsm(GenerateModuleAsm(module, callDefaults, target), target, t.TempDir(), outputPath); err != nil {
+		t.Fatal("compile failed:", err)
+	}
+	err := exec.Command(outputPath).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal("running the program failed:", err)
+	}
+	return 0
+}
+
+// programCase is a program, and the exit code its main function returns.
+type programCase struct {
+	name     string
+	src      string
+	expected int
+}
+
+// runCases type checks each program, and runs it for the host target in a subtest of its own,
+// checking its exit code.
+func runCases(t *testing.T, testCases []programCase) {
+	t.Helper()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			module := parser.Parse(lexer.Tokenize(tc.src))
+			_, callDefaults, errors := typechecker.CheckModule(module, typechecker.Config{})
+			if typechecker.HasErrors(errors) {
+				t.Fatalf("typechecking failed: %v", errors)
+			}
+			if exitCode := runProgram(t, module, callDefaults); exitCode != tc.expected {
+				t.Errorf("Expected exit code %d, got %d", tc.expected, exitCode)
+			}
+		})
+	}
+}
+
+// Only the branch taken by an if- statement with a constant condition is emitted
+func TestConstantConditionPruning(t *testing.T) {
+	stmts := []struct {
+		stmt     string
+		expected int
+	}{
+		{"if false then crash() else return 0", 0},
+		{"if true then return 3 else crash()", 3},
+		{"if (2 * 3 > 5) then return 4 else crash()", 4},
+		{"if 10 / 2 <= 4 then crash()", 1},
+	}
+	testCases := []programCase{}
+	for _, tc := range stmts {
+		src := fmt.Sprintf("func crash(): i32 {\n  let zero: i32 = 0\n  return 1 / zero\n}\nfunc main(): i32 {\n  %s\n  return 1\n}", tc.stmt)
+		testCases = append(testCases, programCase{tc.stmt, src, tc.expected})
+	}
+	runCases(t, testCases)
+	for _, tc := range testCases {
+		t.Run(tc.name+" is pruned", func(t *testing.T) {
+			module := parser.Parse(lexer.Tokenize(tc.src))
+			literalTypes, _, errors := typechecker.CheckModule(module, typechecker.Config{})
+			if errors = append(errors, typechecker.FoldConstants(module, literalTypes)...); typechecker.HasErrors(errors) {
+				t.Fatalf("typechecking failed: %v", errors)
+			}
+			for _, target := range []Target{TargetArm64Darwin, TargetAmd64Linux, TargetArm64Linux} {
+				asm := GenerateModuleAsm(module, nil, target)
+				if strings.Contains(asm, "  bl _crash") || strings.Contains(asm, "  bl crash") || strings.Contains(asm, "  call crash") {
+					t.Errorf("The dead branch was emitted for %s:\n%s", target, asm)
+				}
+			}
+		})
+	}
+	t.Run("dead branch is type checked", func(t *testing.T) {
+		src := "func main(): i32 {\n  if false then return true else return 0\n}"
+		if errors := typechecker.Check(parser.Parse(lexer.Tokenize(src))); len(errors) == 0 {
+			t.Error("Expected a type error in the dead branch")
+		}
+	})
+}
+
+// The right hand side of a logical operator is evaluated only if the left hand side doesn't determine the result
+func TestShortCircuit(t *testing.T) {
+	crash := "func crash(): bool {\n  let zero: i32 = 0\n  return 1 / zero == 0\n}\n"
+	testCases := []programCase{
+		{
+			"and skips the right hand side",
+			"func main(): i32 {\n  let x: i32 = 1\n  let b: bool = x > 5 and { x = 10; true }\n  return x\n}",
+			1,
+		},
+		{
+			"and evaluates the right hand side",
+			"func main(): i32 {\n  let x: i32 = 1\n  let b: bool = x < 5 and { x = 10; true }\n  return if b then x else 0\n}",
+			10,
+		},
+		{
+			"or skips the right hand side",
+			"func main(): i32 {\n  let x: i32 = 1\n  let b: bool = x < 5 or { x = 10; false }\n  return if b then x else 0\n}",
+			1,
+		},
+		{
+			"or evaluates the right hand side",
+			"func main(): i32 {\n  let x: i32 = 1\n  let b: bool = x > 5 or { x = 10; false }\n  return if b then 0 else x\n}",
+			10,
+		},
+		{
+			"skipped call",
+			crash + "func main(): i32 {\n  let x: i32 = 1\n  if x > 5 and crash() then return 2\n  if x < 5 or crash() then return 3\n  return 4\n}",
+			3,
+		},
+		{
+			"nested",
+			"func main(): i32 {\n  let x: i32 = 10\n  return if x < 5 and x > 0 or x == 10 then 7 else 8\n}",
+			7,
+		},
+	}
+	runCases(t, testCases)
+}
+
+func TestLocalVariables(t *testing.T) {
+	testCases := []programCase{
+		{
+			"sum of locals",
+			"func main(): i32 { let a: i32 = 40; let b: i32 = 2; return a + b }",
+			42,
+		},
+		{
+			"assignment",
+			"func main(): i32 {\n  let a: i32 = 1\n  a = a + 9\n  a += a * 2\n  a -= 5\n  return a\n}",
+			25,
+		},
+		{
+			"i8 is truncated when stored",
+			"func main(): i8 {\n  let c: i8 = (100: i8)\n  c += (100: i8)\n  return c + (100: i8)\n}",
+			44,
+		},
+		{
+			"i64 is not truncated",
+			"func main(): i64 {\n  let big: i64 = (65536: i64)\n  big = big * big\n  return big / (65536: i64) / (65536: i64) + (2: i64)\n}",
+			3,
+		},
+		{
+			"shadowing in a block",
+			"func main(): i32 {\n  let a: i32 = 1\n  let b: i32 = 2\n  if true then {\n    let a: i32 = 10\n    b = a + b\n  }\n  return a + b\n}",
+			13,
+		},
+		{
+			"zeroed without an initial value",
+			"func main(): i32 {\n  let a: i32\n  return a + 5\n}",
+			5,
+		},
+	}
+	runCases(t, testCases)
+}
+
+func TestFunctionCalls(t *testing.T) {
+	testCases := []programCase{
+		{
+			"helper function",
+			"func add(x: i32, y: i32): i32 { return x + y }\nfunc main(): i32 { return add(40, 2) }",
+			42,
+		},
+		{
+			"arguments in order",
+			"func sub(x: i32, y: i32): i32 { return x - y }\nfunc main(): i32 { return sub(50, 8) }",
+			42,
+		},
+		{
+			"nested calls",
+			"func add(x: i32, y: i32): i32 { return x + y }\nfunc main(): i32 { return add(add(1, 2), add(3, 4)) * 2 }",
+			20,
+		},
+		{
+			"eight parameters",
+			"func f(a: i32, b: i32, c: i32, d: i32, e: i32, f: i32, g: i32, h: i32): i32 {\n  return a - b + c * d - e + f * g - h\n}\nfunc main(): i32 { return f(1, 2, 3, 4, 5, 6, 7, 8) }",
+			40,
+		},
+		{
+			"parameters are locals",
+			"func twice(x: i32): i32 {\n  let y: i32 = x\n  x += y\n  return x\n}\nfunc main(): i32 {\n  let x: i32 = 5\n  return twice(x + 1) + x\n}",
+			17,
+		},
+		{
+			"default arguments",
+			"func scale(x: i32, factor: i32 = 2, offset: i32 = -1): i32 { return x * factor + offset }\nfunc main(): i32 { return scale(10) + scale(10, 3) + scale(1, 1, 1) }",
+			50,
+		},
+		{
+			"i8 parameter",
+			"func byte(c: i8): i8 { return c + (50: i8) }\nfunc main(): i8 { return byte((-8: i8)) }",
+			42,
+		},
+		{
+			"i64 parameter",
+			"func wide(big: i64, n: i64): i64 { return big / n }\nfunc main(): i64 { return wide((65536: i64) * (65536: i64), (100000000: i64)) }",
+			42,
+		},
+	}
+	runCases(t, testCases)
+}
+
+// Each function is emitted under its own label, and called with the instruction of the target
+func TestCallSequence(t *testing.T) {
+	src := "func double(x: i32): i32 { return x * 2 } func main(): i32 { return double(21) }"
+	module := parser.Parse(lexer.Tokenize(src))
+	if errors := typechecker.Check(module); typechecker.HasErrors(errors) {
+		t.Fatalf("typechecking failed: %v", errors)
+	}
+	testCases := []struct {
+		target   Target
+		label    string
+		argument string
+		call     string
+	}{
+		{TargetArm64Darwin, "_double:", "  ldr x0, [sp, #0]", "  bl _double"},
+		{TargetAmd64Linux, "double:", "  movq 0(%rsp), %rdi", "  call double"},
+		{TargetArm64Linux, "double:", "  ldr x0, [sp, #0]", "  bl double"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.target.String(), func(t *testing.T) {
+			asm := GenerateModuleAsm(module, nil, tc.target)
+			for _, expected := range []string{tc.label, tc.argument, tc.call} {
+				if !strings.Contains(asm, "\n"+expected+"\n") {
+					t.Errorf("Expected %q in the assembly:\n%s", expected, asm)
+				}
+			}
+		})
+	}
+	if exitCode := runProgram(t, module, nil); exitCode != 42 {
+		t.Errorf("Expected exit code 42, got %d", exitCode)
+	}
+}
+
+// Recursive calls each get a stack frame of their own, with the frame pointer and the return address saved
+func TestRecursion(t *testing.T) {
+	testCases := []programCase{
+		{
+			"fibonacci",
+			"func fib(n: i32): i32 { return if n < 2 then n else fib(n-1) + fib(n-2) }\nfunc main(): i32 { return fib(10) }",
+			55,
+		},
+		{
+			"factorial",
+			"func fact(n: i32): i32 {\n  if n <= 1 then return 1\n  let rest: i32 = fact(n - 1)\n  return n * rest\n}\nfunc main(): i32 { return fact(5) }",
+			120,
+		},
+		{
+			"mutual recursion",
+			"func even(n: i32): bool { return if n > 0 then odd(n - 1) else true }\n" +
+				"func odd(n: i32): bool { return if n > 0 then even(n - 1) else false }\n" +
+				"func main(): i32 { return if even(10) then if odd(7) then 3 else 2 else 1 }",
+			3,
+		},
+		{
+			"if- statement with else",
+			"func sign(n: i32): i32 {\n  let s: i32\n  if n < 0 then s = -1 else if n > 0 then s = 1\n  return s\n}\nfunc main(): i32 { return sign(-5) + sign(7) * 10 + sign(0) * 100 + 20 }",
+			29,
+		},
+	}
+	runCases(t, testCases)
+}
+
+// Each distinct string literal is emitted once into a read-only section, with the escapes re-encoded
+func TestStringLiterals(t *testing.T) {
+	src := `func main(): i32 {
+  let greeting: string = "Hello, \"World\"!\n"
+  let repeated: string = "Hello, \"World\"!\n"
+  let other: string = "tab\there\x7f\\"
+  return 0
+}`
+	module := parser.Parse(lexer.Tokenize(src))
+	if errors := typechecker.Check(module); typechecker.HasErrors(errors) {
+		t.Fatalf("typechecking failed: %v", errors)
+	}
+	testCases := []struct {
+		target  Target
+		section string
+		label   string
+		address string
+	}{
+		{TargetArm64Darwin, ".section __TEXT,__cstring,cstring_literals", "L_.str.0", "  adrp x0, L_.str.0@PAGE"},
+		{TargetAmd64Linux, ".section .rodata", ".L.str.0", "  leaq .L.str.0(%rip), %rax"},
+		{TargetArm64Linux, ".section .rodata", ".L.str.0", "  adrp x0, .L.str.0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.target.String(), func(t *testing.T) {
+			asm := GenerateModuleAsm(module, nil, tc.target)
+			expectedData := fmt.Sprintf("%s\n%s:\n  .asciz \"Hello, \\\"World\\\"!\\n\"\n", tc.section, tc.label)
+			if !strings.Contains(asm, expectedData) {
+				t.Errorf("Expected %q in the assembly:\n%s", expectedData, asm)
+			}
+			if !strings.Contains(asm, `  .asciz "tab\there\177\\"`) {
+				t.Errorf("Expected the second literal with its escapes re-encoded in the assembly:\n%s", asm)
+			}
+			if count := strings.Count(asm, tc.label+":"); count != 1 {
+				t.Errorf("Expected the repeated literal to be emitted once, got %d times", count)
+			}
+			if count := strings.Count(asm, tc.address+"\n"); count != 2 {
+				t.Errorf("Expected both occurrences of the repeated literal to refer to its label, got %d", count)
+			}
+		})
+	}
+	if exitCode := runProgram(t, module, nil); exitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d", exitCode)
+	}
+}
+
+// The built-in print functions write their string argument to the standard output, and a function declared
+// by the module by the same name is called instead
+func TestPrint(t *testing.T) {
+	target, ok := HostTarget()
+	if !ok {
+		t.Skipf("No code generation target for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+		exitCode int
+	}{
+		{
+			"hello world",
+			"func main(): i32 {\n  println(\"Hello, World!\")\n  return 0\n}",
+			"Hello, World!\n",
+			0,
+		},
+		{
+			"string variables",
+			"func greet(name: string) {\n  print(\"Hello, \")\n  print(name)\n  println(\"!\")\n}\nfunc main(): i32 {\n  let name: string = \"Cooper\"\n  greet(name)\n  println(\"\")\n  return 0\n}",
+			"Hello, Cooper!\n\n",
+			0,
+		},
+		{
+			"shadowed",
+			"func print(s: string): i32 {\n  return 3\n}\nfunc main(): i32 {\n  println(\"line\")\n  return print(\"ignored\")\n}",
+			"line\n",
+			3,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			module := parser.Parse(lexer.Tokenize(tc.src))
+			if errors := typechecker.Check(module); typechecker.HasErrors(errors) {
+				t.Fatalf("typechecking failed: %v", errors)
+			}
+			outputPath := filepath.Join(t.TempDir(), "main")
+			if err := CompileAsm(GenerateModuleAsm(module, nil, target), target, t.TempDir(), outputPath); err != nil {
+				t.Fatal("compile failed:", err)
+			}
+			output, err := exec.Command(outputPath).Output()
+			exitCode := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal("running the program failed:", err)
+			}
+			if exitCode != tc.exitCode {
+				t.Errorf("Expected exit code %d, got %d", tc.exitCode, exitCode)
+			}
+			if string(output) != tc.expected {
+				t.Errorf("Expected the output %q, got %q", tc.expected, output)
+			}
+		})
+	}
+}
+
+// The remainder computed at runtime has the sign of the dividend, like the one computed by the constant folder
+func TestModuloSign(t *testing.T) {
+	testCases := []struct {
+		expr     string
+		expected int
+	}{
+		{"(-7) % 3", -1},
+		{"7 % (-3)", 1},
+		{"(-7) % (-3)", -1},
+		{"7 % 3", 1},
+		{"(-7) / 2", -3},
+	}
+	programs := []programCase{}
+	for _, tc := range testCases {
+		src := fmt.Sprintf("func main(): i32 { return %s + 10 }", tc.expr)
+		programs = append(programs, programCase{tc.expr, src, tc.expected + 10})
+	}
+	runCases(t, programs)
+}
+
+func TestMissingToolchain(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	err := CompileAsm("", TargetAmd64Linux, t.TempDir(), filepath.Join(t.TempDir(), "main"))
+	if err == nil || !strings.Contains(err.Error(), "as is required for compiling for amd64-linux, but it was not found on PATH") {
+		t.Errorf("Expected an error about the missing assembler, got %v", err)
+	}
+}
+
+func TestGeneratorEmit(t *testing.T) {
+	g := &Generator{}
+	g.emit(".global _%s", "main")
+	g.emit("")
+	g.emit("  mov w0, #%d", 42)
+	g.emit("ret")
+	expected := ".global _main\n\n  mov w0, #42\nret\n"
+	if asm := g.String(); asm != expected {
+		t.Errorf("Expected assembly %q, got %q", expected, asm)
+	}
+}
+
+func TestBlockExpressions(t *testing.T) {
+	testCases := []programCase{
+		{
+			"result expression is the value",
+			"func main(): i32 {\n  let x: i32 = { let a: i32 = 40; a + 2 }\n  return x\n}",
+			42,
+		},
+		{
+			"discarded expression still runs",
+			"func add(a: i32, b: i32): i32 {\n  return a + b\n}\nfunc main(): i32 {\n  let total: i32 = 1\n  let x: i32 = {\n    total = add(total, 40);\n    1\n  }\n  {\n    total = add(total, x);\n  }\n  return total\n}",
+			42,
+		},
+	}
+	runCases(t, testCases)
+}
@@ -0,0 +1,73 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/ast"
+)
+
+// frameSlot is the location of a local variable in the stack frame of a function, as an offset from
+// the bottom of the frame, and its size in bytes.
+type frameSlot struct {
+	offset int
+	size   int
+}
+
+// frameLayout assigns a stack slot to each parameter and local variable of a function, aligning each
+// slot to its size. Variables declared in different blocks get separate slots even if their lifetimes
+// don't overlap.
+type frameLayout struct {
+	slots map[ast.Node]frameSlot // Slots by the declaring TypedIdent parameter, VarDeclStmt or VarDeclAssignExpr
+	size  int
+}
+
+// typeSize returns the size in bytes of a value of the type.
+func typeSize(typeExpr ast.TypeExpr) int {
+	if named, ok := typeExpr.(*ast.NamedTypeExpr); ok {
+		switch named.TypeName {
+		case "bool", "i8":
+			return 1
+		case "i32":
+			return 4
+		case "i64":
+			return 8
+		case "string":
+			// The address of the string
+			return 8
+		}
+	}
+	panic(fmt.Sprintf("unhandled type of a local variable: %s", ast.Print(typeExpr)))
+}
+
+func newFrameLayout(fn *ast.FuncDeclStmt) *frameLayout {
+	layout := &frameLayout{slots: map[ast.Node]frameSlot{}}
+	// The arguments are copied into the frame from the registers they are passed in
+	for _, param := range fn.Parameters {
+		layout.allocate(param, typeSize(param.Type))
+	}
+	ast.Walk(fn.Body, layout)
+	// Keep the stack pointer 16 byte aligned, as required by both arm64 and the System V ABI
+	layout.size = (layout.size + 15) &^ 15
+	return layout
+}
+
+func (l *frameLayout) allocate(decl ast.Node, size int) {
+	offset := (l.size + size - 1) / size * size
+	l.slots[decl] = frameSlot{offset: offset, size: size}
+	l.size = offset + size
+}
+
+func (l *frameLayout) Visit(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.VarDeclStmt:
+		l.allocate(n, typeSize(n.Var.Type))
+	case *ast.VarDeclAssignExpr:
+		// The type of the variable is inferred, so reserve room for the widest integer
+		l.allocate(n, 8)
+	case *ast.FuncDeclStmt:
+		// Nested functions have frames of their own
+		return false
+	}
+	return true
+}
+
+func (l *frameLayout) Leave(node ast.Node) {}
@@ -0,0 +1,455 @@
+package codegen
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// irGenerator lowers the AST into textual LLVM IR, leaving the instruction selection to LLVM.
+// Local variables and parameters live in stack slots allocated in the entry block of each function,
+// and LLVM promotes them into SSA registers. The instructions of the current function are emitted
+// into the buffer of the embedded Generator, and moved into the module once the function is complete.
+//
+// Pointers are typed (e.g. `i32*`), as the opaque `ptr` type is not supported by LLVM 14.
+type irGenerator struct {
+	Generator
+	module     strings.Builder
+	funcs      map[string]*ast.FuncDeclStmt // Functions of the module, by name
+	locals     map[string]irValue           // Stack slots of the variables in scope, by name
+	allocas    []string                     // Stack slot allocations of the current function
+	returnType string                       // LLVM return type of the current function
+	nextId     int
+}
+
+// irValue is a typed LLVM value: a constant, a register, or the pointer to the stack slot of a variable.
+// A number literal is untyped, i.e. it takes the type of the other operand, or of the variable it's stored into.
+type irValue struct {
+	typ     string
+	ref     string
+	untyped bool
+}
+
+// irType returns the LLVM type of a Cooper type.
+func irType(typeExpr ast.TypeExpr) string {
+	switch t := typeExpr.(type) {
+	case nil, *ast.UnitTypeExpr:
+		return "void"
+	case *ast.NamedTypeExpr:
+		switch t.TypeName {
+		case "bool":
+			return "i1"
+		case "i8", "i32", "i64":
+			return t.TypeName
+		}
+	}
+	panic(fmt.Sprintf("unhandled type in LLVM IR generation: %s", ast.Print(typeExpr)))
+}
+
+// id returns a number for naming a register or a basic block uniquely.
+func (g *irGenerator) id() int {
+	g.nextId++
+	return g.nextId
+}
+
+// label starts a new basic block.
+func (g *irGenerator) label(name string) {
+	g.emit("%s:", name)
+}
+
+// declareLocal allocates a stack slot for a variable in scope from here on.
+func (g *irGenerator) declareLocal(name string, typ string) irValue {
+	slot := irValue{typ: typ, ref: fmt.Sprintf("%%%s.%d", name, g.id())}
+	g.allocas = append(g.allocas, fmt.Sprintf("  %s = alloca %s", slot.ref, typ))
+	g.locals[name] = slot
+	return slot
+}
+
+func (g *irGenerator) store(slot irValue, value irValue) {
+	g.emit("  store %s %s, %s* %s", slot.typ, value.ref, slot.typ, slot.ref)
+}
+
+// load reads the value of a stack slot into a new register.
+func (g *irGenerator) load(slot irValue) irValue {
+	result := fmt.Sprintf("%%t%d", g.id())
+	g.emit("  %s = load %s, %s* %s", result, slot.typ, slot.typ, slot.ref)
+	return irValue{typ: slot.typ, ref: result}
+}
+
+// convert truncates or sign extends an integer to the given type. An untyped number literal takes the type
+// as is, as the type checker has verified that it fits.
+func (g *irGenerator) convert(value irValue, typ string) irValue {
+	if value.untyped || value.typ == typ {
+		return irValue{typ: typ, ref: value.ref}
+	}
+	fromBits, _ := strconv.Atoi(strings.TrimPrefix(value.typ, "i"))
+	toBits, _ := strconv.Atoi(strings.TrimPrefix(typ, "i"))
+	instruction := "sext"
+	if toBits < fromBits {
+		instruction = "trunc"
+	}
+	result := fmt.Sprintf("%%t%d", g.id())
+	g.emit("  %s = %s %s %s to %s", result, instruction, value.typ, value.ref, typ)
+	return irValue{typ: typ, ref: result}
+}
+
+func (g *irGenerator) generateFunction(fn *ast.FuncDeclStmt) {
+	if fn.Receiver != nil {
+		panic(fmt.Sprintf("unhandled method in LLVM IR generation: %s", fn.Name))
+	}
+	if len(fn.Parameters) > 0 && fn.Parameters[len(fn.Parameters)-1].Variadic {
+		panic(fmt.Sprintf("unhandled variadic function in LLVM IR generation: %s", fn.Name))
+	}
+	g.returnType = irType(fn.ReturnType)
+	g.locals = map[string]irValue{}
+	g.allocas = []string{}
+
+	params := []string{}
+	for _, param := range fn.Parameters {
+		paramType := irType(param.Type)
+		params = append(params, fmt.Sprintf("%s %%%s", paramType, param.Name))
+		g.store(g.declareLocal(param.Name, paramType), irValue{typ: paramType, ref: "%" + param.Name})
+	}
+	g.generateStmt(fn.Body)
+	// The body of a function returning a value ends in a return statement, so the last block is unreachable
+	if g.returnType == "void" {
+		g.emit("  ret void")
+	} else {
+		g.emit("  unreachable")
+	}
+
+	fmt.Fprintf(&g.module, "define %s @%s(%s) {\n", g.returnType, fn.Name, strings.Join(params, ", "))
+	g.module.WriteString("entry:\n")
+	for _, alloca := range g.allocas {
+		g.module.WriteString(alloca + "\n")
+	}
+	g.module.WriteString(g.String())
+	g.module.WriteString("}\n\n")
+	g.buf.Reset()
+}
+
+func (g *irGenerator) generateStmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		outerLocals := maps.Clone(g.locals)
+		for _, stmt := range s.Statements {
+			g.generateStmt(stmt)
+		}
+		g.locals = outerLocals
+	case *ast.ExpressionStmt:
+		g.generateExpr(s.Expr)
+	case *ast.VarDeclStmt:
+		value := irValue{ref: "0"} // Variables declared without a value are zeroed
+		if s.InitVal != nil {
+			value = g.generateExpr(s.InitVal)
+		}
+		g.store(g.declareLocal(s.Var.Name, irType(s.Var.Type)), value)
+	case *ast.ReturnStmt:
+		if s.Expr == nil {
+			g.emit("  ret void")
+		} else if value := g.generateExpr(s.Expr); value.typ == "void" {
+			g.emit("  ret void")
+		} else {
+			g.emit("  ret %s %s", g.returnType, value.ref)
+		}
+		// Any instructions following the return go into an unreachable block
+		g.label(fmt.Sprintf("dead.%d", g.id()))
+	case *ast.IfStmt:
+		id := g.id()
+		cond := g.generateExpr(s.Cond)
+		elseLabel := fmt.Sprintf("else.%d", id)
+		if s.Else == nil {
+			elseLabel = fmt.Sprintf("end.%d", id)
+		}
+		g.emit("  br i1 %s, label %%then.%d, label %%%s", cond.ref, id, elseLabel)
+		g.label(fmt.Sprintf("then.%d", id))
+		g.generateStmt(s.Then)
+		g.emit("  br label %%end.%d", id)
+		if s.Else != nil {
+			g.label(elseLabel)
+			g.generateStmt(s.Else)
+			g.emit("  br label %%end.%d", id)
+		}
+		g.label(fmt.Sprintf("end.%d", id))
+	case *ast.ForStmt:
+		// The condition is evaluated before each iteration, and the iter clause after each execution of the body
+		id := g.id()
+		outerLocals := maps.Clone(g.locals)
+		g.generateStmt(s.Init)
+		g.emit("  br label %%cond.%d", id)
+		g.label(fmt.Sprintf("cond.%d", id))
+		cond := g.generateExpr(s.Cond)
+		g.emit("  br i1 %s, label %%body.%d, label %%end.%d", cond.ref, id, id)
+		g.label(fmt.Sprintf("body.%d", id))
+		g.generateStmt(s.Body)
+		g.generateStmt(s.Iter)
+		g.emit("  br label %%cond.%d", id)
+		g.label(fmt.Sprintf("end.%d", id))
+		g.locals = outerLocals
+	default:
+		panic(fmt.Sprintf("unhandled statement type in LLVM IR generation: %T", stmt))
+	}
+}
+
+// irArithmetic returns the LLVM instruction of an arithmetic operator, or of a compound assignment.
+func irArithmetic(tokenType lexer.TokenType) (string, bool) {
+	switch tokenType {
+	case lexer.PLUS, lexer.PLUS_EQUALS:
+		return "add", true
+	case lexer.DASH, lexer.DASH_EQUALS:
+		return "sub", true
+	case lexer.STAR, lexer.STAR_EQUALS:
+		return "mul", true
+	case lexer.SLASH, lexer.SLASH_EQUALS:
+		return "sdiv", true
+	case lexer.PERCENT, lexer.PERCENT_EQUALS:
+		return "srem", true
+	default:
+		return "", false
+	}
+}
+
+// irComparison returns the condition of the LLVM icmp instruction of a comparison operator.
+func irComparison(tokenType lexer.TokenType) (string, bool) {
+	switch tokenType {
+	case lexer.DOUBLE_EQUALS:
+		return "eq", true
+	case lexer.NOT_EQUALS:
+		return "ne", true
+	case lexer.LESS:
+		return "slt", true
+	case lexer.LESS_EQUALS:
+		return "sle", true
+	case lexer.GREATER:
+		return "sgt", true
+	case lexer.GREATER_EQUALS:
+		return "sge", true
+	default:
+		return "", false
+	}
+}
+
+// generateBinary emits an arithmetic or a comparison instruction, taking the operand type from
+// the typed operand if the other one is an untyped number literal.
+func (g *irGenerator) generateBinary(operator lexer.Token, lhs irValue, rhs irValue) irValue {
+	operandType := lhs.typ
+	if lhs.untyped {
+		operandType = rhs.typ
+	}
+	result := fmt.Sprintf("%%t%d", g.id())
+	if instruction, ok := irArithmetic(operator.Type); ok {
+		g.emit("  %s = %s %s %s, %s", result, instruction, operandType, lhs.ref, rhs.ref)
+		return irValue{typ: operandType, ref: result}
+	}
+	if cond, ok := irComparison(operator.Type); ok {
+		g.emit("  %s = icmp %s %s %s, %s", result, cond, operandType, lhs.ref, rhs.ref)
+		return irValue{typ: "i1", ref: result}
+	}
+	panic(fmt.Sprintf("unhandled binary operator in LLVM IR generation: %s", operator.Value))
+}
+
+// generatePower emits an exponentiation as a loop multiplying the base exponent times. A negative
+// exponent results in 1, as the loop runs while the remaining exponent is positive. The operand type
+// is taken like in generateBinary, and the result and the remaining exponent live in stack slots.
+func (g *irGenerator) generatePower(base irValue, exponent irValue) irValue {
+	operandType := base.typ
+	if base.untyped {
+		operandType = exponent.typ
+	}
+	id := g.id()
+	result := irValue{typ: operandType, ref: fmt.Sprintf("%%power.%d", id)}
+	remaining := irValue{typ: operandType, ref: fmt.Sprintf("%%exponent.%d", id)}
+	g.allocas = append(g.allocas,
+		fmt.Sprintf("  %s = alloca %s", result.ref, operandType),
+		fmt.Sprintf("  %s = alloca %s", remaining.ref, operandType))
+	g.store(result, irValue{ref: "1"})
+	g.store(remaining, exponent)
+	g.emit("  br label %%powcond.%d", id)
+	g.label(fmt.Sprintf("powcond.%d", id))
+	count := g.load(remaining)
+	positive := fmt.Sprintf("%%t%d", g.id())
+	g.emit("  %s = icmp sgt %s %s, 0", positive, operandType, count.ref)
+	g.emit("  br i1 %s, label %%powbody.%d, label %%powend.%d", positive, id, id)
+	g.label(fmt.Sprintf("powbody.%d", id))
+	g.store(result, g.generateBinary(lexer.Token{Type: lexer.STAR, Value: "*"}, g.load(result), base))
+	g.store(remaining, g.generateBinary(lexer.Token{Type: lexer.DASH, Value: "-"}, count, irValue{ref: "1", untyped: true}))
+	g.emit("  br label %%powcond.%d", id)
+	g.label(fmt.Sprintf("powend.%d", id))
+	return g.load(result)
+}
+
+// generateLogical emits a logical operator as a conditional branch, which skips evaluating the right hand side
+// if the left hand side determines the result: false for `and`, and true for `or`. The result is stored in a
+// stack slot on both paths, like a variable.
+func (g *irGenerator) generateLogical(expr *ast.BinaryExpr) irValue {
+	id := g.id()
+	slot := irValue{typ: "i1", ref: fmt.Sprintf("%%logical.%d", id)}
+	g.allocas = append(g.allocas, fmt.Sprintf("  %s = alloca i1", slot.ref))
+	lhs := g.generateExpr(expr.Lhs)
+	g.store(slot, lhs)
+	if expr.Operator.Type == lexer.AND {
+		g.emit("  br i1 %s, label %%rhs.%d, label %%end.%d", lhs.ref, id, id)
+	} else {
+		g.emit("  br i1 %s, label %%end.%d, label %%rhs.%d", lhs.ref, id, id)
+	}
+	g.label(fmt.Sprintf("rhs.%d", id))
+	g.store(slot, g.generateExpr(expr.Rhs))
+	g.emit("  br label %%end.%d", id)
+	g.label(fmt.Sprintf("end.%d", id))
+	result := fmt.Sprintf("%%t%d", g.id())
+	g.emit("  %s = load i1, i1* %s", result, slot.ref)
+	return irValue{typ: "i1", ref: result}
+}
+
+func (g *irGenerator) generateExpr(expr ast.Expr) irValue {
+	switch e := expr.(type) {
+	case *ast.UnitExpr:
+		return irValue{typ: "void"}
+	case *ast.BlockExpr:
+		// The values of the statements are discarded, and the result expression is the value of the block
+		outerLocals := maps.Clone(g.locals)
+		for _, stmt := range e.Statements {
+			g.generateStmt(stmt)
+		}
+		result := g.generateExpr(e.ResultExpr)
+		g.locals = outerLocals
+		return result
+	case *ast.NumberLiteralExpr:
+		value, err := strconv.ParseInt(e.Value, 0, 64)
+		if err != nil {
+			panic(fmt.Sprintf("unhandled integer literal: %s", e.Value))
+		}
+		return irValue{typ: "i32", ref: strconv.FormatInt(value, 10), untyped: true}
+	case *ast.BoolLiteralExpr:
+		return irValue{typ: "i1", ref: strconv.FormatBool(e.Value)}
+	case *ast.IdentExpr:
+		slot, ok := g.locals[e.Value]
+		if !ok {
+			panic(fmt.Sprintf("unhandled identifier in LLVM IR generation: %s", e.Value))
+		}
+		return g.load(slot)
+	case *ast.GroupExpr:
+		return g.generateExpr(e.Expr)
+	case *ast.AscriptionExpr:
+		return g.convert(g.generateExpr(e.Expr), irType(e.Type))
+	case *ast.UnaryExpr:
+		rhs := g.generateExpr(e.Rhs)
+		switch e.Operator.Type {
+		case lexer.PLUS:
+			return rhs
+		case lexer.DASH:
+			if rhs.untyped {
+				// Keep a negated literal untyped by negating the constant itself
+				value, _ := strconv.ParseInt(rhs.ref, 10, 64)
+				return irValue{typ: rhs.typ, ref: strconv.FormatInt(-value, 10), untyped: true}
+			}
+			return g.generateBinary(lexer.Token{Type: lexer.DASH, Value: "-"}, irValue{ref: "0", untyped: true}, rhs)
+		default:
+			panic(fmt.Sprintf("unhandled unary operator in LLVM IR generation: %s", e.Operator.Value))
+		}
+	case *ast.BinaryExpr:
+		if e.Operator.Type == lexer.AND || e.Operator.Type == lexer.OR {
+			return g.generateLogical(e)
+		}
+		if e.Operator.Type == lexer.CHEVRON {
+			return g.generatePower(g.generateExpr(e.Lhs), g.generateExpr(e.Rhs))
+		}
+		return g.generateBinary(e.Operator, g.generateExpr(e.Lhs), g.generateExpr(e.Rhs))
+	case *ast.AssignExpr:
+		assigne, ok := e.Assigne.(*ast.IdentExpr)
+		if !ok {
+			panic(fmt.Sprintf("unhandled assignee in LLVM IR generation: %T", e.Assigne))
+		}
+		slot := g.locals[assigne.Value]
+		value := g.generateExpr(e.AssignedValue)
+		if e.Operator.Type != lexer.EQUALS {
+			// A compound assignment applies the operator on the current value
+			value = g.generateBinary(e.Operator, g.generateExpr(assigne), value)
+		}
+		g.store(slot, value)
+		return irValue{typ: "void"}
+	case *ast.VarDeclAssignExpr:
+		value := g.generateExpr(e.AssignedValue)
+		g.store(g.declareLocal(e.Name, value.typ), value)
+		return irValue{typ: "void"}
+	case *ast.FuncCallExpr:
+		callee, ok := e.Func.(*ast.IdentExpr)
+		if !ok || g.funcs[callee.Value] == nil {
+			panic(fmt.Sprintf("unhandled callee in LLVM IR generation: %s", ast.Print(e.Func)))
+		}
+		fn := g.funcs[callee.Value]
+		args := []string{}
+		for i, arg := range slices.Concat(e.Args, g.callDefaults[e]) {
+			args = append(args, fmt.Sprintf("%s %s", irType(fn.Parameters[i].Type), g.generateExpr(arg).ref))
+		}
+		returnType := irType(fn.ReturnType)
+		if returnType == "void" {
+			g.emit("  call void @%s(%s)", fn.Name, strings.Join(args, ", "))
+			return irValue{typ: "void"}
+		}
+		result := fmt.Sprintf("%%t%d", g.id())
+		g.emit("  %s = call %s @%s(%s)", result, returnType, fn.Name, strings.Join(args, ", "))
+		return irValue{typ: returnType, ref: result}
+	default:
+		panic(fmt.Sprintf("unhandled expression type in LLVM IR generation: %T", expr))
+	}
+}
+
+// GenerateModuleIR lowers the functions of the module into textual LLVM IR, for the LLVM toolchain
+// to compile for any target it supports. The call defaults are like in GenerateModuleAsm.
+func GenerateModuleIR(module *ast.BlockStmt, callDefaults map[*ast.FuncCallExpr][]ast.Expr) string {
+	g := &irGenerator{funcs: map[string]*ast.FuncDeclStmt{}}
+	g.callDefaults = callDefaults
+
+	// Collect the functions first, as they may be called before they are declared
+	for _, stmt := range module.Statements {
+		if fn, ok := stmt.(*ast.FuncDeclStmt); ok {
+			g.funcs[fn.Name] = fn
+		}
+	}
+	for _, stmt := range module.Statements {
+		switch s := stmt.(type) {
+		case *ast.FuncDeclStmt:
+			g.generateFunction(s)
+		default:
+			// TODO: other top-level statements
+		}
+	}
+
+	return g.module.String()
+}
+
+// CompileIR compiles the LLVM IR for the host into an executable at outputPath (./main by default),
+// using llc for generating the object file, and the C compiler driver for linking it with the C runtime,
+// which calls main. The intermediate files are written into a temporary directory like in CompileAsm.
+func CompileIR(ir string, workingDir string, outputPath string) error {
+	if err := requireTools("compiling LLVM IR", "llc", "cc"); err != nil {
+		return err
+	}
+	if outputPath == "" {
+		outputPath = "./main"
+	}
+	return withBuildDir(workingDir, func(buildDir string) error {
+		irPath := filepath.Join(buildDir, "generated.ll")
+		if err := os.WriteFile(irPath, []byte(ir), 0644); err != nil {
+			return fmt.Errorf("failed to write the LLVM IR: %w", err)
+		}
+
+		// Generate the object file from the IR, position independent for linking into a PIE
+		objPath := filepath.Join(buildDir, "generated.o")
+		if err := runTool("llc", "-filetype=obj", "-relocation-model=pic", "-o", objPath, irPath); err != nil {
+			return fmt.Errorf("failed to generate the object file: %w", err)
+		}
+
+		if err := runTool("cc", "-o", outputPath, objPath); err != nil {
+			return fmt.Errorf("linker error: %w", err)
+		}
+		return nil
+	})
+}
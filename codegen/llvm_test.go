@@ -0,0 +1,197 @@
+package codegen
+
+import (
+	"github.com/ruistola/cooper/lexer"
+	"github.com/ruistola/cooper/parser"
+	"github.com/ruistola/cooper/typechecker"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Compiles each program through LLVM IR, runs it, and checks its exit code, which main returns
+func TestLLVMIR(t *testing.T) {
+	for _, tool := range []string{"llc", "cc"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found on PATH", tool)
+		}
+	}
+	testCases := []struct {
+		name     string
+		src      string
+		expected int
+	}{
+		{
+			"arithmetic",
+			"func main(): i32 { return (2 + 3) * 4 - 100 / 7 % 4 }",
+			18,
+		},
+		{
+			"remainder with the sign of the dividend",
+			"func main(): i32 { return ((-7) % 3) * 10 + 7 % (-3) + 20 }",
+			11,
+		},
+		{
+			"variables and calls",
+			`func square(n: i32): i32 {
+  return n * n
+}
+func main(): i32 {
+  let total: i32 = 0
+  for (let i: i32 = 1; i < 4; i = i + 1) {
+    total += square(i)
+  }
+  return total
+}`,
+			14,
+		},
+		{
+			"branches",
+			`func sign(n: i32): i32 {
+  if n < 0 then return -1
+  if n > 0 then {
+    return 1
+  } else {
+    return 0
+  }
+}
+func main(): i32 {
+  let x: i32 = 5
+  if sign(x) > 0 then return 7 else return 8
+}`,
+			7,
+		},
+		{
+			"unit function",
+			`func nothing() {
+  let x: i32 = 1
+}
+func main(): i32 {
+  nothing()
+  return 3
+}`,
+			3,
+		},
+		{
+			"block expressions",
+			`func add(a: i32, b: i32): i32 {
+  return a + b
+}
+func main(): i32 {
+  let total: i32 = 1
+  let x: i32 = {
+    total = add(total, 40);
+    1
+  }
+  {
+    total = add(total, x);
+  }
+  return total
+}`,
+			42,
+		},
+		{
+			"short-circuit",
+			`func crash(): bool {
+  let zero: i32 = 0
+  return 1 / zero == 0
+}
+func main(): i32 {
+  let x: i32 = 1
+  if x > 5 and crash() then return 2
+  let b: bool = x < 5 or { x = 10; false }
+  if b and x == 1 then return 3
+  return 4
+}`,
+			3,
+		},
+		{
+			"typed locals",
+			`func f(): i64 {
+  let x: i64 = 5
+  return x + 1
+}
+func main(): i32 {
+  let small: i8 = (-100: i8)
+  if f() == (6: i64) and small == (-100: i8) then return 7
+  return 0
+}`,
+			7,
+		},
+		{
+			"exponentiation",
+			`func power(base: i32, exponent: i32): i32 {
+  return base ^ exponent
+}
+func main(): i32 {
+  let big: i64 = (2: i64) ^ 40
+  if big != (1099511627776: i64) then return 1
+  return power(3, 4) + power(5, 0) + 2 ^ 3 ^ 0
+}`,
+			84,
+		},
+		{
+			"default arguments",
+			`func big(x: i64 = 1099511627776): i64 {
+  return x
+}
+func scale(x: i32, factor: i32 = 2, offset: i32 = -1): i32 {
+  return x * factor + offset
+}
+func main(): i32 {
+  if big() != (1099511627776: i64) then return 1
+  return scale(10) + scale(10, 3)
+}`,
+			48,
+		},
+		{
+			// Each step appends a base 4 digit to the trace: 1 for the condition, 2 for the iter clause
+			// and 3 for the body. The condition is evaluated before each iteration, including the first
+			// one, and the iter clause after each execution of the body.
+			"for loop evaluation order",
+			`func main(): i32 {
+  let trace: i32 = 0
+  for (let i: i32 = 0; { trace = trace * 4 + 1; i < 1 }; { trace = trace * 4 + 2; i = i + 1; }) {
+    trace = trace * 4 + 3
+  }
+  return trace
+}`,
+			0b01_11_10_01,
+		},
+		{
+			"for loop condition false at the start",
+			`func main(): i32 {
+  let trace: i32 = 0
+  for (let i: i32 = 2; { trace = trace * 4 + 1; i < 1 }; { trace = trace * 4 + 2; i = i + 1; }) {
+    trace = trace * 4 + 3
+  }
+  return trace
+}`,
+			1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			module := parser.Parse(lexer.Tokenize(tc.src))
+			_, callDefaults, errors := typechecker.CheckModule(module, typechecker.Config{})
+			if typechecker.HasErrors(errors) {
+				t.Fatalf("typechecking failed: %v", errors)
+			}
+			ir := GenerateModuleIR(module, callDefaults)
+			outputPath := filepath.Join(t.TempDir(), "main")
+			if err := CompileIR(ir, t.TempDir(), outputPath); err != nil {
+				t.Fatalf("compile failed: %v\n%s", err, ir)
+			}
+			err := exec.Command(outputPath).Run()
+			exitCode := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal("running the program failed:", err)
+			}
+			if exitCode != tc.expected {
+				t.Errorf("Expected exit code %d, got %d:\n%s", tc.expected, exitCode, ir)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package codegen
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Target selects the instruction set and the object file format of the generated code,
+// and the toolchain used for assembling and linking it.
+type Target int
+
+const (
+	TargetArm64Darwin Target = iota // arm64 macOS, linked against the system library
+	TargetAmd64Linux                // x86-64 Linux (System V ABI), linked without libc
+	TargetArm64Linux                // arm64 Linux, linked without libc
+)
+
+func (t Target) String() string {
+	switch t {
+	case TargetArm64Darwin:
+		return "arm64-darwin"
+	case TargetAmd64Linux:
+		return "amd64-linux"
+	case TargetArm64Linux:
+		return "arm64-linux"
+	default:
+		return fmt.Sprintf("unknown target (%d)", int(t))
+	}
+}
+
+// HostTarget returns the target matching the platform the compiler is running on, if supported.
+func HostTarget() (Target, bool) {
+	switch {
+	case runtime.GOOS == "darwin" && runtime.GOARCH == "arm64":
+		return TargetArm64Darwin, true
+	case runtime.GOOS == "linux" && runtime.GOARCH == "amd64":
+		return TargetAmd64Linux, true
+	case runtime.GOOS == "linux" && runtime.GOARCH == "arm64":
+		return TargetArm64Linux, true
+	default:
+		return 0, false
+	}
+}
+
+func (t Target) instructionSet(g *Generator) instructionSet {
+	switch t {
+	case TargetArm64Darwin:
+		return &arm64{g: g}
+	case TargetAmd64Linux:
+		return &amd64{g: g}
+	case TargetArm64Linux:
+		return &arm64{g: g, linux: true}
+	default:
+		panic(fmt.Sprintf("unhandled target: %s", t))
+	}
+}
+
+// tools returns the external tools needed for assembling and linking the generated code.
+func (t Target) tools() []string {
+	if t == TargetArm64Darwin {
+		return []string{"as", "ld", "xcrun"}
+	}
+	return []string{"as", "ld"}
+}
+
+// linkArgs returns the arguments for the linker to produce an executable from the object file.
+func (t Target) linkArgs(objPath string, outputPath string) ([]string, error) {
+	switch t {
+	case TargetArm64Darwin:
+		// Link against the system library of the active SDK
+		sdkPath, err := exec.Command("xcrun", "--show-sdk-path").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve the SDK path: %w", err)
+		}
+		return []string{"-o", outputPath, objPath,
+			"-lSystem", "-syslibroot", strings.TrimSpace(string(sdkPath)), "-e", "_main", "-arch", "arm64"}, nil
+	case TargetAmd64Linux, TargetArm64Linux:
+		// The generated code provides its own _start, the default entry point
+		return []string{"-o", outputPath, objPath}, nil
+	default:
+		return nil, fmt.Errorf("unhandled target: %s", t)
+	}
+}
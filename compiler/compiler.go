@@ -0,0 +1,104 @@
+// Package compiler runs the whole pipeline from the source of a module to an executable, for embedding the
+// compiler in other programs.
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/codegen"
+	"github.com/ruistola/cooper/lexer"
+	"github.com/ruistola/cooper/parser"
+	"github.com/ruistola/cooper/typechecker"
+)
+
+// ErrInvalidSource is returned by Build when the source has errors, which are found in the diagnostics.
+var ErrInvalidSource = errors.New("the source has errors")
+
+// Options configure a build.
+type Options struct {
+	Target           codegen.Target  // See codegen.HostTarget for the target of the running platform
+	Warnings         map[string]bool // The enabled warnings by name, or nil for all of them
+	WarningsAsErrors bool            // Whether the enabled warnings are reported as errors, failing the build
+	WorkingDir       string          // Where the intermediate files are written, the system temporary directory if empty
+	OutputPath       string          // The path of the executable, ./main if empty
+}
+
+// Result is the outcome of a build: the executable if one was built, and the errors and the warnings in
+// the source.
+type Result struct {
+	BinaryPath  string
+	Diagnostics []typechecker.Diagnostic
+}
+
+// Build compiles the source into an executable. Each stage runs only if the previous ones found no errors.
+// An error in the source is reported as ErrInvalidSource, with the details in the diagnostics of the
+// result, whereas any other error means that the source was fine, but the executable couldn't be built.
+func Build(src string, opts Options) (Result, error) {
+	result := Result{}
+	tokens, err := lexer.TokenizeSafe(src)
+	if err != nil {
+		result.Diagnostics = append(result.Diagnostics, typechecker.Diagnostic{
+			Severity: typechecker.SeverityError,
+			Stage:    typechecker.StageSyntax,
+			Message:  err.Error(),
+		})
+		return result, ErrInvalidSource
+	}
+	module, syntaxErrors := parser.ParseSafe(tokens)
+	for _, err := range syntaxErrors {
+		diagnostic := typechecker.Diagnostic{
+			Severity: typechecker.SeverityError,
+			Stage:    typechecker.StageSyntax,
+			Message:  err.Error(),
+		}
+		var syntaxError *parser.SyntaxError
+		if errors.As(err, &syntaxError) {
+			diagnostic.Message = syntaxError.Message
+			diagnostic.Pos = syntaxError.Pos
+		}
+		result.Diagnostics = append(result.Diagnostics, diagnostic)
+	}
+	if len(syntaxErrors) > 0 {
+		return result, ErrInvalidSource
+	}
+
+	warnings := opts.Warnings
+	if warnings == nil {
+		warnings = typechecker.DefaultWarnings()
+	}
+	literalTypes, callDefaults, diagnostics := typechecker.CheckModule(module, typechecker.Config{Warnings: warnings, WarningsAsErrors: opts.WarningsAsErrors})
+	result.Diagnostics = append(result.Diagnostics, diagnostics...)
+	if typechecker.HasErrors(result.Diagnostics) {
+		return result, ErrInvalidSource
+	}
+	result.Diagnostics = append(result.Diagnostics, typechecker.FoldConstants(module, literalTypes)...)
+	if typechecker.HasErrors(result.Diagnostics) {
+		return result, ErrInvalidSource
+	}
+
+	assembly, err := generateAsm(module, callDefaults, opts.Target)
+	if err != nil {
+		return result, err
+	}
+	outputPath := opts.OutputPath
+	if outputPath == "" {
+		outputPath = "./main"
+	}
+	if err := codegen.CompileAsm(assembly, opts.Target, opts.WorkingDir, outputPath); err != nil {
+		return result, err
+	}
+	result.BinaryPath = outputPath
+	return result, nil
+}
+
+// generateAsm generates the assembly of a checked module, turning the panic of the code generator on a
+// construct it doesn't support yet into an error.
+func generateAsm(module *ast.BlockStmt, callDefaults typechecker.CallDefaults, target codegen.Target) (assembly string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("code generation failed: %v", r)
+		}
+	}()
+	return codegen.GenerateModuleAsm(module, callDefaults, target), nil
+}
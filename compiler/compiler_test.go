@@ -0,0 +1,84 @@
+package compiler
+
+import (
+	"errors"
+	"github.com/ruistola/cooper/codegen"
+	"github.com/ruistola/cooper/typechecker"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	target, ok := codegen.HostTarget()
+	if !ok {
+		t.Skipf("No code generation target for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	options := Options{Target: target, WorkingDir: t.TempDir(), OutputPath: filepath.Join(t.TempDir(), "main")}
+
+	t.Run("valid program", func(t *testing.T) {
+		result, err := Build("func main(): i32 {\n  return 2 * 3 + 1\n}", options)
+		if err != nil {
+			t.Fatalf("Expected the build to succeed, got %v (%q)", err, result.Diagnostics)
+		}
+		if len(result.Diagnostics) > 0 {
+			t.Errorf("Expected no diagnostics, got %q", result.Diagnostics)
+		}
+		var exitErr *exec.ExitError
+		if err := exec.Command(result.BinaryPath).Run(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 7 {
+			t.Errorf("Expected the program to exit with 7, got %v", err)
+		}
+	})
+	t.Run("hello world", func(t *testing.T) {
+		result, err := Build("func main(): i32 {\n  println(\"Hello, World!\")\n  return 0\n}", options)
+		if err != nil {
+			t.Fatalf("Expected the build to succeed, got %v (%q)", err, result.Diagnostics)
+		}
+		output, err := exec.Command(result.BinaryPath).Output()
+		if err != nil || string(output) != "Hello, World!\n" {
+			t.Errorf("Expected the program to print %q, got %q, %v", "Hello, World!\n", output, err)
+		}
+	})
+	t.Run("type error", func(t *testing.T) {
+		result, err := Build("func main(): i32 {\n  return true\n}", options)
+		if !errors.Is(err, ErrInvalidSource) {
+			t.Fatalf("Expected ErrInvalidSource, got %v", err)
+		}
+		if len(result.Diagnostics) != 1 || result.Diagnostics[0].String() != "2:10: Type Error: return type mismatch: expected i32, found bool" {
+			t.Errorf("Expected the return type mismatch, got %q", result.Diagnostics)
+		}
+		if result.BinaryPath != "" {
+			t.Errorf("Expected no executable, got %s", result.BinaryPath)
+		}
+	})
+	t.Run("warnings as errors", func(t *testing.T) {
+		src := "func main(): i32 {\n  let n: i32 = 1\n  n = n\n  return n\n}"
+		result, err := Build(src, options)
+		if err != nil || len(result.Diagnostics) != 1 || result.Diagnostics[0].Severity != typechecker.SeverityWarning {
+			t.Fatalf("Expected the build to succeed with a warning, got %v (%q)", err, result.Diagnostics)
+		}
+		werror := options
+		werror.WarningsAsErrors = true
+		werror.OutputPath = filepath.Join(t.TempDir(), "main")
+		result, err = Build(src, werror)
+		if !errors.Is(err, ErrInvalidSource) {
+			t.Fatalf("Expected ErrInvalidSource, got %v", err)
+		}
+		if len(result.Diagnostics) != 1 || result.Diagnostics[0].String() != "3:3: Semantic Error: self-assignment of n [self-assign]" {
+			t.Errorf("Expected the self-assignment as an error, got %q", result.Diagnostics)
+		}
+		if result.BinaryPath != "" {
+			t.Errorf("Expected no executable, got %s", result.BinaryPath)
+		}
+	})
+	t.Run("syntax errors", func(t *testing.T) {
+		result, err := Build("let x: i32 = )\nlet y: i32 = ]", options)
+		if !errors.Is(err, ErrInvalidSource) {
+			t.Fatalf("Expected ErrInvalidSource, got %v", err)
+		}
+		if len(result.Diagnostics) != 2 || result.Diagnostics[0].Stage != typechecker.StageSyntax || result.Diagnostics[1].Pos.Line != 2 {
+			t.Errorf("Expected a syntax error on each line, got %q", result.Diagnostics)
+		}
+	})
+}
@@ -3,6 +3,8 @@ package lexer
 import (
 	"fmt"
 	"regexp"
+	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -15,8 +17,8 @@ const (
 	WHITESPACE                  // UTF-8 whitespace (tabs, spaces, etc.)
 	WORD                        // Evaluates into a keyword or an identifier
 	COMMENT                     // Double slash until EOL is a comment
-	NUMBER                      // Number literal, e.g. 123, -5e5, 3.141, 0xFF, 0b10101010
-	STRING                      // Double quote delimited string literal, e.g. "Hello, World!"
+	NUMBER                      // Number literal, e.g. 123, -5e5, 3.141, 0xFF, 0b10101010 (only decimal ones may be floats)
+	STRING                      // String literal, e.g. "Hello, World!", or a raw string between backticks
 	IDENTIFIER                  // If a word is not a reserved keyword, then it must be an identifier
 
 	// Multicharacter tokens
@@ -30,6 +32,8 @@ const (
 	STAR_EQUALS    // *=
 	SLASH_EQUALS   // /=
 	PERCENT_EQUALS // %=
+	FAT_ARROW      // =>
+	ELLIPSIS       // ...
 
 	// Single- character tokens
 	EQUALS        // =
@@ -49,6 +53,7 @@ const (
 	SEMICOLON     // ;
 	COLON         // :
 	COMMA         // ,
+	QUESTION      // ?
 	OPEN_BRACKET  // [
 	CLOSE_BRACKET // ]
 	OPEN_CURLY    // {
@@ -58,12 +63,19 @@ const (
 
 	// Reserved keywords
 	AND
+	AS
+	BREAK
+	CONST
 	ELSE
+	ENUM
 	FALSE
 	FOR
 	FUNC
 	IF
+	INTERFACE
 	LET
+	LOOP
+	MATCH
 	OR
 	RETURN
 	STRUCT
@@ -76,8 +88,8 @@ const (
 )
 
 // Golang has no built-in ordered map type, so we store an array of (token type, regex pattern) pairs.
-// The order in which they are stored (or rather, iterated, during tokenization) matters,
-// e.g. the lexer must try `!=` pattern before `!` to correctly identify a NOT_EQUALS token.
+// The order in which they are stored (or rather, iterated, during tokenization) matters, and all of them
+// are tried before the operators, e.g. so that `// note` is identified as a COMMENT rather than two SLASHes.
 type tokenPattern struct {
 	tokenType TokenType
 	pattern   *regexp.Regexp
@@ -89,64 +101,83 @@ type tokenPattern struct {
 var tokenPatterns []tokenPattern = []tokenPattern{
 	// Literals, comments, and special tokens
 	{EOL, regexp.MustCompile(`^(\r\n|\n|\r)`)},
-	{WHITESPACE, regexp.MustCompile(`^\s+`)},
-	{WORD, regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)},
-	{COMMENT, regexp.MustCompile(`^\/\/.*`)},
+	{WHITESPACE, regexp.MustCompile(`^[^\S\r\n]+`)}, // Line endings are left for EOL to match
+	// A lone underscore is not a word, but an UNDERSCORE
+	{WORD, regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9_]*|_[a-zA-Z0-9_]+)`)},
+	{COMMENT, regexp.MustCompile(`^\/\/[^\r\n]*`)},
 	{NUMBER, regexp.MustCompile(`^(0[xX][0-9a-fA-F](_?[0-9a-fA-F])*|0[bB][01](_?[01])*|[0-9](_?[0-9])*(\.([0-9](_?[0-9])*)?)?([eE][+-]?[0-9](_?[0-9])*)?)`)},
-	{STRING, regexp.MustCompile(`^"([^"\\]|\\.)*"`)},
+	{STRING, regexp.MustCompile("^(\"([^\"\\\\]|\\\\.)*\"|`[^`]*`)")}, // A raw string has no escapes, and may span lines
+}
 
+// operators maps the text of each operator and punctuation token to its type. They are matched after the
+// patterns, by looking up the longest prefix of the remaining source first, e.g. so that `!=` is matched as
+// NOT_EQUALS rather than NOT followed by EQUALS.
+var operators map[string]TokenType = map[string]TokenType{
 	// Multicharacter tokens
-	{COLON_EQUALS, regexp.MustCompile(`^:=`)},
-	{DOUBLE_EQUALS, regexp.MustCompile(`^==`)},
-	{NOT_EQUALS, regexp.MustCompile(`^!=`)},
-	{LESS_EQUALS, regexp.MustCompile(`^<=`)},
-	{GREATER_EQUALS, regexp.MustCompile(`^>=`)},
-	{PLUS_EQUALS, regexp.MustCompile(`^\+=`)},
-	{DASH_EQUALS, regexp.MustCompile(`^-=`)},
-	{STAR_EQUALS, regexp.MustCompile(`^\*=`)},
-	{SLASH_EQUALS, regexp.MustCompile(`^/=`)},
-	{PERCENT_EQUALS, regexp.MustCompile(`^%=`)},
+	":=":  COLON_EQUALS,
+	"==":  DOUBLE_EQUALS,
+	"!=":  NOT_EQUALS,
+	"<=":  LESS_EQUALS,
+	">=":  GREATER_EQUALS,
+	"+=":  PLUS_EQUALS,
+	"-=":  DASH_EQUALS,
+	"*=":  STAR_EQUALS,
+	"/=":  SLASH_EQUALS,
+	"%=":  PERCENT_EQUALS,
+	"=>":  FAT_ARROW,
+	"...": ELLIPSIS,
 
 	// Single- character tokens
-	{EQUALS, regexp.MustCompile(`^=`)},
-	{NOT, regexp.MustCompile(`^!`)},
-	{PIPE, regexp.MustCompile(`^\|`)},
-	{AMPERSAND, regexp.MustCompile(`^&`)},
-	{CHEVRON, regexp.MustCompile(`^\^`)},
-	{LESS, regexp.MustCompile(`^<`)},
-	{GREATER, regexp.MustCompile(`^>`)},
-	{PLUS, regexp.MustCompile(`^\+`)},
-	{DASH, regexp.MustCompile(`^-`)},
-	{UNDERSCORE, regexp.MustCompile(`^_`)},
-	{SLASH, regexp.MustCompile(`^/`)},
-	{STAR, regexp.MustCompile(`^\*`)},
-	{PERCENT, regexp.MustCompile(`^%`)},
-	{DOT, regexp.MustCompile(`^\.`)},
-	{SEMICOLON, regexp.MustCompile(`^;`)},
-	{COLON, regexp.MustCompile(`^:`)},
-	{COMMA, regexp.MustCompile(`^,`)},
-	{OPEN_BRACKET, regexp.MustCompile(`^\[`)},
-	{CLOSE_BRACKET, regexp.MustCompile(`^\]`)},
-	{OPEN_CURLY, regexp.MustCompile(`^\{`)},
-	{CLOSE_CURLY, regexp.MustCompile(`^\}`)},
-	{OPEN_PAREN, regexp.MustCompile(`^\(`)},
-	{CLOSE_PAREN, regexp.MustCompile(`^\)`)},
+	"=": EQUALS,
+	"!": NOT,
+	"|": PIPE,
+	"&": AMPERSAND,
+	"^": CHEVRON,
+	"<": LESS,
+	">": GREATER,
+	"+": PLUS,
+	"-": DASH,
+	"_": UNDERSCORE,
+	"/": SLASH,
+	"*": STAR,
+	"%": PERCENT,
+	".": DOT,
+	";": SEMICOLON,
+	":": COLON,
+	",": COMMA,
+	"?": QUESTION,
+	"[": OPEN_BRACKET,
+	"]": CLOSE_BRACKET,
+	"{": OPEN_CURLY,
+	"}": CLOSE_CURLY,
+	"(": OPEN_PAREN,
+	")": CLOSE_PAREN,
 }
 
+// maxOperatorLength is the length of the longest operator, i.e. of the longest prefix to look up.
+const maxOperatorLength = 3
+
 var reservedKeywords map[string]TokenType = map[string]TokenType{
-	"and":    AND,
-	"else":   ELSE,
-	"false":  FALSE,
-	"for":    FOR,
-	"func":   FUNC,
-	"if":     IF,
-	"let":    LET,
-	"or":     OR,
-	"return": RETURN,
-	"struct": STRUCT,
-	"then":   THEN,
-	"true":   TRUE,
-	"use":    USE,
+	"and":       AND,
+	"as":        AS,
+	"break":     BREAK,
+	"const":     CONST,
+	"else":      ELSE,
+	"enum":      ENUM,
+	"false":     FALSE,
+	"for":       FOR,
+	"func":      FUNC,
+	"if":        IF,
+	"interface": INTERFACE,
+	"let":       LET,
+	"loop":      LOOP,
+	"match":     MATCH,
+	"or":        OR,
+	"return":    RETURN,
+	"struct":    STRUCT,
+	"then":      THEN,
+	"true":      TRUE,
+	"use":       USE,
 }
 
 // A lookup table for the Stringer interface implementation
@@ -172,6 +203,8 @@ var tokenDisplayNames map[TokenType]string = map[TokenType]string{
 	STAR_EQUALS:    "star_equals",
 	SLASH_EQUALS:   "slash_equals",
 	PERCENT_EQUALS: "percent_equals",
+	FAT_ARROW:      "fat_arrow",
+	ELLIPSIS:       "ellipsis",
 
 	// Single- character tokens
 	EQUALS:        "equals",
@@ -191,6 +224,7 @@ var tokenDisplayNames map[TokenType]string = map[TokenType]string{
 	SEMICOLON:     "semicolon",
 	COLON:         "colon",
 	COMMA:         "comma",
+	QUESTION:      "question",
 	OPEN_BRACKET:  "open_bracket",
 	CLOSE_BRACKET: "close_bracket",
 	OPEN_CURLY:    "open_curly",
@@ -199,19 +233,26 @@ var tokenDisplayNames map[TokenType]string = map[TokenType]string{
 	CLOSE_PAREN:   "close_paren",
 
 	// Reserved keywords
-	LET:    "let",
-	STRUCT: "struct",
-	TRUE:   "true",
-	FALSE:  "false",
-	FUNC:   "func",
-	IF:     "if",
-	OR:     "or",
-	AND:    "and",
-	THEN:   "then",
-	ELSE:   "else",
-	FOR:    "for",
-	RETURN: "return",
-	USE:    "use",
+	LET:       "let",
+	CONST:     "const",
+	STRUCT:    "struct",
+	ENUM:      "enum",
+	TRUE:      "true",
+	FALSE:     "false",
+	FUNC:      "func",
+	IF:        "if",
+	INTERFACE: "interface",
+	OR:        "or",
+	AND:       "and",
+	AS:        "as",
+	THEN:      "then",
+	ELSE:      "else",
+	FOR:       "for",
+	LOOP:      "loop",
+	MATCH:     "match",
+	BREAK:     "break",
+	RETURN:    "return",
+	USE:       "use",
 }
 
 // Implement Stringer for TokenType.
@@ -228,6 +269,74 @@ type SrcPos struct {
 	Offset int // 0-based byte offset
 }
 
+// String formats the position as `line:column`.
+func (pos SrcPos) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// lineEndings matches the same line endings as the EOL token.
+var lineEndings = regexp.MustCompile(`\r\n|\n|\r`)
+
+// SourceSnippet returns the source line at the position, followed by a line with a ^ marker under the
+// column of the position, for showing the context of an error. Tabs before the column are kept in the
+// marker line, so that the marker stays aligned however wide the tabs are displayed. Likewise, the marker
+// is indented by the width the characters before the column are displayed with in a terminal (see
+// displayWidth), rather than by their count. If the source has no such line, the snippet is empty.
+func SourceSnippet(src string, pos SrcPos) string {
+	lines := lineEndings.Split(src, -1)
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[pos.Line-1], " \t")
+	var marker strings.Builder
+	for i, r := range []rune(line) {
+		if i >= pos.Column-1 {
+			break
+		}
+		if r == '\t' {
+			marker.WriteRune('\t')
+		} else {
+			marker.WriteString(strings.Repeat(" ", displayWidth(r)))
+		}
+	}
+	// A position past the end of the line, e.g. at a line ending, is marked right after the line
+	marker.WriteString(strings.Repeat(" ", max(0, pos.Column-1-utf8.RuneCountInString(line))))
+	marker.WriteRune('^')
+	return line + "\n" + marker.String()
+}
+
+// wideRanges are the ranges of the characters displayed two columns wide in a terminal, e.g. those of
+// Chinese, Japanese and Korean, fullwidth forms and emoji.
+var wideRanges = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x1100, Hi: 0x115F, Stride: 1},
+		{Lo: 0x2E80, Hi: 0xA4CF, Stride: 1},
+		{Lo: 0xAC00, Hi: 0xD7A3, Stride: 1},
+		{Lo: 0xF900, Hi: 0xFAFF, Stride: 1},
+		{Lo: 0xFE30, Hi: 0xFE4F, Stride: 1},
+		{Lo: 0xFF00, Hi: 0xFF60, Stride: 1},
+		{Lo: 0xFFE0, Hi: 0xFFE6, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1F300, Hi: 0x1F64F, Stride: 1},
+		{Lo: 0x1F900, Hi: 0x1F9FF, Stride: 1},
+		{Lo: 0x20000, Hi: 0x3FFFD, Stride: 1},
+	},
+}
+
+// displayWidth returns the number of columns a character is displayed in a terminal: none for a combining
+// mark, which is displayed over the previous character, and two for a wide character.
+func displayWidth(r rune) int {
+	switch {
+	case unicode.Is(unicode.Mn, r):
+		return 0
+	case unicode.Is(wideRanges, r):
+		return 2
+	default:
+		return 1
+	}
+}
+
 // Token stores a type identifier with the corresponding section of the source and its location.
 type Token struct {
 	Type   TokenType
@@ -277,58 +386,196 @@ func tryMatchPattern(src string, re *regexp.Regexp, tokenType TokenType) (int, T
 	}
 }
 
+// A hex or binary number literal is matched only up to its last valid digit, so check that the literal
+// isn't directly followed by a character that looks like it was intended as a part of it, e.g. `0xFG`.
+// Returns the length of the literal, including any such characters, if there are any.
+func invalidRadixDigitsLength(src string, number Token) int {
+	if len(number.Value) < 2 || !strings.ContainsAny(number.Value[1:2], "xXbB") {
+		return 0
+	}
+	length := len(number.Value)
+	for length < len(src) && isAlphaNumeric(src[length]) {
+		length++
+	}
+	if length == len(number.Value) {
+		return 0
+	}
+	return length
+}
+
+// Only decimal number literals may have a fractional part or an exponent, so check that a hex or binary
+// literal isn't directly followed by a dot, e.g. `0x1.8`, which would otherwise be split into separate tokens.
+// Returns the name of the radix and the length of the literal, including the dot and any digits after it,
+// if it is followed by one.
+func radixFractionLength(src string, number Token) (string, int) {
+	if len(number.Value) < 2 || len(src) <= len(number.Value) || src[len(number.Value)] != '.' {
+		return "", 0
+	}
+	var radix string
+	switch number.Value[1] {
+	case 'x', 'X':
+		radix = "hex"
+	case 'b', 'B':
+		radix = "binary"
+	default:
+		return "", 0
+	}
+	length := len(number.Value) + 1
+	for length < len(src) && isAlphaNumeric(src[length]) {
+		length++
+	}
+	return radix, length
+}
+
+func isAlphaNumeric(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
 // Tokenize converts a raw text source into a slice of tokens that can then be used as input for the parser.
+// Panics if the source can't be tokenized; TokenizeSafe returns an error instead.
 func Tokenize(src string) []Token {
-	pos := 0
-	line := 1
-	column := 1
+	tokens, err := TokenizeSafe(src)
+	if err != nil {
+		panic(err.Error())
+	}
+	return tokens
+}
+
+// TokenizeSafe is like Tokenize, but returns an error describing the location of the first section
+// of the source that can't be tokenized, for callers that must not crash on invalid user input.
+func TokenizeSafe(src string) ([]Token, error) {
+	return tokenize(NewScanner(src))
+}
+
+// TokenizeWithComments is like TokenizeSafe, but keeps the comments among the tokens, for tools like formatters
+// and documentation generators. The parser sets the comments aside (see parser.ParseWithComments).
+func TokenizeWithComments(src string) ([]Token, error) {
+	scanner := NewScanner(src)
+	scanner.keepComments = true
+	return tokenize(scanner)
+}
+
+func tokenize(scanner *Scanner) ([]Token, error) {
 	tokens := make([]Token, 0)
+	for token, ok := scanner.Next(); ok; token, ok = scanner.Next() {
+		tokens = append(tokens, token)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Scanner tokenizes a source incrementally, one token at a time, producing the same tokens as Tokenize
+// without holding all of them in memory at once.
+type Scanner struct {
+	src          string
+	pos          int
+	line         int
+	column       int
+	prevIsEOL    bool // Whether the previous token returned is an EOL, making another one redundant
+	keepComments bool
+	err          error
+}
+
+// NewScanner returns a scanner for the tokens of the source.
+func NewScanner(src string) *Scanner {
+	return &Scanner{
+		src:    src,
+		line:   1,
+		column: 1,
+	}
+}
+
+// Err returns the error that stopped the scanner, if a section of the source couldn't be tokenized.
+func (s *Scanner) Err() error {
+	return s.err
+}
 
+// Next returns the next token of the source, skipping whitespace, comments and redundant endlines.
+// Returns false at the end of the source, or if the rest of the source can't be tokenized (see Err).
+func (s *Scanner) Next() (Token, bool) {
 	// While there are unprocessed bytes left...
-	for pos < len(src) {
-		// Keep track of whether we did end up finding a match
-		found := false
+	for s.err == nil && s.pos < len(s.src) {
 		// Get the remaining part as a slice
-		remainingSrc := src[pos:]
-
-		// Find which TokenType represents the next token in the input.
-		// If some pattern does match, add the token to the collection and update the position.
-		for _, tpat := range tokenPatterns {
-			if length, newToken := tryMatchPattern(remainingSrc, tpat.pattern, tpat.tokenType); length != 0 {
-				found = true
-
-				// Add position information to the token
-				newToken.SrcPos = SrcPos{
-					Column: column,
-					Line:   line,
-					Offset: pos,
-				}
-
-				// If not whitespace, comment or a redundant endline, store the token
-				isPrevTokenEOL := len(tokens) > 0 && tokens[len(tokens)-1].Type == EOL
-				isRepeatingEOL := newToken.Type == EOL && isPrevTokenEOL
-				isWhitespace := newToken.Type == WHITESPACE
-				isComment := newToken.Type == COMMENT
-				if !(isWhitespace || isComment || isRepeatingEOL) {
-					tokens = append(tokens, newToken)
-				}
-
-				// Update the current lexer position to the start of the next token
-				pos += length
-				column += utf8.RuneCountInString(newToken.Value)
-				if newToken.Type == EOL {
-					line++
-					column = 1
-				}
-
-				break // out of the pattern loop and onto the next token
-			}
-		}
-		if !found {
+		remainingSrc := s.src[s.pos:]
+
+		// Find which TokenType represents the next token in the input
+		length, newToken := matchToken(remainingSrc)
+		if length == 0 {
 			// Print up to 32 bytes from where the lexer failed
 			sampleLength := min(32, len(remainingSrc))
-			panic(fmt.Sprintf("failed to tokenize source at line %d, column %d: %s", line, column, remainingSrc[:sampleLength]))
+			s.err = fmt.Errorf("failed to tokenize source at line %d, column %d: %s", s.line, s.column, remainingSrc[:sampleLength])
+			break
+		}
+
+		// Add position information to the token
+		newToken.SrcPos = SrcPos{
+			Column: s.column,
+			Line:   s.line,
+			Offset: s.pos,
+		}
+
+		if newToken.Type == NUMBER {
+			if invalidLength := invalidRadixDigitsLength(remainingSrc, newToken); invalidLength > 0 {
+				s.err = fmt.Errorf("invalid digit in number at line %d, column %d: %s", s.line, s.column+length, remainingSrc[:invalidLength])
+				return Token{}, false
+			}
+			if radix, fractionLength := radixFractionLength(remainingSrc, newToken); fractionLength > 0 {
+				s.err = fmt.Errorf("%s float literals are not supported at line %d, column %d: %s", radix, s.line, s.column+length, remainingSrc[:fractionLength])
+				return Token{}, false
+			}
+		}
+
+		// Update the current lexer position to the start of the next token
+		s.pos += length
+		s.advance(newToken.Value)
+
+		// If not whitespace, comment or a redundant endline, return the token
+		isRepeatingEOL := newToken.Type == EOL && s.prevIsEOL
+		isWhitespace := newToken.Type == WHITESPACE
+		isComment := newToken.Type == COMMENT && !s.keepComments
+		if !(isWhitespace || isComment || isRepeatingEOL) {
+			s.prevIsEOL = newToken.Type == EOL
+			return newToken, true
 		}
 	}
-	return tokens
+	return Token{}, false
+}
+
+// advance moves the line and the column past the text of a token, which may contain line endings, like an EOL
+// or a raw string spanning lines.
+func (s *Scanner) advance(text string) {
+	endings := lineEndings.FindAllStringIndex(text, -1)
+	if len(endings) == 0 {
+		s.column += utf8.RuneCountInString(text)
+		return
+	}
+	s.line += len(endings)
+	s.column = 1 + utf8.RuneCountInString(text[endings[len(endings)-1][1]:])
+}
+
+// matchToken matches the token at the start of the source, trying the patterns in order, and then the
+// operators. Returns a zero length if nothing matches.
+func matchToken(src string) (int, Token) {
+	for _, tpat := range tokenPatterns {
+		if length, token := tryMatchPattern(src, tpat.pattern, tpat.tokenType); length > 0 {
+			return length, token
+		}
+	}
+	return matchOperator(src)
+}
+
+// matchOperator matches the longest operator at the start of the source, without the allocations of
+// a regex match. Returns a zero length if the source doesn't start with an operator.
+func matchOperator(src string) (int, Token) {
+	for length := min(maxOperatorLength, len(src)); length > 0; length-- {
+		if tokenType, found := operators[src[:length]]; found {
+			return length, Token{
+				Type:  tokenType,
+				Value: src[:length],
+			}
+		}
+	}
+	return 0, Token{}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/yassinebenaid/godump"
 	"os"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -117,9 +118,9 @@ func testTokenizationPanic(t *testing.T, src string, expectedPanic string) {
 func TestCompleteness(t *testing.T) {
 	numTokens := int(NUM_TOKENS)
 
-	// Add 2 for EOF and IDENTIFIER which are not present in neither patterns nor keywords
-	if numPatternsKeywords := len(tokenPatterns) + len(reservedKeywords) + 2; numPatternsKeywords != numTokens {
-		t.Fatalf("Lexer error! Expected %d token patterns and/or keywords, found %d", numTokens, numPatternsKeywords)
+	// Add 2 for EOF and IDENTIFIER which are not present in neither patterns, operators nor keywords
+	if numPatternsKeywords := len(tokenPatterns) + len(operators) + len(reservedKeywords) + 2; numPatternsKeywords != numTokens {
+		t.Fatalf("Lexer error! Expected %d token patterns, operators and/or keywords, found %d", numTokens, numPatternsKeywords)
 	}
 
 	if numNames := len(tokenDisplayNames); numNames != numTokens {
@@ -141,6 +142,9 @@ func TestBasicTokens(t *testing.T) {
 	}{
 		{"single ampersand", "&", []TokenType{AMPERSAND}},
 		{"assignment operator", ":=", []TokenType{COLON_EQUALS}},
+		{"ellipsis", "xs: i32...", []TokenType{IDENTIFIER, COLON, IDENTIFIER, ELLIPSIS}},
+		{"conditional", "a ? b : c", []TokenType{IDENTIFIER, QUESTION, IDENTIFIER, COLON, IDENTIFIER}},
+		{"cast", "n as i64", []TokenType{IDENTIFIER, AS, IDENTIFIER}},
 		{"identifier", "foo", []TokenType{IDENTIFIER}},
 		{"keywords", "if else for", []TokenType{IF, ELSE, FOR}},
 	}
@@ -187,6 +191,10 @@ func TestFloatingPointNumbers(t *testing.T) {
 		{"float with trailing dot", "1.", []TokenType{NUMBER}},
 		{"leading dot is separate", ".5", []TokenType{DOT, NUMBER}},
 		{"multiple dots", "1.2.3", []TokenType{NUMBER, DOT, NUMBER}},
+		{"float with an exponent", "1.5e3", []TokenType{NUMBER}},
+		{"float with a negative exponent", "2.5E-3", []TokenType{NUMBER}},
+		{"integer with an exponent", "1e10", []TokenType{NUMBER}},
+		{"float with underscores", "1_000.000_1", []TokenType{NUMBER}},
 		{"negative float", "-3.14", []TokenType{DASH, NUMBER}},
 	}
 
@@ -278,6 +286,54 @@ func TestCompleteStatements(t *testing.T) {
 	})
 }
 
+// Test hex and binary numbers followed by digits that don't belong to their radix
+func TestInvalidRadixDigits(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedPanic string
+	}{
+		{"hex with a non-hex letter", "0xFG", "invalid digit in number at line 1, column 4: 0xFG"},
+		{"binary with a non-binary digit", "0b102", "invalid digit in number at line 1, column 5: 0b102"},
+		{"binary with a letter", "x := 0b1a", "invalid digit in number at line 1, column 9: 0b1a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testTokenizationPanic(t, tt.input, tt.expectedPanic)
+		})
+	}
+
+	// A valid literal may still be followed by other tokens
+	testTokenization(t, "0xFF+0b1", NUMBER, PLUS, NUMBER)
+	testTokenization(t, "a[0x1F]", IDENTIFIER, OPEN_BRACKET, NUMBER, CLOSE_BRACKET)
+}
+
+// Test hex and binary numbers with a fractional part, which only decimal numbers may have
+func TestRadixFloats(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedPanic string
+	}{
+		{"hex float", "0x1.8", "hex float literals are not supported at line 1, column 4: 0x1.8"},
+		{"hex float with an exponent", "x := 0x1.8p3", "hex float literals are not supported at line 1, column 9: 0x1.8p3"},
+		{"hex with a trailing dot", "0xF.", "hex float literals are not supported at line 1, column 4: 0xF."},
+		{"uppercase hex float", "0XF.5", "hex float literals are not supported at line 1, column 4: 0XF.5"},
+		{"binary float", "0b10.1", "binary float literals are not supported at line 1, column 5: 0b10.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testTokenizationPanic(t, tt.input, tt.expectedPanic)
+		})
+	}
+
+	// Decimal numbers, including zero, may still have one
+	testTokenization(t, "0.5", NUMBER)
+	testTokenization(t, "0.5e2+10.25", NUMBER, PLUS, NUMBER)
+}
+
 // Test malformed numbers that should fail
 func TestMalformedNumbers(t *testing.T) {
 	if !testing.Short() {
@@ -321,6 +377,16 @@ func BenchmarkTokenizeNumbers(b *testing.B) {
 	}
 }
 
+// Benchmark tokenization of a source consisting mostly of operators and punctuation
+func BenchmarkTokenizeOperators(b *testing.B) {
+	src := strings.Repeat("a[i] += (b - c) * d / e % f; x := y == z != w <= v >= u ? p : q; g(h, ...k) => {}\n", 100)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		Tokenize(src)
+	}
+}
+
 // Test tokenization of redundant newlines
 func TestCollapsedEOLs(t *testing.T) {
 	tests := []struct {
@@ -376,3 +442,204 @@ func TestCollapsedEOLs(t *testing.T) {
 		})
 	}
 }
+
+// Test that the comments are kept only when asked for, at their positions
+func TestTokenizeWithComments(t *testing.T) {
+	src := "// doc\nx := 1 // one\n"
+	testTokenization(t, src, EOL, IDENTIFIER, COLON_EQUALS, NUMBER, EOL)
+	tokens, err := TokenizeWithComments(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []TokenType{}
+	for _, token := range tokens {
+		types = append(types, token.Type)
+	}
+	expected := []TokenType{COMMENT, EOL, IDENTIFIER, COLON_EQUALS, NUMBER, COMMENT, EOL}
+	if !slices.Equal(types, expected) {
+		t.Fatalf("Expected %v, got %v", expected, types)
+	}
+	if comment := tokens[5]; comment.Value != "// one" || comment.SrcPos.Line != 2 || comment.SrcPos.Column != 8 {
+		t.Errorf("Expected the comment %q at 2:8, got %q at %s", "// one", comment.Value, comment.SrcPos)
+	}
+}
+
+// Test that all the supported line endings produce the same tokens at the same lines and columns
+func TestLineEndings(t *testing.T) {
+	src := "struct Point {  \n  x: i32,\n}\n\t\n\nfunc main() {\n  p := Point{ x: 1, }  \n  p.x // comment\n}\n"
+	lfTokens := Tokenize(src)
+
+	for _, eol := range []string{"\r\n", "\r"} {
+		t.Run(fmt.Sprintf("%q", eol), func(t *testing.T) {
+			log := testLogger{t}
+			tokens := Tokenize(strings.ReplaceAll(src, "\n", eol))
+			if len(tokens) != len(lfTokens) {
+				log.Error("Expected %d tokens, got %d", len(lfTokens), len(tokens))
+				log.Dump(tokens)
+				t.FailNow()
+			}
+			for i, tok := range tokens {
+				expected := lfTokens[i]
+				if tok.Type == EOL {
+					if tok.Value != eol {
+						log.Error("Token %d: expected EOL value %q, got %q", i, eol, tok.Value)
+					}
+				} else if tok.Value != expected.Value {
+					log.Error("Token %d: expected value %q, got %q", i, expected.Value, tok.Value)
+				}
+				if tok.Type != expected.Type || tok.SrcPos.Line != expected.SrcPos.Line || tok.SrcPos.Column != expected.SrcPos.Column {
+					log.Error("Token %d: expected %s at %d:%d, got %s at %d:%d", i,
+						expected.Type, expected.SrcPos.Line, expected.SrcPos.Column,
+						tok.Type, tok.SrcPos.Line, tok.SrcPos.Column)
+				}
+			}
+		})
+	}
+
+	// Whitespace at the end of a line must not swallow the line ending
+	testTokenization(t, "foo  \nbar", IDENTIFIER, EOL, IDENTIFIER)
+	if last := lfTokens[len(lfTokens)-2]; last.Type != CLOSE_CURLY || last.SrcPos.Line != 9 || last.SrcPos.Column != 1 {
+		t.Errorf("Expected the last closing curly at 9:1, got %s at %d:%d", last.Type, last.SrcPos.Line, last.SrcPos.Column)
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	t.Run("multi-line", func(t *testing.T) {
+		testTokenization(t, "s := `first\n  second\n`", IDENTIFIER, COLON_EQUALS, STRING)
+	})
+	t.Run("double quotes and backslashes", func(t *testing.T) {
+		tokens := Tokenize("`say \"hi\" \\n`")
+		if len(tokens) != 1 || tokens[0].Type != STRING || tokens[0].Value != "`say \"hi\" \\n`" {
+			t.Fatalf("Expected the raw string as a single STRING token, got %v", tokens)
+		}
+	})
+	t.Run("positions after a multi-line string", func(t *testing.T) {
+		for _, eol := range []string{"\n", "\r\n", "\r"} {
+			src := strings.ReplaceAll("f(`one\ntwo\nthree`, x)\ny", "\n", eol)
+			tokens := Tokenize(src)
+			expected := []struct {
+				tokenType    TokenType
+				line, column int
+			}{
+				{IDENTIFIER, 1, 1}, {OPEN_PAREN, 1, 2}, {STRING, 1, 3}, {COMMA, 3, 7}, {IDENTIFIER, 3, 9},
+				{CLOSE_PAREN, 3, 10}, {EOL, 3, 11}, {IDENTIFIER, 4, 1},
+			}
+			if len(tokens) != len(expected) {
+				t.Fatalf("%q: expected %d tokens, got %d", eol, len(expected), len(tokens))
+			}
+			for i, tok := range tokens {
+				if want := expected[i]; tok.Type != want.tokenType || tok.SrcPos.Line != want.line || tok.SrcPos.Column != want.column {
+					t.Errorf("%q: token %d: expected %s at %d:%d, got %s at %s", eol, i, want.tokenType, want.line, want.column, tok.Type, tok.SrcPos)
+				}
+			}
+		}
+	})
+	t.Run("unterminated", func(t *testing.T) {
+		testTokenizationPanic(t, "s := `no end\n", "failed to tokenize source at line 1, column 6")
+	})
+}
+
+func TestTokenizeSafe(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedError string
+	}{
+		{"invalid byte", "x := 1\ny := \x00", "failed to tokenize source at line 2, column 6"},
+		{"invalid digit", "x := 0xFG", "invalid digit in number at line 1, column 9: 0xFG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := TokenizeSafe(tt.input)
+			if err == nil {
+				t.Fatalf("Expected an error, got tokens %v", tokens)
+			}
+			if !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Expected error containing %q, got %q", tt.expectedError, err)
+			}
+		})
+	}
+
+	tokens, err := TokenizeSafe("x := 1")
+	if err != nil || len(tokens) != 3 {
+		t.Errorf("Expected 3 tokens without an error, got %v, %v", tokens, err)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	src, err := os.ReadFile("../examples/program.coo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := Tokenize(string(src))
+	scanner := NewScanner(string(src))
+	for i, expected := range tokens {
+		token, ok := scanner.Next()
+		if !ok {
+			t.Fatalf("Expected %d tokens, the scanner stopped after %d", len(tokens), i)
+		}
+		if token != expected {
+			t.Fatalf("Expected token %d to be %v, got %v", i, expected, token)
+		}
+	}
+	if token, ok := scanner.Next(); ok || scanner.Err() != nil {
+		t.Errorf("Expected the end of the source, got %v, %v", token, scanner.Err())
+	}
+
+	// The tokens before an invalid section of the source are returned as they are scanned
+	scanner = NewScanner("x := 1\ny := \x00")
+	count := 0
+	for _, ok := scanner.Next(); ok; _, ok = scanner.Next() {
+		count++
+	}
+	if count != 6 || scanner.Err() == nil {
+		t.Errorf("Expected 6 tokens followed by an error, got %d tokens and %v", count, scanner.Err())
+	}
+}
+
+func TestSourceSnippet(t *testing.T) {
+	src := "let x: i32 = 1\r\nfunc f() {\n\treturn x + y  \n}"
+	testCases := []struct {
+		name     string
+		pos      SrcPos
+		expected string
+	}{
+		{"first line", SrcPos{Line: 1, Column: 5}, "let x: i32 = 1\n    ^"},
+		{"first column", SrcPos{Line: 2, Column: 1}, "func f() {\n^"},
+		{"tab indentation", SrcPos{Line: 3, Column: 13}, "\treturn x + y\n\t           ^"},
+		{"end of line", SrcPos{Line: 4, Column: 2}, "}\n ^"},
+		{"missing line", SrcPos{Line: 5, Column: 1}, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if snippet := SourceSnippet(src, tc.pos); snippet != tc.expected {
+				t.Errorf("Expected snippet %q, got %q", tc.expected, snippet)
+			}
+		})
+	}
+}
+
+// The columns of positions count characters rather than bytes, but the marker must be indented by the
+// width the characters are displayed with
+func TestSourceSnippetCharacterWidths(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src      string
+		pos      SrcPos
+		expected string
+	}{
+		{"ascii", "a\nb\nf(x, y)", SrcPos{Line: 3, Column: 5}, "f(x, y)\n    ^"},
+		{"two-byte characters", "a\nb\n\"ä\" y", SrcPos{Line: 3, Column: 5}, "\"ä\" y\n    ^"},
+		{"wide characters", "a\nb\n\"世界\" y", SrcPos{Line: 3, Column: 6}, "\"世界\" y\n       ^"},
+		{"emoji", "a\nb\n\"🙂\" y", SrcPos{Line: 3, Column: 5}, "\"🙂\" y\n     ^"},
+		{"combining marks", "a\nb\n\"a\u0308\" y", SrcPos{Line: 3, Column: 6}, "\"a\u0308\" y\n    ^"},
+		{"tab and wide characters", "a\nb\n\t\"世\" y", SrcPos{Line: 3, Column: 6}, "\t\"世\" y\n\t     ^"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if snippet := SourceSnippet(tc.src, tc.pos); snippet != tc.expected {
+				t.Errorf("Expected snippet %q, got %q", tc.expected, snippet)
+			}
+		})
+	}
+}
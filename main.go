@@ -1,15 +1,47 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"github.com/ruistola/cooper/ast"
 	"github.com/ruistola/cooper/lexer"
 	"github.com/ruistola/cooper/parser"
+	"github.com/ruistola/cooper/repl"
+	"github.com/ruistola/cooper/typechecker"
 	"github.com/yassinebenaid/godump"
 	"os"
+	"strings"
 	"time"
 )
 
 func main() {
+	warnings := typechecker.DefaultWarnings()
+	listWarnings := false
+	flag.Func("W", "enable a warning by name, or disable it with a no- prefix (all/no-all for all of them, list to list them)", func(value string) error {
+		if value == "list" {
+			listWarnings = true
+			return nil
+		}
+		return setWarning(warnings, value)
+	})
+	warningsAsErrors := flag.Bool("Werror", false, "treat the enabled warnings as errors")
+	dumpJSON := flag.Bool("json", false, "print the parsed AST as JSON instead of a Go dump")
+	flag.Parse()
+	if listWarnings {
+		for _, warning := range typechecker.AvailableWarnings() {
+			fmt.Printf("%-20s %s\n", warning.Name, warning.Description)
+		}
+		return
+	}
+	// The repl subcommand starts an interactive session instead of checking the example program
+	if flag.Arg(0) == "repl" {
+		if err := repl.Run(os.Stdin, os.Stdout, typechecker.Config{Warnings: warnings, WarningsAsErrors: *warningsAsErrors}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	filename := "examples/program.coo"
 	sourceBytes, _ := os.ReadFile(filename)
 	src := string(sourceBytes)
@@ -27,26 +59,79 @@ func main() {
 	godump.Dump(tokens)
 
 	startParsing := time.Now()
-	ast := parser.Parse(tokens)
+	module, syntaxErrors := parser.ParseSafe(tokens)
 	durationParsing := time.Since(startParsing)
 	totalDuration += durationParsing
+	if len(syntaxErrors) > 0 {
+		for _, err := range syntaxErrors {
+			fmt.Println(err)
+			if syntaxError, ok := err.(*parser.SyntaxError); ok {
+				fmt.Println(lexer.SourceSnippet(src, syntaxError.Pos))
+			}
+		}
+		os.Exit(1)
+	}
 	fmt.Printf("Parsed %s in %v.\n\n", filename, durationParsing)
 
 	fmt.Println("Parsed AST:")
-	godump.Dump(ast)
-
-	// startTypeChecking := time.Now()
-	// errors := typechecker.Check(ast)
-	// durationTypeChecking := time.Since(startTypeChecking)
-	// totalDuration += durationTypeChecking
-	// if len(errors) == 0 {
-	// 	fmt.Println("0 errors.")
-	// } else {
-	// 	for _, err := range errors {
-	// 		fmt.Println(err)
-	// 	}
-	// }
-	// fmt.Printf("Type checked %s in %v.\n\n", filename, durationTypeChecking)
+	if *dumpJSON {
+		os.Stdout.Write(ast.ToJSON(module))
+	} else {
+		godump.Dump(module)
+	}
+
+	startTypeChecking := time.Now()
+	literalTypes, _, errors := typechecker.CheckModule(module, typechecker.Config{Warnings: warnings, WarningsAsErrors: *warningsAsErrors})
+	durationTypeChecking := time.Since(startTypeChecking)
+	totalDuration += durationTypeChecking
+	if len(errors) == 0 {
+		fmt.Println("0 errors.")
+	} else {
+		colored := isTerminal(os.Stdout)
+		for _, err := range errors {
+			fmt.Println(err.RenderWithSource(src, colored))
+		}
+	}
+	fmt.Printf("Type checked %s in %v.\n\n", filename, durationTypeChecking)
+
+	if !typechecker.HasErrors(errors) {
+		startFolding := time.Now()
+		foldErrors := typechecker.FoldConstants(module, literalTypes)
+		durationFolding := time.Since(startFolding)
+		totalDuration += durationFolding
+		colored := isTerminal(os.Stdout)
+		for _, err := range foldErrors {
+			fmt.Println(err.RenderWithSource(src, colored))
+		}
+		fmt.Printf("Folded the constants of %s in %v.\n\n", filename, durationFolding)
+		errors = append(errors, foldErrors...)
+	}
 
 	fmt.Printf("Done in %v.\n", totalDuration)
+	if typechecker.HasErrors(errors) {
+		os.Exit(1)
+	}
+}
+
+// setWarning applies the value of a -W flag to the enabled warnings: a warning name enables the warning,
+// and the name with a no- prefix disables it. The name all applies to all the warnings.
+func setWarning(enabled map[string]bool, value string) error {
+	name, disable := strings.CutPrefix(value, "no-")
+	if name == "all" {
+		for name := range enabled {
+			enabled[name] = !disable
+		}
+		return nil
+	}
+	if _, ok := enabled[name]; !ok {
+		return fmt.Errorf("unknown warning: %s (see -W list)", name)
+	}
+	enabled[name] = !disable
+	return nil
+}
+
+// isTerminal reports whether the file is a terminal, i.e. whether output written to it can be colored.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"cmp"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"slices"
+)
+
+// comment is a comment set aside by the parser, and whether it is on a line of its own rather than after
+// other tokens on the line.
+type comment struct {
+	token   lexer.Token
+	ownLine bool
+}
+
+// separateComments returns a copy of the tokens without the comments, and the comments. The endline after
+// a comment on a line of its own is left out too, like the lexer leaves out the comment, so that the
+// semicolon inference sees the same tokens with or without the comments.
+func separateComments(tokens []lexer.Token) ([]lexer.Token, []comment) {
+	kept := make([]lexer.Token, 0, len(tokens))
+	comments := []comment{}
+	afterComment := false
+	for i, token := range tokens {
+		if token.Type == lexer.COMMENT {
+			comments = append(comments, comment{
+				token:   token,
+				ownLine: i == 0 || tokens[i-1].Type == lexer.EOL,
+			})
+			afterComment = true
+			continue
+		}
+		isRedundantEOL := token.Type == lexer.EOL && afterComment && len(kept) > 0 && kept[len(kept)-1].Type == lexer.EOL
+		afterComment = false
+		if !isRedundantEOL {
+			kept = append(kept, token)
+		}
+	}
+	return kept, comments
+}
+
+// stmtLists collects the statements listed in the blocks of an AST, as opposed to e.g. the body of
+// a function, which is a statement of its own only within the function declaration.
+type stmtLists []ast.Stmt
+
+func (stmts *stmtLists) Visit(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.BlockStmt:
+		*stmts = append(*stmts, n.Statements...)
+	case *ast.BlockExpr:
+		*stmts = append(*stmts, n.Statements...)
+	}
+	return true
+}
+
+func (stmts *stmtLists) Leave(node ast.Node) {}
+
+// attachComments attaches the comments to the statements of the module. Consecutive comments on lines of
+// their own lead the statement starting on the line right after them, and a comment after other tokens
+// trails the last statement starting before it on the same line. Other comments, e.g. ones separated from
+// the next statement by an empty line, are not attached to any statement.
+func attachComments(module *ast.BlockStmt, comments []comment) ast.CommentMap {
+	stmts := stmtLists{}
+	ast.Walk(module, &stmts)
+	slices.SortFunc(stmts, func(a, b ast.Stmt) int {
+		return cmp.Compare(a.Position().Offset, b.Position().Offset)
+	})
+	commentMap := ast.CommentMap{}
+	attached := func(stmt ast.Stmt) *ast.Comments {
+		if commentMap[stmt] == nil {
+			commentMap[stmt] = &ast.Comments{}
+		}
+		return commentMap[stmt]
+	}
+
+	leading := []lexer.Token{}
+	for i, c := range comments {
+		if !c.ownLine {
+			var trailed ast.Stmt
+			for _, stmt := range stmts {
+				pos := stmt.Position()
+				if pos.Line == c.token.SrcPos.Line && pos.Offset < c.token.SrcPos.Offset {
+					trailed = stmt
+				}
+			}
+			if trailed != nil {
+				attached(trailed).Trailing = &comments[i].token
+			}
+			continue
+		}
+		leading = append(leading, c.token)
+		next := i + 1
+		if next < len(comments) && comments[next].ownLine && comments[next].token.SrcPos.Line == c.token.SrcPos.Line+1 {
+			continue
+		}
+		for _, stmt := range stmts {
+			if stmt.Position().Line == c.token.SrcPos.Line+1 {
+				attached(stmt).Leading = leading
+				break
+			}
+		}
+		leading = []lexer.Token{}
+	}
+	return commentMap
+}
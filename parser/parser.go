@@ -1,10 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"github.com/ruistola/cooper/ast"
 	"github.com/ruistola/cooper/lexer"
 	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // For semicolon inference, the parser keeps track of whether the token currently being inspected
@@ -13,22 +17,103 @@ import (
 //
 // The parser also keeps track of whether the current token is inside a then- branch of an if- statement
 // or if- expression, where (and only where) the "else" keyword is also a valid statement terminator.
+//
+// Within the subject of a match expression, an opening curly brace starts the arms of the match rather
+// than a struct literal, unless it is nested in parentheses. The parser tracks this by the depth of the
+// paren stack at the subject, or -1 outside of any subject.
+//
+// The opening tokens are kept on the stacks for reporting an unclosed one at its position. Curly braces
+// are also tracked on a stack of their own, including those of blocks, which don't affect semicolon inference.
+//
+// The parser looks ahead and behind the current token, and rewrites the endlines among the tokens into
+// semicolons or deletes them in place (see peek), so it keeps its own buffer of the tokens rather than
+// consuming them one by one from a lexer.Scanner, and leaves the tokens of the caller untouched. Any comments
+// among the tokens are set aside in the buffer, for attaching them to the statements after parsing.
 type parser struct {
-	tokens       []lexer.Token
-	pos          int
-	parenStack   []lexer.TokenType
-	inThenBranch bool
+	tokens            []lexer.Token
+	pos               int
+	eof               lexer.Token // Returned past the last token, positioned at the end of the source
+	parenStack        []lexer.Token
+	curlyStack        []lexer.Token
+	inThenBranch      bool
+	blockFollowsDepth int // The paren depth of a match subject or an if condition, which a block follows
+	depth             int // Of the nested expressions, types and statements being parsed, see nest
+	maxDepth          int
+	comments          []comment
+	errors            []error
+}
+
+// MaxNestingDepth limits how deeply expressions, types and statements may be nested in the source, unless
+// configured otherwise. Deeper nesting is reported as a syntax error, rather than parsed recursively until
+// the stack overflows.
+const MaxNestingDepth = 1000
+
+// Config adjusts the parsing by ParseWithConfig.
+type Config struct {
+	// The limit for how deeply the source may be nested, or zero for MaxNestingDepth
+	MaxNestingDepth int
+}
+
+// SyntaxError is an error in the source found by the parser, located at the offending token.
+type SyntaxError struct {
+	Pos     lexer.SrcPos
+	Message string
 }
 
-func newParser(tokens []lexer.Token) parser {
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// fail aborts parsing the current statement with a syntax error at the position. The statement parsing
+// loops recover from the error by skipping to the next statement (see parseStmtOrSkip).
+func (p *parser) fail(pos lexer.SrcPos, format string, args ...any) {
+	panic(&SyntaxError{Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+func newParser(tokens []lexer.Token, config Config) parser {
+	tokens, comments := separateComments(tokens)
+	eof := lexer.Token{Type: lexer.EOF, SrcPos: lexer.SrcPos{Line: 1, Column: 1}}
+	if len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		eof.SrcPos = last.SrcPos
+		eof.SrcPos.Offset += len(last.Value)
+		if last.Type == lexer.EOL {
+			eof.SrcPos.Line++
+			eof.SrcPos.Column = 1
+		} else {
+			eof.SrcPos.Column += utf8.RuneCountInString(last.Value)
+		}
+	}
+	maxDepth := config.MaxNestingDepth
+	if maxDepth == 0 {
+		maxDepth = MaxNestingDepth
+	}
 	return parser{
-		tokens:       tokens,
-		pos:          0,
-		parenStack:   make([]lexer.TokenType, 0),
-		inThenBranch: false,
+		tokens:            tokens,
+		pos:               0,
+		eof:               eof,
+		parenStack:        make([]lexer.Token, 0),
+		curlyStack:        make([]lexer.Token, 0),
+		inThenBranch:      false,
+		blockFollowsDepth: -1,
+		maxDepth:          maxDepth,
+		comments:          comments,
+	}
+}
+
+// nest enters a nested expression, type or statement starting at the next token, failing if that exceeds
+// the maximum nesting depth. Each call must be paired with a deferred call to unnest.
+func (p *parser) nest() {
+	p.depth++
+	if p.depth > p.maxDepth {
+		p.fail(p.peek().SrcPos, "expression nested too deeply")
 	}
 }
 
+func (p *parser) unnest() {
+	p.depth--
+}
+
 var (
 	// An EOL may be converted into a semicolon only if the previous token is one of the following:
 	beforeSemicolon []lexer.TokenType = []lexer.TokenType{
@@ -37,6 +122,7 @@ var (
 		lexer.IDENTIFIER,
 		lexer.UNDERSCORE,
 		lexer.COMMA,
+		lexer.BREAK,
 		lexer.CLOSE_BRACKET,
 		lexer.CLOSE_CURLY,
 		lexer.CLOSE_PAREN,
@@ -59,12 +145,18 @@ var (
 		lexer.OPEN_CURLY,
 		lexer.CLOSE_CURLY,
 		lexer.OPEN_PAREN,
+		lexer.BREAK,
+		lexer.CONST,
 		lexer.FALSE,
 		lexer.FOR,
 		lexer.FUNC,
 		lexer.IF,
 		lexer.ELSE,
+		lexer.ENUM,
+		lexer.INTERFACE,
 		lexer.LET,
+		lexer.LOOP,
+		lexer.MATCH,
 		lexer.RETURN,
 		lexer.STRUCT,
 		lexer.TRUE,
@@ -72,23 +164,62 @@ var (
 	}
 )
 
+// closingTokens are the closing parentheses, curly braces, and square brackets, by the opening ones.
+var closingTokens = map[lexer.TokenType]lexer.TokenType{
+	lexer.OPEN_PAREN:   lexer.CLOSE_PAREN,
+	lexer.OPEN_CURLY:   lexer.CLOSE_CURLY,
+	lexer.OPEN_BRACKET: lexer.CLOSE_BRACKET,
+}
+
 // A closing parenthesis, curly brace, or square bracket (provided as the argument) has been encountered,
 // and must be matched by a corresponding opening one.
-// TODO: Collect errors instead of panicking and exiting early?
-func (p *parser) popParenStack(t lexer.TokenType) {
+func (p *parser) popParenStack(closing lexer.Token) {
 	if len(p.parenStack) == 0 {
-		panic(fmt.Sprintf("Unmatched pairwise symbol '%s' found\n", t))
+		p.fail(closing.SrcPos, "unmatched '%s'", closing.Value)
 	}
 	top := p.parenStack[len(p.parenStack)-1]
-	if (top == lexer.OPEN_PAREN && t != lexer.CLOSE_PAREN) ||
-		(top == lexer.OPEN_CURLY && t != lexer.CLOSE_CURLY) ||
-		(top == lexer.OPEN_BRACKET && t != lexer.CLOSE_BRACKET) {
-		panic(fmt.Sprintf("Unmatched pairwise symbol '%s' found\n", t))
+	if closingTokens[top.Type] != closing.Type {
+		p.fail(closing.SrcPos, "mismatched '%s' closing '%s' opened at %s", closing.Value, top.Value, describeLine(top.SrcPos))
 	}
 	p.parenStack = p.parenStack[:len(p.parenStack)-1]
 }
 
-// prevToken returns the previous token or EOF
+// failUnclosed fails on an unexpected token, if it is due to an opening token that is not closed: reporting the
+// innermost one at the end of the source, and the one to close in place of a closing token of another kind.
+func (p *parser) failUnclosed(found lexer.Token, expected []lexer.TokenType) {
+	var stack []lexer.Token
+	switch {
+	case found.Type == lexer.EOF:
+		stack = p.parenStack
+		if len(p.curlyStack) > 0 && (len(stack) == 0 || p.curlyStack[len(p.curlyStack)-1].SrcPos.Offset > stack[len(stack)-1].SrcPos.Offset) {
+			stack = p.curlyStack
+		}
+	case !isClosing(found.Type) || len(expected) != 1:
+		return
+	case expected[0] == lexer.CLOSE_PAREN || expected[0] == lexer.CLOSE_BRACKET:
+		stack = p.parenStack
+	case expected[0] == lexer.CLOSE_CURLY:
+		stack = p.curlyStack
+	}
+	if len(stack) == 0 {
+		return
+	}
+	top := stack[len(stack)-1]
+	if found.Type == lexer.EOF {
+		p.fail(found.SrcPos, "unclosed '%s' opened at %s", top.Value, describeLine(top.SrcPos))
+	}
+	p.fail(found.SrcPos, "mismatched '%s' closing '%s' opened at %s", found.Value, top.Value, describeLine(top.SrcPos))
+}
+
+func isClosing(tokenType lexer.TokenType) bool {
+	return tokenType == lexer.CLOSE_PAREN || tokenType == lexer.CLOSE_CURLY || tokenType == lexer.CLOSE_BRACKET
+}
+
+func describeLine(pos lexer.SrcPos) string {
+	return fmt.Sprintf("line %d, column %d", pos.Line, pos.Column)
+}
+
+// prevToken returns the previous token, or an EOF without a position at the start
 func (p *parser) prevToken() lexer.Token {
 	result := lexer.Token{}
 	if p.pos > 0 {
@@ -99,7 +230,7 @@ func (p *parser) prevToken() lexer.Token {
 
 // currentToken returns the current token or EOF
 func (p *parser) currentToken() lexer.Token {
-	result := lexer.Token{}
+	result := p.eof
 	if p.pos < len(p.tokens) {
 		result = p.tokens[p.pos]
 	}
@@ -108,7 +239,7 @@ func (p *parser) currentToken() lexer.Token {
 
 // nextToken returns the next token or EOF
 func (p *parser) nextToken() lexer.Token {
-	result := lexer.Token{}
+	result := p.eof
 	if p.pos+1 < len(p.tokens) {
 		result = p.tokens[p.pos+1]
 	}
@@ -136,10 +267,11 @@ func (p *parser) peek() lexer.Token {
 		isOutsideParens := len(p.parenStack) == 0
 		statementCanTerminate := slices.Contains(beforeSemicolon, p.prevToken().Type) && slices.Contains(afterSemicolon, p.nextToken().Type)
 		if isOutsideParens && statementCanTerminate && p.nextToken().Type != lexer.EOF {
-			// EOL is applicable as a statement terminator, replace it with an explicit SEMICOLON token
+			// EOL is applicable as a statement terminator, replace it with a SEMICOLON token.
+			// The token keeps the endline as its value to tell it apart from an explicit semicolon.
 			p.tokens[p.pos] = lexer.Token{
 				Type:   lexer.SEMICOLON,
-				Value:  ";",
+				Value:  currToken.Value,
 				SrcPos: currToken.SrcPos,
 			}
 		} else {
@@ -153,26 +285,38 @@ func (p *parser) peek() lexer.Token {
 }
 
 // Consumes a single token which must be one of the expected token types, if any have been
-// provided as arguments. If the type of the next token is not any of the expected, panics.
+// provided as arguments. If the type of the next token is not any of the expected, fails.
 // When called without arguments, accepts any token (including EOF). Returns the consumed token.
 // Updates the parser's paren stack as appropriate.
 func (p *parser) consume(expected ...lexer.TokenType) lexer.Token {
 	currToken := p.peek()
 	if len(expected) > 0 && !slices.Contains(expected, currToken.Type) {
-		panic(fmt.Sprintf("Expected %s, found %s\n", expected, currToken.Type))
+		p.failUnclosed(currToken, expected)
+	}
+	if len(expected) == 1 && currToken.Type != expected[0] {
+		p.fail(currToken.SrcPos, "expected %s, found %s", expected[0], currToken.Type)
+	}
+	if len(expected) > 1 && !slices.Contains(expected, currToken.Type) {
+		p.fail(currToken.SrcPos, "expected one of %s, found %s", expected, currToken.Type)
 	}
 	switch currToken.Type {
 	case lexer.OPEN_PAREN, lexer.OPEN_BRACKET:
-		p.parenStack = append(p.parenStack, currToken.Type)
+		p.parenStack = append(p.parenStack, currToken)
 	case lexer.CLOSE_PAREN, lexer.CLOSE_BRACKET:
-		p.popParenStack(currToken.Type)
+		p.popParenStack(currToken)
+	case lexer.OPEN_CURLY:
+		p.curlyStack = append(p.curlyStack, currToken)
 	case lexer.CLOSE_CURLY:
-		// Open curly is contextual and can only be pushed to the stack by specific
+		if len(p.curlyStack) == 0 {
+			p.fail(currToken.SrcPos, "unmatched '%s'", currToken.Value)
+		}
+		p.curlyStack = p.curlyStack[:len(p.curlyStack)-1]
+		// Open curly is contextual and can only be pushed to the paren stack by specific
 		// parsing functions, but close curly braces can be safely popped, if a matching
 		// open curly is found on the top of the stack (implying the parser was inside
 		// a struct definition or struct literal body).
-		if len(p.parenStack) > 0 && p.parenStack[len(p.parenStack)-1] == lexer.OPEN_CURLY {
-			p.popParenStack(currToken.Type)
+		if len(p.parenStack) > 0 && p.parenStack[len(p.parenStack)-1].Type == lexer.OPEN_CURLY {
+			p.popParenStack(currToken)
 		}
 	}
 	p.pos++
@@ -209,11 +353,24 @@ func (p *parser) consumeStatementTerminator() {
 		}
 		fallthrough
 	default:
-		panic("Expected statement terminator")
+		p.fail(p.peek().SrcPos, "expected the end of the statement, found %s", p.peek().Type)
 	}
 }
 
+// Compound statements like declarations, if- and for- statements end in a closing curly brace
+// without consuming a statement terminator, so an EOL following them is converted into a semicolon
+// that does not belong to any statement. Consumes such an empty statement, if there is one.
+func (p *parser) skipEmptyStmt() bool {
+	if p.peek().Type == lexer.SEMICOLON {
+		p.consume(lexer.SEMICOLON)
+		return true
+	}
+	return false
+}
+
 // Right binding power of tokens that may appear in the head position of an expression (Pratt: NUD).
+// A unary plus or minus captures any operators that bind tighter than addition: `-a + b` is `(-a) + b`,
+// but `-a * b` is `-(a * b)`, and `-2 ^ 2` is `-(2 ^ 2)`.
 func headPrecedence(tokenType lexer.TokenType) int {
 	switch tokenType {
 	case lexer.EOF, lexer.SEMICOLON, lexer.OPEN_PAREN, lexer.OPEN_CURLY:
@@ -221,64 +378,208 @@ func headPrecedence(tokenType lexer.TokenType) int {
 	case lexer.NUMBER, lexer.STRING, lexer.WORD, lexer.TRUE, lexer.FALSE:
 		return 1
 	case lexer.PLUS, lexer.DASH:
-		return 10
+		return 11
 	default:
 		panic(fmt.Sprintf("Cannot determine binding power for '%s' as a head token", tokenType))
 	}
 }
 
 // Binding power of tokens that may appear in the tail position of an expression (Pratt: LED).
-// Unequal left vs right binding power to enforce left or right associativity as appropriate.
+// Any other token ends the expression, and is left for the enclosing parser to deal with.
+//
+// Unequal left vs right binding power to enforce left or right associativity as appropriate:
+// the parsing of the right-hand side stops at the next operator only if its left binding power
+// doesn't exceed the right binding power of the current one. So a right binding power higher than
+// the left makes an operator left associative (`a - b - c` is `(a - b) - c`), and a lower one
+// makes it right associative (`a = b = c` is `a = (b = c)`).
+//
+// Exponentiation is right associative (`a ^ b ^ c` is `a ^ (b ^ c)`), and binds tighter than the
+// unary minus (see headPrecedence), so that `-2 ^ 2` is `-(2 ^ 2)` as per math convention.
+// A cast binds tighter than the arithmetic operators except exponentiation: `a * b as i64` is `a * (b as i64)`.
 func tailPrecedence(tokenType lexer.TokenType) (int, int) {
 	switch tokenType {
-	case lexer.EOF, lexer.SEMICOLON, lexer.CLOSE_PAREN, lexer.COMMA, lexer.CLOSE_CURLY, lexer.CLOSE_BRACKET, lexer.THEN, lexer.ELSE:
-		return 0, 0
-	case lexer.EQUALS, lexer.PLUS_EQUALS, lexer.DASH_EQUALS, lexer.COLON_EQUALS:
-		return 1, 2
+	case lexer.EQUALS, lexer.PLUS_EQUALS, lexer.DASH_EQUALS, lexer.STAR_EQUALS, lexer.SLASH_EQUALS, lexer.PERCENT_EQUALS, lexer.COLON_EQUALS:
+		return 2, 1
+	case lexer.QUESTION:
+		return 3, 2
 	case lexer.OR, lexer.AND:
-		return 4, 3
+		return 4, 5
 	case lexer.DOUBLE_EQUALS, lexer.NOT_EQUALS:
-		return 5, 6
+		return 6, 7
 	case lexer.LESS, lexer.LESS_EQUALS, lexer.GREATER, lexer.GREATER_EQUALS:
-		return 8, 7
+		return 8, 9
 	case lexer.PLUS, lexer.DASH:
-		return 10, 9
+		return 10, 11
 	case lexer.STAR, lexer.SLASH, lexer.PERCENT:
-		return 12, 11
+		return 12, 13
+	case lexer.AS:
+		return 14, 0
+	case lexer.CHEVRON:
+		return 15, 14
 	case lexer.OPEN_CURLY:
-		return 13, 0
+		return 16, 0
 	case lexer.OPEN_PAREN, lexer.OPEN_BRACKET:
-		return 14, 0
+		return 17, 0
 	case lexer.DOT:
-		return 16, 15
+		return 19, 18
 	default:
-		panic(fmt.Sprintf("Cannot determine binding power for '%s' as a tail token", tokenType))
+		return 0, 0
 	}
 }
 
 // Parse converts a slice of tokens into an AST that can then be used as input for type checking and semantic analysis.
+// Panics on the first syntax error.
 func Parse(tokens []lexer.Token) *ast.BlockStmt {
-	p := newParser(tokens)
-	module := &ast.BlockStmt{}
+	module, syntaxErrors := ParseSafe(tokens)
+	if len(syntaxErrors) > 0 {
+		panic(syntaxErrors[0].Error())
+	}
+	return module
+}
+
+// ParseSafe is like Parse, but returns the syntax errors in the source instead of panicking on the first one.
+// After an error, parsing continues from the next statement, so that independent errors are all reported.
+// The statements failing to parse are left out of the returned AST.
+func ParseSafe(tokens []lexer.Token) (*ast.BlockStmt, []error) {
+	return ParseWithConfig(tokens, Config{})
+}
+
+// ParseWithConfig parses the tokens like ParseSafe, adjusted by the config.
+func ParseWithConfig(tokens []lexer.Token, config Config) (*ast.BlockStmt, []error) {
+	p := newParser(tokens, config)
+	return p.parseModule(), p.errors
+}
+
+// ParseWithComments is like ParseSafe, but also returns the comments among the tokens (see
+// lexer.TokenizeWithComments), attached to the statements they belong to.
+func ParseWithComments(tokens []lexer.Token) (*ast.BlockStmt, ast.CommentMap, []error) {
+	p := newParser(tokens, Config{})
+	module := p.parseModule()
+	return module, attachComments(module, p.comments), p.errors
+}
+
+func (p *parser) parseModule() *ast.BlockStmt {
+	module := &ast.BlockStmt{
+		Pos: lexer.SrcPos{Line: 1, Column: 1},
+	}
 	for p.peek().Type != lexer.EOF {
-		module.Statements = append(module.Statements, p.parseStmt())
+		if p.skipEmptyStmt() {
+			continue
+		}
+		if stmt := p.parseStmtOrSkip(); stmt != nil {
+			module.Statements = append(module.Statements, stmt)
+		}
 	}
 	return module
 }
 
+// ParseSource tokenizes and parses the source in one go. The error is either that of tokenizing the source,
+// or all the syntax errors in it, joined.
+func ParseSource(src string) (*ast.BlockStmt, error) {
+	tokens, err := lexer.TokenizeSafe(src)
+	if err != nil {
+		return nil, err
+	}
+	module, syntaxErrors := ParseSafe(tokens)
+	if len(syntaxErrors) > 0 {
+		return nil, errors.Join(syntaxErrors...)
+	}
+	return module, nil
+}
+
+// parseStmtOrSkip parses a statement. On a syntax error, records the error and skips the rest of the statement,
+// and returns nil.
+func (p *parser) parseStmtOrSkip() (stmt ast.Stmt) {
+	start := p.pos
+	parenStack := slices.Clone(p.parenStack)
+	curlyStack := slices.Clone(p.curlyStack)
+	inThenBranch := p.inThenBranch
+	blockFollowsDepth := p.blockFollowsDepth
+	depth := p.depth
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err, ok := r.(*SyntaxError)
+		if !ok {
+			panic(r)
+		}
+		p.errors = append(p.errors, err)
+		p.parenStack = parenStack
+		p.curlyStack = curlyStack
+		p.inThenBranch = inThenBranch
+		p.blockFollowsDepth = blockFollowsDepth
+		p.depth = depth
+		p.skipStmt(start)
+		// A statement can't start with the closing curly brace of a block, so it must be a stray one
+		if p.pos == start && p.peek().Type != lexer.EOF {
+			p.pos++
+		}
+		stmt = nil
+	}()
+	return p.parseStmt()
+}
+
+// skipStmt skips the tokens of a statement starting at the given position, up to the position of a syntax error,
+// and beyond it, up to and including the next semicolon outside of any curly braces opened within the statement.
+// Stops at the closing curly brace of the enclosing block, leaving it for the block parser. Parentheses and
+// square brackets are not tracked, as a missing closing one is a likely cause of the error.
+func (p *parser) skipStmt(start int) {
+	depth := 0
+	skip := func(token lexer.Token) bool {
+		switch token.Type {
+		case lexer.OPEN_CURLY:
+			depth++
+		case lexer.CLOSE_CURLY:
+			if depth == 0 {
+				return false
+			}
+			depth--
+		}
+		return true
+	}
+	// The tokens consumed before the error may have closed the enclosing block already. Consuming the EOF
+	// advances the position past the last token.
+	for i := start; i < min(p.pos, len(p.tokens)); i++ {
+		if !skip(p.tokens[i]) {
+			p.pos = i
+			return
+		}
+	}
+	for token := p.peek(); token.Type != lexer.EOF; token = p.peek() {
+		if token.Type == lexer.SEMICOLON && depth == 0 {
+			p.pos++
+			return
+		}
+		if !skip(token) {
+			return
+		}
+		p.pos++
+	}
+}
+
 // parseStmt looks at the current token and invokes the appropriate keyword specific
 // parser function. If the token isn't any of the statement opening keywords,
 // defaults to expression parsing where the expression is handled as a statement,
 // ignoring the expression value.
 func (p *parser) parseStmt() ast.Stmt {
+	p.nest()
+	defer p.unnest()
 	switch p.peek().Type {
+	case lexer.BREAK:
+		return p.parseBreakStmt()
+	case lexer.ENUM:
+		return p.parseEnumDeclStmt()
 	case lexer.FOR:
 		return p.parseForStmt()
 	case lexer.FUNC:
 		return p.parseFuncDeclStmt()
 	case lexer.IF:
 		return p.parseIfStmt()
-	case lexer.LET:
+	case lexer.INTERFACE:
+		return p.parseInterfaceDeclStmt()
+	case lexer.LET, lexer.CONST:
 		return p.parseVarDeclStmt()
 	case lexer.RETURN:
 		return p.parseReturnStmt()
@@ -293,10 +594,16 @@ func (p *parser) parseStmt() ast.Stmt {
 
 // A Pratt parser for parsing expressions.
 func (p *parser) parseExpr(min_bp int) ast.Expr {
+	p.nest()
+	defer p.unnest()
 	token := p.consume()
 	leftExpr := p.parseHeadExpr(token)
 	for {
 		token = p.peek()
+		if token.Type == lexer.OPEN_CURLY && len(p.parenStack) == p.blockFollowsDepth {
+			// The arms of a match expression or the then branch of an if follow, rather than a struct literal
+			break
+		}
 		if lbp, rbp := tailPrecedence(token.Type); lbp <= min_bp {
 			break
 		} else {
@@ -312,42 +619,78 @@ func (p *parser) parseHeadExpr(token lexer.Token) ast.Expr {
 	switch token.Type {
 	case lexer.NUMBER:
 		return &ast.NumberLiteralExpr{
+			Pos:   token.SrcPos,
 			Value: token.Value,
 		}
 	case lexer.STRING:
 		return &ast.StringLiteralExpr{
+			Pos:   token.SrcPos,
 			Value: token.Value,
 		}
 	case lexer.IDENTIFIER:
 		return &ast.IdentExpr{
+			Pos:   token.SrcPos,
 			Value: token.Value,
 		}
 	case lexer.TRUE, lexer.FALSE:
 		return &ast.BoolLiteralExpr{
+			Pos:   token.SrcPos,
 			Value: (token.Type == lexer.TRUE),
 		}
 	case lexer.PLUS, lexer.DASH:
 		rbp := headPrecedence(token.Type)
 		rhs := p.parseExpr(rbp)
 		return &ast.UnaryExpr{
+			Pos:      token.SrcPos,
 			Operator: token,
 			Rhs:      rhs,
 		}
 	case lexer.OPEN_PAREN:
 		rbp := headPrecedence(token.Type)
 		rhs := p.parseExpr(rbp)
+		// A colon following the expression makes the parenthesized expression a type ascription
+		if p.peek().Type == lexer.COLON {
+			p.consume(lexer.COLON)
+			ascribedType := p.parseTypeExpr()
+			p.consume(lexer.CLOSE_PAREN)
+			return &ast.AscriptionExpr{
+				Pos:  token.SrcPos,
+				Expr: rhs,
+				Type: ascribedType,
+			}
+		}
+		// A comma following the expression makes the parenthesized expression a tuple
+		if p.peek().Type == lexer.COMMA {
+			elems := []ast.Expr{rhs}
+			for p.peek().Type == lexer.COMMA {
+				p.consume(lexer.COMMA)
+				elems = append(elems, p.parseExpr(rbp))
+			}
+			p.consume(lexer.CLOSE_PAREN)
+			return &ast.TupleExpr{
+				Pos:   token.SrcPos,
+				Elems: elems,
+			}
+		}
 		p.consume(lexer.CLOSE_PAREN)
 		return &ast.GroupExpr{
+			Pos:  token.SrcPos,
 			Expr: rhs,
 		}
 	case lexer.IF:
 		return p.parseIfExpr()
+	case lexer.LOOP:
+		return p.parseLoopExpr(token)
+	case lexer.MATCH:
+		return p.parseMatchExpr(token)
 	case lexer.OPEN_CURLY:
 		rhs := p.parseBlockExpr()
 		p.consume(lexer.CLOSE_CURLY)
 		return rhs
 	default:
-		panic(fmt.Sprintf("Failed to parse head expression from token %v\n", token))
+		p.failUnclosed(token, nil)
+		p.fail(token.SrcPos, "unexpected %s at the start of an expression", token.Type)
+		return nil
 	}
 }
 
@@ -364,10 +707,12 @@ func (p *parser) parseTailExpr(head ast.Expr, rbp int) ast.Expr {
 		lexer.PLUS_EQUALS,
 		lexer.DASH_EQUALS,
 		lexer.STAR_EQUALS,
-		lexer.SLASH_EQUALS:
+		lexer.SLASH_EQUALS,
+		lexer.PERCENT_EQUALS:
 		operator := p.consume()
 		rhs := p.parseExpr(rbp)
 		return &ast.AssignExpr{
+			Pos:           head.Position(),
 			Assigne:       head,
 			Operator:      operator,
 			AssignedValue: rhs,
@@ -377,17 +722,27 @@ func (p *parser) parseTailExpr(head ast.Expr, rbp int) ast.Expr {
 		lexer.STAR,
 		lexer.SLASH,
 		lexer.PERCENT,
+		lexer.CHEVRON,
+		lexer.DOUBLE_EQUALS,
+		lexer.NOT_EQUALS,
 		lexer.LESS,
 		lexer.LESS_EQUALS,
 		lexer.GREATER,
-		lexer.GREATER_EQUALS:
+		lexer.GREATER_EQUALS,
+		lexer.AND,
+		lexer.OR:
 		operator := p.consume()
 		rhs := p.parseExpr(rbp)
 		return &ast.BinaryExpr{
+			Pos:      head.Position(),
 			Lhs:      head,
 			Operator: operator,
 			Rhs:      rhs,
 		}
+	case lexer.QUESTION:
+		return p.parseConditionalExpr(head, rbp)
+	case lexer.AS:
+		return p.parseCastExpr(head)
 	case lexer.OPEN_PAREN:
 		return p.parseFuncCallExpr(head)
 	case lexer.OPEN_CURLY:
@@ -397,7 +752,8 @@ func (p *parser) parseTailExpr(head ast.Expr, rbp int) ast.Expr {
 	case lexer.DOT:
 		return p.parseStructMemberExpr(head)
 	default:
-		panic(fmt.Sprintf("Failed to parse tail expression from token %v\n", currToken))
+		p.fail(currToken.SrcPos, "unexpected %s after an expression", currToken.Type)
+		return nil
 	}
 }
 
@@ -406,16 +762,32 @@ func (p *parser) parseTailExpr(head ast.Expr, rbp int) ast.Expr {
 // -----------------
 
 func (p *parser) parseTypeExpr() ast.TypeExpr {
+	p.nest()
+	defer p.unnest()
 	var t ast.TypeExpr
 	if p.peek().Type == lexer.OPEN_PAREN {
 		// If a type expression is enclosed in parens:
-		p.consume(lexer.OPEN_PAREN)
+		openParen := p.consume(lexer.OPEN_PAREN)
 		if p.peek().Type == lexer.CLOSE_PAREN {
 			// If empty parens (), it is an explicit unit type expression
-			t = &ast.UnitTypeExpr{}
+			t = &ast.UnitTypeExpr{
+				Pos: openParen.SrcPos,
+			}
 		} else {
-			// If non-empty parens, ignore and parse the TypeExpr inside
+			// If non-empty parens, ignore and parse the TypeExpr inside, unless it is the first of a comma
+			// separated list of types, which makes the type expression a tuple type
 			t = p.parseTypeExpr()
+			if p.peek().Type == lexer.COMMA {
+				elemTypes := []ast.TypeExpr{t}
+				for p.peek().Type == lexer.COMMA {
+					p.consume(lexer.COMMA)
+					elemTypes = append(elemTypes, p.parseTypeExpr())
+				}
+				t = &ast.TupleTypeExpr{
+					Pos:       openParen.SrcPos,
+					ElemTypes: elemTypes,
+				}
+			}
 		}
 		p.consume(lexer.CLOSE_PAREN)
 	} else if p.peek().Type == lexer.FUNC {
@@ -424,12 +796,13 @@ func (p *parser) parseTypeExpr() ast.TypeExpr {
 	} else {
 		// The type expression must be a built-in like `i32` or a user defined type (e.g. `Foo` which is declared elsewhere)
 		// TODO: Should there be a StructTypeExpr for anonymous structs that start with the `struct` keyword (like FuncTypeExpr) ?
-		name := p.consume(lexer.IDENTIFIER).Value
+		name := p.consume(lexer.IDENTIFIER)
 		t = &ast.NamedTypeExpr{
-			TypeName: name,
+			Pos:      name.SrcPos,
+			TypeName: name.Value,
 		}
 	}
-	// If a type expression is followed by square brackets, then the complete type expression is T[]
+	// If a type expression is followed by square brackets, then the complete type expression is T[] or T[N]
 	if p.peek().Type == lexer.OPEN_BRACKET {
 		t = p.parseArrayTypeExpr(t)
 	}
@@ -437,10 +810,18 @@ func (p *parser) parseTypeExpr() ast.TypeExpr {
 }
 
 func (p *parser) parseArrayTypeExpr(innerType ast.TypeExpr) ast.TypeExpr {
+	p.nest()
+	defer p.unnest()
 	p.consume(lexer.OPEN_BRACKET)
+	var size ast.Expr
+	if p.peek().Type != lexer.CLOSE_BRACKET {
+		size = p.parseExpr(0)
+	}
 	p.consume(lexer.CLOSE_BRACKET)
 	arrayType := &ast.ArrayTypeExpr{
+		Pos:            innerType.Position(),
 		UnderlyingType: innerType,
+		Size:           size,
 	}
 	if p.peek().Type == lexer.OPEN_BRACKET {
 		return p.parseArrayTypeExpr(arrayType)
@@ -460,19 +841,28 @@ func (p *parser) parseArrayTypeExpr(innerType ast.TypeExpr) ast.TypeExpr {
 //
 // is a function type expression.
 func (p *parser) parseFuncTypeExpr() *ast.FuncTypeExpr {
-	p.consume(lexer.FUNC)
-	p.consume(lexer.OPEN_PAREN)
+	funcToken := p.consume(lexer.FUNC)
+	funcType := p.parseFuncSignature()
+	funcType.Pos = funcToken.SrcPos
+	return funcType
+}
+
+// Parses the parameter types and the optional return type of a function type expression,
+// i.e. everything following the `func` keyword. Also used for interface method signatures.
+func (p *parser) parseFuncSignature() *ast.FuncTypeExpr {
+	openParen := p.consume(lexer.OPEN_PAREN)
 	paramTypes := []ast.TypeExpr{}
 	for p.peek().Type != lexer.CLOSE_PAREN {
 		if p.peek().Type == lexer.IDENTIFIER {
-			name := p.consume(lexer.IDENTIFIER).Value
+			name := p.consume(lexer.IDENTIFIER)
 			if p.peek().Type == lexer.COLON {
 				p.consume(lexer.COLON)
 				paramType := p.parseTypeExpr()
 				paramTypes = append(paramTypes, paramType)
 			} else {
 				paramTypes = append(paramTypes, &ast.NamedTypeExpr{
-					TypeName: name,
+					Pos:      name.SrcPos,
+					TypeName: name.Value,
 				})
 			}
 		} else {
@@ -485,15 +875,18 @@ func (p *parser) parseFuncTypeExpr() *ast.FuncTypeExpr {
 			break
 		}
 	}
-	p.consume(lexer.CLOSE_PAREN)
+	closeParen := p.consume(lexer.CLOSE_PAREN)
 	var returnType ast.TypeExpr
 	if p.peek().Type == lexer.COLON {
 		p.consume(lexer.COLON)
 		returnType = p.parseTypeExpr()
 	} else {
-		returnType = &ast.UnitTypeExpr{}
+		returnType = &ast.UnitTypeExpr{
+			Pos: closeParen.SrcPos,
+		}
 	}
 	return &ast.FuncTypeExpr{
+		Pos:        openParen.SrcPos,
 		ReturnType: returnType,
 		ParamTypes: paramTypes,
 	}
@@ -503,18 +896,23 @@ func (p *parser) parseDeclAssignExpr(expr ast.Expr) *ast.VarDeclAssignExpr {
 	p.consume(lexer.COLON_EQUALS)
 	if identExpr, ok := expr.(*ast.IdentExpr); ok {
 		return &ast.VarDeclAssignExpr{
+			Pos:           identExpr.Pos,
 			Name:          identExpr.Value,
 			AssignedValue: p.parseExpr(0),
 		}
-	} else {
-		panic("The left-hand side of a declaration-assignment must be an identifier")
 	}
+	p.fail(expr.Position(), "the left-hand side of := must be an identifier")
+	return nil
 }
 
-// A variable declaration with a let- statement.
-func (p *parser) parseVarDeclStmt() *ast.VarDeclStmt {
-	p.consume(lexer.LET)
-	varName := p.consume(lexer.IDENTIFIER).Value
+// A variable declaration with a let- statement, or a const- statement for an immutable variable.
+// A parenthesized list of variables following the keyword declares a variable for each element of a tuple.
+func (p *parser) parseVarDeclStmt() ast.Stmt {
+	let := p.consume(lexer.LET, lexer.CONST)
+	if p.peek().Type == lexer.OPEN_PAREN {
+		return p.parseDestructuringVarDeclStmt(let)
+	}
+	varName := p.consume(lexer.IDENTIFIER)
 	var varType ast.TypeExpr = nil
 	if p.peek().Type == lexer.COLON {
 		p.consume(lexer.COLON)
@@ -527,30 +925,98 @@ func (p *parser) parseVarDeclStmt() *ast.VarDeclStmt {
 	}
 	p.consumeStatementTerminator()
 	return &ast.VarDeclStmt{
+		Pos: let.SrcPos,
 		Var: ast.TypedIdent{
-			Name: varName,
+			Pos:  varName.SrcPos,
+			Name: varName.Value,
 			Type: varType,
 		},
 		InitVal: initVal,
+		Const:   let.Type == lexer.CONST,
+	}
+}
+
+// The variables of a destructuring let- statement, each with an optional type, and the tuple value.
+func (p *parser) parseDestructuringVarDeclStmt(let lexer.Token) *ast.DestructuringVarDeclStmt {
+	p.consume(lexer.OPEN_PAREN)
+	vars := []*ast.TypedIdent{}
+	for p.peek().Type != lexer.CLOSE_PAREN {
+		varName := p.consume(lexer.IDENTIFIER)
+		var varType ast.TypeExpr
+		if p.peek().Type == lexer.COLON {
+			p.consume(lexer.COLON)
+			varType = p.parseTypeExpr()
+		}
+		vars = append(vars, &ast.TypedIdent{
+			Pos:  varName.SrcPos,
+			Name: varName.Value,
+			Type: varType,
+		})
+		if p.peek().Type == lexer.COMMA {
+			p.consume(lexer.COMMA)
+		} else {
+			break
+		}
+	}
+	p.consume(lexer.CLOSE_PAREN)
+	p.consume(lexer.EQUALS)
+	initVal := p.parseExpr(0)
+	p.consumeStatementTerminator()
+	return &ast.DestructuringVarDeclStmt{
+		Pos:     let.SrcPos,
+		Vars:    vars,
+		InitVal: initVal,
+		Const:   let.Type == lexer.CONST,
 	}
 }
 
 func (p *parser) parseFuncDeclStmt() *ast.FuncDeclStmt {
-	p.consume(lexer.FUNC)
+	funcToken := p.consume(lexer.FUNC)
+	// A parenthesized receiver before the name makes the function a method, e.g. `func (self: Point) length(): f64`
+	var receiver *ast.TypedIdent
+	if p.peek().Type == lexer.OPEN_PAREN {
+		p.consume(lexer.OPEN_PAREN)
+		receiverName := p.consume(lexer.IDENTIFIER)
+		p.consume(lexer.COLON)
+		receiver = &ast.TypedIdent{
+			Pos:  receiverName.SrcPos,
+			Name: receiverName.Value,
+			Type: p.parseTypeExpr(),
+		}
+		p.consume(lexer.CLOSE_PAREN)
+	}
 	name := p.consume(lexer.IDENTIFIER).Value
 	p.consume(lexer.OPEN_PAREN)
 	params := make([]*ast.TypedIdent, 0)
 	for p.peek().Type != lexer.CLOSE_PAREN {
-		paramName := p.consume(lexer.IDENTIFIER).Value
+		paramName := p.consume(lexer.IDENTIFIER)
 		p.consume(lexer.COLON)
-		paramType := p.parseTypeExpr()
-		params = append(params, &ast.TypedIdent{
-			Name: paramName,
-			Type: paramType,
-		})
-		if p.peek().Type == lexer.COMMA {
+		param := &ast.TypedIdent{
+			Pos:  paramName.SrcPos,
+			Name: paramName.Value,
+			Type: p.parseTypeExpr(),
+		}
+		if p.peek().Type == lexer.ELLIPSIS {
+			p.consume(lexer.ELLIPSIS)
+			param.Variadic = true
+		}
+		// An optional default value follows the type, e.g. `scale: i32 = 1`
+		if p.peek().Type == lexer.EQUALS {
+			if param.Variadic {
+				p.fail(param.Pos, "variadic parameter %s can't have a default value", param.Name)
+			}
+			p.consume(lexer.EQUALS)
+			param.Default = p.parseExpr(0)
+		}
+		params = append(params, param)
+		// The parameters are separated by commas, and a trailing comma is optional
+		if p.peek().Type != lexer.CLOSE_PAREN {
 			p.consume(lexer.COMMA)
 		}
+		// A variadic parameter takes the rest of the arguments
+		if param.Variadic && p.peek().Type != lexer.CLOSE_PAREN {
+			p.fail(param.Pos, "variadic parameter %s must be the last parameter", param.Name)
+		}
 	}
 	p.consume(lexer.CLOSE_PAREN)
 	var returnType ast.TypeExpr
@@ -562,6 +1028,8 @@ func (p *parser) parseFuncDeclStmt() *ast.FuncDeclStmt {
 	funcBody := p.parseBlockStmt()
 	p.consume(lexer.CLOSE_CURLY)
 	return &ast.FuncDeclStmt{
+		Pos:        funcToken.SrcPos,
+		Receiver:   receiver,
 		Name:       name,
 		Parameters: params,
 		ReturnType: returnType,
@@ -577,37 +1045,104 @@ func (p *parser) parseFuncDeclStmt() *ast.FuncDeclStmt {
 //	  baz: string,
 //	}
 func (p *parser) parseStructDeclStmt() *ast.StructDeclStmt {
-	p.consume(lexer.STRUCT)
+	structToken := p.consume(lexer.STRUCT)
 	name := p.consume(lexer.IDENTIFIER).Value
-	p.consume(lexer.OPEN_CURLY)
-	p.parenStack = append(p.parenStack, lexer.OPEN_CURLY)
+	openCurly := p.consume(lexer.OPEN_CURLY)
+	p.parenStack = append(p.parenStack, openCurly)
 	members := make([]*ast.TypedIdent, 0)
 	for p.peek().Type != lexer.CLOSE_CURLY {
-		memberName := p.consume(lexer.IDENTIFIER).Value
+		memberName := p.consume(lexer.IDENTIFIER)
 		p.consume(lexer.COLON)
 		memberType := p.parseTypeExpr()
 		newMember := &ast.TypedIdent{
-			Name: memberName,
+			Pos:  memberName.SrcPos,
+			Name: memberName.Value,
 			Type: memberType,
 		}
 		members = append(members, newMember)
-		if p.peek().Type == lexer.COMMA {
+		// Like in struct literals, the trailing comma is optional
+		if p.peek().Type != lexer.CLOSE_CURLY {
 			p.consume(lexer.COMMA)
 		}
 	}
 	p.consume(lexer.CLOSE_CURLY)
 	return &ast.StructDeclStmt{
+		Pos:     structToken.SrcPos,
 		Name:    name,
 		Members: members,
 	}
 }
 
+// A closed set of named values, accessed through the name of the enum, e.g. `Color.Red`.
+// Like in use blocks, the trailing comma is mandatory.
+// Example:
+//
+//	enum Color {
+//	  Red,
+//	  Green,
+//	}
+func (p *parser) parseEnumDeclStmt() *ast.EnumDeclStmt {
+	enumToken := p.consume(lexer.ENUM)
+	name := p.consume(lexer.IDENTIFIER).Value
+	openCurly := p.consume(lexer.OPEN_CURLY)
+	p.parenStack = append(p.parenStack, openCurly)
+	variants := make([]string, 0)
+	for p.peek().Type != lexer.CLOSE_CURLY {
+		variants = append(variants, p.consume(lexer.IDENTIFIER).Value)
+		p.consume(lexer.COMMA)
+	}
+	p.consume(lexer.CLOSE_CURLY)
+	return &ast.EnumDeclStmt{
+		Pos:      enumToken.SrcPos,
+		Name:     name,
+		Variants: variants,
+	}
+}
+
+// A set of method signatures, which a struct satisfies by declaring all of them as its methods.
+// Example:
+//
+//	interface Shape {
+//	  area(): f64,
+//	  scale(factor: f64),
+//	}
+func (p *parser) parseInterfaceDeclStmt() *ast.InterfaceDeclStmt {
+	interfaceToken := p.consume(lexer.INTERFACE)
+	name := p.consume(lexer.IDENTIFIER).Value
+	openCurly := p.consume(lexer.OPEN_CURLY)
+	p.parenStack = append(p.parenStack, openCurly)
+	methods := make([]*ast.TypedIdent, 0)
+	for p.peek().Type != lexer.CLOSE_CURLY {
+		methodName := p.consume(lexer.IDENTIFIER)
+		methods = append(methods, &ast.TypedIdent{
+			Pos:  methodName.SrcPos,
+			Name: methodName.Value,
+			Type: p.parseFuncSignature(),
+		})
+		// Like in struct declarations, the trailing comma is optional
+		if p.peek().Type != lexer.CLOSE_CURLY {
+			p.consume(lexer.COMMA)
+		}
+	}
+	p.consume(lexer.CLOSE_CURLY)
+	return &ast.InterfaceDeclStmt{
+		Pos:     interfaceToken.SrcPos,
+		Name:    name,
+		Methods: methods,
+	}
+}
+
 // Example:
 //
 // let x = if x < 0 then 0 else x
 func (p *parser) parseIfExpr() *ast.IfExpr {
-	cond := p.parseExpr(0)
-	p.consume(lexer.THEN)
+	// The `if` keyword has already been consumed as the head token
+	ifToken := p.prevToken()
+	cond := p.parseExprBeforeBlock()
+	// Like in the if- statement, `then` is optional before a block
+	if p.peek().Type != lexer.OPEN_CURLY {
+		p.consume(lexer.THEN)
+	}
 	var thenExpr ast.Expr
 	if p.peek().Type == lexer.OPEN_CURLY {
 		p.consume(lexer.OPEN_CURLY)
@@ -620,9 +1155,16 @@ func (p *parser) parseIfExpr() *ast.IfExpr {
 	// (the `beforeSemicolon` and `afterSemicolon` categories can't differentiate
 	// between lexer.ELSE in a statement vs expression context)
 	// so just consume it silently if there is one
+	beforeSemicolon := p.pos
 	if p.peek().Type == lexer.SEMICOLON {
 		p.consume(lexer.SEMICOLON)
 	}
+	// Unlike the if- statement, the expression must have a value whether the condition holds or not. The
+	// semicolon is left to terminate the statement, for the error recovery to resume after it.
+	if p.peek().Type != lexer.ELSE {
+		p.pos = beforeSemicolon
+		p.fail(p.peek().SrcPos, "missing else branch of if- expression")
+	}
 	var elseExpr ast.Expr
 	p.consume(lexer.ELSE)
 	if p.peek().Type == lexer.OPEN_CURLY {
@@ -633,23 +1175,37 @@ func (p *parser) parseIfExpr() *ast.IfExpr {
 		elseExpr = p.parseExpr(0)
 	}
 	return &ast.IfExpr{
+		Pos:  ifToken.SrcPos,
 		Cond: cond,
 		Then: thenExpr,
 		Else: elseExpr,
 	}
 }
 
+// The else branch may be another if- statement, making an else-if chain, e.g.
+// `if a then foo() else if b then bar() else baz()`, in which each else belongs to the nearest if
+// before it without one. The else branch of an if- statement nested in the then branch of another one
+// is thus terminated by the else of the outer one, like the then branch.
+//
+// The condition is followed by `then`, or directly by the block of the then branch. As the block ends the
+// condition, a struct literal in it must be parenthesized, like in the subject of a match expression.
+//
 // Example:
 //
-//	if x < 0 then {
+//	if x < 0 {
 //	  doA()
 //	  doB()
-//	}
+//	} else if x > 0 then doC()
 func (p *parser) parseIfStmt() ast.Stmt {
-	p.consume(lexer.IF)
-	cond := p.parseExpr(0)
-	p.consume(lexer.THEN)
+	ifToken := p.consume(lexer.IF)
+	cond := p.parseExprBeforeBlock()
+	// The then branch is either a block, optionally preceded by `then`, or a single statement preceded by it.
+	// Without a block, `then` is needed to tell where the condition ends.
+	if p.peek().Type != lexer.OPEN_CURLY {
+		p.consume(lexer.THEN)
+	}
 	var thenStmt ast.Stmt
+	inThenBranch := p.inThenBranch
 	if p.peek().Type == lexer.OPEN_CURLY {
 		p.consume(lexer.OPEN_CURLY)
 		thenStmt = p.parseBlockStmt()
@@ -657,7 +1213,7 @@ func (p *parser) parseIfStmt() ast.Stmt {
 	} else {
 		p.inThenBranch = true
 		thenStmt = p.parseStmt()
-		p.inThenBranch = false
+		p.inThenBranch = inThenBranch
 	}
 	var elseStmt ast.Stmt
 	if p.peek().Type == lexer.ELSE {
@@ -671,6 +1227,7 @@ func (p *parser) parseIfStmt() ast.Stmt {
 		}
 	}
 	return &ast.IfStmt{
+		Pos:  ifToken.SrcPos,
 		Cond: cond,
 		Then: thenStmt,
 		Else: elseStmt,
@@ -679,16 +1236,18 @@ func (p *parser) parseIfStmt() ast.Stmt {
 
 // TODO: Ranges
 func (p *parser) parseForStmt() ast.Stmt {
-	p.consume(lexer.FOR)
+	forToken := p.consume(lexer.FOR)
 	p.consume(lexer.OPEN_PAREN)
 	initStmt := p.parseStmt()
 	condExpr := p.parseExpressionStmt().(*ast.ExpressionStmt).Expr
-	iterStmt := &ast.ExpressionStmt{Expr: p.parseExpr(0)}
+	iterExpr := p.parseExpr(0)
+	iterStmt := &ast.ExpressionStmt{Pos: iterExpr.Position(), Expr: iterExpr}
 	p.consume(lexer.CLOSE_PAREN)
 	p.consume(lexer.OPEN_CURLY)
 	body := p.parseBlockStmt()
 	p.consume(lexer.CLOSE_CURLY)
 	return &ast.ForStmt{
+		Pos:  forToken.SrcPos,
 		Init: initStmt,
 		Cond: condExpr,
 		Iter: iterStmt,
@@ -696,37 +1255,71 @@ func (p *parser) parseForStmt() ast.Stmt {
 	}
 }
 
+// Parses the shorthand `cond ? a : b` of the if- expression `if cond then a else b`, the condition of which
+// has already been parsed. It is right associative, so `a ? b : c ? d : e` is `a ? b : (c ? d : e)`.
+func (p *parser) parseConditionalExpr(cond ast.Expr, rbp int) *ast.IfExpr {
+	p.consume(lexer.QUESTION)
+	thenExpr := p.parseExpr(0)
+	p.consume(lexer.COLON)
+	elseExpr := p.parseExpr(rbp)
+	return &ast.IfExpr{
+		Pos:  cond.Position(),
+		Cond: cond,
+		Then: thenExpr,
+		Else: elseExpr,
+	}
+}
+
+func (p *parser) parseCastExpr(left ast.Expr) *ast.CastExpr {
+	p.consume(lexer.AS)
+	targetType := p.parseTypeExpr()
+	return &ast.CastExpr{
+		Pos:        left.Position(),
+		Expr:       left,
+		TargetType: targetType,
+	}
+}
+
 func (p *parser) parseFuncCallExpr(left ast.Expr) *ast.FuncCallExpr {
 	p.consume(lexer.OPEN_PAREN)
 	args := []ast.Expr{}
 	for p.peek().Type != lexer.CLOSE_PAREN {
 		args = append(args, p.parseExpr(0))
-		if p.peek().Type == lexer.COMMA {
+		// The arguments are separated by commas, and a trailing comma is optional
+		if p.peek().Type != lexer.CLOSE_PAREN {
 			p.consume(lexer.COMMA)
 		}
 	}
-	p.consume(lexer.CLOSE_PAREN)
+	closeParen := p.consume(lexer.CLOSE_PAREN)
 	return &ast.FuncCallExpr{
-		Func: left,
-		Args: args,
+		Pos:        left.Position(),
+		Func:       left,
+		Args:       args,
+		CloseParen: closeParen.SrcPos,
 	}
 }
 
+// The members are separated by commas, and unlike in use blocks, the trailing comma is optional, so that
+// a short literal fits on one line, e.g. `Point{ x: 1, y: 2 }`.
 func (p *parser) parseStructLiteralExpr(left ast.Expr) *ast.StructLiteralExpr {
-	p.consume(lexer.OPEN_CURLY)
-	p.parenStack = append(p.parenStack, lexer.OPEN_CURLY)
+	openCurly := p.consume(lexer.OPEN_CURLY)
+	p.parenStack = append(p.parenStack, openCurly)
 	members := []*ast.MemberAssignExpr{}
 	for p.peek().Type != lexer.CLOSE_CURLY {
-		memberName := p.consume(lexer.IDENTIFIER).Value
+		memberName := p.consume(lexer.IDENTIFIER)
 		p.consume(lexer.COLON)
 		members = append(members, &ast.MemberAssignExpr{
-			Name:  memberName,
+			Pos:   memberName.SrcPos,
+			Name:  memberName.Value,
 			Value: p.parseExpr(0),
 		})
-		p.consume(lexer.COMMA)
+		if p.peek().Type != lexer.CLOSE_CURLY {
+			p.consume(lexer.COMMA)
+		}
 	}
 	p.consume(lexer.CLOSE_CURLY)
 	return &ast.StructLiteralExpr{
+		Pos:     left.Position(),
 		Struct:  left,
 		Members: members,
 	}
@@ -734,10 +1327,13 @@ func (p *parser) parseStructLiteralExpr(left ast.Expr) *ast.StructLiteralExpr {
 
 func (p *parser) parseStructMemberExpr(left ast.Expr) *ast.StructMemberExpr {
 	p.consume(lexer.DOT)
+	member := p.consume(lexer.IDENTIFIER)
 	return &ast.StructMemberExpr{
+		Pos:    left.Position(),
 		Struct: left,
 		Member: &ast.IdentExpr{
-			Value: p.consume(lexer.IDENTIFIER).Value,
+			Pos:   member.SrcPos,
+			Value: member.Value,
 		},
 	}
 }
@@ -747,60 +1343,184 @@ func (p *parser) parseArrayIndexExpr(left ast.Expr) *ast.ArrayIndexExpr {
 	indexExpr := p.parseExpr(0)
 	p.consume(lexer.CLOSE_BRACKET)
 	return &ast.ArrayIndexExpr{
+		Pos:   left.Position(),
 		Array: left,
 		Index: indexExpr,
 	}
 }
 
+// The loop keyword has been consumed already, as the head of the expression.
+func (p *parser) parseLoopExpr(loopToken lexer.Token) *ast.LoopExpr {
+	p.consume(lexer.OPEN_CURLY)
+	body := p.parseBlockStmt()
+	p.consume(lexer.CLOSE_CURLY)
+	return &ast.LoopExpr{
+		Pos:  loopToken.SrcPos,
+		Body: body,
+	}
+}
+
+// Parses an expression followed directly by a block, like the subject of a match expression. An open curly
+// brace outside parentheses ends the expression, so a struct literal in it must be parenthesized, e.g.
+// `match (Point{ x: 1 }) { ... }`.
+func (p *parser) parseExprBeforeBlock() ast.Expr {
+	outerDepth := p.blockFollowsDepth
+	p.blockFollowsDepth = len(p.parenStack)
+	expr := p.parseExpr(0)
+	p.blockFollowsDepth = outerDepth
+	return expr
+}
+
+// Like in use blocks, the trailing comma of each arm is mandatory. The match keyword has been consumed
+// already, as the head of the expression.
+//
+//	match n {
+//	  0 => "zero",
+//	  1 => "one",
+//	  _ => "many",
+//	}
+func (p *parser) parseMatchExpr(matchToken lexer.Token) *ast.MatchExpr {
+	subject := p.parseExprBeforeBlock()
+	openCurly := p.consume(lexer.OPEN_CURLY)
+	p.parenStack = append(p.parenStack, openCurly)
+	arms := []*ast.MatchArm{}
+	for p.peek().Type != lexer.CLOSE_CURLY {
+		pattern := p.parseMatchPattern()
+		p.consume(lexer.FAT_ARROW)
+		arms = append(arms, &ast.MatchArm{
+			Pos:     pattern.Position(),
+			Pattern: pattern,
+			Body:    p.parseExpr(0),
+		})
+		p.consume(lexer.COMMA)
+	}
+	p.consume(lexer.CLOSE_CURLY)
+	return &ast.MatchExpr{
+		Pos:     matchToken.SrcPos,
+		Subject: subject,
+		Arms:    arms,
+	}
+}
+
+// A pattern is a literal, possibly a negative number, the `_` wildcard, or an identifier binding the subject.
+func (p *parser) parseMatchPattern() ast.Expr {
+	token := p.consume()
+	switch token.Type {
+	case lexer.UNDERSCORE:
+		return &ast.WildcardExpr{Pos: token.SrcPos}
+	case lexer.NUMBER, lexer.STRING, lexer.IDENTIFIER, lexer.TRUE, lexer.FALSE:
+		return p.parseHeadExpr(token)
+	case lexer.DASH:
+		number := p.consume(lexer.NUMBER)
+		return &ast.UnaryExpr{
+			Pos:      token.SrcPos,
+			Operator: token,
+			Rhs:      p.parseHeadExpr(number),
+		}
+	default:
+		p.fail(token.SrcPos, "unexpected %s in a match pattern", token.Type)
+		return nil
+	}
+}
+
+func (p *parser) parseBreakStmt() *ast.BreakStmt {
+	breakToken := p.consume(lexer.BREAK)
+	if p.statementTerminates() {
+		p.consumeStatementTerminator()
+		return &ast.BreakStmt{Pos: breakToken.SrcPos}
+	}
+	value := p.parseExpr(0)
+	p.consumeStatementTerminator()
+	return &ast.BreakStmt{Pos: breakToken.SrcPos, Value: value}
+}
+
 func (p *parser) parseReturnStmt() *ast.ReturnStmt {
-	p.consume(lexer.RETURN)
+	returnToken := p.consume(lexer.RETURN)
 	if p.statementTerminates() {
 		p.consumeStatementTerminator()
-		return &ast.ReturnStmt{Expr: nil}
+		return &ast.ReturnStmt{Pos: returnToken.SrcPos, Expr: nil}
 	}
 	expr := p.parseExpr(0)
+	// Multiple values are returned as a tuple, i.e. `return a, b` is `return (a, b)`
+	if p.peek().Type == lexer.COMMA {
+		elems := []ast.Expr{expr}
+		for p.peek().Type == lexer.COMMA {
+			p.consume(lexer.COMMA)
+			elems = append(elems, p.parseExpr(0))
+		}
+		expr = &ast.TupleExpr{Pos: expr.Position(), Elems: elems}
+	}
 	p.consumeStatementTerminator()
-	return &ast.ReturnStmt{Expr: expr}
+	return &ast.ReturnStmt{Pos: returnToken.SrcPos, Expr: expr}
 }
 
 func (p *parser) parseExpressionStmt() ast.Stmt {
 	expr := p.parseExpr(0)
-	explicitSemicolon := p.peek().Type == lexer.SEMICOLON
+	explicitSemicolon := p.peek().Type == lexer.SEMICOLON && p.peek().Value == ";"
 	p.consumeStatementTerminator()
 	return &ast.ExpressionStmt{
+		Pos:               expr.Position(),
 		Expr:              expr,
 		ExplicitSemicolon: explicitSemicolon,
 	}
 }
 
+// Parses the statements of a block, the opening curly brace of which has already been consumed.
 func (p *parser) parseBlockStmt() *ast.BlockStmt {
+	openCurly := p.prevToken()
 	statements := []ast.Stmt{}
 	for token := p.peek(); token.Type != lexer.EOF && token.Type != lexer.CLOSE_CURLY; token = p.peek() {
-		statements = append(statements, p.parseStmt())
+		if p.skipEmptyStmt() {
+			continue
+		}
+		if stmt := p.parseStmtOrSkip(); stmt != nil {
+			statements = append(statements, stmt)
+		}
 	}
 	return &ast.BlockStmt{
+		Pos:        openCurly.SrcPos,
 		Statements: statements,
 	}
 }
 
+// Parses the statements of a block expression. A trailing expression statement is the result of the block,
+// unless it ends in an explicit semicolon, which discards its value.
 func (p *parser) parseBlockExpr() *ast.BlockExpr {
-	statements := p.parseBlockStmt().Statements
-	var resultExpr ast.Expr = &ast.UnitExpr{}
+	block := p.parseBlockStmt()
+	statements := block.Statements
+	// A block without a result expression evaluates to unit at its closing curly brace
+	var resultExpr ast.Expr = &ast.UnitExpr{Pos: p.peek().SrcPos}
 	if len(statements) > 0 {
-		if exprStmt, ok := statements[len(statements)-1].(*ast.ExpressionStmt); ok {
+		if exprStmt, ok := statements[len(statements)-1].(*ast.ExpressionStmt); ok && !exprStmt.ExplicitSemicolon {
 			resultExpr = exprStmt.Expr
 			statements = statements[:len(statements)-1]
 		}
 	}
 	return &ast.BlockExpr{
+		Pos:        block.Pos,
 		Statements: statements,
 		ResultExpr: resultExpr,
 	}
 }
 
+// A module is named by an identifier, or by a string literal of a path with slash separated segments,
+// e.g. `"std/io"`.
+func (p *parser) parseModulePath() ast.ModulePath {
+	token := p.consume(lexer.IDENTIFIER, lexer.STRING)
+	if token.Type == lexer.IDENTIFIER {
+		return ast.ModulePath{Segments: []string{token.Value}}
+	}
+	path, err := strconv.Unquote(token.Value)
+	segments := strings.Split(path, "/")
+	if err != nil || slices.Contains(segments, "") {
+		p.fail(token.SrcPos, "invalid module path %s", token.Value)
+	}
+	return ast.ModulePath{Segments: segments, Quoted: true}
+}
+
 // Always required to be a use block, to minimize diff noise from when the number of declared uses
-// goes from 1 -> 2. Like struct declarations and struct literals, trailing comma is mandatory,
-// for the same reason: consistency and less noise in diffs.
+// goes from 1 -> 2. The trailing comma is mandatory, for the same reason: consistency and less noise
+// in diffs.
 //
 //	use {
 //	  moduleNameOrPath,
@@ -808,20 +1528,22 @@ func (p *parser) parseBlockExpr() *ast.BlockExpr {
 //	}
 func (p *parser) parseUseDeclStmt() *ast.UseDeclStmt {
 	specs := make([]*ast.UseSpecExpr, 0)
-	p.consume(lexer.USE)
-	p.consume(lexer.OPEN_CURLY)
+	useToken := p.consume(lexer.USE)
+	openCurly := p.consume(lexer.OPEN_CURLY)
+	p.parenStack = append(p.parenStack, openCurly)
 	for p.peek().Type != lexer.CLOSE_CURLY {
-		name := p.consume(lexer.IDENTIFIER).Value
+		name := p.consume(lexer.IDENTIFIER)
 		p.consume(lexer.COLON)
-		module := p.consume(lexer.IDENTIFIER).Value
 		specs = append(specs, &ast.UseSpecExpr{
-			Name:   name,
-			Module: module,
+			Pos:    name.SrcPos,
+			Name:   name.Value,
+			Module: p.parseModulePath(),
 		})
 		p.consume(lexer.COMMA)
 	}
 	p.consume(lexer.CLOSE_CURLY)
 	return &ast.UseDeclStmt{
+		Pos:      useToken.SrcPos,
 		UseSpecs: specs,
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"github.com/ruistola/cooper/ast"
 	"github.com/ruistola/cooper/lexer"
 	"github.com/yassinebenaid/godump"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -95,6 +97,132 @@ func TestIfExpressionSemicolonNewline(t *testing.T) {
 	}
 }
 
+// An if- expression must have an else branch to have a value in either case, while an if- statement needn't
+func TestIfExpressionWithoutElse(t *testing.T) {
+	if _, err := ParseSource("let x: i32 = if c then 1 else 2"); err != nil {
+		t.Errorf("Expected the complete if- expression to parse, got %v", err)
+	}
+	if _, err := ParseSource("if c then x = 1"); err != nil {
+		t.Errorf("Expected the if- statement to parse, got %v", err)
+	}
+	testCases := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{"at the end of the source", "let x: i32 = if c then 1", []string{"1:25: missing else branch of if- expression"}},
+		{"before another statement", "x = if c then { 1 }\nlet y: i32 = 2", []string{"1:20: missing else branch of if- expression"}},
+		{"recovering at the next statement", "let x: i32 = if c then 1; let y: i32 = )", []string{
+			"1:25: missing else branch of if- expression",
+			"1:40: unmatched ')'",
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, syntaxErrors := ParseSafe(lexer.Tokenize(tc.src))
+			messages := []string{}
+			for _, err := range syntaxErrors {
+				messages = append(messages, err.Error())
+			}
+			if !slices.Equal(messages, tc.expected) {
+				t.Errorf("Expected %q, got %q", tc.expected, messages)
+			}
+		})
+	}
+}
+
+func TestConditionalExpression(t *testing.T) {
+	testCases := []struct {
+		src      string
+		expected string
+	}{
+		{"result = a ? b : c ? d : e", "result = if a then b else if c then d else e"},
+		{"result = (a ? b : c) ? d : e", "result = if (if a then b else c) then d else e"},
+		{"result = x < 5 ? x + 1 : -x * 2", "result = if x < 5 then x + 1 else -x * 2"},
+		{"result = a ? b = 1 : c", "result = if a then b = 1 else c"},
+	}
+	for _, tc := range testCases {
+		conditional, err := ParseSource(tc.src)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tc.src, err)
+		}
+		if diff := ast.Diff(Parse(lexer.Tokenize(tc.expected)), conditional); diff != "" {
+			t.Errorf("Expected %s to parse like %s: %s", tc.src, tc.expected, diff)
+		}
+	}
+	if _, err := ParseSource("result = a ? b"); err == nil || !strings.Contains(err.Error(), "expected colon") {
+		t.Errorf("Expected an error about the missing colon, got %v", err)
+	}
+}
+
+func TestCastExpression(t *testing.T) {
+	ident := func(name string) ast.Expr { return &ast.IdentExpr{Value: name} }
+	cast := func(expr ast.Expr, typeName string) ast.Expr {
+		return &ast.CastExpr{Expr: expr, TargetType: &ast.NamedTypeExpr{TypeName: typeName}}
+	}
+	binary := func(lhs ast.Expr, operator lexer.TokenType, value string, rhs ast.Expr) ast.Expr {
+		return &ast.BinaryExpr{Lhs: lhs, Operator: lexer.Token{Type: operator, Value: value}, Rhs: rhs}
+	}
+	testCases := []struct {
+		src      string
+		expected ast.Expr
+	}{
+		{"a * b as i64", binary(ident("a"), lexer.STAR, "*", cast(ident("b"), "i64"))},
+		{"-x as i64", &ast.UnaryExpr{Operator: lexer.Token{Type: lexer.DASH, Value: "-"}, Rhs: cast(ident("x"), "i64")}},
+		{"x ^ y as f64", cast(binary(ident("x"), lexer.CHEVRON, "^", ident("y")), "f64")},
+		{"x as i64 as i8 + 1", binary(cast(cast(ident("x"), "i64"), "i8"), lexer.PLUS, "+", &ast.NumberLiteralExpr{Value: "1"})},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			expr := Parse(lexer.Tokenize(tc.src)).Statements[0].(*ast.ExpressionStmt).Expr
+			if diff := ast.Diff(tc.expected, expr); diff != "" {
+				t.Errorf("Expected %s to parse as %s, got %s (%s)", tc.src, ast.Print(tc.expected), ast.Print(expr), diff)
+			}
+		})
+	}
+}
+
+func TestFixedSizeArrayType(t *testing.T) {
+	i32 := &ast.NamedTypeExpr{TypeName: "i32"}
+	testCases := []struct {
+		src      string
+		expected ast.TypeExpr
+	}{
+		{"let a: i32[4]", &ast.ArrayTypeExpr{UnderlyingType: i32, Size: &ast.NumberLiteralExpr{Value: "4"}}},
+		{"let a: i32[]", &ast.ArrayTypeExpr{UnderlyingType: i32}},
+		{"let a: i32[2 * 3][]", &ast.ArrayTypeExpr{
+			UnderlyingType: &ast.ArrayTypeExpr{
+				UnderlyingType: i32,
+				Size: &ast.BinaryExpr{
+					Lhs:      &ast.NumberLiteralExpr{Value: "2"},
+					Operator: lexer.Token{Type: lexer.STAR, Value: "*"},
+					Rhs:      &ast.NumberLiteralExpr{Value: "3"},
+				},
+			},
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			typeExpr := Parse(lexer.Tokenize(tc.src)).Statements[0].(*ast.VarDeclStmt).Var.Type
+			if diff := ast.Diff(tc.expected, typeExpr); diff != "" {
+				t.Errorf("Expected %s to parse as %s, got %s (%s)", tc.src, ast.Print(tc.expected), ast.Print(typeExpr), diff)
+			}
+		})
+	}
+}
+
+// The parser rewrites the endlines in its own buffer, without modifying the tokens passed to it
+func TestParseKeepsTokens(t *testing.T) {
+	tokens := lexer.Tokenize("x = 1\ny = {\n  2\n}\n")
+	original := slices.Clone(tokens)
+	if _, syntaxErrors := ParseSafe(tokens); len(syntaxErrors) > 0 {
+		t.Fatal(syntaxErrors)
+	}
+	if !slices.Equal(tokens, original) {
+		t.Errorf("Expected the tokens to be unchanged, got %v", tokens)
+	}
+}
+
 func TestIfStatementExplicitSemicolon(t *testing.T) {
 	src := "if x < 5 then foo(); else bar();"
 	parsedAst := Parse(lexer.Tokenize(src))
@@ -150,6 +278,43 @@ func TestOneLineIfStatementComplex(t *testing.T) {
 	if testing.Verbose() {
 		godump.Dump(parsedAst)
 	}
+	exprStmt := func(expr ast.Expr) *ast.ExpressionStmt { return &ast.ExpressionStmt{Expr: expr} }
+	binary := func(lhs string, operator lexer.TokenType, value string, rhs string) *ast.BinaryExpr {
+		return &ast.BinaryExpr{
+			Lhs:      &ast.IdentExpr{Value: lhs},
+			Operator: lexer.Token{Type: operator, Value: value},
+			Rhs:      &ast.NumberLiteralExpr{Value: rhs},
+		}
+	}
+	expected := &ast.IfStmt{
+		Cond: binary("x", lexer.LESS, "<", "5"),
+		Then: exprStmt(&ast.FuncCallExpr{Func: &ast.IdentExpr{Value: "foo"}, Args: []ast.Expr{}}),
+		Else: &ast.IfStmt{
+			Cond: binary("x", lexer.GREATER, ">", "10"),
+			Then: exprStmt(&ast.NumberLiteralExpr{Value: "100"}),
+			Else: exprStmt(&ast.NumberLiteralExpr{Value: "10"}),
+		},
+	}
+	if diff := ast.Diff(expected, parsedAst.Statements[0]); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+}
+
+// An else-if chain nested in the then branch of an if- statement ends at the else of the outer one
+func TestNestedElseIfChain(t *testing.T) {
+	nested, err := ParseSource("if a then if b then foo() else if c then bar() else baz() else qux()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	braced := Parse(lexer.Tokenize("if a then { if b then foo() else { if c then bar() else baz() } } else qux()"))
+	unwrap := func(stmt ast.Stmt) ast.Stmt { return stmt.(*ast.BlockStmt).Statements[0] }
+	outer := braced.Statements[0].(*ast.IfStmt)
+	outer.Then = unwrap(outer.Then)
+	inner := outer.Then.(*ast.IfStmt)
+	inner.Else = unwrap(inner.Else)
+	if diff := ast.Diff(braced, nested); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
 }
 
 func TestFuncArrayType(t *testing.T) {
@@ -309,3 +474,667 @@ func TestBlockValueSemantics(t *testing.T) {
 		}
 	}
 }
+
+// An explicit semicolon discards the value of the last expression of a block, which then evaluates to unit
+func TestBlockDiscardedResult(t *testing.T) {
+	for _, tc := range []struct {
+		src       string
+		discarded bool
+	}{
+		{"{\n  f()\n}", false},
+		{"{ f() }", false},
+		{"{\n  f();\n}", true},
+		{"{ f(); }", true},
+	} {
+		block := Parse(lexer.Tokenize(tc.src)).Statements[0].(*ast.ExpressionStmt).Expr.(*ast.BlockExpr)
+		_, isUnit := block.ResultExpr.(*ast.UnitExpr)
+		if isUnit != tc.discarded || len(block.Statements) != map[bool]int{false: 0, true: 1}[tc.discarded] {
+			t.Errorf("Expected the value of f() to be discarded (%t) in %q, got %s", tc.discarded, tc.src, ast.Print(block))
+		}
+	}
+}
+
+// The same program must parse into the same AST regardless of the line endings used
+func TestLineEndings(t *testing.T) {
+	src := `struct Point {
+  x: i32,
+  y: i32,
+}
+
+func origin(): Point {
+  p := Point{
+    x: 0,
+    y: 0,
+  }
+  if p.x < 0 then return p
+  p.x = p.x + 1
+  p
+}
+`
+	lfAst := Parse(lexer.Tokenize(src))
+	for _, eol := range []string{"\r\n", "\r"} {
+		parsedAst := Parse(lexer.Tokenize(strings.ReplaceAll(src, "\n", eol)))
+		if diff := ast.Diff(lfAst, parsedAst); diff != "" {
+			t.Errorf("AST parsed with %q line endings differs from the one parsed with \"\\n\": %s", eol, diff)
+			if testing.Verbose() {
+				godump.Dump(parsedAst)
+			}
+		}
+	}
+}
+
+func TestSourcePositions(t *testing.T) {
+	src := `func f(n: i32) {
+  let p: Point = Point{ x: n, }
+  if p.x < 0 then return
+  g(p.x, -1)
+}`
+	funcDecl := Parse(lexer.Tokenize(src)).Statements[0].(*ast.FuncDeclStmt)
+	varDecl := funcDecl.Body.Statements[0].(*ast.VarDeclStmt)
+	structLiteral := varDecl.InitVal.(*ast.StructLiteralExpr)
+	ifStmt := funcDecl.Body.Statements[1].(*ast.IfStmt)
+	cond := ifStmt.Cond.(*ast.BinaryExpr)
+	call := funcDecl.Body.Statements[2].(*ast.ExpressionStmt).Expr.(*ast.FuncCallExpr)
+
+	testCases := []struct {
+		name     string
+		node     ast.Node
+		expected string
+	}{
+		{"function declaration", funcDecl, "1:1"},
+		{"parameter", funcDecl.Parameters[0], "1:8"},
+		{"parameter type", funcDecl.Parameters[0].Type, "1:11"},
+		{"function body", funcDecl.Body, "1:16"},
+		{"variable declaration", varDecl, "2:3"},
+		{"declared variable", &varDecl.Var, "2:7"},
+		{"struct literal", structLiteral, "2:18"},
+		{"struct literal member", structLiteral.Members[0], "2:25"},
+		{"if statement", ifStmt, "3:3"},
+		{"binary expression", cond, "3:6"},
+		{"struct member", cond.Lhs.(*ast.StructMemberExpr).Member, "3:8"},
+		{"return statement", ifStmt.Then, "3:19"},
+		{"function call", call, "4:3"},
+		{"unary expression", call.Args[1], "4:10"},
+	}
+	for _, tc := range testCases {
+		if pos := tc.node.Position().String(); pos != tc.expected {
+			t.Errorf("Expected %s at %s, found at %s", tc.name, tc.expected, pos)
+		}
+	}
+}
+
+func TestExponentPrecedence(t *testing.T) {
+	number := func(value string) ast.Expr {
+		return &ast.NumberLiteralExpr{Value: value}
+	}
+	power := func(lhs, rhs ast.Expr) ast.Expr {
+		return &ast.BinaryExpr{Lhs: lhs, Operator: lexer.Token{Type: lexer.CHEVRON, Value: "^"}, Rhs: rhs}
+	}
+	negate := func(rhs ast.Expr) ast.Expr {
+		return &ast.UnaryExpr{Operator: lexer.Token{Type: lexer.DASH, Value: "-"}, Rhs: rhs}
+	}
+	testCases := []struct {
+		src      string
+		expected ast.Expr
+	}{
+		{"-2 ^ 2", negate(power(number("2"), number("2")))},
+		{"(-2) ^ 2", power(&ast.GroupExpr{Expr: negate(number("2"))}, number("2"))},
+		{"2 ^ 3 ^ 2", power(number("2"), power(number("3"), number("2")))},
+		{"2 ^ -2", power(number("2"), negate(number("2")))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			expr := Parse(lexer.Tokenize(tc.src)).Statements[0].(*ast.ExpressionStmt).Expr
+			if diff := ast.Diff(tc.expected, expr); diff != "" {
+				t.Errorf("Expected %s to parse as %s, got %s (%s)", tc.src, ast.Print(tc.expected), ast.Print(expr), diff)
+			}
+		})
+	}
+}
+
+// Binary operators associate to the left, and assignments to the right
+func TestAssociativity(t *testing.T) {
+	ident := func(name string) ast.Expr { return &ast.IdentExpr{Value: name} }
+	binary := func(lhs ast.Expr, operator lexer.TokenType, value string, rhs ast.Expr) ast.Expr {
+		return &ast.BinaryExpr{Lhs: lhs, Operator: lexer.Token{Type: operator, Value: value}, Rhs: rhs}
+	}
+	assign := func(assignee ast.Expr, operator lexer.TokenType, value string, rhs ast.Expr) ast.Expr {
+		return &ast.AssignExpr{Assigne: assignee, Operator: lexer.Token{Type: operator, Value: value}, AssignedValue: rhs}
+	}
+	testCases := []struct {
+		src      string
+		expected ast.Expr
+	}{
+		{"a - b - c", binary(binary(ident("a"), lexer.DASH, "-", ident("b")), lexer.DASH, "-", ident("c"))},
+		{"a / b * c", binary(binary(ident("a"), lexer.SLASH, "/", ident("b")), lexer.STAR, "*", ident("c"))},
+		{"a or b or c", binary(binary(ident("a"), lexer.OR, "or", ident("b")), lexer.OR, "or", ident("c"))},
+		{"a = b = c", assign(ident("a"), lexer.EQUALS, "=", assign(ident("b"), lexer.EQUALS, "=", ident("c")))},
+		{"a += b = c", assign(ident("a"), lexer.PLUS_EQUALS, "+=", assign(ident("b"), lexer.EQUALS, "=", ident("c")))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			expr := Parse(lexer.Tokenize(tc.src)).Statements[0].(*ast.ExpressionStmt).Expr
+			if diff := ast.Diff(tc.expected, expr); diff != "" {
+				t.Errorf("Expected %s to parse as %s, got %s (%s)", tc.src, ast.Print(tc.expected), ast.Print(expr), diff)
+			}
+		})
+	}
+}
+
+// The logical operators bind looser than the comparisons, and with each other from left to right
+func TestLogicalOperators(t *testing.T) {
+	ident := func(name string) ast.Expr { return &ast.IdentExpr{Value: name} }
+	binary := func(lhs ast.Expr, operator lexer.TokenType, value string, rhs ast.Expr) ast.Expr {
+		return &ast.BinaryExpr{Lhs: lhs, Operator: lexer.Token{Type: operator, Value: value}, Rhs: rhs}
+	}
+	testCases := []struct {
+		src      string
+		expected ast.Expr
+	}{
+		{"a and b", binary(ident("a"), lexer.AND, "and", ident("b"))},
+		{"a and b or c", binary(binary(ident("a"), lexer.AND, "and", ident("b")), lexer.OR, "or", ident("c"))},
+		{"a < b or c == d", binary(binary(ident("a"), lexer.LESS, "<", ident("b")), lexer.OR, "or", binary(ident("c"), lexer.DOUBLE_EQUALS, "==", ident("d")))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			expr := Parse(lexer.Tokenize(tc.src)).Statements[0].(*ast.ExpressionStmt).Expr
+			if diff := ast.Diff(tc.expected, expr); diff != "" {
+				t.Errorf("Expected %s to parse as %s, got %s (%s)", tc.src, ast.Print(tc.expected), ast.Print(expr), diff)
+			}
+		})
+	}
+}
+
+func TestAscription(t *testing.T) {
+	expr := Parse(lexer.Tokenize("(5 : i64)")).Statements[0].(*ast.ExpressionStmt).Expr
+	expected := &ast.AscriptionExpr{
+		Expr: &ast.NumberLiteralExpr{Value: "5"},
+		Type: &ast.NamedTypeExpr{TypeName: "i64"},
+	}
+	if diff := ast.Diff(expected, expr); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+	grouped := Parse(lexer.Tokenize("(5)")).Statements[0].(*ast.ExpressionStmt).Expr
+	if _, ok := grouped.(*ast.GroupExpr); !ok {
+		t.Errorf("Expected a GroupExpr without an ascribed type, got %T", grouped)
+	}
+}
+
+func TestDestructuringVarDecl(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("let (q: i32, r) = divmod(a, b)")).Statements[0]
+	expected := &ast.DestructuringVarDeclStmt{
+		Vars: []*ast.TypedIdent{
+			{Name: "q", Type: &ast.NamedTypeExpr{TypeName: "i32"}},
+			{Name: "r"},
+		},
+		InitVal: &ast.FuncCallExpr{
+			Func: &ast.IdentExpr{Value: "divmod"},
+			Args: []ast.Expr{&ast.IdentExpr{Value: "a"}, &ast.IdentExpr{Value: "b"}},
+		},
+	}
+	if diff := ast.Diff(expected, stmt); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+}
+
+func TestTuple(t *testing.T) {
+	funcDecl := Parse(lexer.Tokenize("func divmod(a: i32, b: i32): (i32, i32) { return (a / b, a % b) }")).Statements[0].(*ast.FuncDeclStmt)
+	expectedType := &ast.TupleTypeExpr{
+		ElemTypes: []ast.TypeExpr{&ast.NamedTypeExpr{TypeName: "i32"}, &ast.NamedTypeExpr{TypeName: "i32"}},
+	}
+	if diff := ast.Diff(expectedType, funcDecl.ReturnType); diff != "" {
+		t.Errorf("Unexpected return type: %s", diff)
+	}
+	tuple, ok := funcDecl.Body.Statements[0].(*ast.ReturnStmt).Expr.(*ast.TupleExpr)
+	if !ok || len(tuple.Elems) != 2 {
+		t.Errorf("Expected a tuple of two elements to be returned, got %s", ast.Print(funcDecl.Body.Statements[0]))
+	}
+}
+
+func TestLoopExpr(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("let x: i32 = loop {\n  break 5\n}")).Statements[0].(*ast.VarDeclStmt)
+	expected := &ast.LoopExpr{
+		Body: &ast.BlockStmt{
+			Statements: []ast.Stmt{&ast.BreakStmt{Value: &ast.NumberLiteralExpr{Value: "5"}}},
+		},
+	}
+	if diff := ast.Diff(expected, stmt.InitVal); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+	body := Parse(lexer.Tokenize("loop { break; x }")).Statements[0].(*ast.ExpressionStmt).Expr.(*ast.LoopExpr).Body
+	if brk, ok := body.Statements[0].(*ast.BreakStmt); !ok || brk.Value != nil {
+		t.Errorf("Expected a break without a value, got %s", ast.Print(body.Statements[0]))
+	}
+}
+
+// The then keyword is optional before a block, in both the if- statement and the if- expression, but required
+// before a single statement or expression
+func TestIfWithoutThen(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src      string
+		withThen string
+	}{
+		{"statement with blocks", "if x < 5 { foo() } else { bar() }", "if x < 5 then { foo() } else { bar() }"},
+		{"statement over lines", "if x < 5 {\n  foo()\n} else if x > 10 {\n  bar()\n}", "if x < 5 then {\n  foo()\n} else if x > 10 then {\n  bar()\n}"},
+		{"block then statement", "if x < 5 { foo() } else if x > 10 then bar()", "if x < 5 then { foo() } else if x > 10 then bar()"},
+		{"expression with blocks", "result = if x < 5 { 0 } else { 5 }", "result = if x < 5 then { 0 } else { 5 }"},
+		{"parenthesized struct literal in the condition", "if (p == Point{ x: 1, }) { foo() }", "if (p == Point{ x: 1, }) then { foo() }"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsedAst, err := ParseSource(tc.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := ast.Diff(Parse(lexer.Tokenize(tc.withThen)), parsedAst); diff != "" {
+				t.Errorf("Unexpected AST: %s", diff)
+			}
+		})
+	}
+
+	errorCases := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{"statement without a block", "if x < 5 foo()", "1:10: expected then, found identifier"},
+		{"expression without a block", "result = if x < 5 0 else 5", "1:19: expected then, found number"},
+		{"struct literal in the condition", "if p == Point{ x: 1, } then foo()", "1:17: expected the end of the statement, found colon"},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseSource(tc.src); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected an error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestMatchExpr(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("let x: i32 = match p {\n  0 => 1,\n  -1 => 2,\n  n => n,\n  _ => 3,\n}")).Statements[0].(*ast.VarDeclStmt)
+	expected := &ast.MatchExpr{
+		Subject: &ast.IdentExpr{Value: "p"},
+		Arms: []*ast.MatchArm{
+			{Pattern: &ast.NumberLiteralExpr{Value: "0"}, Body: &ast.NumberLiteralExpr{Value: "1"}},
+			{
+				Pattern: &ast.UnaryExpr{Operator: lexer.Token{Type: lexer.DASH, Value: "-"}, Rhs: &ast.NumberLiteralExpr{Value: "1"}},
+				Body:    &ast.NumberLiteralExpr{Value: "2"},
+			},
+			{Pattern: &ast.IdentExpr{Value: "n"}, Body: &ast.IdentExpr{Value: "n"}},
+			{Pattern: &ast.WildcardExpr{}, Body: &ast.NumberLiteralExpr{Value: "3"}},
+		},
+	}
+	if diff := ast.Diff(expected, stmt.InitVal); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+	// The curly brace after the subject starts the arms, unless the subject is parenthesized
+	match := Parse(lexer.Tokenize("match (Point{ x: 1, }) { _ => 0, }")).Statements[0].(*ast.ExpressionStmt).Expr.(*ast.MatchExpr)
+	if _, ok := match.Subject.(*ast.GroupExpr).Expr.(*ast.StructLiteralExpr); !ok {
+		t.Errorf("Expected a struct literal subject, got %s", ast.Print(match.Subject))
+	}
+	if _, err := ParseSource("match x { 1 => 2 }"); err == nil || !strings.Contains(err.Error(), "expected comma, found close_curly") {
+		t.Errorf("Expected an error about the missing trailing comma, got %v", err)
+	}
+}
+
+func TestMethodDecl(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("func (self: Point) scaled(factor: i32): Point {}")).Statements[0]
+	expected := &ast.FuncDeclStmt{
+		Receiver:   &ast.TypedIdent{Name: "self", Type: &ast.NamedTypeExpr{TypeName: "Point"}},
+		Name:       "scaled",
+		Parameters: []*ast.TypedIdent{{Name: "factor", Type: &ast.NamedTypeExpr{TypeName: "i32"}}},
+		ReturnType: &ast.NamedTypeExpr{TypeName: "Point"},
+		Body:       &ast.BlockStmt{Statements: []ast.Stmt{}},
+	}
+	if diff := ast.Diff(expected, stmt); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+}
+
+func TestDefaultParameter(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("func f(x: i32, y: i32 = -1) {}")).Statements[0]
+	expected := &ast.FuncDeclStmt{
+		Name: "f",
+		Parameters: []*ast.TypedIdent{
+			{Name: "x", Type: &ast.NamedTypeExpr{TypeName: "i32"}},
+			{Name: "y", Type: &ast.NamedTypeExpr{TypeName: "i32"}, Default: &ast.UnaryExpr{
+				Operator: lexer.Token{Type: lexer.DASH, Value: "-"},
+				Rhs:      &ast.NumberLiteralExpr{Value: "1"},
+			}},
+		},
+		Body: &ast.BlockStmt{Statements: []ast.Stmt{}},
+	}
+	if diff := ast.Diff(expected, stmt); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+}
+
+func TestVariadicParameter(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("func sum(xs: i32...): i32 {}")).Statements[0]
+	expected := &ast.FuncDeclStmt{
+		Name:       "sum",
+		Parameters: []*ast.TypedIdent{{Name: "xs", Type: &ast.NamedTypeExpr{TypeName: "i32"}, Variadic: true}},
+		ReturnType: &ast.NamedTypeExpr{TypeName: "i32"},
+		Body:       &ast.BlockStmt{Statements: []ast.Stmt{}},
+	}
+	if diff := ast.Diff(expected, stmt); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+	if _, err := ParseSource("func f(xs: i32..., y: i32) {}"); err == nil || !strings.Contains(err.Error(), "variadic parameter xs must be the last parameter") {
+		t.Errorf("Expected an error about the variadic parameter not being last, got %v", err)
+	}
+}
+
+func TestReturnMultipleValues(t *testing.T) {
+	withoutParens := Parse(lexer.Tokenize("func f(): (i32, bool) {\n  return 1, true\n}"))
+	withParens := Parse(lexer.Tokenize("func f(): (i32, bool) {\n  return (1, true)\n}"))
+	if diff := ast.Diff(withParens, withoutParens); diff != "" {
+		t.Errorf("Expected the values to be returned as a tuple: %s", diff)
+	}
+}
+
+func TestEnumDecl(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("enum Color {\n  Red,\n  Green,\n}")).Statements[0]
+	expected := &ast.EnumDeclStmt{Name: "Color", Variants: []string{"Red", "Green"}}
+	if diff := ast.Diff(expected, stmt); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+	if _, err := ParseSource("enum Color { Red, Green }"); err == nil || !strings.Contains(err.Error(), "expected comma, found close_curly") {
+		t.Errorf("Expected an error about the missing trailing comma, got %v", err)
+	}
+}
+
+func TestInterfaceDecl(t *testing.T) {
+	withComma := Parse(lexer.Tokenize("interface S {\n  f(),\n  g(x: i32): bool,\n}"))
+	withoutComma := Parse(lexer.Tokenize("interface S { f(), g(x: i32): bool }"))
+	if diff := ast.Diff(withComma, withoutComma); diff != "" {
+		t.Errorf("Expected the trailing comma to be optional: %s", diff)
+	}
+	if _, err := ParseSource("interface S { f() g() }"); err == nil || !strings.Contains(err.Error(), "expected comma, found identifier") {
+		t.Errorf("Expected an error about the missing comma between the methods, got %v", err)
+	}
+}
+
+func TestTrailingCommas(t *testing.T) {
+	for _, src := range []string{"func f(a: i32, b: i32) {}", "f(1, 2)"} {
+		withComma := strings.Replace(src, ")", ",)", 1)
+		if diff := ast.Diff(Parse(lexer.Tokenize(src)), Parse(lexer.Tokenize(withComma))); diff != "" {
+			t.Errorf("%s and %s parsed differently: %s", src, withComma, diff)
+		}
+	}
+	for src, message := range map[string]string{
+		"f(,)":                     "unexpected comma",
+		"f(1 2)":                   "expected comma, found number",
+		"func f(a: i32 b: i32) {}": "expected comma, found identifier",
+		"func f(,) {}":             "expected identifier, found comma",
+	} {
+		if _, err := ParseSource(src); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q for %s, got %v", message, src, err)
+		}
+	}
+}
+
+func TestStructCommas(t *testing.T) {
+	for _, pair := range [][2]string{
+		{"p := Point{ x: 1, y: 2, }", "p := Point{ x: 1, y: 2 }"},
+		{"p := Point{ x: 1, }", "p := Point{ x: 1 }"},
+		{"struct Point {\n  x: i32,\n  y: i32,\n}", "struct Point { x: i32, y: i32 }"},
+	} {
+		if diff := ast.Diff(Parse(lexer.Tokenize(pair[0])), Parse(lexer.Tokenize(pair[1]))); diff != "" {
+			t.Errorf("%s and %s parsed differently: %s", pair[0], pair[1], diff)
+		}
+	}
+	for src, message := range map[string]string{
+		"p := Point{ x: 1 y: 2 }":        "expected comma, found identifier",
+		"struct Point { x: i32 y: i32 }": "expected comma, found identifier",
+		"p := Point{ , }":                "expected identifier, found comma",
+	} {
+		if _, err := ParseSource(src); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q for %s, got %v", message, src, err)
+		}
+	}
+}
+
+func TestUseDecl(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("use {\n  io: \"std/io\",\n  fmt: \"fmt\",\n  math: math,\n}")).Statements[0]
+	expected := &ast.UseDeclStmt{
+		UseSpecs: []*ast.UseSpecExpr{
+			{Name: "io", Module: ast.ModulePath{Segments: []string{"std", "io"}, Quoted: true}},
+			{Name: "fmt", Module: ast.ModulePath{Segments: []string{"fmt"}, Quoted: true}},
+			{Name: "math", Module: ast.ModulePath{Segments: []string{"math"}}},
+		},
+	}
+	if diff := ast.Diff(expected, stmt); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+	for _, path := range []string{`""`, `"std//io"`, `"/io"`} {
+		if _, err := ParseSource("use { io: " + path + ", }"); err == nil || !strings.Contains(err.Error(), "invalid module path "+path) {
+			t.Errorf("Expected an invalid module path error for %s, got %v", path, err)
+		}
+	}
+}
+
+func TestConstDecl(t *testing.T) {
+	stmt := Parse(lexer.Tokenize("const x: i32 = 5")).Statements[0]
+	expected := &ast.VarDeclStmt{
+		Var:     ast.TypedIdent{Name: "x", Type: &ast.NamedTypeExpr{TypeName: "i32"}},
+		InitVal: &ast.NumberLiteralExpr{Value: "5"},
+		Const:   true,
+	}
+	if diff := ast.Diff(expected, stmt); diff != "" {
+		t.Errorf("Unexpected AST: %s", diff)
+	}
+	if printed := ast.Print(stmt); printed != "const x: i32 = 5" {
+		t.Errorf("Expected the declaration to print as const, got %q", printed)
+	}
+}
+
+func TestSyntaxErrorRecovery(t *testing.T) {
+	testCases := []struct {
+		name       string
+		src        string
+		expected   []string
+		statements int
+	}{
+		{
+			"two top-level statements",
+			"let x: i32 = )\nlet y: i32 = 2\nlet z i32 = 3\nw := 4",
+			[]string{"1:14: unmatched ')'", "3:7: expected equals, found identifier"},
+			2,
+		},
+		{
+			"two statements in a function body",
+			"func f(): i32 {\n  let a: i32 = (1 + 2]\n  let b: i32 = a +\n  return a\n}\nlet c: i32 = 1",
+			[]string{"2:22: mismatched ']' closing '(' opened at line 2, column 16", "4:3: unexpected return at the start of an expression"},
+			2,
+		},
+		{
+			"semicolons inside the skipped statement",
+			"let a: i32 = { 1; 2; 3 } } ; let b: i32 = 2\nlet c: bool = let",
+			[]string{"1:26: unmatched '}'", "2:15: unexpected let at the start of an expression"},
+			2,
+		},
+		{
+			"stray closing curly brace",
+			"}\nfoo() := 1\nlet d: i32 = 4",
+			[]string{"1:1: unmatched '}'", "2:1: the left-hand side of := must be an identifier"},
+			1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			module, errors := ParseSafe(lexer.Tokenize(tc.src))
+			if len(errors) != len(tc.expected) {
+				t.Fatalf("Expected %d errors, got %q", len(tc.expected), errors)
+			}
+			for i, err := range errors {
+				if err.Error() != tc.expected[i] {
+					t.Errorf("Expected error %q, got %q", tc.expected[i], err)
+				}
+			}
+			if len(module.Statements) != tc.statements {
+				t.Errorf("Expected %d statements, got %s", tc.statements, ast.Print(module))
+			}
+		})
+	}
+
+	if module, errors := ParseSafe(lexer.Tokenize("let x: i32 = 1")); len(errors) > 0 || len(module.Statements) != 1 {
+		t.Errorf("Expected a statement without errors, got %s, %q", ast.Print(module), errors)
+	}
+}
+
+func TestUnbalancedBrackets(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{"extra closing curly brace", "func f() {\n  g()\n}\n}\nlet x: i32 = 1", []string{"4:1: unmatched '}'"}},
+		{"missing closing paren", "let x: i32 = f(1, (2 + 3)", []string{"1:26: unclosed '(' opened at line 1, column 15"}},
+		{"missing closing paren of an incomplete expression", "let x: i32 = (1 +", []string{"1:18: unclosed '(' opened at line 1, column 14"}},
+		{"missing closing curly brace", "func f() {\n  if x then {\n    g()\n  }\n", []string{"5:1: unclosed '{' opened at line 1, column 10"}},
+		{"mismatched closing bracket", "let x: i32 = (a[1) + 2]", []string{"1:18: mismatched ')' closing '[' opened at line 1, column 16"}},
+		{"mismatched closing paren", "let x: i32 = (1 + 2]\nlet y: i32 = 3", []string{"1:20: mismatched ']' closing '(' opened at line 1, column 14"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errors := ParseSafe(lexer.Tokenize(tc.src))
+			if len(errors) != len(tc.expected) {
+				t.Fatalf("Expected %d errors, got %q", len(tc.expected), errors)
+			}
+			for i, err := range errors {
+				if err.Error() != tc.expected[i] {
+					t.Errorf("Expected error %q, got %q", tc.expected[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	module, err := ParseSource("let x: i32 = 1\nx += 2")
+	if err != nil || len(module.Statements) != 2 {
+		t.Errorf("Expected 2 statements without an error, got %v, %v", module, err)
+	}
+	for _, tc := range []struct {
+		src      string
+		expected string
+	}{
+		{"let x: i32 = 0xFG", "invalid digit in number at line 1, column 17: 0xFG"},
+		{"let x: i32 = )\nlet y = ]", "1:14: unmatched ')'\n2:9: unmatched ']'"},
+	} {
+		if module, err := ParseSource(tc.src); err == nil || err.Error() != tc.expected {
+			t.Errorf("Expected the error %q for %q, got %v, %v", tc.expected, tc.src, module, err)
+		}
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	deep := MaxNestingDepth * 2
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{"parentheses", "let x: i32 = " + strings.Repeat("(", deep) + "1" + strings.Repeat(")", deep), "expression nested too deeply"},
+		{"unary operators", "let x: i32 = " + strings.Repeat("-", deep) + "1", "expression nested too deeply"},
+		{"array types", "let x: i32" + strings.Repeat("[]", deep) + " = y", "expression nested too deeply"},
+		{"blocks", strings.Repeat("{\n", deep) + strings.Repeat("}\n", deep), "expression nested too deeply"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			module, errors := ParseSafe(lexer.Tokenize(tc.src + "\nlet z: i32 = 1"))
+			if len(errors) != 1 || !strings.Contains(errors[0].Error(), tc.expected) {
+				t.Fatalf("Expected the error %q, got %q", tc.expected, errors)
+			}
+			if len(module.Statements) == 0 {
+				t.Errorf("Expected parsing to continue after the error")
+			}
+		})
+	}
+
+	t.Run("adjusted limit", func(t *testing.T) {
+		config := Config{MaxNestingDepth: 4}
+		if _, errors := ParseWithConfig(lexer.Tokenize("let x: i32 = (1)"), config); len(errors) > 0 {
+			t.Errorf("Expected no errors within the limit, got %q", errors)
+		}
+		_, errors := ParseWithConfig(lexer.Tokenize("let x: i32 = (((1)))"), config)
+		if len(errors) != 1 || errors[0].Error() != "1:17: expression nested too deeply" {
+			t.Errorf("Expected the error %q, got %q", "1:17: expression nested too deeply", errors)
+		}
+	})
+}
+
+func TestComments(t *testing.T) {
+	src := `// The answer
+let answer: i32 = 42 // Not a guess
+
+// add returns the sum
+// of two numbers.
+func add(x: i32, y: i32): i32 {
+  // Overflow is fine
+  return x + y
+}
+
+// A stray comment
+
+let z: i32 = add(answer, 1)
+if z > 0 then { // Always
+  z = 0
+}`
+	tokens, err := lexer.TokenizeWithComments(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, comments, errors := ParseWithComments(tokens)
+	if len(errors) > 0 {
+		t.Fatalf("Expected no errors, got %q", errors)
+	}
+	if without := Parse(lexer.Tokenize(src)); !ast.Equal(without, module) {
+		t.Errorf("Expected the same AST as without the comments:\n%s", ast.Diff(without, module))
+	}
+
+	funcDecl := module.Statements[1].(*ast.FuncDeclStmt)
+	if doc := comments[funcDecl].Doc(); doc != "add returns the sum\nof two numbers." {
+		t.Errorf("Expected the doc comment of the function, got %q", doc)
+	}
+	letAnswer := module.Statements[0]
+	if doc := comments[letAnswer].Doc(); doc != "The answer" {
+		t.Errorf("Expected the doc comment of the variable, got %q", doc)
+	}
+	if trailing := comments[letAnswer].Trailing; trailing == nil || trailing.Value != "// Not a guess" {
+		t.Errorf("Expected the trailing comment of the variable, got %v", trailing)
+	}
+	if doc := comments[funcDecl.Body.Statements[0]].Doc(); doc != "Overflow is fine" {
+		t.Errorf("Expected the comment of the return statement, got %q", doc)
+	}
+	if c, ok := comments[module.Statements[2]]; ok {
+		t.Errorf("Expected the comment separated by an empty line not to be attached, got %v", c)
+	}
+	if trailing := comments[module.Statements[3]].Trailing; trailing == nil || trailing.Value != "// Always" {
+		t.Errorf("Expected the trailing comment of the if- statement, got %v", trailing)
+	}
+	if len(comments) != 4 {
+		t.Errorf("Expected 4 statements with comments, got %d", len(comments))
+	}
+}
+
+// A comment never reaches the statement parsing, even as the only line of a block
+func TestCommentOnlyBlock(t *testing.T) {
+	src := "func f() {\n  // Nothing yet\n}\nlet x: i32 = {\n  // Just the value\n  1\n}"
+	tokens, err := lexer.TokenizeWithComments(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, comments, errors := ParseWithComments(tokens)
+	if len(errors) > 0 {
+		t.Fatalf("Expected no errors, got %q", errors)
+	}
+	if body := module.Statements[0].(*ast.FuncDeclStmt).Body; len(body.Statements) != 0 {
+		t.Errorf("Expected an empty function body, got %d statements", len(body.Statements))
+	}
+	if len(comments) != 0 {
+		t.Errorf("Expected the comments to be left unattached, got %d", len(comments))
+	}
+}
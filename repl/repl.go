@@ -0,0 +1,130 @@
+// Package repl implements an interactive session for experimenting with the language: each line entered is
+// checked in the context of the lines entered before it, and the type of an expression is shown.
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"github.com/ruistola/cooper/parser"
+	"github.com/ruistola/cooper/typechecker"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Session keeps the lines entered so far that declare or assign something, forming the source of a module
+// that each new line is appended to for checking.
+type Session struct {
+	config typechecker.Config
+	lines  []string
+}
+
+// NewSession creates a session without any declarations, checking the lines with the config.
+func NewSession(config typechecker.Config) *Session {
+	return &Session{config: config}
+}
+
+// Eval checks a line of input, and returns what to show for it: the type of an expression, or the errors and
+// the warnings in the line. A valid line that is not just an expression is kept in the session, so that
+// e.g. a variable it declares can be used by the lines that follow.
+func (s *Session) Eval(line string) string {
+	// The line is tokenized alone first, for an error to point into it rather than into the session
+	if _, err := lexer.TokenizeSafe(line); err != nil {
+		return fmt.Sprintf("%s %s: %s", typechecker.StageSyntax, typechecker.SeverityError, err)
+	}
+	lineNumber := len(s.lines) + 1
+	src := strings.Join(append(slices.Clone(s.lines), line), "\n")
+	module, syntaxErrors := parser.ParseSafe(lexer.Tokenize(src))
+	if len(syntaxErrors) > 0 {
+		output := []string{}
+		for _, err := range syntaxErrors {
+			diagnostic := typechecker.Diagnostic{
+				Severity: typechecker.SeverityError,
+				Stage:    typechecker.StageSyntax,
+				Message:  err.Error(),
+			}
+			var syntaxError *parser.SyntaxError
+			if errors.As(err, &syntaxError) {
+				diagnostic.Message = syntaxError.Message
+				diagnostic.Pos = syntaxError.Pos
+			}
+			output = append(output, renderInLine(diagnostic, line, lineNumber))
+		}
+		return strings.Join(output, "\n")
+	}
+
+	valueType, diagnostics := typechecker.CheckValue(module, s.config)
+	output := []string{}
+	// The warnings of the lines entered before have been shown already
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Pos.Line >= lineNumber {
+			output = append(output, renderInLine(diagnostic, line, lineNumber))
+		}
+	}
+	if typechecker.HasErrors(diagnostics) {
+		return strings.Join(output, "\n")
+	}
+	if declares(module.Statements, lineNumber) {
+		s.lines = append(s.lines, line)
+	}
+	if valueType != nil && isValue(module.Statements[len(module.Statements)-1]) {
+		output = append(output, valueType.String())
+	}
+	return strings.Join(output, "\n")
+}
+
+// renderInLine renders a diagnostic located in the line entered, with its position relative to the line.
+func renderInLine(diagnostic typechecker.Diagnostic, line string, lineNumber int) string {
+	if diagnostic.Pos.Line >= lineNumber {
+		diagnostic.Pos.Line -= lineNumber - 1
+	}
+	return diagnostic.RenderWithSource(line, false)
+}
+
+// isValue reports whether the statement is an expression computing a value, rather than e.g. assigning one.
+func isValue(stmt ast.Stmt) bool {
+	exprStmt, ok := stmt.(*ast.ExpressionStmt)
+	if !ok {
+		return false
+	}
+	switch exprStmt.Expr.(type) {
+	case *ast.AssignExpr, *ast.VarDeclAssignExpr:
+		return false
+	}
+	return true
+}
+
+// declares reports whether any of the statements on the line of the given number or after it is not just
+// a value, i.e. whether keeping the line would change the lines that follow it.
+func declares(statements []ast.Stmt, lineNumber int) bool {
+	for _, stmt := range statements {
+		if stmt.Position().Line >= lineNumber && !isValue(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run reads lines from in until the end of the input, showing a prompt before each, and the result of
+// evaluating it after it, in out.
+func Run(in io.Reader, out io.Writer, config typechecker.Config) error {
+	session := NewSession(config)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if output := session.Eval(line); output != "" {
+			fmt.Fprintln(out, output)
+		}
+	}
+}
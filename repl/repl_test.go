@@ -0,0 +1,53 @@
+package repl
+
+import (
+	"github.com/ruistola/cooper/typechecker"
+	"strings"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	input := `let x: i32 = 5
+x + 1
+x < 2
+
+func double(n: i32): i32 { return n * 2 }
+double(x)
+y + 1
+let b: bool = x
+b
+x = 7
+x as i8
+"text"
+let 5
+`
+	expected := `> > i32
+> bool
+> > > i32
+> 1:1: Resolve Error: undefined identifier: y
+y + 1
+^
+> 1:1: Type Error: type mismatch: variable b declared as bool but initialized with i32
+let b: bool = x
+^
+> 1:1: Resolve Error: undefined identifier: b
+b
+^
+> > 1:1: Semantic Warning: cast from i32 to i8 may truncate the value [narrowing-cast]
+x as i8
+^
+i8
+> string
+> 1:5: Syntax Error: expected identifier, found number
+let 5
+    ^
+> 
+`
+	var out strings.Builder
+	if err := Run(strings.NewReader(input), &out, typechecker.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected the session output:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
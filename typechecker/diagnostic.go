@@ -0,0 +1,86 @@
+package typechecker
+
+import (
+	"fmt"
+	"github.com/ruistola/cooper/lexer"
+	"slices"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "Error"
+	case SeverityWarning:
+		return "Warning"
+	default:
+		return fmt.Sprintf("unknown severity (%d)", int(s))
+	}
+}
+
+// Stage identifies the pass that reported a diagnostic.
+type Stage string
+
+const (
+	StageSyntax   Stage = "Syntax"
+	StageResolve  Stage = "Resolve"
+	StageType     Stage = "Type"
+	StageSemantic Stage = "Semantic"
+	StageFold     Stage = "Fold"
+)
+
+// Diagnostic is an error or a warning reported by one of the passes, located at a position in the source.
+type Diagnostic struct {
+	Severity Severity
+	Stage    Stage
+	Message  string
+	Pos      lexer.SrcPos
+}
+
+// String renders the diagnostic as plain text, e.g. `3:5: Type Error: undefined variable: x`.
+func (d Diagnostic) String() string {
+	return d.Render(false)
+}
+
+// Render renders the diagnostic as text, colored with ANSI escape codes if requested.
+// A diagnostic without a known position (e.g. an internal error) is rendered without one.
+func (d Diagnostic) Render(colored bool) string {
+	text := fmt.Sprintf("%s %s: %s", d.Stage, d.Severity, d.Message)
+	if d.Pos.Line > 0 {
+		text = fmt.Sprintf("%s: %s", d.Pos, text)
+	}
+	if !colored {
+		return text
+	}
+	color := "\033[31m" // red
+	if d.Severity == SeverityWarning {
+		color = "\033[33m" // yellow
+	}
+	return color + text + "\033[0m"
+}
+
+// RenderWithSource renders the diagnostic like Render, followed by the source line at the position of
+// the diagnostic with a marker under the column.
+func (d Diagnostic) RenderWithSource(src string, colored bool) string {
+	text := d.Render(colored)
+	if d.Pos.Line == 0 {
+		return text
+	}
+	if snippet := lexer.SourceSnippet(src, d.Pos); snippet != "" {
+		text += "\n" + snippet
+	}
+	return text
+}
+
+// HasErrors reports whether any of the diagnostics is an error, as opposed to a warning.
+func HasErrors(diagnostics []Diagnostic) bool {
+	return slices.ContainsFunc(diagnostics, func(d Diagnostic) bool {
+		return d.Severity == SeverityError
+	})
+}
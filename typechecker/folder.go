@@ -0,0 +1,467 @@
+package typechecker
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// integerRanges are the ranges of the values of the fixed-width integer types.
+var integerRanges = map[string]struct{ min, max *big.Int }{
+	"i8":  {big.NewInt(-1 << 7), big.NewInt(1<<7 - 1)},
+	"i32": {big.NewInt(-1 << 31), big.NewInt(1<<31 - 1)},
+	"i64": {big.NewInt(-1 << 63), big.NewInt(1<<63 - 1)},
+}
+
+// constant is the value of a constant expression, of one of the types: a fixed-width integer type, a floating
+// point type, bool or string.
+type constant struct {
+	typeName string
+	integer  *big.Int
+	float    float64 // Rounded to the precision of f32 for the type
+	boolean  bool
+	str      string
+}
+
+// isFloat reports whether the constant is of a floating point type.
+func (c constant) isFloat() bool {
+	return c.typeName == "f32" || c.typeName == "f64"
+}
+
+// constantStatus tells whether an expression is constant, and if it is, whether it could be evaluated.
+type constantStatus int
+
+const (
+	notConstant constantStatus = iota
+	isConstant
+	invalidConstant // Constant, but the evaluation failed (e.g. on a division by zero), which has been reported
+)
+
+// ConstantFolder replaces the constant subexpressions of a module, like `2 + 3 * 4`, with literals of the
+// same type. Number literals are of type i32, unless their type is ascribed or given by the type checker.
+// Floating point values that are not finite, like the result of dividing by zero, have no literal and are
+// left unfolded. It is the last pass, run on a module that has been type checked successfully.
+type ConstantFolder struct {
+	Errors       []Diagnostic
+	constants    map[ast.Expr]bool // Constant expressions, whose subexpressions need not be visited
+	reported     map[ast.Node]bool // Expressions that an error has been reported for
+	literalTypes LiteralTypes      // Types of the number literals given one by the type checker
+}
+
+// LiteralTypes are the types the type checker gives to the number literals assigned to a variable or
+// matched against a value, instead of the default i32, e.g. i8 to the literal in `let x: i8 = -5`.
+type LiteralTypes map[*ast.NumberLiteralExpr]Type
+
+func NewConstantFolder(literalTypes LiteralTypes) *ConstantFolder {
+	return &ConstantFolder{
+		constants:    map[ast.Expr]bool{},
+		reported:     map[ast.Node]bool{},
+		literalTypes: literalTypes,
+	}
+}
+
+// Err adds an error for the expression, unless one has been added already
+func (f *ConstantFolder) Err(node ast.Node, msg string) {
+	if f.reported[node] {
+		return
+	}
+	f.reported[node] = true
+	f.Errors = append(f.Errors, Diagnostic{
+		Severity: SeverityError,
+		Stage:    StageFold,
+		Message:  msg,
+		Pos:      node.Position(),
+	})
+}
+
+// FoldConstants folds the constant subexpressions of the module in place, reporting the constant expressions
+// that fail to evaluate: divisions by zero, and values overflowing their type. The number literals take the
+// types given by CheckModule, if any. Folding is idempotent, and leaves the expressions that are not constant
+// untouched.
+func FoldConstants(module *ast.BlockStmt, literalTypes LiteralTypes) []Diagnostic {
+	folder := NewConstantFolder(literalTypes)
+	ast.Walk(module, folder)
+	return folder.Errors
+}
+
+var exprType = reflect.TypeFor[ast.Expr]()
+
+// Visit folds the constant expressions among the children of the node. The parent does the folding, as
+// it holds the reference to replace, and the subexpressions of a constant expression are not visited.
+func (f *ConstantFolder) Visit(node ast.Node) bool {
+	if expr, ok := node.(ast.Expr); ok && f.constants[expr] {
+		return false
+	}
+	v := reflect.ValueOf(node).Elem()
+	for i := range v.NumField() {
+		field := v.Field(i)
+		switch {
+		case field.Type() == exprType && !field.IsNil():
+			field.Set(reflect.ValueOf(f.fold(field.Interface().(ast.Expr))))
+		case field.Type() == reflect.SliceOf(exprType):
+			for j := range field.Len() {
+				field.Index(j).Set(reflect.ValueOf(f.fold(field.Index(j).Interface().(ast.Expr))))
+			}
+		}
+	}
+	return true
+}
+
+func (f *ConstantFolder) Leave(node ast.Node) {}
+
+var errNotConstant = errors.New("not a constant expression")
+
+// evalConst evaluates an expression consisting of only constants like the folder, with its number literals
+// of the given type, for the checks needing the value of an expression at compile time. Returns an error
+// if the expression is not constant, or if evaluating it fails, e.g. on an overflow or a division by zero.
+func evalConst(expr ast.Expr, literalType string) (constant, error) {
+	f := NewConstantFolder(nil)
+	value, status := f.evaluate(expr, literalType)
+	switch status {
+	case notConstant:
+		return constant{}, errNotConstant
+	case invalidConstant:
+		return constant{}, errors.New(f.Errors[0].Message)
+	}
+	return value, nil
+}
+
+// fold returns the literal the expression evaluates to if it is constant, or the expression itself.
+// Literals are kept as written in the source.
+func (f *ConstantFolder) fold(expr ast.Expr) ast.Expr {
+	value, status := f.evaluate(expr, "i32")
+	if status == notConstant {
+		return expr
+	}
+	if status == isConstant && value.isFloat() && (math.IsInf(value.float, 0) || math.IsNaN(value.float)) {
+		return expr
+	}
+	if status == isConstant && !isLiteral(expr) {
+		expr = value.literal(expr.Position())
+	}
+	f.constants[expr] = true
+	return expr
+}
+
+// isLiteral reports whether the expression is a literal, possibly negated or of an ascribed type, i.e. one
+// of the forms of the expressions produced by folding.
+func isLiteral(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.NumberLiteralExpr, *ast.BoolLiteralExpr, *ast.StringLiteralExpr:
+		return true
+	case *ast.UnaryExpr:
+		_, isNumber := e.Rhs.(*ast.NumberLiteralExpr)
+		return e.Operator.Type == lexer.DASH && isNumber
+	case *ast.AscriptionExpr:
+		return isLiteral(e.Expr)
+	}
+	return false
+}
+
+// literal returns the expression for the value: a literal, negated if negative, and ascribed its type
+// if it is a numeric type other than the default i32. The value of a floating point literal is written
+// with a decimal point or an exponent, to tell it apart from an integer.
+func (c constant) literal(pos lexer.SrcPos) ast.Expr {
+	switch c.typeName {
+	case "bool":
+		return &ast.BoolLiteralExpr{Pos: pos, Value: c.boolean}
+	case "string":
+		return &ast.StringLiteralExpr{Pos: pos, Value: strconv.Quote(c.str)}
+	}
+	var text string
+	var isNegative bool
+	if c.isFloat() {
+		text = strconv.FormatFloat(math.Abs(c.float), 'g', -1, numericWidths[c.typeName])
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		isNegative = math.Signbit(c.float)
+	} else {
+		text = new(big.Int).Abs(c.integer).String()
+		isNegative = c.integer.Sign() < 0
+	}
+	var expr ast.Expr = &ast.NumberLiteralExpr{Pos: pos, Value: text}
+	if isNegative {
+		expr = &ast.UnaryExpr{
+			Pos:      pos,
+			Operator: lexer.Token{Type: lexer.DASH, Value: "-", SrcPos: pos},
+			Rhs:      expr,
+		}
+	}
+	if c.typeName != "i32" {
+		expr = &ast.AscriptionExpr{
+			Pos:  pos,
+			Expr: expr,
+			Type: &ast.NamedTypeExpr{Pos: pos, TypeName: c.typeName},
+		}
+	}
+	return expr
+}
+
+// evaluate evaluates an expression consisting of only constants, with its number literals of the given
+// type. The operands of a well typed expression are of the same type, so the type of the result follows
+// from that of the left hand side operand.
+func (f *ConstantFolder) evaluate(expr ast.Expr, literalType string) (constant, constantStatus) {
+	switch e := expr.(type) {
+	case *ast.NumberLiteralExpr:
+		return f.number(e, e.Value, f.literalType(e, literalType))
+	case *ast.BoolLiteralExpr:
+		return constant{typeName: "bool", boolean: e.Value}, isConstant
+	case *ast.StringLiteralExpr:
+		value, err := strconv.Unquote(e.Value)
+		if err != nil {
+			return constant{}, notConstant
+		}
+		return constant{typeName: "string", str: value}, isConstant
+	case *ast.GroupExpr:
+		return f.evaluate(e.Expr, literalType)
+	case *ast.AscriptionExpr:
+		// A number literal takes the ascribed type, instead of the default i32
+		if named, ok := e.Type.(*ast.NamedTypeExpr); ok && untypedNumberLiteral(e.Expr) != nil {
+			if _, isNumeric := numericWidths[named.TypeName]; isNumeric {
+				return f.evaluate(e.Expr, named.TypeName)
+			}
+		}
+		return f.evaluate(e.Expr, "i32")
+	case *ast.UnaryExpr:
+		// The range of a type is asymmetric, so a negated literal is parsed as a negative number, to let
+		// e.g. -128 be an i8 although 128 isn't
+		if literal, ok := ungroup(e.Rhs).(*ast.NumberLiteralExpr); ok && e.Operator.Type == lexer.DASH {
+			return f.number(literal, "-"+literal.Value, f.literalType(literal, literalType))
+		}
+		rhs, status := f.evaluate(e.Rhs, literalType)
+		if status != isConstant {
+			return constant{}, status
+		}
+		return f.applyUnary(e, rhs)
+	case *ast.BinaryExpr:
+		return f.evaluateBinary(e)
+	}
+	return constant{}, notConstant
+}
+
+// literalType returns the name of the type given to a number literal by the type checker, or the default.
+func (f *ConstantFolder) literalType(literal *ast.NumberLiteralExpr, defaultType string) string {
+	if t, ok := f.literalTypes[literal]; ok {
+		return t.String()
+	}
+	return defaultType
+}
+
+// ungroup returns the expression inside any number of parentheses.
+func ungroup(expr ast.Expr) ast.Expr {
+	for {
+		group, ok := expr.(*ast.GroupExpr)
+		if !ok {
+			return expr
+		}
+		expr = group.Expr
+	}
+}
+
+// number parses the text of a number literal as a value of the numeric type. Returns notConstant for
+// a floating point literal of an integer type.
+func (f *ConstantFolder) number(literal *ast.NumberLiteralExpr, text string, typeName string) (constant, constantStatus) {
+	if typeName == "f32" || typeName == "f64" {
+		value, _, err := big.ParseFloat(text, 0, 53, big.ToNearestEven)
+		if err != nil {
+			return constant{}, notConstant
+		}
+		float, _ := value.Float64()
+		return floatConstant(typeName, float), isConstant
+	}
+	value, ok := new(big.Int).SetString(text, 0)
+	if !ok {
+		// e.g. a floating point number
+		return constant{}, notConstant
+	}
+	return f.checkRange(literal, constant{typeName: typeName, integer: value})
+}
+
+// floatConstant returns the value as a constant of the floating point type, rounded to its precision.
+func floatConstant(typeName string, value float64) constant {
+	if typeName == "f32" {
+		value = float64(float32(value))
+	}
+	return constant{typeName: typeName, float: value}
+}
+
+// checkRange reports an integer value overflowing its type, telling a literal apart from a computed constant.
+func (f *ConstantFolder) checkRange(expr ast.Expr, value constant) (constant, constantStatus) {
+	bounds, ok := integerRanges[value.typeName]
+	if !ok {
+		return constant{}, notConstant
+	}
+	if value.integer.Cmp(bounds.min) < 0 || value.integer.Cmp(bounds.max) > 0 {
+		kind := "constant"
+		if _, isLiteral := expr.(*ast.NumberLiteralExpr); isLiteral {
+			kind = "literal"
+		}
+		f.Err(expr, fmt.Sprintf("%s %s overflows %s", kind, value.integer, value.typeName))
+		return constant{}, invalidConstant
+	}
+	return value, isConstant
+}
+
+func (f *ConstantFolder) applyUnary(expr *ast.UnaryExpr, rhs constant) (constant, constantStatus) {
+	switch {
+	case expr.Operator.Type == lexer.PLUS && rhs.integer != nil:
+		return rhs, isConstant
+	case expr.Operator.Type == lexer.DASH && rhs.integer != nil:
+		return f.checkRange(expr, constant{typeName: rhs.typeName, integer: new(big.Int).Neg(rhs.integer)})
+	case expr.Operator.Type == lexer.PLUS && rhs.isFloat():
+		return rhs, isConstant
+	case expr.Operator.Type == lexer.DASH && rhs.isFloat():
+		return floatConstant(rhs.typeName, -rhs.float), isConstant
+	case expr.Operator.Type == lexer.NOT && rhs.typeName == "bool":
+		return constant{typeName: "bool", boolean: !rhs.boolean}, isConstant
+	}
+	return constant{}, notConstant
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+func (f *ConstantFolder) evaluateBinary(expr *ast.BinaryExpr) (constant, constantStatus) {
+	lhs, lhsStatus := f.evaluate(expr.Lhs, "i32")
+	rhs, rhsStatus := f.evaluate(expr.Rhs, "i32")
+	if lhsStatus == notConstant || rhsStatus == notConstant {
+		return constant{}, notConstant
+	}
+	if lhsStatus == invalidConstant || rhsStatus == invalidConstant {
+		return constant{}, invalidConstant
+	}
+	switch {
+	case lhs.integer != nil && rhs.integer != nil:
+		if result, ok := compare(expr.Operator.Type, lhs.integer.Cmp(rhs.integer)); ok {
+			return constant{typeName: "bool", boolean: result}, isConstant
+		}
+		value, err := integerOp(expr.Operator.Type, lhs.integer, rhs.integer)
+		if err != nil {
+			f.Err(expr, err.Error())
+			return constant{}, invalidConstant
+		}
+		if value == nil {
+			return constant{}, notConstant
+		}
+		return f.checkRange(expr, constant{typeName: lhs.typeName, integer: value})
+	case lhs.isFloat() && rhs.isFloat():
+		if result, ok := compareFloats(expr.Operator.Type, lhs.float, rhs.float); ok {
+			return constant{typeName: "bool", boolean: result}, isConstant
+		}
+		if value, ok := floatOp(expr.Operator.Type, lhs.float, rhs.float); ok {
+			return floatConstant(lhs.typeName, value), isConstant
+		}
+	case lhs.typeName == "bool" && rhs.typeName == "bool":
+		switch expr.Operator.Type {
+		case lexer.AND:
+			return constant{typeName: "bool", boolean: lhs.boolean && rhs.boolean}, isConstant
+		case lexer.OR:
+			return constant{typeName: "bool", boolean: lhs.boolean || rhs.boolean}, isConstant
+		case lexer.DOUBLE_EQUALS:
+			return constant{typeName: "bool", boolean: lhs.boolean == rhs.boolean}, isConstant
+		case lexer.NOT_EQUALS:
+			return constant{typeName: "bool", boolean: lhs.boolean != rhs.boolean}, isConstant
+		}
+	case lhs.typeName == "string" && rhs.typeName == "string":
+		if result, ok := compare(expr.Operator.Type, strings.Compare(lhs.str, rhs.str)); ok {
+			return constant{typeName: "bool", boolean: result}, isConstant
+		}
+		if expr.Operator.Type == lexer.PLUS {
+			return constant{typeName: "string", str: lhs.str + rhs.str}, isConstant
+		}
+	}
+	return constant{}, notConstant
+}
+
+// compare applies a comparison operator on the result of comparing two integers or strings. Returns false
+// if the operator is not a comparison.
+func compare(operator lexer.TokenType, cmp int) (bool, bool) {
+	switch operator {
+	case lexer.DOUBLE_EQUALS:
+		return cmp == 0, true
+	case lexer.NOT_EQUALS:
+		return cmp != 0, true
+	case lexer.LESS:
+		return cmp < 0, true
+	case lexer.LESS_EQUALS:
+		return cmp <= 0, true
+	case lexer.GREATER:
+		return cmp > 0, true
+	case lexer.GREATER_EQUALS:
+		return cmp >= 0, true
+	}
+	return false, false
+}
+
+// compareFloats applies a comparison operator on two floating point numbers. Unlike compare, treats NaN
+// as unordered and unequal to everything. Returns false if the operator is not a comparison.
+func compareFloats(operator lexer.TokenType, lhs, rhs float64) (bool, bool) {
+	switch operator {
+	case lexer.DOUBLE_EQUALS:
+		return lhs == rhs, true
+	case lexer.NOT_EQUALS:
+		return lhs != rhs, true
+	case lexer.LESS:
+		return lhs < rhs, true
+	case lexer.LESS_EQUALS:
+		return lhs <= rhs, true
+	case lexer.GREATER:
+		return lhs > rhs, true
+	case lexer.GREATER_EQUALS:
+		return lhs >= rhs, true
+	}
+	return false, false
+}
+
+// floatOp applies an arithmetic operator on two floating point numbers. Division by zero results in an
+// infinity. Returns false if the operator is not applicable.
+func floatOp(operator lexer.TokenType, lhs, rhs float64) (float64, bool) {
+	switch operator {
+	case lexer.PLUS:
+		return lhs + rhs, true
+	case lexer.DASH:
+		return lhs - rhs, true
+	case lexer.STAR:
+		return lhs * rhs, true
+	case lexer.SLASH:
+		return lhs / rhs, true
+	case lexer.CHEVRON:
+		return math.Pow(lhs, rhs), true
+	}
+	return 0, false
+}
+
+// integerOp applies an arithmetic operator on two integers. Division truncates toward zero, so the
+// remainder has the sign of the dividend. Returns nil if the operator is not applicable (e.g. on a
+// negative exponent).
+func integerOp(operator lexer.TokenType, lhs, rhs *big.Int) (*big.Int, error) {
+	switch operator {
+	case lexer.PLUS:
+		return new(big.Int).Add(lhs, rhs), nil
+	case lexer.DASH:
+		return new(big.Int).Sub(lhs, rhs), nil
+	case lexer.STAR:
+		return new(big.Int).Mul(lhs, rhs), nil
+	case lexer.SLASH, lexer.PERCENT:
+		if rhs.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		if operator == lexer.SLASH {
+			return new(big.Int).Quo(lhs, rhs), nil
+		}
+		return new(big.Int).Rem(lhs, rhs), nil
+	case lexer.CHEVRON:
+		// Bound the exponent, so that the result of an overflowing exponentiation stays reasonably sized
+		if rhs.Sign() < 0 || !rhs.IsInt64() || rhs.Int64() > 64 && lhs.CmpAbs(big.NewInt(1)) > 0 {
+			return nil, nil
+		}
+		return new(big.Int).Exp(lhs, rhs, nil), nil
+	}
+	return nil, nil
+}
@@ -1,31 +1,160 @@
 package typechecker
 
 import (
+	"cmp"
 	"fmt"
 	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
+	"slices"
 )
 
-// Scope represents a lexical scope, with parent being nil if this is the module top scope
+// SymbolKind tells what kind of a declaration a symbol is.
+type SymbolKind int
+
+const (
+	SymbolVar SymbolKind = iota
+	SymbolFunc
+	SymbolStruct
+	SymbolInterface
+	SymbolMethod
+	SymbolEnum
+	SymbolModule
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case SymbolVar:
+		return "var"
+	case SymbolFunc:
+		return "func"
+	case SymbolStruct:
+		return "struct"
+	case SymbolInterface:
+		return "interface"
+	case SymbolMethod:
+		return "method"
+	case SymbolEnum:
+		return "enum"
+	case SymbolModule:
+		return "module"
+	default:
+		return fmt.Sprintf("unknown symbol kind (%d)", int(k))
+	}
+}
+
+// Symbol is a name declared in a scope, for tooling like an editor listing the symbols of a workspace.
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	Pos  lexer.SrcPos // Position of the declaration
+}
+
+// Scope represents a lexical scope. The parent of the module top scope is the scope of the built-in functions,
+// whose parent is nil.
 type Scope struct {
-	parent      *Scope
-	vars        map[string]Type
-	structTypes map[string]StructType
-	funcs       map[string]FuncType
+	parent         *Scope
+	vars           map[string]Type
+	consts         map[string]bool // The variables of vars that are immutable
+	structTypes    map[string]StructType
+	enumTypes      map[string]EnumType
+	interfaceTypes map[string]InterfaceType
+	funcs          map[string]FuncType
+	modules        map[string]*Scope // The used modules by their aliases, as the scopes of their declarations
+	symbols        []Symbol          // The declarations of the scope, in the order they were resolved
 }
 
 // NewScope creates a new scope with optional parent
 func NewScope(parent *Scope) *Scope {
 	return &Scope{
-		parent:      parent,
-		vars:        make(map[string]Type),
-		structTypes: make(map[string]StructType),
-		funcs:       make(map[string]FuncType),
+		parent:         parent,
+		vars:           make(map[string]Type),
+		consts:         make(map[string]bool),
+		structTypes:    make(map[string]StructType),
+		enumTypes:      make(map[string]EnumType),
+		interfaceTypes: make(map[string]InterfaceType),
+		funcs:          make(map[string]FuncType),
+		modules:        make(map[string]*Scope),
+	}
+}
+
+// declare records the declaration of a symbol in the scope
+func (s *Scope) declare(name string, kind SymbolKind, pos lexer.SrcPos) {
+	s.symbols = append(s.symbols, Symbol{Name: name, Kind: kind, Pos: pos})
+}
+
+// declaredKind returns the kind of the symbol declared by a name in the scope itself, not checking parent
+// scopes. A name is declared at most once per scope across the kinds, so the order they are checked in only
+// matters for erroneous code: var, func, struct, enum, interface, module.
+func (s *Scope) declaredKind(name string) (SymbolKind, bool) {
+	if _, ok := s.vars[name]; ok {
+		return SymbolVar, true
+	}
+	if _, ok := s.funcs[name]; ok {
+		return SymbolFunc, true
+	}
+	if _, ok := s.structTypes[name]; ok {
+		return SymbolStruct, true
+	}
+	if _, ok := s.enumTypes[name]; ok {
+		return SymbolEnum, true
+	}
+	if _, ok := s.interfaceTypes[name]; ok {
+		return SymbolInterface, true
 	}
+	if _, ok := s.modules[name]; ok {
+		return SymbolModule, true
+	}
+	return 0, false
+}
+
+// lookupValue looks up what a name used as a value refers to, returning its kind and the scope declaring it.
+// Variables, functions, structs, enums and modules share one namespace, in which the innermost scope declaring
+// the name decides, whatever the kind: e.g. a local variable shadows a function or a struct of an enclosing
+// scope. Interfaces are only types, so they are skipped. Type expressions are resolved separately, so a
+// shadowed struct can still be named as a type.
+func (s *Scope) lookupValue(name string) (SymbolKind, *Scope, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if kind, ok := scope.declaredKind(name); ok && kind != SymbolInterface {
+			return kind, scope, true
+		}
+	}
+	return 0, nil, false
+}
+
+// declarationConflict returns why a name can't be declared as a symbol of the kind in the scope, or "" if it
+// can. The names of primitive types are reserved, and a name can only be declared as one kind per scope.
+// Redeclaring a name as the same kind is left for the caller to check, as e.g. variables may be redeclared.
+func declarationConflict(scope *Scope, primitives map[string]Type, name string, kind SymbolKind) string {
+	if _, ok := primitives[name]; ok {
+		return fmt.Sprintf("cannot declare %s %s, which is the name of a primitive type", kind, name)
+	}
+	if declared, ok := scope.declaredKind(name); ok && declared != kind {
+		return fmt.Sprintf("%s is already declared as a %s in the same scope", name, declared)
+	}
+	return ""
 }
 
 // DefineVar adds a variable to the current scope
 func (s *Scope) DefineVar(name string, varType Type) {
 	s.vars[name] = varType
+	delete(s.consts, name)
+}
+
+// DefineConst adds an immutable variable to the current scope
+func (s *Scope) DefineConst(name string, varType Type) {
+	s.vars[name] = varType
+	s.consts[name] = true
+}
+
+// IsConst reports whether a variable is immutable, checking parent scopes if not found
+func (s *Scope) IsConst(name string) bool {
+	if _, ok := s.vars[name]; ok {
+		return s.consts[name]
+	}
+	if s.parent != nil {
+		return s.parent.IsConst(name)
+	}
+	return false
 }
 
 // LookupVarType looks up a variable type, checking parent scopes if not found
@@ -55,6 +184,54 @@ func (s *Scope) LookupStructType(name string) (StructType, bool) {
 	return StructType{}, false
 }
 
+// DefineEnumType adds an enum type to the current scope
+func (s *Scope) DefineEnumType(name string, enumType EnumType) {
+	s.enumTypes[name] = enumType
+}
+
+// LookupEnumType looks up an enum type, checking parent scopes if not found
+func (s *Scope) LookupEnumType(name string) (EnumType, bool) {
+	if enumType, ok := s.enumTypes[name]; ok {
+		return enumType, true
+	}
+	if s.parent != nil {
+		return s.parent.LookupEnumType(name)
+	}
+	return EnumType{}, false
+}
+
+// DefineModule adds a used module to the current scope under an alias
+func (s *Scope) DefineModule(alias string, module *Scope) {
+	s.modules[alias] = module
+}
+
+// LookupModule looks up a used module by its alias, checking parent scopes if not found
+func (s *Scope) LookupModule(alias string) (*Scope, bool) {
+	if module, ok := s.modules[alias]; ok {
+		return module, true
+	}
+	if s.parent != nil {
+		return s.parent.LookupModule(alias)
+	}
+	return nil, false
+}
+
+// DefineInterfaceType adds an interface type to the current scope
+func (s *Scope) DefineInterfaceType(name string, interfaceType InterfaceType) {
+	s.interfaceTypes[name] = interfaceType
+}
+
+// LookupInterfaceType looks up an interface type, checking parent scopes if not found
+func (s *Scope) LookupInterfaceType(name string) (InterfaceType, bool) {
+	if interfaceType, ok := s.interfaceTypes[name]; ok {
+		return interfaceType, true
+	}
+	if s.parent != nil {
+		return s.parent.LookupInterfaceType(name)
+	}
+	return InterfaceType{}, false
+}
+
 // DefineFunc adds a function to the current scope
 func (s *Scope) DefineFunc(name string, funcType FuncType) {
 	s.funcs[name] = funcType
@@ -73,45 +250,106 @@ func (s *Scope) LookupFunc(name string) (FuncType, bool) {
 
 // ResolvedModule represents the result of symbol resolution
 type ResolvedModule struct {
-	RootScope *Scope         // Module-level scope
-	Scopes    map[any]*Scope // Maps AST nodes to their scopes
-	Errors    []string
+	RootScope *Scope                // Module-level scope
+	Scopes    map[any]*Scope        // Maps AST nodes to their scopes
+	Types     map[ast.TypeExpr]Type // Maps AST type expressions to the types they resolve to
+	Errors    []Diagnostic
+}
+
+// AllSymbols returns the symbols declared in all the scopes of the module, in the order of their positions.
+func (rm *ResolvedModule) AllSymbols() []Symbol {
+	symbols := slices.Clone(rm.RootScope.symbols)
+	visited := map[*Scope]bool{rm.RootScope: true}
+	for _, scope := range rm.Scopes {
+		if !visited[scope] {
+			visited[scope] = true
+			symbols = append(symbols, scope.symbols...)
+		}
+	}
+	slices.SortFunc(symbols, func(a, b Symbol) int {
+		return cmp.Or(cmp.Compare(a.Pos.Line, b.Pos.Line), cmp.Compare(a.Pos.Column, b.Pos.Column))
+	})
+	return symbols
+}
+
+// ModuleLoader supplies the modules named in use declarations, as the scopes of their top-level declarations,
+// e.g. the RootScope of another resolved module. It is up to the caller how the names map to the modules.
+type ModuleLoader interface {
+	// LoadModule returns the scope of the declarations of a module by its name or path (like std/io), or false
+	// if there is no such module
+	LoadModule(name string) (*Scope, bool)
 }
 
 // Resolver handles symbol resolution and builds symbol tables
 type Resolver struct {
-	errors     []string
-	currScope  *Scope         // Current scope during traversal
-	scopes     map[any]*Scope // Maps AST nodes to their scopes
+	errors     []Diagnostic
+	currScope  *Scope                // Current scope during traversal
+	scopes     map[any]*Scope        // Maps AST nodes to their scopes
+	types      map[ast.TypeExpr]Type // Maps AST type expressions to the types they resolve to
 	primitives map[string]Type
+	modules    ModuleLoader // Loads the used modules, or nil if there are none to use
 }
 
-// NewResolver creates a new resolver with built-in primitive types
-func NewResolver() *Resolver {
+// NewResolver creates a new resolver with the given primitive types
+func NewResolver(primitives map[string]Type) *Resolver {
 	return &Resolver{
-		errors:    []string{},
-		currScope: NewScope(nil),
-		scopes:    make(map[any]*Scope),
-		primitives: map[string]Type{
-			"bool":   PrimitiveType{Name: "bool"},
-			"string": PrimitiveType{Name: "string"},
-			"i8":     PrimitiveType{Name: "i8"},
-			"i32":    PrimitiveType{Name: "i32"},
-			"i64":    PrimitiveType{Name: "i64"},
-			"f32":    PrimitiveType{Name: "f32"},
-			"f64":    PrimitiveType{Name: "f64"},
-		},
+		errors:     []Diagnostic{},
+		currScope:  NewScope(builtinScope()),
+		scopes:     make(map[any]*Scope),
+		types:      make(map[ast.TypeExpr]Type),
+		primitives: primitives,
 	}
 }
 
-// Err adds an error to the resolver's error list
-func (r *Resolver) Err(msg string) {
-	coloredMsg := fmt.Sprintf("\033[31mResolve Error: %s\033[0m", msg)
-	r.errors = append(r.errors, coloredMsg)
+// declare records the declaration of a symbol in a scope, reporting a name that conflicts with a primitive
+// type or another kind of symbol in the scope. Methods are declared by qualified names, which can't conflict.
+func (r *Resolver) declare(scope *Scope, name string, kind SymbolKind, pos lexer.SrcPos) {
+	if kind != SymbolMethod {
+		if conflict := declarationConflict(scope, r.primitives, name, kind); conflict != "" {
+			r.Err(pos, conflict)
+		}
+	}
+	scope.declare(name, kind, pos)
 }
 
-// ResolveType converts an AST type expression to a concrete Type
+// Err adds an error at the given source position to the resolver's error list
+func (r *Resolver) Err(pos lexer.SrcPos, msg string) {
+	r.errors = append(r.errors, Diagnostic{
+		Severity: SeverityError,
+		Stage:    StageResolve,
+		Message:  msg,
+		Pos:      pos,
+	})
+}
+
+// ResolveType converts an AST type expression to a concrete Type, and records it for the later passes
 func (r *Resolver) ResolveType(typeExpr ast.TypeExpr) Type {
+	t := r.resolveTypeExpr(typeExpr)
+	if t != nil {
+		r.types[typeExpr] = t
+	}
+	return t
+}
+
+// resolveArraySize evaluates the size of a fixed-size array type, which must be a positive integer constant.
+func (r *Resolver) resolveArraySize(sizeExpr ast.Expr) (int, bool) {
+	size, err := evalConst(sizeExpr, "i32")
+	if err != nil {
+		r.Err(sizeExpr.Position(), fmt.Sprintf("invalid array size: %s", err))
+		return 0, false
+	}
+	if size.integer == nil {
+		r.Err(sizeExpr.Position(), fmt.Sprintf("array size must be an integer, found %s", size.typeName))
+		return 0, false
+	}
+	if size.integer.Sign() <= 0 {
+		r.Err(sizeExpr.Position(), fmt.Sprintf("array size must be positive, found %s", size.integer))
+		return 0, false
+	}
+	return int(size.integer.Int64()), true
+}
+
+func (r *Resolver) resolveTypeExpr(typeExpr ast.TypeExpr) Type {
 	switch e := typeExpr.(type) {
 	case *ast.NamedTypeExpr:
 		if prim, ok := r.primitives[e.TypeName]; ok {
@@ -120,14 +358,27 @@ func (r *Resolver) ResolveType(typeExpr ast.TypeExpr) Type {
 		if structType, ok := r.currScope.LookupStructType(e.TypeName); ok {
 			return structType
 		}
-		r.Err(fmt.Sprintf("undefined type: %s", e.TypeName))
+		if interfaceType, ok := r.currScope.LookupInterfaceType(e.TypeName); ok {
+			return interfaceType
+		}
+		if enumType, ok := r.currScope.LookupEnumType(e.TypeName); ok {
+			return enumType
+		}
+		r.Err(e.Pos, fmt.Sprintf("undefined type: %s", e.TypeName))
 		return nil
 	case *ast.ArrayTypeExpr:
 		elemType := r.ResolveType(e.UnderlyingType)
 		if elemType == nil {
 			return nil
 		}
-		return ArrayType{ElemType: elemType}
+		if e.Size == nil {
+			return ArrayType{ElemType: elemType}
+		}
+		size, ok := r.resolveArraySize(e.Size)
+		if !ok {
+			return nil
+		}
+		return ArrayType{ElemType: elemType, Size: size}
 	case *ast.FuncTypeExpr:
 		paramTypes := []Type{}
 		for _, astParamType := range e.ParamTypes {
@@ -145,47 +396,104 @@ func (r *Resolver) ResolveType(typeExpr ast.TypeExpr) Type {
 			ReturnType: returnType,
 			ParamTypes: paramTypes,
 		}
+	case *ast.TupleTypeExpr:
+		elemTypes := []Type{}
+		for _, astElemType := range e.ElemTypes {
+			elemType := r.ResolveType(astElemType)
+			if elemType == nil {
+				return nil
+			}
+			elemTypes = append(elemTypes, elemType)
+		}
+		return TupleType{ElemTypes: elemTypes}
+	case *ast.UnitTypeExpr:
+		return UnitType{}
 	default:
-		r.Err(fmt.Sprintf("unknown type: %T", typeExpr))
+		r.Err(lexer.SrcPos{}, fmt.Sprintf("unknown type: %T", typeExpr))
 		return nil
 	}
 }
 
 // Resolve performs symbol resolution on the module
-func Resolve(module *ast.BlockStmt) *ResolvedModule {
-	resolver := NewResolver()
+func Resolve(module *ast.BlockStmt, primitives map[string]Type, modules ModuleLoader) *ResolvedModule {
+	resolver := NewResolver(primitives)
+	resolver.modules = modules
 	// Process module statements directly in root scope - don't create a child scope
-	for _, stmt := range module.Statements {
-		resolver.resolveStmt(stmt)
-	}
+	resolver.resolveStmts(module.Statements)
 	return &ResolvedModule{
 		RootScope: resolver.currScope,
 		Scopes:    resolver.scopes,
+		Types:     resolver.types,
 		Errors:    resolver.errors,
 	}
 }
 
-// resolveStmt resolves symbols in a statement
+// resolveStmts resolves a list of statements sharing the current scope. Struct, interface and function
+// declarations are hoisted, so that they can be referred to before they are declared (e.g. for mutual
+// recursion): first all the type names are declared, then struct members, interface methods and function
+// signatures are resolved, and only then the statements in order. Variables are not hoisted.
+func (r *Resolver) resolveStmts(stmts []ast.Stmt) {
+	structs := []*ast.StructDeclStmt{}
+	interfaces := []*ast.InterfaceDeclStmt{}
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.UseDeclStmt:
+			r.resolveUseDeclStmt(s)
+		case *ast.EnumDeclStmt:
+			r.declareEnumType(s)
+		case *ast.StructDeclStmt:
+			if r.declareStructType(s) {
+				structs = append(structs, s)
+			}
+		case *ast.InterfaceDeclStmt:
+			if r.declareInterfaceType(s) {
+				interfaces = append(interfaces, s)
+			}
+		}
+	}
+	for _, stmt := range structs {
+		r.resolveStructMembers(stmt)
+	}
+	for _, stmt := range interfaces {
+		r.resolveInterfaceMethods(stmt)
+	}
+	for _, stmt := range stmts {
+		if funcDecl, ok := stmt.(*ast.FuncDeclStmt); ok {
+			r.declareFunc(funcDecl)
+		}
+	}
+	for _, stmt := range stmts {
+		r.resolveStmt(stmt)
+	}
+}
+
+// resolveStmt resolves symbols in a statement. Declarations must have already been hoisted by resolveStmts.
 func (r *Resolver) resolveStmt(stmt ast.Stmt) {
 	switch s := stmt.(type) {
 	case *ast.BlockStmt:
 		r.resolveBlockStmt(s)
 	case *ast.VarDeclStmt:
 		r.resolveVarDeclStmt(s)
-	case *ast.StructDeclStmt:
-		r.resolveStructDeclStmt(s)
+	case *ast.DestructuringVarDeclStmt:
+		r.resolveDestructuringVarDeclStmt(s)
+	case *ast.StructDeclStmt, *ast.EnumDeclStmt, *ast.InterfaceDeclStmt, *ast.UseDeclStmt:
+		// Fully resolved when hoisted
 	case *ast.FuncDeclStmt:
-		r.resolveFuncDeclStmt(s)
+		r.resolveFuncBody(s)
 	case *ast.IfStmt:
 		r.resolveIfStmt(s)
 	case *ast.ForStmt:
 		r.resolveForStmt(s)
 	case *ast.ReturnStmt:
 		r.resolveReturnStmt(s)
+	case *ast.BreakStmt:
+		if s.Value != nil {
+			r.resolveExpr(s.Value)
+		}
 	case *ast.ExpressionStmt:
 		r.resolveExpr(s.Expr)
 	default:
-		r.Err(fmt.Sprintf("unknown statement type: %T", stmt))
+		r.Err(stmt.Position(), fmt.Sprintf("unknown statement type: %T", stmt))
 	}
 }
 
@@ -193,8 +501,35 @@ func (r *Resolver) resolveStmt(stmt ast.Stmt) {
 func (r *Resolver) resolveBlockStmt(block *ast.BlockStmt) {
 	oldTable := r.currScope
 	r.currScope = NewScope(oldTable)
-	for _, stmt := range block.Statements {
-		r.resolveStmt(stmt)
+	r.scopes[block] = r.currScope
+	r.resolveStmts(block.Statements)
+	r.currScope = oldTable
+}
+
+// resolveBlockExpr resolves the statements and the result expression of a block expression in a scope
+// of its own, so that the declarations within the block are not visible after it
+func (r *Resolver) resolveBlockExpr(block *ast.BlockExpr) {
+	oldTable := r.currScope
+	r.currScope = NewScope(oldTable)
+	r.scopes[block] = r.currScope
+	r.resolveStmts(block.Statements)
+	r.resolveExpr(block.ResultExpr)
+	r.currScope = oldTable
+}
+
+// resolveMatchExpr resolves the subject and the arms of a match expression. Each arm has a scope of its own,
+// where a binding pattern defines a variable. Its type is the type of the subject, determined by the type checker.
+func (r *Resolver) resolveMatchExpr(expr *ast.MatchExpr) {
+	r.resolveExpr(expr.Subject)
+	oldTable := r.currScope
+	for _, arm := range expr.Arms {
+		r.currScope = NewScope(oldTable)
+		r.scopes[arm] = r.currScope
+		if binding, ok := arm.Pattern.(*ast.IdentExpr); ok {
+			r.declare(r.currScope, binding.Value, SymbolVar, binding.Pos)
+			r.currScope.DefineVar(binding.Value, nil)
+		}
+		r.resolveExpr(arm.Body)
 	}
 	r.currScope = oldTable
 }
@@ -208,38 +543,165 @@ func (r *Resolver) resolveVarDeclStmt(stmt *ast.VarDeclStmt) {
 	if stmt.InitVal != nil {
 		r.resolveExpr(stmt.InitVal)
 	}
-	r.currScope.DefineVar(stmt.Var.Name, declaredType)
+	r.declare(r.currScope, stmt.Var.Name, SymbolVar, stmt.Var.Pos)
+	if !stmt.Const {
+		r.currScope.DefineVar(stmt.Var.Name, declaredType)
+		return
+	}
+	// A constant can't be assigned to later, so it must be initialized when declared
+	if stmt.InitVal == nil {
+		r.Err(stmt.Var.Pos, fmt.Sprintf("missing value of immutable variable %s", stmt.Var.Name))
+	}
+	r.currScope.DefineConst(stmt.Var.Name, declaredType)
 }
 
-// resolveStructDeclStmt resolves a struct declaration
-func (r *Resolver) resolveStructDeclStmt(stmt *ast.StructDeclStmt) {
-	if _, ok := r.currScope.LookupStructType(stmt.Name); ok {
-		r.Err(fmt.Sprintf("redeclared struct %s in the same scope", stmt.Name))
+// resolveVarDeclAssignExpr resolves a variable declared with :=. The variable is defined after the value is
+// resolved, so that the value can't refer to it. Its type is left for the type checker to infer from the value.
+func (r *Resolver) resolveVarDeclAssignExpr(expr *ast.VarDeclAssignExpr) {
+	r.resolveExpr(expr.AssignedValue)
+	if r.currScope.consts[expr.Name] {
+		r.Err(expr.Pos, fmt.Sprintf("cannot redeclare immutable variable %s with :=", expr.Name))
 		return
 	}
-	members := make(map[string]Type)
-	memberNames := make(map[string]bool)
+	r.declare(r.currScope, expr.Name, SymbolVar, expr.Pos)
+	if _, defined := r.currScope.vars[expr.Name]; !defined {
+		r.currScope.DefineVar(expr.Name, nil)
+	}
+}
+
+// resolveDestructuringVarDeclStmt resolves a destructuring variable declaration. The variables are
+// defined after the value is resolved, so that the value can't refer to them.
+func (r *Resolver) resolveDestructuringVarDeclStmt(stmt *ast.DestructuringVarDeclStmt) {
+	r.resolveExpr(stmt.InitVal)
+	for _, variable := range stmt.Vars {
+		if variable.Type == nil {
+			r.Err(variable.Pos, fmt.Sprintf("missing type of destructured variable %s", variable.Name))
+			continue
+		}
+		declaredType := r.ResolveType(variable.Type)
+		if declaredType == nil {
+			continue
+		}
+		r.declare(r.currScope, variable.Name, SymbolVar, variable.Pos)
+		if stmt.Const {
+			r.currScope.DefineConst(variable.Name, declaredType)
+		} else {
+			r.currScope.DefineVar(variable.Name, declaredType)
+		}
+	}
+}
+
+// declareStructType declares the name of a struct type, so that it can be referred to before its members
+// have been resolved. Returns false if the struct has already been declared.
+func (r *Resolver) declareStructType(stmt *ast.StructDeclStmt) bool {
+	if _, ok := r.currScope.structTypes[stmt.Name]; ok {
+		r.Err(stmt.Pos, fmt.Sprintf("redeclared struct %s in the same scope", stmt.Name))
+		return false
+	}
+	r.declare(r.currScope, stmt.Name, SymbolStruct, stmt.Pos)
+	r.currScope.DefineStructType(stmt.Name, StructType{
+		Name:    stmt.Name,
+		Members: make(map[string]Type),
+		Methods: make(map[string]FuncType),
+	})
+	return true
+}
+
+// resolveStructMembers resolves the member types of a declared struct type
+func (r *Resolver) resolveStructMembers(stmt *ast.StructDeclStmt) {
+	// The maps are shared by all the copies of the struct type, including the ones already resolved as member types
+	structType, _ := r.currScope.LookupStructType(stmt.Name)
 	for _, member := range stmt.Members {
-		if memberNames[member.Name] {
-			r.Err(fmt.Sprintf("duplicate member %s in struct %s", member.Name, stmt.Name))
+		if _, ok := structType.Members[member.Name]; ok {
+			r.Err(member.Pos, fmt.Sprintf("duplicate member %s in struct %s", member.Name, stmt.Name))
 			continue
 		}
 		memberType := r.ResolveType(member.Type)
 		if memberType != nil {
-			members[member.Name] = memberType
-			memberNames[member.Name] = true
+			structType.Members[member.Name] = memberType
 		}
 	}
-	r.currScope.DefineStructType(stmt.Name, StructType{
+}
+
+// resolveUseDeclStmt loads the used modules, and defines each under its alias in the current scope
+func (r *Resolver) resolveUseDeclStmt(stmt *ast.UseDeclStmt) {
+	for _, spec := range stmt.UseSpecs {
+		if _, ok := r.currScope.modules[spec.Name]; ok {
+			r.Err(spec.Pos, fmt.Sprintf("redeclared module alias %s in the same scope", spec.Name))
+			continue
+		}
+		var module *Scope
+		ok := false
+		if r.modules != nil {
+			module, ok = r.modules.LoadModule(spec.Module.String())
+		}
+		if !ok {
+			r.Err(spec.Pos, fmt.Sprintf("unknown module: %s", spec.Module))
+			continue
+		}
+		r.declare(r.currScope, spec.Name, SymbolModule, spec.Pos)
+		r.currScope.DefineModule(spec.Name, module)
+	}
+}
+
+// declareEnumType declares an enum type along with its variants, which can't repeat
+func (r *Resolver) declareEnumType(stmt *ast.EnumDeclStmt) {
+	if _, ok := r.currScope.enumTypes[stmt.Name]; ok {
+		r.Err(stmt.Pos, fmt.Sprintf("redeclared enum %s in the same scope", stmt.Name))
+		return
+	}
+	for i, variant := range stmt.Variants {
+		if slices.Contains(stmt.Variants[:i], variant) {
+			r.Err(stmt.Pos, fmt.Sprintf("duplicate variant %s in enum %s", variant, stmt.Name))
+		}
+	}
+	r.declare(r.currScope, stmt.Name, SymbolEnum, stmt.Pos)
+	r.currScope.DefineEnumType(stmt.Name, EnumType{
+		Name:     stmt.Name,
+		Variants: stmt.Variants,
+	})
+}
+
+// declareInterfaceType declares the name of an interface type, so that it can be referred to before
+// its methods have been resolved. Returns false if the interface has already been declared.
+func (r *Resolver) declareInterfaceType(stmt *ast.InterfaceDeclStmt) bool {
+	if _, ok := r.currScope.interfaceTypes[stmt.Name]; ok {
+		r.Err(stmt.Pos, fmt.Sprintf("redeclared interface %s in the same scope", stmt.Name))
+		return false
+	}
+	r.declare(r.currScope, stmt.Name, SymbolInterface, stmt.Pos)
+	r.currScope.DefineInterfaceType(stmt.Name, InterfaceType{
 		Name:    stmt.Name,
-		Members: members,
+		Methods: make(map[string]FuncType),
 	})
+	return true
 }
 
-// resolveFuncDeclStmt resolves a function declaration
-func (r *Resolver) resolveFuncDeclStmt(stmt *ast.FuncDeclStmt) {
-	if _, ok := r.currScope.LookupFunc(stmt.Name); ok {
-		r.Err(fmt.Sprintf("redeclared function %s in the same scope", stmt.Name))
+// resolveInterfaceMethods resolves the method signatures of a declared interface type
+func (r *Resolver) resolveInterfaceMethods(stmt *ast.InterfaceDeclStmt) {
+	interfaceType, _ := r.currScope.LookupInterfaceType(stmt.Name)
+	for _, method := range stmt.Methods {
+		if _, ok := interfaceType.Methods[method.Name]; ok {
+			r.Err(method.Pos, fmt.Sprintf("duplicate method %s in interface %s", method.Name, stmt.Name))
+			continue
+		}
+		if methodType, ok := r.ResolveType(method.Type).(FuncType); ok {
+			interfaceType.Methods[method.Name] = methodType
+		}
+	}
+}
+
+// declareFunc resolves the signature of a function and declares it, along with the scope of its parameters.
+// A method is not declared in the scope, but as a method of the struct type of its receiver instead.
+func (r *Resolver) declareFunc(stmt *ast.FuncDeclStmt) {
+	var structType StructType
+	if stmt.Receiver != nil {
+		var ok bool
+		if structType, ok = r.resolveReceiver(stmt); !ok {
+			return
+		}
+	} else if _, ok := r.currScope.funcs[stmt.Name]; ok {
+		r.Err(stmt.Pos, fmt.Sprintf("redeclared function %s in the same scope", stmt.Name))
 		return
 	}
 
@@ -253,31 +715,106 @@ func (r *Resolver) resolveFuncDeclStmt(stmt *ast.FuncDeclStmt) {
 
 	paramTypes := make([]Type, 0, len(stmt.Parameters))
 	funcScope := NewScope(r.currScope)
+	if stmt.Receiver != nil {
+		r.declare(funcScope, stmt.Receiver.Name, SymbolVar, stmt.Receiver.Pos)
+		funcScope.DefineVar(stmt.Receiver.Name, structType)
+	}
 
+	// A default value is filled in at the call site, where the names it could refer to may be shadowed,
+	// so only a literal is allowed
+	defaults := []constant{}
 	for _, param := range stmt.Parameters {
 		paramType := r.ResolveType(param.Type)
 		if paramType == nil {
 			return
 		}
+		if param.Variadic {
+			paramType = ArrayType{ElemType: paramType}
+		}
+		if param.Default != nil {
+			if !isLiteral(param.Default) {
+				r.Err(param.Default.Position(), fmt.Sprintf("default value of parameter %s must be a literal", param.Name))
+			}
+			defaults = append(defaults, defaultValue(param.Default, paramType))
+		} else if len(defaults) > 0 && !param.Variadic {
+			r.Err(param.Pos, fmt.Sprintf("required parameter %s after a parameter with a default value", param.Name))
+			defaults = defaults[:0]
+		}
 		paramTypes = append(paramTypes, paramType)
+		// A parameter may shadow the function itself like any outer name, but not the receiver or another parameter
+		if _, ok := funcScope.vars[param.Name]; ok {
+			r.Err(param.Pos, fmt.Sprintf("duplicate parameter name %s", param.Name))
+			continue
+		}
+		r.declare(funcScope, param.Name, SymbolVar, param.Pos)
 		funcScope.DefineVar(param.Name, paramType)
 	}
 
 	funcType := FuncType{
 		ReturnType: returnType,
 		ParamTypes: paramTypes,
+		Defaults:   defaults,
+		Variadic:   len(stmt.Parameters) > 0 && stmt.Parameters[len(stmt.Parameters)-1].Variadic,
+	}
+	if stmt.Receiver != nil {
+		// The maps are shared by all the copies of the struct type, so the method is visible wherever the struct is
+		structType.Methods[stmt.Name] = funcType
+		r.declare(r.currScope, structType.Name+"."+stmt.Name, SymbolMethod, stmt.Pos)
+	} else {
+		r.declare(r.currScope, stmt.Name, SymbolFunc, stmt.Pos)
+		r.currScope.DefineFunc(stmt.Name, funcType)
 	}
-	r.currScope.DefineFunc(stmt.Name, funcType)
 
 	// Record function scope in map using statement pointer as key
 	r.scopes[stmt] = funcScope
+}
+
+// defaultValue evaluates the default value of a parameter, a number literal taking the type of the parameter.
+// A value that fails to evaluate, e.g. one out of the range of the type, is reported by the type checker.
+func defaultValue(value ast.Expr, paramType Type) constant {
+	literalType := "i32"
+	if IsNumeric(paramType) {
+		literalType = paramType.String()
+	}
+	c, _ := evalConst(value, literalType)
+	return c
+}
+
+// resolveReceiver resolves the type of the receiver of a method, which must be a struct without a member
+// or another method by the name of the method.
+func (r *Resolver) resolveReceiver(stmt *ast.FuncDeclStmt) (StructType, bool) {
+	receiverType := r.ResolveType(stmt.Receiver.Type)
+	if receiverType == nil {
+		return StructType{}, false
+	}
+	structType, ok := receiverType.(StructType)
+	if !ok {
+		r.Err(stmt.Receiver.Pos, fmt.Sprintf("method receiver must be a struct, found %s", receiverType))
+		return StructType{}, false
+	}
+	if _, ok := structType.Members[stmt.Name]; ok {
+		r.Err(stmt.Pos, fmt.Sprintf("method %s conflicts with a member of struct %s", stmt.Name, structType.Name))
+		return StructType{}, false
+	}
+	if _, ok := structType.Methods[stmt.Name]; ok {
+		r.Err(stmt.Pos, fmt.Sprintf("redeclared method %s of struct %s", stmt.Name, structType.Name))
+		return StructType{}, false
+	}
+	return structType, true
+}
+
+// resolveFuncBody resolves the body of a declared function in the scope of its parameters
+func (r *Resolver) resolveFuncBody(stmt *ast.FuncDeclStmt) {
+	funcScope, ok := r.scopes[stmt]
+	if !ok {
+		// The signature failed to resolve, or the function is a redeclaration
+		return
+	}
 	oldTable := r.currScope
 	r.currScope = funcScope
 
 	// Process function body statements directly in function scope
-	for _, bodyStmt := range stmt.Body.Statements {
-		r.resolveStmt(bodyStmt)
-	}
+	r.resolveStmts(stmt.Body.Statements)
 
 	r.currScope = oldTable
 }
@@ -285,18 +822,23 @@ func (r *Resolver) resolveFuncDeclStmt(stmt *ast.FuncDeclStmt) {
 // resolveIfStmt resolves an if statement
 func (r *Resolver) resolveIfStmt(stmt *ast.IfStmt) {
 	r.resolveExpr(stmt.Cond)
-	r.resolveStmt(stmt.Then)
+	r.resolveStmts([]ast.Stmt{stmt.Then})
 	if stmt.Else != nil {
-		r.resolveStmt(stmt.Else)
+		r.resolveStmts([]ast.Stmt{stmt.Else})
 	}
 }
 
-// resolveForStmt resolves a for statement
+// resolveForStmt resolves a for statement. The loop header has a scope of its own, so that a variable declared
+// by the init statement is visible in the condition, the iteration and the body, but not after the loop.
 func (r *Resolver) resolveForStmt(stmt *ast.ForStmt) {
+	oldTable := r.currScope
+	r.currScope = NewScope(oldTable)
+	r.scopes[stmt] = r.currScope
 	r.resolveStmt(stmt.Init)
 	r.resolveExpr(stmt.Cond)
 	r.resolveExpr(stmt.Iter.Expr)
 	r.resolveBlockStmt(stmt.Body)
+	r.currScope = oldTable
 }
 
 // resolveReturnStmt resolves a return statement
@@ -309,16 +851,11 @@ func (r *Resolver) resolveReturnStmt(stmt *ast.ReturnStmt) {
 // resolveExpr resolves symbols in an expression
 func (r *Resolver) resolveExpr(expr ast.Expr) {
 	switch e := expr.(type) {
-	case *ast.NumberLiteralExpr, *ast.StringLiteralExpr, *ast.BoolLiteralExpr:
+	case *ast.NumberLiteralExpr, *ast.StringLiteralExpr, *ast.BoolLiteralExpr, *ast.UnitExpr:
 		// Literals don't need resolution
 	case *ast.IdentExpr:
-		// Check if identifier exists in symbol table
-		if _, ok := r.currScope.LookupVarType(e.Value); !ok {
-			if _, ok := r.currScope.LookupStructType(e.Value); !ok {
-				if _, ok := r.currScope.LookupFunc(e.Value); !ok {
-					r.Err(fmt.Sprintf("undefined identifier: %s", e.Value))
-				}
-			}
+		if _, _, ok := r.currScope.lookupValue(e.Value); !ok {
+			r.Err(e.Pos, fmt.Sprintf("undefined identifier: %s", e.Value))
 		}
 	case *ast.BinaryExpr:
 		r.resolveExpr(e.Lhs)
@@ -327,6 +864,26 @@ func (r *Resolver) resolveExpr(expr ast.Expr) {
 		r.resolveExpr(e.Rhs)
 	case *ast.GroupExpr:
 		r.resolveExpr(e.Expr)
+	case *ast.BlockExpr:
+		r.resolveBlockExpr(e)
+	case *ast.AscriptionExpr:
+		r.resolveExpr(e.Expr)
+		r.ResolveType(e.Type)
+	case *ast.CastExpr:
+		r.resolveExpr(e.Expr)
+		r.ResolveType(e.TargetType)
+	case *ast.TupleExpr:
+		for _, elem := range e.Elems {
+			r.resolveExpr(elem)
+		}
+	case *ast.LoopExpr:
+		r.resolveBlockStmt(e.Body)
+	case *ast.MatchExpr:
+		r.resolveMatchExpr(e)
+	case *ast.IfExpr:
+		r.resolveExpr(e.Cond)
+		r.resolveExpr(e.Then)
+		r.resolveExpr(e.Else)
 	case *ast.FuncCallExpr:
 		r.resolveExpr(e.Func)
 		for _, arg := range e.Args {
@@ -339,6 +896,8 @@ func (r *Resolver) resolveExpr(expr ast.Expr) {
 		}
 	case *ast.StructMemberExpr:
 		r.resolveExpr(e.Struct)
+	case *ast.MemberAssignExpr:
+		r.resolveExpr(e.Value)
 	case *ast.ArrayIndexExpr:
 		r.resolveExpr(e.Array)
 		r.resolveExpr(e.Index)
@@ -346,10 +905,8 @@ func (r *Resolver) resolveExpr(expr ast.Expr) {
 		r.resolveExpr(e.Assigne)
 		r.resolveExpr(e.AssignedValue)
 	case *ast.VarDeclAssignExpr:
-		r.resolveExpr(e.AssignedValue)
-		// Define the variable in current scope (type inference will happen in type checker)
-		// For now, we can't determine the type without the type checker
+		r.resolveVarDeclAssignExpr(e)
 	default:
-		r.Err(fmt.Sprintf("unknown expression type: %T", expr))
+		r.Err(expr.Position(), fmt.Sprintf("unknown expression type: %T", expr))
 	}
 }
@@ -3,164 +3,124 @@ package typechecker
 import (
 	"fmt"
 	"github.com/ruistola/cooper/ast"
+	"github.com/ruistola/cooper/lexer"
 	"slices"
 )
 
 // SemanticAnalyzer handles semantic validation and control flow analysis
 type SemanticAnalyzer struct {
-	errors      []string
-	symbolTable *Scope
+	errors         []Diagnostic
+	scopes         map[any]*Scope                    // AST nodes to their scopes (from resolver)
+	narrowingCasts map[*ast.CastExpr]Type            // Casts that may truncate the value, to the types cast from (from type checker)
+	outOfBounds    map[*ast.ArrayIndexExpr]ArrayType // Constant indices out of bounds, to the arrays' types (from type checker)
+	warnings       map[string]bool                   // Enabled warnings by name
 }
 
-// NewSemanticAnalyzer creates a new semantic analyzer
-func NewSemanticAnalyzer(symbolTable *Scope) *SemanticAnalyzer {
+// NewSemanticAnalyzer creates a new semantic analyzer reporting the enabled warnings
+func NewSemanticAnalyzer(scopes map[any]*Scope, narrowingCasts map[*ast.CastExpr]Type, outOfBounds map[*ast.ArrayIndexExpr]ArrayType, warnings map[string]bool) *SemanticAnalyzer {
 	return &SemanticAnalyzer{
-		errors:      []string{},
-		symbolTable: symbolTable,
+		errors:         []Diagnostic{},
+		scopes:         scopes,
+		narrowingCasts: narrowingCasts,
+		outOfBounds:    outOfBounds,
+		warnings:       warnings,
 	}
 }
 
-// Err adds an error to the semantic analyzer's error list
-func (sa *SemanticAnalyzer) Err(msg string) {
-	coloredMsg := fmt.Sprintf("\033[31mSemantic Error: %s\033[0m", msg)
-	sa.errors = append(sa.errors, coloredMsg)
+// Err adds an error at the given source position to the semantic analyzer's error list
+func (sa *SemanticAnalyzer) Err(pos lexer.SrcPos, msg string) {
+	sa.errors = append(sa.errors, Diagnostic{
+		Severity: SeverityError,
+		Stage:    StageSemantic,
+		Message:  msg,
+		Pos:      pos,
+	})
+}
+
+// Warn adds a warning at the given source position to the semantic analyzer's error list, if the named
+// warning is enabled. The name is appended to the message, for finding out how to disable the warning.
+func (sa *SemanticAnalyzer) Warn(pos lexer.SrcPos, name string, msg string) {
+	if !sa.warnings[name] {
+		return
+	}
+	sa.errors = append(sa.errors, Diagnostic{
+		Severity: SeverityWarning,
+		Stage:    StageSemantic,
+		Message:  fmt.Sprintf("%s [%s]", msg, name),
+		Pos:      pos,
+	})
 }
 
-// AnalyzeSemantics performs semantic analysis on the module
-func AnalyzeSemantics(module *ast.BlockStmt, symbolTable *Scope) []string {
-	analyzer := NewSemanticAnalyzer(symbolTable)
-	analyzer.analyzeBlockStmt(module)
+// AnalyzeSemantics performs semantic analysis on the module, reporting the enabled warnings
+func AnalyzeSemantics(module *ast.BlockStmt, scopes map[any]*Scope, narrowingCasts map[*ast.CastExpr]Type, outOfBounds map[*ast.ArrayIndexExpr]ArrayType, warnings map[string]bool) []Diagnostic {
+	analyzer := NewSemanticAnalyzer(scopes, narrowingCasts, outOfBounds, warnings)
+	ast.Walk(module, analyzer)
 	return analyzer.errors
 }
 
-// analyzeStmt analyzes semantic rules for a statement
-func (sa *SemanticAnalyzer) analyzeStmt(stmt ast.Stmt) {
-	switch s := stmt.(type) {
+// Visit implements ast.Visitor. It checks the semantic rules specific to a node before the
+// children of the node are analyzed by the walk.
+func (sa *SemanticAnalyzer) Visit(node ast.Node) bool {
+	switch n := node.(type) {
 	case *ast.BlockStmt:
-		sa.analyzeBlockStmt(s)
-	case *ast.VarDeclStmt:
-		sa.analyzeVarDeclStmt(s)
-	case *ast.StructDeclStmt:
-		sa.analyzeStructDeclStmt(s)
+		sa.checkUnreachableCode(n)
+		// A trailing expression without a semicolon is the value of the block, e.g. of a function body
+		statements := n.Statements
+		if len(statements) > 0 {
+			if last, ok := statements[len(statements)-1].(*ast.ExpressionStmt); ok && !last.ExplicitSemicolon {
+				statements = statements[:len(statements)-1]
+			}
+		}
+		sa.checkUnusedResults(statements)
+	case *ast.BlockExpr:
+		// The result expression is not among the statements
+		sa.checkUnusedResults(n.Statements)
 	case *ast.FuncDeclStmt:
-		sa.analyzeFuncDeclStmt(s)
+		sa.analyzeFuncDeclStmt(n)
 	case *ast.IfStmt:
-		sa.analyzeIfStmt(s)
+		sa.checkConstantCondition(n.Cond)
+		sa.checkAssignmentCondition(n.Cond)
+	case *ast.IfExpr:
+		sa.checkConstantCondition(n.Cond)
+		sa.checkAssignmentCondition(n.Cond)
 	case *ast.ForStmt:
-		sa.analyzeForStmt(s)
-	case *ast.ReturnStmt:
-		sa.analyzeReturnStmt(s)
-	case *ast.ExpressionStmt:
-		sa.analyzeExpr(s.Expr)
-	default:
-		sa.Err(fmt.Sprintf("unknown statement type for semantic analysis: %T", stmt))
-	}
-}
-
-// analyzeBlockStmt analyzes a block statement for semantic rules
-func (sa *SemanticAnalyzer) analyzeBlockStmt(block *ast.BlockStmt) {
-	for _, stmt := range block.Statements {
-		sa.analyzeStmt(stmt)
-	}
-	// Check for unreachable code
-	sa.checkUnreachableCode(block)
-}
-
-// analyzeVarDeclStmt analyzes variable declarations for semantic rules
-func (sa *SemanticAnalyzer) analyzeVarDeclStmt(stmt *ast.VarDeclStmt) {
-	// Variable declaration semantic rules can be added here
-	// For example: checking if variable shadows outer scope variables, etc.
-	if stmt.InitVal != nil {
-		sa.analyzeExpr(stmt.InitVal)
+		sa.checkAssignmentCondition(n.Cond)
+	case *ast.AssignExpr:
+		sa.checkSelfAssignment(n)
+	case *ast.StructLiteralExpr:
+		sa.checkMemberValues(n)
+	case *ast.CastExpr:
+		if fromType, ok := sa.narrowingCasts[n]; ok {
+			sa.Warn(n.Pos, WarnNarrowingCast, fmt.Sprintf("cast from %s to %s may truncate the value", fromType, ast.Print(n.TargetType)))
+		}
+	case *ast.ArrayIndexExpr:
+		if arrayType, ok := sa.outOfBounds[n]; ok {
+			sa.Warn(n.Index.Position(), WarnIndexOutOfBounds, fmt.Sprintf("index %s is out of bounds for %s", ast.Print(n.Index), arrayType))
+		}
 	}
+	return true
 }
 
-// analyzeStructDeclStmt analyzes struct declarations for semantic rules
-func (sa *SemanticAnalyzer) analyzeStructDeclStmt(stmt *ast.StructDeclStmt) {
-	// Struct declaration semantic rules can be added here
-	// For example: checking for recursive struct definitions, etc.
-}
+// Leave implements ast.Visitor. No semantic rules are checked after the children of a node.
+func (sa *SemanticAnalyzer) Leave(node ast.Node) {}
 
 // analyzeFuncDeclStmt analyzes function declarations for semantic rules
 func (sa *SemanticAnalyzer) analyzeFuncDeclStmt(stmt *ast.FuncDeclStmt) {
-	// Get function type from symbol table
-	funcType, ok := sa.symbolTable.LookupFunc(stmt.Name)
+	var funcType FuncType
+	funcScope, ok := sa.scopes[stmt]
+	if ok {
+		funcType, ok = declaredFuncType(stmt, funcScope)
+	}
 	if !ok {
-		sa.Err(fmt.Sprintf("function %s not found in symbol table during semantic analysis", stmt.Name))
+		sa.Err(stmt.Pos, fmt.Sprintf("function %s not found in symbol table during semantic analysis", stmt.Name))
 		return
 	}
 
-	// Analyze function body
-	sa.analyzeBlockStmt(stmt.Body)
-
 	// Check that all code paths return a value if needed
 	if funcType.ReturnType != nil && !IsUnit(funcType.ReturnType) {
 		if !sa.blockReturns(stmt.Body) {
-			sa.Err(fmt.Sprintf("function '%s' with return type %s does not return a value in all code paths", stmt.Name, funcType.ReturnType))
-		}
-	}
-}
-
-// analyzeIfStmt analyzes if statements for semantic rules
-func (sa *SemanticAnalyzer) analyzeIfStmt(stmt *ast.IfStmt) {
-	sa.analyzeExpr(stmt.Cond)
-	sa.analyzeStmt(stmt.Then)
-	if stmt.Else != nil {
-		sa.analyzeStmt(stmt.Else)
-	}
-}
-
-// analyzeForStmt analyzes for statements for semantic rules
-func (sa *SemanticAnalyzer) analyzeForStmt(stmt *ast.ForStmt) {
-	sa.analyzeStmt(stmt.Init)
-	sa.analyzeExpr(stmt.Cond)
-	sa.analyzeExpr(stmt.Iter.Expr)
-	sa.analyzeBlockStmt(stmt.Body)
-}
-
-// analyzeReturnStmt analyzes return statements for semantic rules
-func (sa *SemanticAnalyzer) analyzeReturnStmt(stmt *ast.ReturnStmt) {
-	if stmt.Expr != nil {
-		sa.analyzeExpr(stmt.Expr)
-	}
-}
-
-// analyzeExpr analyzes expressions for semantic rules
-func (sa *SemanticAnalyzer) analyzeExpr(expr ast.Expr) {
-	switch e := expr.(type) {
-	case *ast.NumberLiteralExpr, *ast.StringLiteralExpr, *ast.BoolLiteralExpr:
-		// Literals don't need semantic analysis
-	case *ast.IdentExpr:
-		// Identifier semantic rules can be added here
-	case *ast.BinaryExpr:
-		sa.analyzeExpr(e.Lhs)
-		sa.analyzeExpr(e.Rhs)
-	case *ast.UnaryExpr:
-		sa.analyzeExpr(e.Rhs)
-	case *ast.GroupExpr:
-		sa.analyzeExpr(e.Expr)
-	case *ast.FuncCallExpr:
-		sa.analyzeExpr(e.Func)
-		for _, arg := range e.Args {
-			sa.analyzeExpr(arg)
-		}
-	case *ast.StructLiteralExpr:
-		sa.analyzeExpr(e.Struct)
-		for _, member := range e.Members {
-			sa.analyzeExpr(member.Value)
+			sa.Err(stmt.Pos, fmt.Sprintf("function '%s' with return type %s does not return a value in all code paths", stmt.Name, funcType.ReturnType))
 		}
-	case *ast.StructMemberExpr:
-		sa.analyzeExpr(e.Struct)
-	case *ast.ArrayIndexExpr:
-		sa.analyzeExpr(e.Array)
-		sa.analyzeExpr(e.Index)
-	case *ast.AssignExpr:
-		sa.analyzeExpr(e.Assigne)
-		sa.analyzeExpr(e.AssignedValue)
-	case *ast.VarDeclAssignExpr:
-		sa.analyzeExpr(e.AssignedValue)
-	default:
-		sa.Err(fmt.Sprintf("unknown expression type for semantic analysis: %T", expr))
 	}
 }
 
@@ -190,31 +150,82 @@ func (sa *SemanticAnalyzer) blockReturns(block *ast.BlockStmt) bool {
 	})
 }
 
-// checkUnreachableCode detects unreachable code after return statements
+// checkUnreachableCode detects unreachable code after return statements.
+// Nested blocks are checked separately when the walk reaches them.
 func (sa *SemanticAnalyzer) checkUnreachableCode(block *ast.BlockStmt) {
 	for i := range len(block.Statements) - 1 {
 		if sa.stmtReturns(block.Statements[i]) {
-			sa.Err(fmt.Sprintf("unreachable code after statement %d", i+1))
+			sa.Err(block.Statements[i+1].Position(), fmt.Sprintf("unreachable code after statement %d", i+1))
 			break
 		}
 	}
+}
 
-	// Recursively check nested blocks
-	for _, stmt := range block.Statements {
-		switch s := stmt.(type) {
-		case *ast.BlockStmt:
-			sa.checkUnreachableCode(s)
-		case *ast.IfStmt:
-			if thenBlock, ok := s.Then.(*ast.BlockStmt); ok {
-				sa.checkUnreachableCode(thenBlock)
-			}
-			if s.Else != nil {
-				if elseBlock, ok := s.Else.(*ast.BlockStmt); ok {
-					sa.checkUnreachableCode(elseBlock)
-				}
+// checkConstantCondition warns about an if- condition that is a boolean literal, leaving one of the
+// branches dead.
+func (sa *SemanticAnalyzer) checkConstantCondition(cond ast.Expr) {
+	for {
+		group, ok := cond.(*ast.GroupExpr)
+		if !ok {
+			break
+		}
+		cond = group.Expr
+	}
+	if literal, ok := cond.(*ast.BoolLiteralExpr); ok {
+		sa.Warn(literal.Pos, WarnConstantCondition, fmt.Sprintf("condition is always %t", literal.Value))
+	}
+}
+
+// checkAssignmentCondition warns about a condition that is an assignment, possibly parenthesized, which was
+// likely meant to be a comparison. An assignment nested in the condition, e.g. in a call, is not reported.
+func (sa *SemanticAnalyzer) checkAssignmentCondition(cond ast.Expr) {
+	if assign, ok := ungroup(cond).(*ast.AssignExpr); ok && assign.Operator.Type == lexer.EQUALS {
+		sa.Warn(assign.Pos, WarnAssignInCondition, "assignment used as a condition, did you mean ==?")
+	}
+}
+
+// checkUnusedResults warns about expression statements that compute a value with an operator, or are just
+// a literal, without using it. Calls and assignments are made for their side effects, and are not reported.
+func (sa *SemanticAnalyzer) checkUnusedResults(statements []ast.Stmt) {
+	for _, stmt := range statements {
+		exprStmt, ok := stmt.(*ast.ExpressionStmt)
+		if !ok {
+			continue
+		}
+		switch ungroup(exprStmt.Expr).(type) {
+		case *ast.BinaryExpr, *ast.UnaryExpr, *ast.NumberLiteralExpr, *ast.StringLiteralExpr, *ast.BoolLiteralExpr:
+			sa.Warn(exprStmt.Pos, WarnUnusedResult, "expression result is unused")
+		}
+	}
+}
+
+// checkSelfAssignment warns about assigning a variable to itself, which has no effect.
+func (sa *SemanticAnalyzer) checkSelfAssignment(expr *ast.AssignExpr) {
+	assigne, ok := expr.Assigne.(*ast.IdentExpr)
+	if !ok || expr.Operator.Type != lexer.EQUALS {
+		return
+	}
+	if value, ok := expr.AssignedValue.(*ast.IdentExpr); ok && value.Value == assigne.Value {
+		sa.Warn(expr.Pos, WarnSelfAssign, fmt.Sprintf("self-assignment of %s", assigne.Value))
+	}
+}
+
+// checkMemberValues reports member values of a struct literal that are assignments, possibly parenthesized,
+// e.g. `Point{ x: (y = 5), }`. A member value should be a pure expression, and an assignment in place of
+// one is almost certainly a mistake.
+func (sa *SemanticAnalyzer) checkMemberValues(expr *ast.StructLiteralExpr) {
+	for _, member := range expr.Members {
+		value := member.Value
+		for {
+			group, ok := value.(*ast.GroupExpr)
+			if !ok {
+				break
 			}
-		case *ast.ForStmt:
-			sa.checkUnreachableCode(s.Body)
+			value = group.Expr
+		}
+		switch value.(type) {
+		case *ast.AssignExpr, *ast.VarDeclAssignExpr:
+			sa.Err(value.Position(), fmt.Sprintf("assignment in the value of struct member %s", member.Name))
 		}
 	}
 }
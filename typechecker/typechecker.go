@@ -4,60 +4,147 @@ import (
 	"fmt"
 	"github.com/ruistola/cooper/ast"
 	"github.com/ruistola/cooper/lexer"
+	"math/big"
+	"slices"
+	"strings"
 )
 
 type TypeChecker struct {
-	Errors                []string
-	currScope             *Scope         // Current scope during traversal
-	scopes                map[any]*Scope // AST nodes to their scopes (from resolver)
+	Errors                []Diagnostic
+	currScope             *Scope                // Current scope during traversal
+	scopes                map[any]*Scope        // AST nodes to their scopes (from resolver)
+	types                 map[ast.TypeExpr]Type // AST type expressions to their types (from resolver)
 	primitives            map[string]Type
 	currentFuncReturnType Type
+	loops                 []*loopContext                    // The loops enclosing the current statement, the innermost last
+	narrowingCasts        map[*ast.CastExpr]Type            // Casts that may truncate the value, to the types cast from
+	outOfBounds           map[*ast.ArrayIndexExpr]ArrayType // Constant indices out of the bounds of fixed-size arrays
+	literalTypes          LiteralTypes                      // Types pinned to number literals, for the folder
+	callDefaults          CallDefaults                      // Default values of the arguments omitted from calls
 }
 
-func NewTypeChecker(rootScope *Scope, scopes map[any]*Scope) *TypeChecker {
+// CallDefaults are the arguments filled in for the parameters omitted from calls, from their default values,
+// e.g. `2` for the call `scale(1)` of `func scale(x: i32, factor: i32 = 2)`. Each call has literals of its own.
+type CallDefaults map[*ast.FuncCallExpr][]ast.Expr
+
+// loopContext collects the type of the values of the break statements of a loop.
+type loopContext struct {
+	isExpr    bool // Only a loop expression can be broken out of with a value
+	breakType Type // The type of the first break statement, or nil before one is found
+}
+
+func NewTypeChecker(rootScope *Scope, scopes map[any]*Scope, types map[ast.TypeExpr]Type, primitives map[string]Type) *TypeChecker {
 	return &TypeChecker{
-		Errors:    []string{},
-		currScope: rootScope,
-		scopes:    scopes,
-		primitives: map[string]Type{
-			"bool":   PrimitiveType{Name: "bool"},
-			"string": PrimitiveType{Name: "string"},
-			"i8":     PrimitiveType{Name: "i8"},
-			"i32":    PrimitiveType{Name: "i32"},
-			"i64":    PrimitiveType{Name: "i64"},
-			"f32":    PrimitiveType{Name: "f32"},
-			"f64":    PrimitiveType{Name: "f64"},
-		},
+		Errors:         []Diagnostic{},
+		currScope:      rootScope,
+		scopes:         scopes,
+		types:          types,
+		primitives:     primitives,
+		narrowingCasts: map[*ast.CastExpr]Type{},
+		outOfBounds:    map[*ast.ArrayIndexExpr]ArrayType{},
+		literalTypes:   LiteralTypes{},
+		callDefaults:   CallDefaults{},
 	}
 }
 
-func (tc *TypeChecker) Err(msg string) {
-	coloredMsg := fmt.Sprintf("\033[31mType Error: %s\033[0m", msg)
-	tc.Errors = append(tc.Errors, coloredMsg)
+func (tc *TypeChecker) Err(pos lexer.SrcPos, msg string) {
+	tc.Errors = append(tc.Errors, Diagnostic{
+		Severity: SeverityError,
+		Stage:    StageType,
+		Message:  msg,
+		Pos:      pos,
+	})
+}
+
+func Check(module *ast.BlockStmt) []Diagnostic {
+	return CheckWithConfig(module, Config{})
+}
+
+// Config adjusts the checks performed by CheckWithConfig.
+type Config struct {
+	// Primitive types augmenting the built-in ones, e.g. types defined by a host application embedding Cooper.
+	// The names of the built-in types can't be redefined.
+	Primitives map[string]Type
+	// The enabled warnings by name, or nil for all of them (see AvailableWarnings)
+	Warnings map[string]bool
+	// Report the enabled warnings as errors, e.g. for failing a build on any of them
+	WarningsAsErrors bool
+	// Loads the modules named in use declarations, or nil if the module can't use any
+	Modules ModuleLoader
+}
+
+// CheckWithConfig checks the module like Check, adjusted by the config.
+func CheckWithConfig(module *ast.BlockStmt, config Config) []Diagnostic {
+	_, diagnostics := CheckValue(module, config)
+	return diagnostics
 }
 
-func Check(module *ast.BlockStmt) []string {
+// CheckValue checks the module like CheckWithConfig, and returns the type of its last statement if it is
+// an expression, e.g. for showing the type of an expression entered in an interactive session. The type is
+// nil if the last statement is not an expression, or if the module has errors.
+func CheckValue(module *ast.BlockStmt, config Config) (Type, []Diagnostic) {
+	valueType, _, _, diagnostics := check(module, config)
+	return valueType, diagnostics
+}
+
+// CheckModule checks the module like CheckWithConfig, and returns the types its number literals take from
+// the context, for folding the constants of the module with FoldConstants once it has passed the checks,
+// and the default values of the arguments omitted from calls, for generating code.
+func CheckModule(module *ast.BlockStmt, config Config) (LiteralTypes, CallDefaults, []Diagnostic) {
+	_, literalTypes, callDefaults, diagnostics := check(module, config)
+	return literalTypes, callDefaults, diagnostics
+}
+
+func check(module *ast.BlockStmt, config Config) (Type, LiteralTypes, CallDefaults, []Diagnostic) {
+	primitives := defaultPrimitives()
+	for name, t := range config.Primitives {
+		if _, isBuiltin := primitives[name]; !isBuiltin {
+			primitives[name] = t
+		}
+	}
+	warnings := config.Warnings
+	if warnings == nil {
+		warnings = DefaultWarnings()
+	}
+
 	// First pass: Resolve symbols
-	resolved := Resolve(module)
+	resolved := Resolve(module, primitives, config.Modules)
 	allErrors := resolved.Errors
 
 	// Second pass: Type checking
+	var valueType Type
+	var literalTypes LiteralTypes
+	var callDefaults CallDefaults
 	if len(resolved.Errors) == 0 {
-		tc := NewTypeChecker(resolved.RootScope, resolved.Scopes)
+		tc := NewTypeChecker(resolved.RootScope, resolved.Scopes, resolved.Types, primitives)
+		literalTypes = tc.literalTypes
+		callDefaults = tc.callDefaults
 		// Process module statements directly in root scope
-		for _, stmt := range module.Statements {
+		for i, stmt := range module.Statements {
+			if exprStmt, ok := stmt.(*ast.ExpressionStmt); ok && i == len(module.Statements)-1 {
+				valueType = tc.CheckExpr(exprStmt.Expr)
+				continue
+			}
 			tc.CheckStmt(stmt)
 		}
 		allErrors = append(allErrors, tc.Errors...)
 
 		// Third pass: Semantic analysis (only if type checking passed)
 		if len(tc.Errors) == 0 {
-			semanticErrors := AnalyzeSemantics(module, resolved.RootScope)
+			semanticErrors := AnalyzeSemantics(module, resolved.Scopes, tc.narrowingCasts, tc.outOfBounds, warnings)
 			allErrors = append(allErrors, semanticErrors...)
 		}
 	}
 
-	return allErrors
+	if config.WarningsAsErrors {
+		for i := range allErrors {
+			allErrors[i].Severity = SeverityError
+		}
+	}
+	if HasErrors(allErrors) {
+		return nil, literalTypes, callDefaults, allErrors
+	}
+	return valueType, literalTypes, callDefaults, allErrors
 }
 
 func (tc *TypeChecker) CheckStmt(stmt ast.Stmt) {
@@ -66,8 +153,14 @@ func (tc *TypeChecker) CheckStmt(stmt ast.Stmt) {
 		tc.CheckBlockStmt(s)
 	case *ast.VarDeclStmt:
 		tc.CheckVarDeclStmt(s)
+	case *ast.DestructuringVarDeclStmt:
+		tc.CheckDestructuringVarDeclStmt(s)
 	case *ast.StructDeclStmt:
 		tc.CheckStructDeclStmt(s)
+	case *ast.EnumDeclStmt, *ast.UseDeclStmt:
+		// Fully checked by the resolver
+	case *ast.InterfaceDeclStmt:
+		tc.CheckInterfaceDeclStmt(s)
 	case *ast.FuncDeclStmt:
 		tc.CheckFuncDeclStmt(s)
 	case *ast.IfStmt:
@@ -76,66 +169,150 @@ func (tc *TypeChecker) CheckStmt(stmt ast.Stmt) {
 		tc.CheckForStmt(s)
 	case *ast.ReturnStmt:
 		tc.CheckReturnStmt(s)
+	case *ast.BreakStmt:
+		tc.CheckBreakStmt(s)
 	case *ast.ExpressionStmt:
 		tc.CheckExpr(s.Expr)
 	default:
-		tc.Err(fmt.Sprintf("unknown statement type: %T", stmt))
+		tc.Err(stmt.Position(), fmt.Sprintf("unknown statement type: %T", stmt))
 	}
 }
 
 func (tc *TypeChecker) CheckBlockStmt(block *ast.BlockStmt) {
+	blockScope, ok := tc.scopes[block]
+	if !ok {
+		tc.Err(block.Pos, "block scope not found in scope map")
+		return
+	}
 	oldTable := tc.currScope
-	tc.currScope = NewScope(oldTable)
+	tc.currScope = blockScope
 	for _, stmt := range block.Statements {
 		tc.CheckStmt(stmt)
 	}
 	tc.currScope = oldTable
 }
 
+// CheckBlockExpr checks the statements of a block expression in the scope of the block, and returns the
+// type of its result expression (unit if the block ends in a semicolon or a statement)
+func (tc *TypeChecker) CheckBlockExpr(block *ast.BlockExpr) Type {
+	blockScope, ok := tc.scopes[block]
+	if !ok {
+		tc.Err(block.Pos, "block scope not found in scope map")
+		return nil
+	}
+	oldTable := tc.currScope
+	tc.currScope = blockScope
+	defer func() { tc.currScope = oldTable }()
+	for _, stmt := range block.Statements {
+		tc.CheckStmt(stmt)
+	}
+	return tc.CheckExpr(block.ResultExpr)
+}
+
+// checkUnitValue reports the use of an expression of the unit type, like a call of a function with no return
+// value, where a value of the expected type is needed. Returns false if it was reported.
+func (tc *TypeChecker) checkUnitValue(expr ast.Expr, exprType Type, expectedType Type) bool {
+	if !IsUnit(exprType) || IsUnit(expectedType) {
+		return true
+	}
+	tc.Err(expr.Position(), fmt.Sprintf("cannot use value of type () as %s", expectedType))
+	return false
+}
+
 func (tc *TypeChecker) CheckVarDeclStmt(stmt *ast.VarDeclStmt) {
 	declaredType, ok := tc.currScope.LookupVarType(stmt.Var.Name)
 	if !ok {
-		tc.Err(fmt.Sprintf("unknown variable: %s", stmt.Var.Name))
+		tc.Err(stmt.Var.Pos, fmt.Sprintf("unknown variable: %s", stmt.Var.Name))
 		return
 	}
 	if stmt.InitVal != nil {
+		if tc.pinNumberLiteral(stmt.InitVal, declaredType) {
+			return
+		}
 		initType := tc.CheckExpr(stmt.InitVal)
-		if initType == nil {
+		if initType == nil || !tc.checkUnitValue(stmt.InitVal, initType, declaredType) {
 			return
 		}
-		if !declaredType.Equals(initType) {
-			tc.Err(fmt.Sprintf("type mismatch: variable %s declared as %s but initialized with %s", stmt.Var.Name, declaredType, initType))
+		if !IsAssignable(initType, declaredType) {
+			tc.Err(stmt.Pos, fmt.Sprintf("type mismatch: variable %s declared as %s but initialized with %s", stmt.Var.Name, declaredType, initType))
+		}
+	}
+}
+
+func (tc *TypeChecker) CheckDestructuringVarDeclStmt(stmt *ast.DestructuringVarDeclStmt) {
+	initType := tc.CheckExpr(stmt.InitVal)
+	if initType == nil {
+		return
+	}
+	tupleType, ok := initType.(TupleType)
+	if !ok {
+		tc.Err(stmt.InitVal.Position(), fmt.Sprintf("cannot destructure non-tuple type %s", initType))
+		return
+	}
+	if len(tupleType.ElemTypes) != len(stmt.Vars) {
+		tc.Err(stmt.Pos, fmt.Sprintf("cannot destructure %s into %d variables", tupleType, len(stmt.Vars)))
+		return
+	}
+	for i, variable := range stmt.Vars {
+		declaredType, ok := tc.currScope.LookupVarType(variable.Name)
+		if !ok {
+			continue
+		}
+		if elemType := tupleType.ElemTypes[i]; !IsAssignable(elemType, declaredType) {
+			tc.Err(variable.Pos, fmt.Sprintf("type mismatch: variable %s declared as %s but destructured from %s", variable.Name, declaredType, elemType))
 		}
 	}
 }
 
 func (tc *TypeChecker) CheckStructDeclStmt(stmt *ast.StructDeclStmt) {
 	if _, ok := tc.currScope.LookupStructType(stmt.Name); !ok {
-		tc.Err(fmt.Sprintf("unknown struct: %s", stmt.Name))
+		tc.Err(stmt.Pos, fmt.Sprintf("unknown struct: %s", stmt.Name))
 		return
 	}
+	// A struct without members is allowed, e.g. as a marker type, and all of its values are equal
 	// Additional type checking for struct members can be added here if needed
 }
 
-func (tc *TypeChecker) CheckFuncDeclStmt(stmt *ast.FuncDeclStmt) {
-	funcType, ok := tc.currScope.LookupFunc(stmt.Name)
-	if !ok {
-		tc.Err(fmt.Sprintf("unknown function: %s", stmt.Name))
-		return
+func (tc *TypeChecker) CheckInterfaceDeclStmt(stmt *ast.InterfaceDeclStmt) {
+	if _, ok := tc.currScope.LookupInterfaceType(stmt.Name); !ok {
+		tc.Err(stmt.Pos, fmt.Sprintf("unknown interface: %s", stmt.Name))
 	}
+}
 
+func (tc *TypeChecker) CheckFuncDeclStmt(stmt *ast.FuncDeclStmt) {
 	// Get the function scope from the resolver's scope map using statement pointer
 	funcScope, ok := tc.scopes[stmt]
 	if !ok {
-		tc.Err(fmt.Sprintf("function %s scope not found in scope map", stmt.Name))
+		tc.Err(stmt.Pos, fmt.Sprintf("function %s scope not found in scope map", stmt.Name))
+		return
+	}
+	funcType, ok := declaredFuncType(stmt, funcScope)
+	if !ok {
+		tc.Err(stmt.Pos, fmt.Sprintf("unknown function: %s", stmt.Name))
 		return
 	}
 
+	for i, param := range stmt.Parameters {
+		if param.Default == nil {
+			continue
+		}
+		if tc.pinNumberLiteral(param.Default, funcType.ParamTypes[i]) {
+			continue
+		}
+		defaultType := tc.CheckExpr(param.Default)
+		if defaultType != nil && !IsAssignable(defaultType, funcType.ParamTypes[i]) {
+			tc.Err(param.Default.Position(), fmt.Sprintf("type mismatch: parameter %s declared as %s but defaults to %s", param.Name, funcType.ParamTypes[i], defaultType))
+		}
+	}
+
 	// Set up function context
 	oldReturnType := tc.currentFuncReturnType
 	tc.currentFuncReturnType = funcType.ReturnType
 	oldTable := tc.currScope
 	tc.currScope = funcScope
+	// A break statement can't break out of a loop enclosing the function declaration
+	oldLoops := tc.loops
+	tc.loops = nil
 
 	// Type check function body statements directly in function scope
 	for _, bodyStmt := range stmt.Body.Statements {
@@ -145,12 +322,28 @@ func (tc *TypeChecker) CheckFuncDeclStmt(stmt *ast.FuncDeclStmt) {
 	// Restore previous context
 	tc.currentFuncReturnType = oldReturnType
 	tc.currScope = oldTable
+	tc.loops = oldLoops
+}
+
+// declaredFuncType looks up the type of a declared function, given the scope of its parameters. The type of
+// a method is found in the struct type of its receiver, which is defined in the same scope as the parameters.
+func declaredFuncType(stmt *ast.FuncDeclStmt, funcScope *Scope) (FuncType, bool) {
+	if stmt.Receiver == nil {
+		return funcScope.parent.LookupFunc(stmt.Name)
+	}
+	receiverType, _ := funcScope.LookupVarType(stmt.Receiver.Name)
+	structType, ok := receiverType.(StructType)
+	if !ok {
+		return FuncType{}, false
+	}
+	methodType, ok := structType.Methods[stmt.Name]
+	return methodType, ok
 }
 
 func (tc *TypeChecker) CheckIfStmt(stmt *ast.IfStmt) {
 	condType := tc.CheckExpr(stmt.Cond)
 	if !IsPrimitive(condType, "bool") {
-		tc.Err("if- statement condition does not evaluate to a boolean type")
+		tc.Err(stmt.Cond.Position(), "if- statement condition does not evaluate to a boolean type")
 	}
 	tc.CheckStmt(stmt.Then)
 	if stmt.Else != nil {
@@ -158,25 +351,167 @@ func (tc *TypeChecker) CheckIfStmt(stmt *ast.IfStmt) {
 	}
 }
 
+// CheckIfExpr checks that both branches of an if- expression evaluate to the same type, the type of the expression
+func (tc *TypeChecker) CheckIfExpr(expr *ast.IfExpr) Type {
+	condType := tc.CheckExpr(expr.Cond)
+	if condType != nil && !IsPrimitive(condType, "bool") {
+		tc.Err(expr.Cond.Position(), "if- expression condition does not evaluate to a boolean type")
+	}
+	thenType := tc.CheckExpr(expr.Then)
+	elseType := tc.CheckExpr(expr.Else)
+	if thenType == nil || elseType == nil {
+		return nil
+	}
+	if !thenType.Equals(elseType) {
+		tc.Err(expr.Else.Position(), fmt.Sprintf("if- expression branches have different types: %s and %s", thenType, elseType))
+		return nil
+	}
+	return thenType
+}
+
+// CheckForStmt checks a for statement in the scope of its header, where the init statement may declare
+// the loop variable.
 func (tc *TypeChecker) CheckForStmt(stmt *ast.ForStmt) {
+	headerScope, ok := tc.scopes[stmt]
+	if !ok {
+		tc.Err(stmt.Pos, "for- statement scope not found in scope map")
+		return
+	}
+	oldTable := tc.currScope
+	tc.currScope = headerScope
+	defer func() { tc.currScope = oldTable }()
 	tc.CheckStmt(stmt.Init)
 	condType := tc.CheckExpr(stmt.Cond)
 	if !IsPrimitive(condType, "bool") {
-		tc.Err("for- statement condition does not evaluate to a boolean type")
+		tc.Err(stmt.Cond.Position(), "for- statement condition does not evaluate to a boolean type")
 	}
 	tc.CheckStmt(stmt.Iter)
+	tc.loops = append(tc.loops, &loopContext{isExpr: false})
 	tc.CheckStmt(stmt.Body)
+	tc.loops = tc.loops[:len(tc.loops)-1]
+}
+
+// CheckLoopExpr checks the body of a loop expression. All the break statements of the loop must agree on
+// the type of their value, which becomes the type of the loop expression. A loop without any break
+// statements doesn't end, but is considered to be of the unit type all the same.
+func (tc *TypeChecker) CheckLoopExpr(expr *ast.LoopExpr) Type {
+	loop := &loopContext{isExpr: true}
+	tc.loops = append(tc.loops, loop)
+	tc.CheckStmt(expr.Body)
+	tc.loops = tc.loops[:len(tc.loops)-1]
+	if loop.breakType == nil {
+		return UnitType{}
+	}
+	return loop.breakType
+}
+
+// CheckMatchExpr checks that the patterns of a match expression match the type of its subject, and that all
+// the arms evaluate to the same type, the type of the expression. The match must also be exhaustive: a bool
+// subject is covered by arms for both true and false, any other subject only by a wildcard or a binding.
+func (tc *TypeChecker) CheckMatchExpr(expr *ast.MatchExpr) Type {
+	subjectType := tc.CheckExpr(expr.Subject)
+	var matchType Type
+	exhaustive := false
+	boolsCovered := map[bool]bool{}
+	for _, arm := range expr.Arms {
+		armScope, ok := tc.scopes[arm]
+		if !ok {
+			tc.Err(arm.Pos, "match arm scope not found in scope map")
+			return nil
+		}
+		switch pattern := arm.Pattern.(type) {
+		case *ast.WildcardExpr:
+			exhaustive = true
+		case *ast.IdentExpr:
+			armScope.DefineVar(pattern.Value, subjectType)
+			exhaustive = true
+		default:
+			tc.checkMatchPattern(pattern, subjectType)
+			if literal, ok := pattern.(*ast.BoolLiteralExpr); ok {
+				boolsCovered[literal.Value] = true
+			}
+		}
+		oldTable := tc.currScope
+		tc.currScope = armScope
+		bodyType := tc.CheckExpr(arm.Body)
+		tc.currScope = oldTable
+		if bodyType == nil {
+			continue
+		}
+		if matchType == nil {
+			matchType = bodyType
+		} else if !matchType.Equals(bodyType) {
+			tc.Err(arm.Body.Position(), fmt.Sprintf("match arm type mismatch: expected %s, found %s", matchType, bodyType))
+		}
+	}
+	if subjectType == nil || exhaustive {
+		return matchType
+	}
+	if IsPrimitive(subjectType, "bool") {
+		for _, value := range []bool{true, false} {
+			if !boolsCovered[value] {
+				tc.Err(expr.Pos, fmt.Sprintf("non-exhaustive match over bool, missing %t", value))
+			}
+		}
+		return matchType
+	}
+	tc.Err(expr.Pos, fmt.Sprintf("non-exhaustive match over %s, missing a _ arm", subjectType))
+	return matchType
+}
+
+// checkMatchPattern checks that a literal pattern can match a subject of the given type. Like a number literal
+// assigned to a variable, a number pattern takes the type of the subject.
+func (tc *TypeChecker) checkMatchPattern(pattern ast.Expr, subjectType Type) {
+	if subjectType == nil {
+		return
+	}
+	if literal := untypedNumberLiteral(pattern); literal != nil {
+		if !numberLiteralFits(literal, subjectType) {
+			tc.Err(pattern.Position(), fmt.Sprintf("pattern type mismatch: expected %s, found the number literal %s", subjectType, literal.Value))
+			return
+		}
+		tc.literalTypes[literal] = subjectType
+		tc.checkLiteralRange(pattern, subjectType)
+		return
+	}
+	patternType := tc.CheckExpr(pattern)
+	if patternType != nil && !patternType.Equals(subjectType) {
+		tc.Err(pattern.Position(), fmt.Sprintf("pattern type mismatch: expected %s, found %s", subjectType, patternType))
+	}
+}
+
+func (tc *TypeChecker) CheckBreakStmt(stmt *ast.BreakStmt) {
+	if len(tc.loops) == 0 {
+		tc.Err(stmt.Pos, "break statement outside of a loop")
+		return
+	}
+	loop := tc.loops[len(tc.loops)-1]
+	var valueType Type = UnitType{}
+	if stmt.Value != nil {
+		if !loop.isExpr {
+			tc.Err(stmt.Value.Position(), "cannot break with a value out of a for- loop")
+			return
+		}
+		if valueType = tc.CheckExpr(stmt.Value); valueType == nil {
+			return
+		}
+	}
+	if loop.breakType == nil {
+		loop.breakType = valueType
+	} else if !valueType.Equals(loop.breakType) {
+		tc.Err(stmt.Pos, fmt.Sprintf("break value type mismatch: expected %s, found %s", loop.breakType, valueType))
+	}
 }
 
 func (tc *TypeChecker) CheckReturnStmt(stmt *ast.ReturnStmt) {
 	if tc.currentFuncReturnType == nil {
-		tc.Err("return statement outside of function")
+		tc.Err(stmt.Pos, "return statement outside of function")
 		return
 	}
 	isUnitReturn := IsUnit(tc.currentFuncReturnType)
 	if stmt.Expr == nil {
 		if !isUnitReturn {
-			tc.Err(fmt.Sprintf("expected function to return %s", tc.currentFuncReturnType))
+			tc.Err(stmt.Pos, fmt.Sprintf("expected function to return %s", tc.currentFuncReturnType))
 		}
 		return
 	}
@@ -185,31 +520,44 @@ func (tc *TypeChecker) CheckReturnStmt(stmt *ast.ReturnStmt) {
 	case exprType == nil:
 		return
 	case isUnitReturn:
-		tc.Err("cannot return a value from a function with no declared return type")
-	case !exprType.Equals(tc.currentFuncReturnType):
-		tc.Err(fmt.Sprintf("return type mismatch: expected %s, found %s", tc.currentFuncReturnType, exprType))
+		tc.Err(stmt.Expr.Position(), "cannot return a value from a function with no declared return type")
+	case !tc.checkUnitValue(stmt.Expr, exprType, tc.currentFuncReturnType):
+	case !IsAssignable(exprType, tc.currentFuncReturnType):
+		tc.Err(stmt.Expr.Position(), fmt.Sprintf("return type mismatch: expected %s, found %s", tc.currentFuncReturnType, exprType))
 	}
 }
 
 func (tc *TypeChecker) CheckExpr(expr ast.Expr) Type {
 	switch e := expr.(type) {
 	case *ast.NumberLiteralExpr:
+		if literalType, ok := tc.literalTypes[e]; ok {
+			return literalType
+		}
 		return tc.primitives["i32"] // todo; evaluate the number literal to determine exact type
 	case *ast.StringLiteralExpr:
 		return tc.primitives["string"]
 	case *ast.BoolLiteralExpr:
 		return tc.primitives["bool"]
 	case *ast.IdentExpr:
-		if varType, ok := tc.currScope.LookupVarType(e.Value); ok {
-			return varType
+		kind, scope, ok := tc.currScope.lookupValue(e.Value)
+		// A variable declared with := has no type until its declaration is checked. Up to that point the
+		// name refers to a declaration of an enclosing scope, e.g. in the value of `x := x + 1`.
+		for ok && kind == SymbolVar && scope.vars[e.Value] == nil {
+			kind, scope, ok = scope.parent.lookupValue(e.Value)
 		}
-		if structType, ok := tc.currScope.LookupStructType(e.Value); ok {
-			return structType
+		if !ok {
+			// The value of the declaration failed to check, which has been reported
+			return nil
 		}
-		if funcType, ok := tc.currScope.LookupFunc(e.Value); ok {
-			return funcType
+		switch kind {
+		case SymbolVar:
+			return scope.vars[e.Value]
+		case SymbolFunc:
+			return scope.funcs[e.Value]
+		case SymbolStruct:
+			return scope.structTypes[e.Value]
 		}
-		tc.Err(fmt.Sprintf("undefined variable: %s", e.Value))
+		tc.Err(e.Pos, fmt.Sprintf("undefined variable: %s", e.Value))
 		return nil
 	case *ast.BinaryExpr:
 		return tc.CheckBinaryExpr(e)
@@ -217,6 +565,34 @@ func (tc *TypeChecker) CheckExpr(expr ast.Expr) Type {
 		return tc.CheckUnaryExpr(e)
 	case *ast.GroupExpr:
 		return tc.CheckExpr(e.Expr)
+	case *ast.UnitExpr:
+		return UnitType{}
+	case *ast.MemberAssignExpr:
+		// The members of a struct literal are checked against the struct by CheckStructLiteralExpr, which
+		// leaves only the value to check here
+		return tc.CheckExpr(e.Value)
+	case *ast.BlockExpr:
+		return tc.CheckBlockExpr(e)
+	case *ast.AscriptionExpr:
+		return tc.CheckAscriptionExpr(e)
+	case *ast.CastExpr:
+		return tc.CheckCastExpr(e)
+	case *ast.TupleExpr:
+		elemTypes := []Type{}
+		for _, elem := range e.Elems {
+			elemType := tc.CheckExpr(elem)
+			if elemType == nil {
+				return nil
+			}
+			elemTypes = append(elemTypes, elemType)
+		}
+		return TupleType{ElemTypes: elemTypes}
+	case *ast.IfExpr:
+		return tc.CheckIfExpr(e)
+	case *ast.LoopExpr:
+		return tc.CheckLoopExpr(e)
+	case *ast.MatchExpr:
+		return tc.CheckMatchExpr(e)
 	case *ast.FuncCallExpr:
 		return tc.CheckFuncCallExpr(e)
 	case *ast.StructLiteralExpr:
@@ -230,7 +606,7 @@ func (tc *TypeChecker) CheckExpr(expr ast.Expr) Type {
 	case *ast.VarDeclAssignExpr:
 		return tc.CheckVarDeclAssignExpr(e)
 	default:
-		tc.Err(fmt.Sprintf("unknown expression type: %T", expr))
+		tc.Err(expr.Position(), fmt.Sprintf("unknown expression type: %T", expr))
 		return nil
 	}
 }
@@ -241,19 +617,40 @@ func (tc *TypeChecker) CheckBinaryExpr(expr *ast.BinaryExpr) Type {
 	if leftType == nil || rightType == nil {
 		return nil
 	}
+	// `a < b < c` would compare the bool of `a < b` to c, which is never what is meant, so an operand of
+	// a comparison that is itself a comparison must be parenthesized, e.g. `(a == b) == c`
+	if isComparison(expr.Operator.Type) && (isComparisonExpr(expr.Lhs) || isComparisonExpr(expr.Rhs)) {
+		tc.Err(expr.Operator.SrcPos, "comparison operators cannot be chained")
+		return nil
+	}
 	switch expr.Operator.Type {
-	case lexer.PLUS, lexer.DASH, lexer.STAR, lexer.SLASH, lexer.PERCENT:
+	case lexer.PLUS, lexer.DASH, lexer.STAR, lexer.SLASH, lexer.PERCENT, lexer.CHEVRON:
+		// Integer division traps on a zero divisor, while floating point division results in an infinity
+		isDivision := expr.Operator.Type == lexer.SLASH || expr.Operator.Type == lexer.PERCENT
+		if isDivision && IsInteger(leftType) && isConstantZero(expr.Rhs) {
+			tc.Err(expr.Rhs.Position(), "division by zero")
+			return nil
+		}
+		// The remainder is only defined for integers
+		if expr.Operator.Type == lexer.PERCENT && IsNumeric(leftType) && IsNumeric(rightType) && !(IsInteger(leftType) && IsInteger(rightType)) {
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("operator %% requires integer operands, found %s and %s", leftType, rightType))
+			return nil
+		}
 		if IsNumeric(leftType) && IsNumeric(rightType) {
 			return leftType // no specific reason, just pick one arbitrarily until we have e.g. type promotion (i32 -> f32 etc.)
 		}
 		if expr.Operator.Type == lexer.PLUS && IsPrimitive(leftType, "string") && IsPrimitive(rightType, "string") {
 			return tc.primitives["string"]
 		}
-		tc.Err(fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, leftType, rightType))
+		tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, leftType, rightType))
 		return nil
 	case lexer.DOUBLE_EQUALS, lexer.NOT_EQUALS:
 		if !leftType.Equals(rightType) {
-			tc.Err(fmt.Sprintf("cannot compare %s and %s", leftType, rightType))
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("cannot compare %s and %s", leftType, rightType))
+			return nil
+		}
+		if !isComparable(leftType) {
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("cannot compare values of type %s", leftType))
 			return nil
 		}
 		return tc.primitives["bool"]
@@ -261,20 +658,39 @@ func (tc *TypeChecker) CheckBinaryExpr(expr *ast.BinaryExpr) Type {
 		if IsNumeric(leftType) && IsNumeric(rightType) {
 			return tc.primitives["bool"]
 		}
-		tc.Err(fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, leftType, rightType))
+		// Strings are ordered lexicographically, by their bytes
+		if IsPrimitive(leftType, "string") && IsPrimitive(rightType, "string") {
+			return tc.primitives["bool"]
+		}
+		tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, leftType, rightType))
 		return nil
 	case lexer.OR, lexer.AND:
 		if IsPrimitive(leftType, "bool") && IsPrimitive(rightType, "bool") {
 			return tc.primitives["bool"]
 		}
-		tc.Err(fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, leftType, rightType))
+		tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, leftType, rightType))
 		return nil
 	default:
-		tc.Err(fmt.Sprintf("unsupported binary operator: %s", expr.Operator.Value))
+		tc.Err(expr.Operator.SrcPos, fmt.Sprintf("unsupported binary operator: %s", expr.Operator.Value))
 		return nil
 	}
 }
 
+// isComparison reports whether an operator compares its operands, resulting in a bool
+func isComparison(operator lexer.TokenType) bool {
+	switch operator {
+	case lexer.DOUBLE_EQUALS, lexer.NOT_EQUALS, lexer.LESS, lexer.LESS_EQUALS, lexer.GREATER, lexer.GREATER_EQUALS:
+		return true
+	}
+	return false
+}
+
+// isComparisonExpr reports whether an expression is a comparison that is not parenthesized
+func isComparisonExpr(expr ast.Expr) bool {
+	binary, ok := expr.(*ast.BinaryExpr)
+	return ok && isComparison(binary.Operator.Type)
+}
+
 func (tc *TypeChecker) CheckUnaryExpr(expr *ast.UnaryExpr) Type {
 	operandType := tc.CheckExpr(expr.Rhs)
 	if operandType == nil {
@@ -285,20 +701,135 @@ func (tc *TypeChecker) CheckUnaryExpr(expr *ast.UnaryExpr) Type {
 		if IsNumeric(operandType) {
 			return operandType
 		}
-		tc.Err(fmt.Sprintf("invalid operand for %s: %s", expr.Operator.Value, operandType))
+		tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operand for %s: %s", expr.Operator.Value, operandType))
 		return nil
 	case lexer.NOT:
 		if IsPrimitive(operandType, "bool") {
 			return tc.primitives["bool"]
 		}
-		tc.Err(fmt.Sprintf("invalid operand for %s: %s", expr.Operator.Value, operandType))
+		tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operand for %s: %s", expr.Operator.Value, operandType))
 		return nil
 	default:
-		tc.Err(fmt.Sprintf("unsupported unary operator: %s", expr.Operator.Value))
+		tc.Err(expr.Operator.SrcPos, fmt.Sprintf("unsupported unary operator: %s", expr.Operator.Value))
 		return nil
 	}
 }
 
+func (tc *TypeChecker) CheckAscriptionExpr(expr *ast.AscriptionExpr) Type {
+	ascribedType, ok := tc.types[expr.Type]
+	if !ok {
+		tc.Err(expr.Type.Position(), "ascribed type not found in type map")
+		return nil
+	}
+	if literal := untypedNumberLiteral(expr.Expr); literal != nil {
+		if !numberLiteralFits(literal, ascribedType) {
+			tc.Err(expr.Pos, fmt.Sprintf("cannot ascribe %s to the number literal %s", ascribedType, literal.Value))
+			return nil
+		}
+		if !tc.checkLiteralRange(expr.Expr, ascribedType) {
+			return nil
+		}
+		return ascribedType
+	}
+	exprType := tc.CheckExpr(expr.Expr)
+	if exprType == nil {
+		return nil
+	}
+	if !IsAssignable(exprType, ascribedType) {
+		tc.Err(expr.Pos, fmt.Sprintf("cannot ascribe %s to an expression of type %s", ascribedType, exprType))
+		return nil
+	}
+	return ascribedType
+}
+
+// CheckCastExpr checks a conversion between numeric types, recording a narrowing one for the semantic analyzer
+// to warn about. Any other cast is an error, unless the value is of the target type already.
+func (tc *TypeChecker) CheckCastExpr(expr *ast.CastExpr) Type {
+	targetType, ok := tc.types[expr.TargetType]
+	if !ok {
+		tc.Err(expr.TargetType.Position(), "cast target type not found in type map")
+		return nil
+	}
+	exprType := tc.CheckExpr(expr.Expr)
+	if exprType == nil {
+		return nil
+	}
+	if exprType.Equals(targetType) {
+		return targetType
+	}
+	if !IsNumeric(exprType) || !IsNumeric(targetType) {
+		tc.Err(expr.Pos, fmt.Sprintf("cannot cast %s to %s", exprType, targetType))
+		return nil
+	}
+	if isNarrowing(exprType, targetType) {
+		tc.narrowingCasts[expr] = exprType
+	}
+	return targetType
+}
+
+// isConstantZero reports whether the expression is a constant integer with the value zero, e.g. `0x0` or `(2 - 2)`.
+func isConstantZero(expr ast.Expr) bool {
+	value, err := evalConst(expr, "i32")
+	return err == nil && value.integer != nil && value.integer.Sign() == 0
+}
+
+// untypedNumberLiteral returns the number literal of an expression consisting of only a number literal,
+// possibly signed or parenthesized, or nil for any other expression. The type of such a literal can be
+// pinned by ascribing it one.
+func untypedNumberLiteral(expr ast.Expr) *ast.NumberLiteralExpr {
+	switch e := expr.(type) {
+	case *ast.NumberLiteralExpr:
+		return e
+	case *ast.GroupExpr:
+		return untypedNumberLiteral(e.Expr)
+	case *ast.UnaryExpr:
+		return untypedNumberLiteral(e.Rhs)
+	default:
+		return nil
+	}
+}
+
+// pinNumberLiteral gives a value consisting of only a number literal the numeric type it is assigned to,
+// e.g. in `let x: i8 = -5`, instead of the default i32, recording the type of the literal for the folder.
+// Reports an integer literal out of the range of the type. Returns false if the value is not such a literal,
+// to be checked as usual.
+func (tc *TypeChecker) pinNumberLiteral(value ast.Expr, t Type) bool {
+	literal := untypedNumberLiteral(value)
+	if literal == nil || !IsNumeric(t) || !numberLiteralFits(literal, t) {
+		return false
+	}
+	tc.literalTypes[literal] = t
+	// e.g. `!5`
+	if tc.CheckExpr(value) != nil {
+		tc.checkLiteralRange(value, t)
+	}
+	return true
+}
+
+// checkLiteralRange reports an integer literal out of the range of the type it takes. A negated literal
+// like `-128` is evaluated as a whole, so that the smallest value of a signed type is in its range even
+// though the literal without the sign is not. Returns false if the literal is out of range.
+func (tc *TypeChecker) checkLiteralRange(value ast.Expr, t Type) bool {
+	if _, isInteger := integerRanges[t.String()]; !isInteger {
+		return true
+	}
+	if _, err := evalConst(value, t.String()); err != nil {
+		tc.Err(value.Position(), err.Error())
+		return false
+	}
+	return true
+}
+
+// numberLiteralFits reports whether a number literal can take the given type: an integer literal fits
+// any numeric type, but a literal with a fraction or an exponent only the floating point types.
+func numberLiteralFits(literal *ast.NumberLiteralExpr, t Type) bool {
+	isHex := strings.HasPrefix(literal.Value, "0x") || strings.HasPrefix(literal.Value, "0X")
+	if !isHex && strings.ContainsAny(literal.Value, ".eE") {
+		return IsPrimitive(t, "f32") || IsPrimitive(t, "f64")
+	}
+	return IsNumeric(t)
+}
+
 func (tc *TypeChecker) CheckFuncCallExpr(expr *ast.FuncCallExpr) Type {
 	funcType := tc.CheckExpr(expr.Func)
 	if funcType == nil {
@@ -306,32 +837,92 @@ func (tc *TypeChecker) CheckFuncCallExpr(expr *ast.FuncCallExpr) Type {
 	}
 	ft, ok := funcType.(FuncType)
 	if !ok {
-		tc.Err(fmt.Sprintf("cannot call non-function value of type %s", funcType))
+		what, pos := describeCallee(expr.Func)
+		tc.Err(pos, fmt.Sprintf("cannot call %s of non-function type %s", what, funcType))
+		// The arguments are checked regardless, for any errors of their own
+		for _, arg := range expr.Args {
+			tc.CheckExpr(arg)
+		}
+		return nil
+	}
+	// The parameters before a variadic one take an argument each, and the variadic one any number of them
+	fixed := len(ft.ParamTypes)
+	if ft.Variadic {
+		fixed--
+	}
+	// Point at the first extra argument, or at where the first missing one is expected
+	if len(expr.Args) > fixed && !ft.Variadic {
+		tc.Err(expr.Args[fixed].Position(), fmt.Sprintf("too many arguments, expected %d, found %d", fixed, len(expr.Args)))
 		return nil
 	}
-	if len(expr.Args) != len(ft.ParamTypes) {
-		tc.Err(fmt.Sprintf("wrong number of arguments, expected %d, found %d", len(ft.ParamTypes), len(expr.Args)))
+	required := fixed - len(ft.Defaults)
+	if len(expr.Args) < required {
+		if len(ft.Defaults) > 0 || ft.Variadic {
+			tc.Err(expr.CloseParen, fmt.Sprintf("not enough arguments, expected at least %d, found %d", required, len(expr.Args)))
+		} else {
+			tc.Err(expr.CloseParen, fmt.Sprintf("not enough arguments, expected %d, found %d", required, len(expr.Args)))
+		}
 		return nil
 	}
+	// Each argument is checked even if an earlier one is invalid, e.g. refers to a misspelled member
+	valid := true
 	for i, arg := range expr.Args {
 		argType := tc.CheckExpr(arg)
 		if argType == nil {
-			return nil
+			valid = false
+			continue
 		}
-		if !ft.ParamTypes[i].Equals(argType) {
-			tc.Err(fmt.Sprintf("argument %d type mismatch: expected %s, found %s", i+1, ft.ParamTypes[i], argType))
-			return nil
+		paramType := ft.ParamTypes[min(i, len(ft.ParamTypes)-1)]
+		if i >= fixed {
+			paramType = paramType.(ArrayType).ElemType
+		}
+		if !tc.checkUnitValue(arg, argType, paramType) {
+			valid = false
+			continue
+		}
+		if !IsAssignable(argType, paramType) {
+			tc.Err(arg.Position(), fmt.Sprintf("argument %d type mismatch: expected %s, found %s", i+1, paramType, argType))
+			valid = false
 		}
 	}
+	if !valid {
+		return nil
+	}
+	// The omitted arguments take their default values, which were checked with the declaration
+	for _, value := range ft.Defaults[min(len(expr.Args)-required, len(ft.Defaults)):] {
+		tc.callDefaults[expr] = append(tc.callDefaults[expr], value.literal(expr.CloseParen))
+	}
 	return ft.ReturnType
 }
 
+// describeCallee describes the expression called as a function for an error message, and returns the
+// position of the part of it that was meant to be a function, e.g. of the member name in `p.x()`.
+func describeCallee(callee ast.Expr) (string, lexer.SrcPos) {
+	switch e := ungroup(callee).(type) {
+	case *ast.IdentExpr:
+		return "variable " + e.Value, e.Pos
+	case *ast.StructMemberExpr:
+		return "member " + e.Member.Value, e.Member.Pos
+	case *ast.ArrayIndexExpr:
+		return "an array element", callee.Position()
+	case *ast.NumberLiteralExpr:
+		return "the number literal " + e.Value, e.Pos
+	case *ast.StringLiteralExpr:
+		return "the string literal " + e.Value, e.Pos
+	case *ast.BoolLiteralExpr:
+		return fmt.Sprintf("the literal %t", e.Value), e.Pos
+	case *ast.FuncCallExpr:
+		return "the result of a call", callee.Position()
+	}
+	return "a value", callee.Position()
+}
+
 func (tc *TypeChecker) CheckStructLiteralExpr(expr *ast.StructLiteralExpr) Type {
 	var structType StructType
 	structTypeValue := tc.CheckExpr(expr.Struct)
 	structType, ok := structTypeValue.(StructType)
 	if !ok {
-		tc.Err(fmt.Sprintf("expression of type %s cannot be used as a struct", structTypeValue))
+		tc.Err(expr.Struct.Position(), fmt.Sprintf("expression of type %s cannot be used as a struct", structTypeValue))
 		return nil
 	}
 	assignedMembers := make(map[string]bool, len(structType.Members))
@@ -341,49 +932,129 @@ func (tc *TypeChecker) CheckStructLiteralExpr(expr *ast.StructLiteralExpr) Type
 	for _, member := range expr.Members {
 		assigneType, ok := structType.Members[member.Name]
 		if !ok {
-			tc.Err(fmt.Sprintf("%s is not a member of struct %s", member.Name, structType.Name))
+			tc.errNotMember(member.Pos, member.Name, structType)
+			// The value is checked all the same, for the errors in it not to go unnoticed
+			tc.CheckExpr(member.Value)
 			continue
 		}
 		if assignedMembers[member.Name] == true {
-			tc.Err(fmt.Sprintf("struct member %s assigned multiple times", member.Name))
+			tc.Err(member.Pos, fmt.Sprintf("struct member %s assigned multiple times", member.Name))
 			continue
 		}
+		// Assigned even if the value is invalid, which is reported only once
+		assignedMembers[member.Name] = true
 		assignedValueType := tc.CheckExpr(member.Value)
-		if assignedValueType == nil {
+		if assignedValueType == nil || !tc.checkUnitValue(member.Value, assignedValueType, assigneType) {
 			continue
 		}
-		if !assigneType.Equals(assignedValueType) {
-			tc.Err(fmt.Sprintf("cannot assign %s to %s of struct member %s", assignedValueType, assigneType, member.Name))
-			continue
+		if !IsAssignable(assignedValueType, assigneType) {
+			tc.Err(member.Value.Position(), fmt.Sprintf("cannot assign %s to %s of struct member %s", assignedValueType, assigneType, member.Name))
 		}
-		assignedMembers[member.Name] = true
 	}
 	for memberName, assigned := range assignedMembers {
 		if !assigned {
-			tc.Err(fmt.Sprintf("struct member %s is not assigned a value", memberName))
+			tc.Err(expr.Pos, fmt.Sprintf("struct member %s is not assigned a value", memberName))
 		}
 	}
 	return structType
 }
 
 func (tc *TypeChecker) CheckStructMemberExpr(expr *ast.StructMemberExpr) Type {
+	if module, ok := tc.lookupModuleName(expr.Struct); ok {
+		return tc.checkModuleMember(module, expr)
+	}
+	if enumType, ok := tc.lookupEnumName(expr.Struct); ok {
+		if !slices.Contains(enumType.Variants, expr.Member.Value) {
+			tc.Err(expr.Member.Pos, fmt.Sprintf("%s is not a variant of enum %s", expr.Member.Value, enumType.Name))
+			return nil
+		}
+		return enumType
+	}
 	structTypeValue := tc.CheckExpr(expr.Struct)
+	if structTypeValue == nil {
+		return nil
+	}
+	// The length of an array is a built-in member of it
+	if arrayType, ok := structTypeValue.(ArrayType); ok {
+		if expr.Member.Value != "length" {
+			tc.Err(expr.Member.Pos, fmt.Sprintf("%s is not a member of array type %s", expr.Member.Value, arrayType))
+			return nil
+		}
+		return tc.primitives["i32"]
+	}
 	structType, ok := structTypeValue.(StructType)
 	if !ok {
-		tc.Err(fmt.Sprintf("expression of type %s cannot be used as a struct", structTypeValue))
+		tc.Err(expr.Struct.Position(), fmt.Sprintf("expression of type %s cannot be used as a struct", structTypeValue))
 		return nil
 	}
 	memberType, ok := structType.Members[expr.Member.Value]
 	if !ok {
-		tc.Err(fmt.Sprintf("%s is not a member of struct %s", expr.Member.Value, structType.Name))
+		// A method is called through the struct like a member of a function type, with the struct as its receiver
+		if methodType, ok := structType.Methods[expr.Member.Value]; ok {
+			return methodType
+		}
+		tc.errNotMember(expr.Member.Pos, expr.Member.Value, structType)
 		return nil
 	}
 	return memberType
 }
 
+// errNotMember reports a name used as a member of a struct that has no such member, alike for a member
+// read or assigned, and for one given a value in a struct literal.
+func (tc *TypeChecker) errNotMember(pos lexer.SrcPos, name string, structType StructType) {
+	tc.Err(pos, fmt.Sprintf("%s is not a member of struct %s", name, structType.Name))
+}
+
+// lookupEnumName returns the enum type an expression names, if the expression is the name of an enum type
+// not shadowed by another declaration. Its variants are accessed through the name like members of a struct.
+func (tc *TypeChecker) lookupEnumName(expr ast.Expr) (EnumType, bool) {
+	ident, ok := expr.(*ast.IdentExpr)
+	if !ok {
+		return EnumType{}, false
+	}
+	if kind, scope, ok := tc.currScope.lookupValue(ident.Value); ok && kind == SymbolEnum {
+		return scope.enumTypes[ident.Value], true
+	}
+	return EnumType{}, false
+}
+
+// lookupModuleName returns the used module an expression names, if the expression is the alias of a module
+// not shadowed by another declaration.
+func (tc *TypeChecker) lookupModuleName(expr ast.Expr) (*Scope, bool) {
+	ident, ok := expr.(*ast.IdentExpr)
+	if !ok {
+		return nil, false
+	}
+	if kind, scope, ok := tc.currScope.lookupValue(ident.Value); ok && kind == SymbolModule {
+		return scope.modules[ident.Value], true
+	}
+	return nil, false
+}
+
+// checkModuleMember returns the type of a top-level declaration of a used module, accessed through its alias
+func (tc *TypeChecker) checkModuleMember(module *Scope, expr *ast.StructMemberExpr) Type {
+	name := expr.Member.Value
+	if varType, ok := module.LookupVarType(name); ok {
+		return varType
+	}
+	if structType, ok := module.LookupStructType(name); ok {
+		return structType
+	}
+	// The built-in functions are visible in the module, but not declared by it
+	if funcType, ok := module.LookupFunc(name); ok && !isBuiltinFunc(module, name) {
+		return funcType
+	}
+	tc.Err(expr.Member.Pos, fmt.Sprintf("%s is not declared in module %s", name, expr.Struct.(*ast.IdentExpr).Value))
+	return nil
+}
+
 func (tc *TypeChecker) CheckArrayIndexExpr(expr *ast.ArrayIndexExpr) Type {
-	if !IsNumeric(tc.CheckExpr(expr.Index)) {
-		tc.Err(fmt.Sprintf("array index expression does not result in a numeric type: %s", expr.Index))
+	indexType := tc.CheckExpr(expr.Index)
+	if indexType == nil {
+		return nil
+	}
+	if !IsNumeric(indexType) {
+		tc.Err(expr.Index.Position(), fmt.Sprintf("array index expression does not result in a numeric type: %s", indexType))
 		return nil
 	}
 	arrayExprType := tc.CheckExpr(expr.Array)
@@ -392,37 +1063,105 @@ func (tc *TypeChecker) CheckArrayIndexExpr(expr *ast.ArrayIndexExpr) Type {
 	}
 	arrayType, ok := arrayExprType.(ArrayType)
 	if !ok {
-		tc.Err(fmt.Sprintf("cannot index non-array type %s", arrayType))
+		tc.Err(expr.Array.Position(), fmt.Sprintf("cannot index non-array type %s", arrayExprType))
 		return nil
 	}
+	// The size of a fixed-size array is known, so a constant index can be checked against it
+	if arrayType.Size > 0 {
+		if index, err := evalConst(expr.Index, "i32"); err == nil && index.integer != nil {
+			if index.integer.Sign() < 0 || index.integer.Cmp(big.NewInt(int64(arrayType.Size))) >= 0 {
+				tc.outOfBounds[expr] = arrayType
+			}
+		}
+	}
 	return arrayType.ElemType
 }
 
+// isLvalue reports whether the expression designates a location that can be assigned to
+func isLvalue(expr ast.Expr) bool {
+	switch expr.(type) {
+	case *ast.IdentExpr, *ast.StructMemberExpr, *ast.ArrayIndexExpr:
+		return true
+	}
+	return false
+}
+
+// checkMutable reports an assignment whose target is an immutable variable, or a member or an element of
+// one. A member of a struct or an element of an array is as immutable as the variable holding it.
+func (tc *TypeChecker) checkMutable(assigne ast.Expr) {
+	what := "variable"
+	for {
+		switch e := assigne.(type) {
+		case *ast.StructMemberExpr:
+			what = "member of variable"
+			assigne = e.Struct
+			continue
+		case *ast.ArrayIndexExpr:
+			what = "element of variable"
+			assigne = e.Array
+			continue
+		case *ast.IdentExpr:
+			if tc.currScope.IsConst(e.Value) {
+				tc.Err(assigne.Position(), fmt.Sprintf("cannot assign to immutable %s %s", what, e.Value))
+			}
+		}
+		return
+	}
+}
+
 func (tc *TypeChecker) CheckAssignExpr(expr *ast.AssignExpr) Type {
+	if !isLvalue(expr.Assigne) {
+		tc.Err(expr.Assigne.Position(), "cannot assign to non-lvalue expression")
+		return nil
+	}
+	tc.checkMutable(expr.Assigne)
 	assigneType := tc.CheckExpr(expr.Assigne)
+	if expr.Operator.Type == lexer.EQUALS && assigneType != nil {
+		if tc.pinNumberLiteral(expr.AssignedValue, assigneType) {
+			return assigneType
+		}
+	}
 	assignedValueType := tc.CheckExpr(expr.AssignedValue)
+	if assigneType == nil || assignedValueType == nil {
+		// The invalid side has been reported, e.g. a misspelled member assigned to
+		return assigneType
+	}
 	switch expr.Operator.Type {
 	case lexer.EQUALS:
-		if !assigneType.Equals(assignedValueType) {
-			tc.Err(fmt.Sprintf("cannot assign %s to %s", assignedValueType, assigneType))
+		if !tc.checkUnitValue(expr.AssignedValue, assignedValueType, assigneType) {
+			break
+		}
+		if !IsAssignable(assignedValueType, assigneType) {
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("cannot assign %s to %s", assignedValueType, assigneType))
 		}
 	case lexer.PLUS_EQUALS:
 		numeric := IsNumeric(assigneType) && IsNumeric(assignedValueType)
 		strings := IsPrimitive(assigneType, "string") && IsPrimitive(assignedValueType, "string")
 		if !numeric && !strings {
-			tc.Err(fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, assigneType, assignedValueType))
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, assigneType, assignedValueType))
 		}
-	case lexer.DASH_EQUALS:
+	case lexer.DASH_EQUALS, lexer.STAR_EQUALS, lexer.SLASH_EQUALS:
 		numeric := IsNumeric(assigneType) && IsNumeric(assignedValueType)
 		if !numeric {
-			tc.Err(fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, assigneType, assignedValueType))
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, assigneType, assignedValueType))
+		}
+	case lexer.PERCENT_EQUALS:
+		if IsNumeric(assigneType) && IsNumeric(assignedValueType) && !(IsInteger(assigneType) && IsInteger(assignedValueType)) {
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("operator %%= requires integer operands, found %s and %s", assigneType, assignedValueType))
+		} else if !IsInteger(assigneType) || !IsInteger(assignedValueType) {
+			tc.Err(expr.Operator.SrcPos, fmt.Sprintf("invalid operands for %s: %s and %s", expr.Operator.Value, assigneType, assignedValueType))
 		}
 	}
 	return assigneType
 }
 
+// CheckVarDeclAssignExpr checks a `:=` declaration, which always declares a mutable variable, inferring its
+// type from the value. The resolver defines the variable without a type, which is set here.
 func (tc *TypeChecker) CheckVarDeclAssignExpr(expr *ast.VarDeclAssignExpr) Type {
 	assignedValueType := tc.CheckExpr(expr.AssignedValue)
+	if IsUnit(assignedValueType) {
+		tc.Err(expr.AssignedValue.Position(), fmt.Sprintf("cannot use value of type () to initialize variable %s", expr.Name))
+	}
 	tc.currScope.DefineVar(expr.Name, assignedValueType)
 	return assignedValueType
 }
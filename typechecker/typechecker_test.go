@@ -2,9 +2,11 @@ package typechecker
 
 import (
 	"fmt"
+	"github.com/ruistola/cooper/ast"
 	"github.com/ruistola/cooper/lexer"
 	"github.com/ruistola/cooper/parser"
 	"github.com/yassinebenaid/godump"
+	"strings"
 	"testing"
 )
 
@@ -24,3 +26,1814 @@ func Test(t *testing.T) {
 		}
 	}
 }
+
+// expectErrors type checks the source and verifies that each of the expected error substrings is found
+// in the reported errors, in order. Without expected substrings, verifies that there are no errors.
+func expectErrors(t *testing.T, src string, expected ...string) {
+	t.Helper()
+	errors := Check(parser.Parse(lexer.Tokenize(src)))
+	if len(errors) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %q", len(expected), len(errors), errors)
+	}
+	for i, err := range errors {
+		if !strings.Contains(err.String(), expected[i]) {
+			t.Errorf("Expected error containing %q, got %q", expected[i], err)
+		}
+	}
+}
+
+// TestErrorMessages feeds a minimal broken program to each error branch of the checks, and verifies the reported
+// diagnostics. Branches guarding the consistency of the passes themselves, like a missing scope, are not included.
+func TestErrorMessages(t *testing.T) {
+	const point = "struct Point {\n  x: i32,\n}\n"
+	testCases := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		// Resolver
+		{"undefined type", "let x: Foo", []string{"1:8: Resolve Error: undefined type: Foo"}},
+		{"undefined identifier", "x = 1", []string{"1:1: Resolve Error: undefined identifier: x"}},
+		{"missing value of a constant", "const x: i32", []string{"1:7: Resolve Error: missing value of immutable variable x"}},
+		{"missing type of a destructured variable", "let (a, b: i32) = (1, 2)", []string{"1:6: Resolve Error: missing type of destructured variable a"}},
+		{"redeclared struct", point + "struct Point {\n  y: i32,\n}", []string{"4:1: Resolve Error: redeclared struct Point in the same scope"}},
+		{"duplicate parameter", "func f(x: i32, x: bool) {\n}", []string{"1:16: Resolve Error: duplicate parameter name x"}},
+		{"parameter named like the receiver", "struct P {\n  x: i32,\n}\nfunc (p: P) f(p: i32) {\n}", []string{"4:15: Resolve Error: duplicate parameter name p"}},
+		{"duplicate struct member", "struct Point {\n  x: i32,\n  x: i32,\n}", []string{"3:3: Resolve Error: duplicate member x in struct Point"}},
+		{"redeclared enum", "enum E {\n  A,\n}\nenum E {\n  B,\n}", []string{"4:1: Resolve Error: redeclared enum E in the same scope"}},
+		{"duplicate enum variant", "enum E {\n  A,\n  A,\n}", []string{"1:1: Resolve Error: duplicate variant A in enum E"}},
+		{"redeclared interface", "interface S {}\ninterface S {}", []string{"2:1: Resolve Error: redeclared interface S in the same scope"}},
+		{"duplicate interface method", "interface S {\n  f(),\n  f(),\n}", []string{"3:3: Resolve Error: duplicate method f in interface S"}},
+		{"redeclared function", "func f() {}\nfunc f() {}", []string{"2:1: Resolve Error: redeclared function f in the same scope"}},
+		{"method of a non-struct", "func (n: i32) f() {}", []string{"1:7: Resolve Error: method receiver must be a struct, found i32"}},
+		{"method named like a member", point + "func (p: Point) x() {}", []string{"4:1: Resolve Error: method x conflicts with a member of struct Point"}},
+		{"redeclared method", point + "func (p: Point) f() {}\nfunc (p: Point) f() {}", []string{"5:1: Resolve Error: redeclared method f of struct Point"}},
+
+		// Declarations
+		{"variable type mismatch", "let x: bool = 1", []string{"1:1: Type Error: type mismatch: variable x declared as bool but initialized with i32"}},
+		{"destructuring a non-tuple", "let (a: i32, b: i32) = 1", []string{"1:24: Type Error: cannot destructure non-tuple type i32"}},
+		{"destructuring into too many variables", "let (a: i32, b: i32, c: i32) = (1, 2)", []string{"1:1: Type Error: cannot destructure (i32, i32) into 3 variables"}},
+		{"destructured type mismatch", "let (a: i32, b: bool) = (1, 2)", []string{"1:14: Type Error: type mismatch: variable b declared as bool but destructured from i32"}},
+		{"redeclaring a constant with :=", "const c: i32 = 0\nc := 1", []string{"2:1: Resolve Error: cannot redeclare immutable variable c with :="}},
+
+		// Control flow
+		{"non-bool if- statement condition", "if 1 then {\n}", []string{"1:4: Type Error: if- statement condition does not evaluate to a boolean type"}},
+		{"non-bool if- expression condition", "let x: i32 = if 1 then 2 else 3", []string{"1:17: Type Error: if- expression condition does not evaluate to a boolean type"}},
+		{"if- expression branch mismatch", "let x: i32 = if true then 2 else false", []string{"1:34: Type Error: if- expression branches have different types: i32 and bool"}},
+		{"non-bool for- statement condition", "for (let i: i32 = 0; i; i += 1) {\n}", []string{"1:22: Type Error: for- statement condition does not evaluate to a boolean type"}},
+		{"match arm type mismatch", "let x: i32 = match 1 {\n  0 => 1,\n  _ => true,\n}", []string{"3:8: Type Error: match arm type mismatch: expected i32, found bool"}},
+		{"match pattern type mismatch", "let x: i32 = match 1 {\n  true => 1,\n  _ => 0,\n}", []string{"2:3: Type Error: pattern type mismatch: expected i32, found bool"}},
+		{"match number pattern type mismatch", "let x: i32 = match true {\n  1 => 1,\n  _ => 0,\n}", []string{"2:3: Type Error: pattern type mismatch: expected bool, found the number literal 1"}},
+		{"non-exhaustive match over bool", "let x: i32 = match true {\n  false => 1,\n}", []string{"1:14: Type Error: non-exhaustive match over bool, missing true"}},
+		{"non-exhaustive match", "let x: i32 = match 1 {\n  0 => 1,\n}", []string{"1:14: Type Error: non-exhaustive match over i32, missing a _ arm"}},
+		{"break outside of a loop", "break", []string{"1:1: Type Error: break statement outside of a loop"}},
+		{"break with a value out of a for- loop", "for (let i: i32 = 0; i < 3; i += 1) {\n  break 1\n}", []string{"2:9: Type Error: cannot break with a value out of a for- loop"}},
+		{"break value type mismatch", "let x: i32 = loop {\n  break 1\n  break true\n}", []string{"3:3: Type Error: break value type mismatch: expected i32, found bool"}},
+		{"return outside of a function", "return 1", []string{"1:1: Type Error: return statement outside of function"}},
+		{"missing return value", "func f(): i32 {\n  return\n}", []string{"2:3: Type Error: expected function to return i32"}},
+		{"return value without a return type", "func f() {\n  return 1\n}", []string{"2:10: Type Error: cannot return a value from a function with no declared return type"}},
+		{"return type mismatch", "func f(): i32 {\n  return true\n}", []string{"2:10: Type Error: return type mismatch: expected i32, found bool"}},
+
+		// Operators
+		{"integer division by zero", "let x: i32 = 1 / 0", []string{"1:18: Type Error: division by zero"}},
+		{"invalid arithmetic operands", "let x: i32 = 1 + true", []string{"1:16: Type Error: invalid operands for +: i32 and bool"}},
+		{"invalid comparison operands", "let x: bool = true < 1", []string{"1:20: Type Error: invalid operands for <: bool and i32"}},
+		{"invalid unary operand", "let x: bool = -true", []string{"1:15: Type Error: invalid operand for -: bool"}},
+		{"ascribing a number literal", "let x: bool = (1: bool)", []string{"1:15: Type Error: cannot ascribe bool to the number literal 1"}},
+		{"ascribing an expression", "let x: i32 = (true: i32)", []string{"1:14: Type Error: cannot ascribe i32 to an expression of type bool"}},
+
+		// Calls
+		{"calling a non-function", "let x: i32 = 1\nlet y: i32 = x()", []string{"2:14: Type Error: cannot call variable x of non-function type i32"}},
+		{"too many arguments", "func f(a: i32) {}\nf(1, 2)", []string{"2:6: Type Error: too many arguments, expected 1, found 2"}},
+		{"not enough arguments", "func f(a: i32) {}\nf()", []string{"2:3: Type Error: not enough arguments, expected 1, found 0"}},
+		{"argument type mismatch", "func f(a: i32) {}\nf(true)", []string{"2:3: Type Error: argument 1 type mismatch: expected i32, found bool"}},
+
+		// Structs, enums and arrays
+		{"struct literal of a non-struct", "let x: i32 = 1\nlet y: i32 = x{ a: 1, }", []string{"2:14: Type Error: expression of type i32 cannot be used as a struct"}},
+		{"struct literal with an unknown member", point + "let p: Point = Point{ x: 1, y: 2, }", []string{"4:29: Type Error: y is not a member of struct Point"}},
+		{"struct literal assigning a member twice", point + "let p: Point = Point{ x: 1, x: 2, }", []string{"4:29: Type Error: struct member x assigned multiple times"}},
+		{"struct literal member type mismatch", point + "let p: Point = Point{ x: true, }", []string{"4:26: Type Error: cannot assign bool to i32 of struct member x"}},
+		{"struct literal missing a member", point + "let p: Point = Point{}", []string{"4:16: Type Error: struct member x is not assigned a value"}},
+		{"member of a non-struct", "let x: i32 = 1\nlet y: i32 = x.a", []string{"2:14: Type Error: expression of type i32 cannot be used as a struct"}},
+		{"unknown struct member", point + "let p: Point = Point{ x: 1, }\nlet y: i32 = p.y", []string{"5:16: Type Error: y is not a member of struct Point"}},
+		{"unknown enum variant", "enum E {\n  A,\n}\nlet e: E = E.B", []string{"4:14: Type Error: B is not a variant of enum E"}},
+		{"non-numeric array index", "let a: i32[]\nlet x: i32 = a[true]", []string{"2:16: Type Error: array index expression does not result in a numeric type: bool"}},
+		{"indexing a non-array", "let a: i32 = 1\nlet x: i32 = a[0]", []string{"2:14: Type Error: cannot index non-array type i32"}},
+
+		// Assignments
+		{"assigning to a non-lvalue", "5 = 1", []string{"1:1: Type Error: cannot assign to non-lvalue expression"}},
+		{"assigning to a constant", "const c: i32 = 0\nc = 1", []string{"2:1: Type Error: cannot assign to immutable variable c"}},
+		{"assignment type mismatch", "let x: i32 = 0\nx = true", []string{"2:3: Type Error: cannot assign bool to i32"}},
+		{"invalid += operands", "let x: bool = true\nx += 1", []string{"2:3: Type Error: invalid operands for +=: bool and i32"}},
+		{"invalid -= operands", "let x: string = \"a\"\nx -= \"b\"", []string{"2:3: Type Error: invalid operands for -=: string and string"}},
+
+		// Semantic analysis
+		{"missing return", "func f(): i32 {\n}", []string{"1:1: Semantic Error: function 'f' with return type i32 does not return a value in all code paths"}},
+		{"unreachable code", "func f(): i32 {\n  return 1\n  return 2\n}", []string{"3:3: Semantic Error: unreachable code after statement 1"}},
+		{"assignment as a struct member value", point + "let y: i32 = 0\nlet p: Point = Point{ x: y = 1, }", []string{"5:26: Semantic Error: assignment in the value of struct member x"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectErrors(t, tc.src, tc.expected...)
+		})
+	}
+}
+
+func TestInterfaceSatisfaction(t *testing.T) {
+	t.Run("struct satisfies an interface it declares all methods of", func(t *testing.T) {
+		expectErrors(t, `interface Any {}
+struct Point {
+  x: i32,
+}
+let p: Point = Point{ x: 1, }
+let a: Any = p`)
+	})
+	t.Run("struct does not satisfy an interface with a missing method", func(t *testing.T) {
+		expectErrors(t, `interface Shape {
+  area(): f64,
+}
+struct Point {
+  x: i32,
+}
+let p: Point = Point{ x: 1, }
+let s: Shape = p`, "variable s declared as Shape but initialized with Point")
+	})
+}
+
+// The members and methods of a struct may refer to the struct itself, so comparing types mentioning it must
+// not compare the struct structurally
+func TestRecursiveTypeEquality(t *testing.T) {
+	newStruct := func(name string) StructType {
+		s := StructType{Name: name, Members: map[string]Type{}, Methods: map[string]FuncType{}}
+		s.Members["children"] = ArrayType{ElemType: s}
+		s.Methods["append"] = FuncType{ReturnType: s, ParamTypes: []Type{s}}
+		return s
+	}
+	node, otherNode, tree := newStruct("Node"), newStruct("Node"), newStruct("Tree")
+	testCases := []struct {
+		name     string
+		a, b     Type
+		expected bool
+	}{
+		{"same struct", node, node, true},
+		{"struct of the same name", node, otherNode, true},
+		{"struct of another name", node, tree, false},
+		{"method types", node.Methods["append"], otherNode.Methods["append"], true},
+		{"method types of different structs", node.Methods["append"], tree.Methods["append"], false},
+		{"member types", node.Members["children"], otherNode.Members["children"], true},
+		{"func of the struct", FuncType{ReturnType: UnitType{}, ParamTypes: []Type{node}}, FuncType{ReturnType: UnitType{}, ParamTypes: []Type{otherNode}}, true},
+		{"func of another struct", FuncType{ReturnType: UnitType{}, ParamTypes: []Type{node}}, FuncType{ReturnType: UnitType{}, ParamTypes: []Type{tree}}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := tc.a.Equals(tc.b); actual != tc.expected {
+				t.Errorf("Expected %s.Equals(%s) to be %t", tc.a, tc.b, tc.expected)
+			}
+		})
+	}
+	iface := InterfaceType{Name: "Appendable", Methods: map[string]FuncType{"append": node.Methods["append"]}}
+	if !otherNode.Satisfies(iface) || tree.Satisfies(iface) {
+		t.Errorf("Expected only Node to satisfy %s", iface)
+	}
+	t.Run("source", func(t *testing.T) {
+		expectErrors(t, `interface Appendable {
+  append(n: Node): Node,
+}
+struct Node {
+  children: Node[],
+}
+func (n: Node) append(child: Node): Node {
+  return child
+}
+func g(n: Node): Node {
+  let a: Appendable = n
+  let f: func(Node): Node = n.append
+  return f(n)
+}`)
+	})
+}
+
+func TestErrorPositions(t *testing.T) {
+	expectErrors(t, `func f(): i32 {
+  return 1 + missing
+}`, "2:14: Resolve Error: undefined identifier: missing")
+	expectErrors(t, `let flag: bool = true
+  let count: i32 = flag
+x := count + "one"`,
+		"2:3: Type Error: type mismatch: variable count declared as i32",
+		"3:12: Type Error: invalid operands for +: i32 and string")
+}
+
+func TestDiagnosticRendering(t *testing.T) {
+	diagnostic := Diagnostic{
+		Severity: SeverityError,
+		Stage:    StageType,
+		Message:  "undefined variable: x",
+		Pos:      lexer.SrcPos{Line: 3, Column: 5},
+	}
+	if plain := diagnostic.Render(false); plain != "3:5: Type Error: undefined variable: x" {
+		t.Errorf("Unexpected plain rendering %q", plain)
+	}
+	if colored := diagnostic.Render(true); colored != "\033[31m3:5: Type Error: undefined variable: x\033[0m" {
+		t.Errorf("Unexpected colored rendering %q", colored)
+	}
+	src := "func f(): i32 {\n  return 1\n}\nlet y: i32 = x\n"
+	diagnostic.Pos = lexer.SrcPos{Line: 4, Column: 14}
+	if snippet := diagnostic.RenderWithSource(src, false); snippet != "4:14: Type Error: undefined variable: x\nlet y: i32 = x\n             ^" {
+		t.Errorf("Unexpected rendering with source %q", snippet)
+	}
+	diagnostic.Pos = lexer.SrcPos{}
+	if plain := diagnostic.String(); plain != "Type Error: undefined variable: x" {
+		t.Errorf("Unexpected rendering without a position %q", plain)
+	}
+	if plain := diagnostic.RenderWithSource(src, false); plain != "Type Error: undefined variable: x" {
+		t.Errorf("Unexpected rendering with source without a position %q", plain)
+	}
+}
+
+func TestDefaultParameters(t *testing.T) {
+	decls := `func scale(x: i32, factor: i32 = 2, verbose: bool = false): i32 {
+  return x * factor
+}
+`
+	t.Run("omitted defaulted argument", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = scale(1)\nlet b: i32 = scale(1, 3)")
+	})
+	t.Run("supplied defaulted argument", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = scale(1, 3, true)")
+	})
+	t.Run("supplied argument type mismatch", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = scale(1, true)", "4:23: Type Error: argument 2 type mismatch: expected i32, found bool")
+	})
+	t.Run("missing required argument", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = scale()", "4:20: Type Error: not enough arguments, expected at least 1, found 0")
+	})
+	t.Run("default type mismatch", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32 = true) {}", "1:17: Type Error: type mismatch: parameter x declared as i32 but defaults to bool")
+	})
+	t.Run("required parameter after a default", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32 = 1, y: i32) {}", "1:20: Resolve Error: required parameter y after a parameter with a default value")
+	})
+	t.Run("default is not a literal", func(t *testing.T) {
+		expectErrors(t, "let n: i32 = 1\nfunc f(x: i32 = n) {}", "2:17: Resolve Error: default value of parameter x must be a literal")
+	})
+	t.Run("i64 default", func(t *testing.T) {
+		expectErrors(t, "func f(x: i64 = 1, y: i64 = -5000000000): i64 {\n  return x + y\n}\nlet a: i64 = f()")
+	})
+	t.Run("f64 default", func(t *testing.T) {
+		expectErrors(t, "func f(x: f64 = 1.5, y: f64 = 2): f64 {\n  return x + y\n}\nlet a: f64 = f()")
+	})
+	t.Run("default out of range", func(t *testing.T) {
+		expectErrors(t, "func f(x: i8 = 300) {}", "1:16: Type Error: literal 300 overflows i8")
+	})
+	t.Run("omitted arguments are recorded per call", func(t *testing.T) {
+		src := decls + "let a: i32 = scale(1)\nlet b: i32 = scale(1, 3)\nlet c: i32 = scale(1)"
+		module := parser.Parse(lexer.Tokenize(src))
+		_, callDefaults, errors := CheckModule(module, Config{})
+		if len(errors) > 0 {
+			t.Fatalf("Expected no errors, got %v", errors)
+		}
+		if diff := ast.Diff(parser.Parse(lexer.Tokenize(src)), module); diff != "" {
+			t.Errorf("Expected the AST to be left as is:\n%s", diff)
+		}
+		calls := []*ast.FuncCallExpr{}
+		for _, stmt := range module.Statements[1:] {
+			calls = append(calls, stmt.(*ast.VarDeclStmt).InitVal.(*ast.FuncCallExpr))
+		}
+		for i, expected := range []string{"2 false", "false", "2 false"} {
+			printed := []string{}
+			for _, value := range callDefaults[calls[i]] {
+				printed = append(printed, ast.Print(value))
+			}
+			if strings.Join(printed, " ") != expected {
+				t.Errorf("Expected the call %s to default to %s, got %s", ast.Print(calls[i]), expected, printed)
+			}
+		}
+		if callDefaults[calls[0]][0] == callDefaults[calls[2]][0] {
+			t.Error("Expected each call to have default values of its own")
+		}
+	})
+}
+
+func TestVariadicParameters(t *testing.T) {
+	decls := `func sum(base: i32, xs: i32...): i32 {
+  let first: i32 = xs[0]
+  return base + first
+}
+`
+	t.Run("no variadic arguments", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = sum(1)")
+	})
+	t.Run("one variadic argument", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = sum(1, 2)")
+	})
+	t.Run("several variadic arguments", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = sum(1, 2, 3, 4)")
+	})
+	t.Run("variadic argument type mismatch", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = sum(1, 2, true)", "5:24: Type Error: argument 3 type mismatch: expected i32, found bool")
+	})
+	t.Run("missing fixed argument", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = sum()", "5:18: Type Error: not enough arguments, expected at least 1, found 0")
+	})
+	t.Run("variadic parameter is an array", func(t *testing.T) {
+		expectErrors(t, "func f(xs: i32...) {\n  let b: bool = xs\n}", "2:3: Type Error: type mismatch: variable b declared as bool but initialized with i32[]")
+	})
+	t.Run("variadic function type", func(t *testing.T) {
+		expectErrors(t, decls+"let b: bool = sum", "5:1: Type Error: type mismatch: variable b declared as bool but initialized with func(i32,i32...):i32")
+	})
+}
+
+func TestArrayLength(t *testing.T) {
+	t.Run("last element", func(t *testing.T) {
+		expectErrors(t, "let arr: bool[]\nlet last: bool = arr[arr.length - 1]")
+	})
+	t.Run("length of a nested array", func(t *testing.T) {
+		expectErrors(t, "let grid: i32[][]\nlet n: i32 = grid[0].length")
+	})
+	t.Run("length is an i32", func(t *testing.T) {
+		expectErrors(t, "let arr: i32[]\nlet n: bool = arr.length", "2:1: Type Error: type mismatch: variable n declared as bool but initialized with i32")
+	})
+	t.Run("length of a non-array", func(t *testing.T) {
+		expectErrors(t, "let x: i32\nlet n: i32 = x.length", "2:14: Type Error: expression of type i32 cannot be used as a struct")
+	})
+	t.Run("unknown array member", func(t *testing.T) {
+		expectErrors(t, "let arr: i32[]\nlet n: i32 = arr.size", "2:18: Type Error: size is not a member of array type i32[]")
+	})
+}
+
+func TestOrdering(t *testing.T) {
+	t.Run("strings", func(t *testing.T) {
+		expectErrors(t, `let s: string = "b"`+"\n"+`let b: bool = "a" < s`+"\n"+`let c: bool = s >= "c"`)
+	})
+	t.Run("numbers", func(t *testing.T) {
+		expectErrors(t, "let x: i64 = (5: i64)\nlet b: bool = x > (1: i64)\nlet c: bool = 2 <= 3")
+	})
+	t.Run("string and number", func(t *testing.T) {
+		expectErrors(t, `let b: bool = "a" < 1`, "1:19: Type Error: invalid operands for <: string and i32")
+	})
+	t.Run("bools", func(t *testing.T) {
+		expectErrors(t, "let b: bool = true > false", "1:20: Type Error: invalid operands for >: bool and bool")
+	})
+}
+
+func TestEquality(t *testing.T) {
+	decls := `struct Point {
+  x: i32,
+  y: i32,
+}
+struct Node {
+  value: i32,
+  children: Node[],
+}
+struct Callback {
+  f: func(): i32,
+}
+let p: Point = Point{ x: 1, y: 2, }
+let q: Point = Point{ x: 2, y: 1, }
+let a: Point[]
+let b: Point[]
+`
+	t.Run("structs", func(t *testing.T) {
+		expectErrors(t, decls+"let same: bool = p == q")
+	})
+	t.Run("arrays", func(t *testing.T) {
+		expectErrors(t, decls+"let same: bool = a != b")
+	})
+	t.Run("recursive struct", func(t *testing.T) {
+		expectErrors(t, decls+"let n: Node\nlet same: bool = n == n")
+	})
+	t.Run("tuples", func(t *testing.T) {
+		expectErrors(t, decls+"let same: bool = (p, 1) == (q, 2)")
+	})
+	t.Run("functions", func(t *testing.T) {
+		expectErrors(t, "func f(): i32 {\n  return 1\n}\nlet same: bool = f == f", "4:20: Type Error: cannot compare values of type func():i32")
+	})
+	t.Run("struct with a function member", func(t *testing.T) {
+		expectErrors(t, decls+"let c: Callback\nlet same: bool = c == c", "17:20: Type Error: cannot compare values of type Callback")
+	})
+	t.Run("arrays of functions", func(t *testing.T) {
+		expectErrors(t, "let fs: (func(): i32)[]\nlet same: bool = fs == fs", "2:21: Type Error: cannot compare values of type func():i32[]")
+	})
+	t.Run("different types", func(t *testing.T) {
+		expectErrors(t, decls+"let same: bool = p == a", "16:20: Type Error: cannot compare Point and Point[]")
+	})
+}
+
+func TestCallingNonFunctions(t *testing.T) {
+	t.Run("i32 member", func(t *testing.T) {
+		expectErrors(t, "struct P {\n  x: i32,\n}\nlet p: P = P{ x: 1, }\nlet y: i32 = p.x()", "5:16: Type Error: cannot call member x of non-function type i32")
+	})
+	t.Run("string variable", func(t *testing.T) {
+		expectErrors(t, "let s: string = \"a\"\nlet y: i32 = s()", "2:14: Type Error: cannot call variable s of non-function type string")
+	})
+	t.Run("number literal", func(t *testing.T) {
+		expectErrors(t, "let y: i32 = (5)()", "1:15: Type Error: cannot call the number literal 5 of non-function type i32")
+	})
+	t.Run("array element", func(t *testing.T) {
+		expectErrors(t, "let a: i32[]\nlet y: i32 = a[0]()", "2:14: Type Error: cannot call an array element of non-function type i32")
+	})
+	t.Run("arguments are checked", func(t *testing.T) {
+		expectErrors(t, "let y: i32 = 5(z)", "1:16: Resolve Error: undefined identifier: z")
+		expectErrors(t, "let y: i32 = 5(1 + true)",
+			"1:14: Type Error: cannot call the number literal 5 of non-function type i32",
+			"1:18: Type Error: invalid operands for +: i32 and bool")
+	})
+}
+
+func TestUnitAndMemberAssignExprs(t *testing.T) {
+	t.Run("empty block expression", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = { ; }", "1:14: Type Error: cannot use value of type () as i32")
+	})
+	t.Run("stray member assignment", func(t *testing.T) {
+		module := &ast.BlockStmt{Statements: []ast.Stmt{
+			&ast.ExpressionStmt{Expr: &ast.MemberAssignExpr{Name: "x", Value: &ast.NumberLiteralExpr{Value: "1"}}},
+		}}
+		if errors := Check(module); len(errors) > 0 {
+			t.Errorf("Expected no errors, got %q", errors)
+		}
+	})
+}
+
+func TestMethods(t *testing.T) {
+	decls := `struct Point {
+  x: i32,
+  y: i32,
+}
+func (self: Point) sum(): i32 {
+  return self.x + self.y
+}
+func (self: Point) scaled(factor: i32): Point {
+  return Point{ x: self.x * factor, y: self.y * factor, }
+}
+let p: Point = Point{ x: 1, y: 2, }
+`
+	t.Run("method call", func(t *testing.T) {
+		expectErrors(t, decls+"let s: i32 = p.scaled(2).sum()")
+	})
+	t.Run("argument type mismatch", func(t *testing.T) {
+		expectErrors(t, decls+"let q: Point = p.scaled(true)", "12:25: Type Error: argument 1 type mismatch: expected i32, found bool")
+	})
+	t.Run("missing method", func(t *testing.T) {
+		expectErrors(t, decls+"let n: i32 = p.length()", "12:16: Type Error: length is not a member of struct Point")
+	})
+	t.Run("method is not a function in scope", func(t *testing.T) {
+		expectErrors(t, decls+"let n: i32 = sum()", "12:14: Resolve Error: undefined identifier: sum")
+	})
+	t.Run("unknown receiver member", func(t *testing.T) {
+		expectErrors(t, decls+"func (self: Point) w(): i32 {\n  return self.z\n}", "13:15: Type Error: z is not a member of struct Point")
+	})
+	t.Run("method satisfies an interface", func(t *testing.T) {
+		expectErrors(t, decls+"interface Summable {\n  sum(): i32,\n}\nlet s: Summable = p")
+	})
+	t.Run("receiver is not a struct", func(t *testing.T) {
+		expectErrors(t, "func (self: i32) double(): i32 {\n  return self * 2\n}", "1:7: Resolve Error: method receiver must be a struct, found i32")
+	})
+	t.Run("method named like a member", func(t *testing.T) {
+		expectErrors(t, decls+"func (self: Point) x(): i32 {\n  return 0\n}", "12:1: Resolve Error: method x conflicts with a member of struct Point")
+	})
+	t.Run("redeclared method", func(t *testing.T) {
+		expectErrors(t, decls+"func (other: Point) sum(): i32 {\n  return 0\n}", "12:1: Resolve Error: redeclared method sum of struct Point")
+	})
+}
+
+func TestEnums(t *testing.T) {
+	decls := "enum Color {\n  Red,\n  Green,\n}\n"
+	t.Run("variant of a typed variable", func(t *testing.T) {
+		expectErrors(t, decls+"let c: Color = Color.Red\nc = Color.Green")
+	})
+	t.Run("enum member of a struct", func(t *testing.T) {
+		expectErrors(t, decls+"struct Pixel {\n  color: Color,\n}\nlet p: Pixel = Pixel{ color: Color.Green, }")
+	})
+	t.Run("unknown variant", func(t *testing.T) {
+		expectErrors(t, decls+"let c: Color = Color.Blue", "5:22: Type Error: Blue is not a variant of enum Color")
+	})
+	t.Run("variant assigned to another type", func(t *testing.T) {
+		expectErrors(t, decls+"let n: i32 = Color.Red", "5:1: Type Error: type mismatch: variable n declared as i32 but initialized with Color")
+	})
+	t.Run("variants are accessed through the enum name only", func(t *testing.T) {
+		expectErrors(t, decls+"let c: Color = Color.Red\nlet d: Color = c.Green", "6:16: Type Error: expression of type Color cannot be used as a struct")
+	})
+	t.Run("duplicate variant", func(t *testing.T) {
+		expectErrors(t, "enum Color {\n  Red,\n  Red,\n}", "1:1: Resolve Error: duplicate variant Red in enum Color")
+	})
+	t.Run("redeclared enum", func(t *testing.T) {
+		expectErrors(t, decls+"enum Color {\n  Blue,\n}", "5:1: Resolve Error: redeclared enum Color in the same scope")
+	})
+}
+
+func TestReturnFuncValue(t *testing.T) {
+	t.Run("returned function matches the declared return type", func(t *testing.T) {
+		expectErrors(t, `func double(x: i32): i32 {
+  return x * 2
+}
+func log(message: string) {}
+func adder(): func(i32): i32 {
+  return double
+}
+func logger(): func(string) {
+  return log
+}`)
+	})
+	t.Run("returned function does not match the declared return type", func(t *testing.T) {
+		expectErrors(t, `func isNegative(x: i32): bool {
+  return x < 0
+}
+func adder(): func(i32): i32 {
+  return isNegative
+}`, "5:10: Type Error: return type mismatch: expected func(i32):i32, found func(i32):bool")
+	})
+	t.Run("returned function name must be declared", func(t *testing.T) {
+		expectErrors(t, `func adder(): func(i32): i32 {
+  return undeclared
+}`, "2:10: Resolve Error: undefined identifier: undeclared")
+	})
+}
+
+func TestHoisting(t *testing.T) {
+	t.Run("mutually recursive functions at module level", func(t *testing.T) {
+		expectErrors(t, `func isEven(n: i32): bool {
+  if n < 1 then return true
+  return isOdd(n - 1)
+}
+func isOdd(n: i32): bool {
+  if n < 1 then return false
+  return isEven(n - 1)
+}
+let even: bool = isEven(4)`)
+	})
+	t.Run("mutually recursive functions at block level", func(t *testing.T) {
+		expectErrors(t, `func countdown(n: i32): i32 {
+  if n > 0 then {
+    func ping(n: i32): i32 {
+      if n < 1 then return 0
+      return pong(n - 1)
+    }
+    func pong(n: i32): i32 {
+      return ping(n - 1)
+    }
+    return ping(n)
+  }
+  return 0
+}`)
+	})
+	t.Run("forward referenced structs", func(t *testing.T) {
+		expectErrors(t, `struct Line {
+  from: Point,
+  to: Point,
+}
+func length(line: Line): i32 {
+  return line.to.x - line.from.x
+}
+struct Point {
+  x: i32,
+  y: i32,
+}
+let line: Line = Line{ from: Point{ x: 0, y: 0, }, to: Point{ x: 3, y: 4, }, }`)
+	})
+	t.Run("block level declarations are not visible outside the block", func(t *testing.T) {
+		expectErrors(t, `func f(n: i32) {
+  if n > 0 then {
+    struct Local {
+      x: i32,
+    }
+    func g() {}
+  }
+  g()
+}`, "8:3: Resolve Error: undefined identifier: g")
+	})
+	t.Run("variables are not hoisted", func(t *testing.T) {
+		expectErrors(t, `func f(): i32 {
+  return later
+}
+let later: i32 = 1`, "2:10: Resolve Error: undefined identifier: later")
+	})
+}
+
+func TestAscription(t *testing.T) {
+	t.Run("pinned number literal", func(t *testing.T) {
+		expectErrors(t, "let x: i64 = (5 : i64)")
+	})
+	t.Run("pinned float literal", func(t *testing.T) {
+		expectErrors(t, "let x: f64 = (-2.5 : f64)")
+	})
+	t.Run("typed expression", func(t *testing.T) {
+		expectErrors(t, "let b: bool = (true : bool)")
+	})
+	t.Run("incompatible type", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = (true : i32)", "1:14: Type Error: cannot ascribe i32 to an expression of type bool")
+	})
+	t.Run("float literal as an integer", func(t *testing.T) {
+		expectErrors(t, "let x: i64 = (2.5 : i64)", "cannot ascribe i64 to the number literal 2.5")
+	})
+	t.Run("ascribed type is the type of the expression", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = (5 : i64)", "variable x declared as i32 but initialized with i64")
+	})
+}
+
+func TestCustomPrimitives(t *testing.T) {
+	src := "let a: u16\nlet b: u16 = a"
+	t.Run("registered primitive", func(t *testing.T) {
+		errors := CheckWithConfig(parser.Parse(lexer.Tokenize(src)), Config{Primitives: map[string]Type{"u16": PrimitiveType{Name: "u16"}}})
+		if len(errors) > 0 {
+			t.Errorf("Expected no errors, got %q", errors)
+		}
+	})
+	t.Run("unregistered primitive", func(t *testing.T) {
+		expectErrors(t, src, "1:8: Resolve Error: undefined type: u16", "2:8: Resolve Error: undefined type: u16")
+	})
+	t.Run("distinct from the built-in types", func(t *testing.T) {
+		errors := CheckWithConfig(parser.Parse(lexer.Tokenize("let a: u16\nlet b: i32 = a")), Config{Primitives: map[string]Type{"u16": PrimitiveType{Name: "u16"}}})
+		if len(errors) != 1 || !strings.Contains(errors[0].String(), "declared as i32 but initialized with u16") {
+			t.Errorf("Expected a type mismatch error, got %q", errors)
+		}
+	})
+}
+
+func TestBlockExprScoping(t *testing.T) {
+	t.Run("nested block as expression", func(t *testing.T) {
+		expectErrors(t, `let result: i32 = {
+  let temp: i32 = {
+    let a: i32 = 1
+    let b: i32 = 2
+    a + b
+  }
+  temp * 10
+}`)
+	})
+	t.Run("inner declarations don't leak", func(t *testing.T) {
+		expectErrors(t, `let result: i32 = {
+  let temp: i32 = {
+    let a: i32 = 1
+    a
+  }
+  temp + a
+}`, "6:10: Resolve Error: undefined identifier: a")
+	})
+	t.Run("outer declarations don't leak", func(t *testing.T) {
+		expectErrors(t, `let result: i32 = {
+  let temp: i32 = 1
+  temp
+}
+let other: i32 = temp`, "5:18: Resolve Error: undefined identifier: temp")
+	})
+	t.Run("type of the result expression", func(t *testing.T) {
+		expectErrors(t, `let flag: bool = {
+  let n: i32 = 1
+  n
+}`, "variable flag declared as bool but initialized with i32")
+	})
+	t.Run("discarded result expression", func(t *testing.T) {
+		expectErrors(t, `let n: i32 = 1
+let result: i32 = {
+  n += 1;
+}`, "cannot use value of type () as i32")
+	})
+}
+
+func TestVarDeclAssign(t *testing.T) {
+	t.Run("use and reassignment", func(t *testing.T) {
+		expectErrors(t, "x := 1\nx = 2\nlet y: i32 = x + 1")
+	})
+	t.Run("inferred type", func(t *testing.T) {
+		expectErrors(t, "x := true\nlet n: i32 = x", "2:1: Type Error: type mismatch: variable n declared as i32 but initialized with bool")
+	})
+	t.Run("shadowing in an inner block", func(t *testing.T) {
+		expectErrors(t, `x := 1
+let s: string = {
+  x := "inner"
+  x
+}
+let n: i32 = x`)
+	})
+	t.Run("shadowed variable in the value", func(t *testing.T) {
+		expectErrors(t, `x := 1
+let b: bool = {
+  x := x == 1
+  x
+}`)
+	})
+	t.Run("use before the declaration", func(t *testing.T) {
+		expectErrors(t, "let y: i32 = x\nx := 1", "1:14: Resolve Error: undefined identifier: x")
+	})
+	t.Run("inner declaration doesn't leak", func(t *testing.T) {
+		expectErrors(t, "let n: i32 = {\n  x := 1\n  x\n}\nlet m: i32 = x", "5:14: Resolve Error: undefined identifier: x")
+	})
+}
+
+func TestWarnings(t *testing.T) {
+	src := `func f(n: i32): i32 {
+  n = n
+  if (true) then return n
+  return 0
+}`
+	t.Run("all warnings by default", func(t *testing.T) {
+		expectErrors(t, src,
+			"2:3: Semantic Warning: self-assignment of n [self-assign]",
+			"3:7: Semantic Warning: condition is always true [constant-condition]")
+	})
+	t.Run("only the enabled warning", func(t *testing.T) {
+		errors := CheckWithConfig(parser.Parse(lexer.Tokenize(src)), Config{Warnings: map[string]bool{WarnSelfAssign: true}})
+		if len(errors) != 1 || !strings.Contains(errors[0].String(), "[self-assign]") {
+			t.Errorf("Expected only the self-assign warning, got %q", errors)
+		}
+		if HasErrors(errors) {
+			t.Errorf("Expected no errors, got %q", errors)
+		}
+	})
+	t.Run("warnings as errors", func(t *testing.T) {
+		errors := CheckWithConfig(parser.Parse(lexer.Tokenize(src)), Config{WarningsAsErrors: true})
+		if len(errors) != 2 || !HasErrors(errors) || errors[0].String() != "2:3: Semantic Error: self-assignment of n [self-assign]" {
+			t.Errorf("Expected both warnings as errors, got %q", errors)
+		}
+	})
+	t.Run("all warnings disabled", func(t *testing.T) {
+		if errors := CheckWithConfig(parser.Parse(lexer.Tokenize(src)), Config{Warnings: map[string]bool{}}); len(errors) > 0 {
+			t.Errorf("Expected no warnings, got %q", errors)
+		}
+	})
+	t.Run("every warning is available", func(t *testing.T) {
+		available := AvailableWarnings()
+		if len(available) != len(DefaultWarnings()) {
+			t.Errorf("Expected %d available warnings, got %d", len(DefaultWarnings()), len(available))
+		}
+	})
+}
+
+func TestComparisonChaining(t *testing.T) {
+	const decls = "let a: i32 = 1\nlet b: i32 = 2\nlet c: i32 = 3\nlet flag: bool = true\n"
+	testCases := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{"chained less than", "let x: bool = a < b < c", []string{"5:21: Type Error: comparison operators cannot be chained"}},
+		{"chained equality", "let x: bool = a == b == flag", []string{"5:22: Type Error: comparison operators cannot be chained"}},
+		{"chained not-equals", "let x: bool = a != b != flag", []string{"5:22: Type Error: comparison operators cannot be chained"}},
+		{"parenthesized comparison in a chain", "let x: bool = flag == (a < b) == flag", []string{"5:31: Type Error: comparison operators cannot be chained"}},
+		{"parenthesized comparison", "let x: bool = (a < b) == flag", nil},
+		{"conjunction of comparisons", "let x: bool = a < b and b < c", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectErrors(t, decls+tc.src, tc.expected...)
+		})
+	}
+}
+
+func TestAssignmentCondition(t *testing.T) {
+	const decls = "let flag: bool = true\nlet other: bool = false\n"
+	t.Run("assignment as the condition of an if- statement", func(t *testing.T) {
+		expectErrors(t, decls+"if flag = other then flag = true",
+			"3:4: Semantic Warning: assignment used as a condition, did you mean ==? [assign-in-condition]")
+	})
+	t.Run("parenthesized assignment as the condition of an if- expression", func(t *testing.T) {
+		expectErrors(t, decls+"let n: i32 = if (flag = other) then 1 else 2",
+			"3:18: Semantic Warning: assignment used as a condition, did you mean ==? [assign-in-condition]")
+	})
+	t.Run("assignment as the condition of a for loop", func(t *testing.T) {
+		expectErrors(t, decls+"for (let i: i32 = 0; flag = other; i += 1) {\n}",
+			"3:22: Semantic Warning: assignment used as a condition, did you mean ==? [assign-in-condition]")
+	})
+	t.Run("comparison", func(t *testing.T) {
+		expectErrors(t, decls+"if flag == other then flag = true")
+	})
+	t.Run("nested assignment", func(t *testing.T) {
+		expectErrors(t, decls+"func f(b: bool): bool {\n  return b\n}\nif f(flag = other) then flag = true")
+	})
+}
+
+func TestUnusedResult(t *testing.T) {
+	t.Run("discarded arithmetic", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32, y: i32) {\n  x + y\n  g()\n}\nfunc g() {}",
+			"2:3: Semantic Warning: expression result is unused [unused-result]")
+	})
+	t.Run("discarded with an explicit semicolon", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32) {\n  (-x);\n}", "2:3: Semantic Warning: expression result is unused [unused-result]")
+	})
+	t.Run("discarded in a block expression", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = {\n  1\n  2\n}", "2:3: Semantic Warning: expression result is unused [unused-result]")
+	})
+	t.Run("trailing call", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32) {\n  x = 2\n  g()\n}\nfunc g() {}")
+	})
+	t.Run("last expression of a block", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32) {\n  g()\n  x * 2\n}\nfunc g() {}")
+	})
+}
+
+func TestDestructuringVarDecl(t *testing.T) {
+	divmod := "func divmod(a: i32, b: i32): (i32, i32) {\n  return (a / b, a % b)\n}\n"
+	t.Run("tuple return destructured", func(t *testing.T) {
+		expectErrors(t, divmod+"func f(): i32 {\n  let (q: i32, r: i32) = divmod(7, 2)\n  return q + r\n}")
+	})
+	t.Run("arity mismatch", func(t *testing.T) {
+		expectErrors(t, divmod+"let (q: i32, r: i32, s: i32) = divmod(7, 2)",
+			"4:1: Type Error: cannot destructure (i32, i32) into 3 variables")
+	})
+	t.Run("element type mismatch", func(t *testing.T) {
+		expectErrors(t, divmod+"let (q: i32, r: bool) = divmod(7, 2)",
+			"4:14: Type Error: type mismatch: variable r declared as bool but destructured from i32")
+	})
+	t.Run("non-tuple value", func(t *testing.T) {
+		expectErrors(t, "let (q: i32, r: i32) = 5",
+			"1:24: Type Error: cannot destructure non-tuple type i32")
+	})
+	t.Run("missing type", func(t *testing.T) {
+		expectErrors(t, divmod+"let (q, r: i32) = divmod(7, 2)",
+			"4:6: Resolve Error: missing type of destructured variable q")
+	})
+}
+
+func TestFoldConstants(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src      string
+		expected string
+	}{
+		{"arithmetic", "let x: i32 = 2 + 3 * 4", "let x: i32 = 14\n"},
+		{"negative result", "let x: i32 = (1 - 8) / 2", "let x: i32 = -3\n"},
+		{"remainder of a negative dividend", "let x: i32 = (-7) % 3", "let x: i32 = -1\n"},
+		{"ascribed type is kept", "let x: i64 = (3000000000: i64) * (2: i64)", "let x: i64 = (6000000000: i64)\n"},
+		{"comparison", "let b: bool = 2 * 3 > 5", "let b: bool = true\n"},
+		{"string concatenation", `let s: string = "Hello, " + "\"World\"!"`, `let s: string = "Hello, \"World\"!"` + "\n"},
+		{"raw string concatenation", "let s: string = `\"a\" \\n\n` + \"b\"", `let s: string = "\"a\" \\n\nb"` + "\n"},
+		{"string ordering", `let b: bool = "apple" < "apples"`, "let b: bool = true\n"},
+		{"floating point arithmetic", "let x: f64 = (1.5: f64) * (4: f64) - (0.5: f64)", "let x: f64 = (5.5: f64)\n"},
+		{"floating point division by zero unfolded", "let x: f64 = (1.5: f64) / (0: f64)", "let x: f64 = (1.5: f64) / (0: f64)\n"},
+		{"literals as written", "let x: i32 = 0x10\nlet y: i8 = (-5: i8)", "let x: i32 = 0x10\nlet y: i8 = (-5: i8)\n"},
+		{
+			"non-constant operands untouched",
+			"func f(n: i32): i32 {\n  return n * (2 + 3) - n + 4 * 5\n}",
+			"func f(n: i32): i32 {\n\treturn n * 5 - n + 20\n}\n",
+		},
+		{
+			"nested in statements",
+			"func f(n: i32): i32 {\n  if 1 < 2 then return (n + 10 / 5)\n  return -(2 + 3)\n}",
+			"func f(n: i32): i32 {\n\tif true then return (n + 2)\n\treturn -5\n}\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			module := parser.Parse(lexer.Tokenize(tc.src))
+			literalTypes, _, errors := CheckModule(module, Config{})
+			if HasErrors(errors) {
+				t.Fatalf("Type checking failed: %q", errors)
+			}
+			if errors := FoldConstants(module, literalTypes); len(errors) > 0 {
+				t.Fatalf("Expected no errors, got %q", errors)
+			}
+			if folded := ast.Print(module); folded != tc.expected {
+				t.Errorf("Expected the folded module %q, got %q", tc.expected, folded)
+			}
+			// Folding again changes nothing
+			refolded := parser.Parse(lexer.Tokenize(tc.src))
+			FoldConstants(refolded, nil)
+			FoldConstants(refolded, nil)
+			if diff := ast.Diff(module, refolded); diff != "" {
+				t.Errorf("Folding is not idempotent: %s", diff)
+			}
+		})
+	}
+	t.Run("folded AST shape", func(t *testing.T) {
+		module := parser.Parse(lexer.Tokenize("let x: i64 = (5: i64) - (7: i64) * (2: i64)"))
+		FoldConstants(module, nil)
+		expected := &ast.AscriptionExpr{
+			Expr: &ast.UnaryExpr{
+				Operator: lexer.Token{Type: lexer.DASH, Value: "-"},
+				Rhs:      &ast.NumberLiteralExpr{Value: "9"},
+			},
+			Type: &ast.NamedTypeExpr{TypeName: "i64"},
+		}
+		if diff := ast.Diff(expected, module.Statements[0].(*ast.VarDeclStmt).InitVal); diff != "" {
+			t.Errorf("Unexpected folded expression: %s", diff)
+		}
+	})
+
+	errorCases := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{"division by zero", "let x: i32 = 1 + 10 / (5 - 5)", []string{"1:18: Fold Error: division by zero"}},
+		{"remainder by zero", "func f(n: i32): i32 {\n  return n + 3 % (2 - 2)\n}", []string{"2:14: Fold Error: division by zero"}},
+		{"overflowing literal", "let x: i32 = 2147483648 - 1", []string{"1:14: Fold Error: literal 2147483648 overflows i32"}},
+		{"overflowing sum", "let x: i32 = 2147483647 + 1", []string{"1:14: Fold Error: constant 2147483648 overflows i32"}},
+		{"overflowing fixed-width literal", "let x: i8 = (100: i8) + (28: i8)", []string{"1:13: Fold Error: constant 128 overflows i8"}},
+		{"reported once", "let x: i32 = (1 / (1 - 1)) * 2 + 3", []string{"1:15: Fold Error: division by zero"}},
+	}
+	// The folder reports the errors on its own, also those caught by the type checker first, like a division
+	// by a constant zero, so the modules are not type checked
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			module := parser.Parse(lexer.Tokenize(tc.src))
+			errors := FoldConstants(module, nil)
+			if len(errors) != len(tc.expected) {
+				t.Fatalf("Expected %d errors, got %q", len(tc.expected), errors)
+			}
+			for i, err := range errors {
+				if err.String() != tc.expected[i] {
+					t.Errorf("Expected error %q, got %q", tc.expected[i], err)
+				}
+			}
+		})
+	}
+	t.Run("smallest i32", func(t *testing.T) {
+		module := parser.Parse(lexer.Tokenize("let x: i32 = -2147483648 + 0"))
+		if errors := FoldConstants(module, nil); len(errors) > 0 {
+			t.Errorf("Expected no errors, got %q", errors)
+		}
+	})
+}
+
+func TestSignedIntegerBoundaries(t *testing.T) {
+	testCases := []struct {
+		typeName string
+		min      string
+		max      string
+		belowMin string
+		aboveMax string
+	}{
+		{"i8", "128", "127", "129", "128"},
+		{"i32", "2147483648", "2147483647", "2147483649", "2147483648"},
+		{"i64", "9223372036854775808", "9223372036854775807", "9223372036854775809", "9223372036854775808"},
+	}
+	// An ascribed literal is range checked by the type checker, and the folder checks anything else
+	fold := func(t *testing.T, typeName string, value string) []Diagnostic {
+		src := fmt.Sprintf("let x: %s = (%s: %s)", typeName, value, typeName)
+		module := parser.Parse(lexer.Tokenize(src))
+		literalTypes, _, errors := CheckModule(module, Config{})
+		if HasErrors(errors) {
+			return errors
+		}
+		return FoldConstants(module, literalTypes)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.typeName, func(t *testing.T) {
+			for _, value := range []string{"-" + tc.min, "-(" + tc.min + ")", tc.max} {
+				if errors := fold(t, tc.typeName, value); len(errors) > 0 {
+					t.Errorf("Expected %s to fit %s, got %q", value, tc.typeName, errors)
+				}
+			}
+			for _, value := range []string{"-" + tc.belowMin, tc.aboveMax, "-(-" + tc.min + ")"} {
+				errors := fold(t, tc.typeName, value)
+				if len(errors) != 1 || !strings.Contains(errors[0].Message, "overflows "+tc.typeName) {
+					t.Errorf("Expected %s to overflow %s, got %q", value, tc.typeName, errors)
+				}
+			}
+		})
+	}
+}
+
+func TestEvalConst(t *testing.T) {
+	parse := func(src string) ast.Expr {
+		return parser.Parse(lexer.Tokenize(src)).Statements[0].(*ast.ExpressionStmt).Expr
+	}
+	// The parser has no logical operators yet
+	logical := func(lhs bool, operator lexer.TokenType, rhs bool) ast.Expr {
+		return &ast.BinaryExpr{
+			Lhs:      &ast.BoolLiteralExpr{Value: lhs},
+			Operator: lexer.Token{Type: operator},
+			Rhs:      &ast.BoolLiteralExpr{Value: rhs},
+		}
+	}
+	testCases := []struct {
+		name     string
+		expr     ast.Expr
+		expected string // The value printed as a literal
+	}{
+		{"addition", parse("2 + 3"), "5"},
+		{"subtraction", parse("2 - 3"), "-1"},
+		{"multiplication", parse("-2 * 3"), "-6"},
+		{"division", parse("-7 / 2"), "-3"},
+		{"remainder", parse("-7 % 2"), "-1"},
+		{"exponentiation", parse("2 ^ 10"), "1024"},
+		{"fixed-width integers", parse("(100: i64) * (100: i64)"), "(10000: i64)"},
+		{"less", parse("1 < 2"), "true"},
+		{"less or equal", parse("2 <= 1"), "false"},
+		{"greater", parse("(2: i8) > (1: i8)"), "true"},
+		{"greater or equal", parse("1 >= 1"), "true"},
+		{"equal", parse("0x10 == 16"), "true"},
+		{"not equal", parse("0b10 != 2"), "false"},
+		{"and", logical(true, lexer.AND, false), "false"},
+		{"or", logical(true, lexer.OR, false), "true"},
+		{"not", &ast.UnaryExpr{Operator: lexer.Token{Type: lexer.NOT}, Rhs: &ast.BoolLiteralExpr{Value: true}}, "false"},
+		{"floating point", parse("(1.5: f64) * (4: f64) - (0.5e1: f64)"), "(1.0: f64)"},
+		{"floating point comparison", parse("(0.1: f32) < (0.2: f32)"), "true"},
+		{"single precision", parse("(1: f32) / (3: f32)"), "(0.33333334: f32)"},
+		{"string concatenation", parse(`"con" + "cat"`), `"concat"`},
+		{"string comparison", parse(`"a" < "b"`), "true"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := evalConst(tc.expr, "i32")
+			if err != nil {
+				t.Fatalf("Expected %s, got %v", tc.expected, err)
+			}
+			if printed := ast.Print(value.literal(lexer.SrcPos{})); printed != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, printed)
+			}
+		})
+	}
+
+	errorCases := []struct {
+		name     string
+		expr     ast.Expr
+		expected string
+	}{
+		{"overflow", parse("2147483647 + 1"), "constant 2147483648 overflows i32"},
+		{"fixed-width overflow", parse("(-128: i8) - (1: i8)"), "constant -129 overflows i8"},
+		{"division by zero", parse("1 / (2 - 2)"), "division by zero"},
+		{"non-constant", parse("x + 1"), "not a constant expression"},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if value, err := evalConst(tc.expr, "i32"); err == nil || err.Error() != tc.expected {
+				t.Errorf("Expected the error %q, got %v, %v", tc.expected, value, err)
+			}
+		})
+	}
+}
+
+func TestLiteralWidths(t *testing.T) {
+	testCases := []struct {
+		typeName string
+		fits     []string
+		overflow map[string]string // The overflowing literals, and their values in the error message
+	}{
+		{"i8", []string{"-128", "127", "0x7F", "-(0x80)", "0b0111_1111"}, map[string]string{"-129": "-129", "128": "128", "0x80": "128", "0b1111_1111": "255"}},
+		{"i32", []string{"-2147483648", "2147483647", "0x7FFF_FFFF"}, map[string]string{"-2147483649": "-2147483649", "2147483648": "2147483648", "0x8000_0000": "2147483648"}},
+		{"i64", []string{"-9223372036854775808", "9223372036854775807"}, map[string]string{"-9223372036854775809": "-9223372036854775809", "9223372036854775808": "9223372036854775808"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.typeName, func(t *testing.T) {
+			// The literals are also checked when ascribed the type, or matched against a value of the type
+			matchSrc := "let x: %s = 0\nlet r: i32 = match x {\n  %s => 1,\n  _ => 0,\n}"
+			for _, literal := range tc.fits {
+				expectErrors(t, fmt.Sprintf("let x: %s = %s\nx = %s", tc.typeName, literal, literal))
+				expectErrors(t, fmt.Sprintf("x := (%s: %s)", literal, tc.typeName))
+				if !strings.Contains(literal, "(") {
+					expectErrors(t, fmt.Sprintf(matchSrc, tc.typeName, literal))
+				}
+			}
+			for literal, value := range tc.overflow {
+				expected := fmt.Sprintf("Type Error: literal %s overflows %s", value, tc.typeName)
+				expectErrors(t, fmt.Sprintf("let x: %s = %s", tc.typeName, literal), expected)
+				expectErrors(t, fmt.Sprintf("let x: %s\nx = %s", tc.typeName, literal), expected)
+				expectErrors(t, fmt.Sprintf("x := (%s: %s)", literal, tc.typeName), "1:7: "+expected)
+				expectErrors(t, fmt.Sprintf(matchSrc, tc.typeName, literal), "3:3: "+expected)
+			}
+		})
+	}
+	t.Run("folded with the pinned type", func(t *testing.T) {
+		src := "let x: i64 = 3000000000\nlet y: i8 = -128\nx = -3000000000\nlet r: i32 = match x {\n  3000000000 => 1,\n  _ => 0,\n}"
+		module := parser.Parse(lexer.Tokenize(src))
+		literalTypes, _, errors := CheckModule(module, Config{})
+		if len(errors) > 0 {
+			t.Fatalf("Expected no errors, got %q", errors)
+		}
+		// The type checker leaves the literals as they are
+		if diff := ast.Diff(parser.Parse(lexer.Tokenize(src)), module); diff != "" {
+			t.Errorf("Expected the AST not to change: %s", diff)
+		}
+		if errors := FoldConstants(module, literalTypes); len(errors) > 0 {
+			t.Errorf("Expected no errors, got %q", errors)
+		}
+	})
+}
+
+func TestRemainder(t *testing.T) {
+	decls := "let i: i64 = 7\nlet f: f64 = 3.0\n"
+	t.Run("integers", func(t *testing.T) {
+		expectErrors(t, decls+"let r: i64 = i % (2: i64)\ni %= (2: i64)\nlet n: i32 = 7 % 3")
+	})
+	t.Run("floats", func(t *testing.T) {
+		expectErrors(t, decls+"let r: f64 = f % f", "3:16: Type Error: operator % requires integer operands, found f64 and f64")
+	})
+	t.Run("mixed", func(t *testing.T) {
+		expectErrors(t, decls+"let r: i64 = i % f", "3:16: Type Error: operator % requires integer operands, found i64 and f64")
+	})
+	t.Run("compound assignment of floats", func(t *testing.T) {
+		expectErrors(t, decls+"f %= f", "3:3: Type Error: operator %= requires integer operands, found f64 and f64")
+	})
+	t.Run("compound assignment of a string", func(t *testing.T) {
+		expectErrors(t, decls+"let s: string = \"a\"\ns %= 2", "4:3: Type Error: invalid operands for %=: string and i32")
+	})
+	t.Run("other compound assignments", func(t *testing.T) {
+		expectErrors(t, decls+"f *= f\nf /= f\ni *= true", "5:3: Type Error: invalid operands for *=: i64 and bool")
+	})
+}
+
+func TestMultipleReturnValues(t *testing.T) {
+	decls := `func divmod(n: i32, d: i32): (i32, i32) {
+  return n / d, n % d
+}
+`
+	t.Run("two values", func(t *testing.T) {
+		expectErrors(t, decls+"let (q: i32, r: i32) = divmod(7, 2)\nlet pair: (i32, i32) = divmod(9, 4)")
+	})
+	t.Run("passing a tuple", func(t *testing.T) {
+		expectErrors(t, decls+"func sum(pair: (i32, i32)): i32 {\n  let (a: i32, b: i32) = pair\n  return a + b\n}\nlet s: i32 = sum(divmod(7, 2))")
+	})
+	t.Run("arity mismatch", func(t *testing.T) {
+		expectErrors(t, "func f(): (i32, i32) {\n  return 1, 2, 3\n}", "2:10: Type Error: return type mismatch: expected (i32, i32), found (i32, i32, i32)")
+	})
+	t.Run("destructuring arity mismatch", func(t *testing.T) {
+		expectErrors(t, decls+"let (a: i32, b: i32, c: i32) = divmod(7, 2)", "4:1: Type Error: cannot destructure (i32, i32) into 3 variables")
+	})
+}
+
+func TestConditionalExpr(t *testing.T) {
+	t.Run("nested", func(t *testing.T) {
+		expectErrors(t, "let n: i32 = 5\nlet sign: i32 = n < 0 ? -1 : n > 0 ? 1 : 0")
+	})
+	t.Run("non-bool condition", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = 1 ? 2 : 3", "1:14: Type Error: if- expression condition does not evaluate to a boolean type")
+	})
+	t.Run("branch mismatch", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = true ? 2 : false", "1:25: Type Error: if- expression branches have different types: i32 and bool")
+	})
+}
+
+func TestCasts(t *testing.T) {
+	t.Run("widening", func(t *testing.T) {
+		expectErrors(t, "func f(x: i8, y: i32): f64 {\n  let z: i64 = x as i64 + y as i64\n  return z as f64\n}")
+	})
+	t.Run("narrowing", func(t *testing.T) {
+		expectErrors(t, "func f(x: i64): i32 {\n  return x as i32\n}",
+			"2:10: Semantic Warning: cast from i64 to i32 may truncate the value [narrowing-cast]")
+	})
+	t.Run("floating point to integer", func(t *testing.T) {
+		expectErrors(t, "func f(x: f32): i64 {\n  return x as i64\n}",
+			"2:10: Semantic Warning: cast from f32 to i64 may truncate the value [narrowing-cast]")
+	})
+	t.Run("to the same type", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32): i32 {\n  return x as i32\n}")
+	})
+	t.Run("struct to integer", func(t *testing.T) {
+		expectErrors(t, "struct Point {\n  x: i32,\n}\nlet p: Point = Point{ x: 1 }\nlet x: i32 = p as i32",
+			"5:14: Type Error: cannot cast Point to i32")
+	})
+	t.Run("bool to integer", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = true as i32", "1:14: Type Error: cannot cast bool to i32")
+	})
+}
+
+func TestFixedSizeArrays(t *testing.T) {
+	t.Run("constant size", func(t *testing.T) {
+		expectErrors(t, "let a: i32[4]\nlet b: i32[2 * 2] = a\nlet x: i32 = b[3] + b.length")
+	})
+	t.Run("mismatched sizes", func(t *testing.T) {
+		expectErrors(t, "let a: i32[4]\nlet b: i32[3] = a",
+			"2:1: Type Error: type mismatch: variable b declared as i32[3] but initialized with i32[4]")
+	})
+	t.Run("fixed-size to dynamic", func(t *testing.T) {
+		expectErrors(t, "let a: i32[4]\nlet b: i32[] = a",
+			"2:1: Type Error: type mismatch: variable b declared as i32[] but initialized with i32[4]")
+	})
+	t.Run("index out of bounds", func(t *testing.T) {
+		expectErrors(t, "let a: i32[4]\nlet x: i32 = a[4]\nlet y: i32 = a[-1]",
+			"2:16: Semantic Warning: index 4 is out of bounds for i32[4] [index-out-of-bounds]",
+			"3:16: Semantic Warning: index -1 is out of bounds for i32[4] [index-out-of-bounds]")
+	})
+	t.Run("non-constant size", func(t *testing.T) {
+		expectErrors(t, "let n: i32 = 4\nlet a: i32[n]", "2:12: Resolve Error: invalid array size: not a constant expression")
+	})
+	t.Run("non-positive size", func(t *testing.T) {
+		expectErrors(t, "let a: i32[0]", "1:12: Resolve Error: array size must be positive, found 0")
+	})
+	t.Run("non-integer size", func(t *testing.T) {
+		expectErrors(t, "let a: i32[true]", "1:12: Resolve Error: array size must be an integer, found bool")
+	})
+}
+
+func TestUnitValues(t *testing.T) {
+	decls := "func log() {}\nfunc inc(x: i32): i32 { return x + 1 }\nstruct Point {\n  x: i32,\n}\n"
+	t.Run("variable initialized with", func(t *testing.T) {
+		expectErrors(t, decls+"let x: i32 = log()", "6:14: Type Error: cannot use value of type () as i32")
+	})
+	t.Run("variable declared with :=", func(t *testing.T) {
+		expectErrors(t, decls+"x := log()", "6:6: Type Error: cannot use value of type () to initialize variable x")
+	})
+	t.Run("assigned", func(t *testing.T) {
+		expectErrors(t, decls+"let x: i32 = 0\nx = log()", "7:5: Type Error: cannot use value of type () as i32")
+	})
+	t.Run("passed as an argument", func(t *testing.T) {
+		expectErrors(t, decls+"let x: i32 = inc(log())", "6:18: Type Error: cannot use value of type () as i32")
+	})
+	t.Run("returned", func(t *testing.T) {
+		expectErrors(t, decls+"func f(): i32 { return log() }", "6:24: Type Error: cannot use value of type () as i32")
+	})
+	t.Run("struct member", func(t *testing.T) {
+		expectErrors(t, decls+"let p: Point = Point{ x: log() }", "6:26: Type Error: cannot use value of type () as i32")
+	})
+	t.Run("called as a statement", func(t *testing.T) {
+		expectErrors(t, decls+"log()")
+	})
+}
+
+func TestMisspelledMembers(t *testing.T) {
+	decls := "struct Point {\n  x: i32,\n  y: i32,\n}\nlet p: Point = Point{ x: 1, y: 2 }\nfunc f(a: i32, b: i32) {}\n"
+	t.Run("in a literal", func(t *testing.T) {
+		expectErrors(t, decls+"let q: Point = Point{ x: 1, yy: 2 + true, y: 3 }",
+			"7:29: Type Error: yy is not a member of struct Point",
+			"7:35: Type Error: invalid operands for +: i32 and bool")
+	})
+	t.Run("in a read", func(t *testing.T) {
+		expectErrors(t, decls+"let a: i32 = p.xx + (1 + true)",
+			"7:16: Type Error: xx is not a member of struct Point",
+			"7:24: Type Error: invalid operands for +: i32 and bool")
+	})
+	t.Run("in an argument", func(t *testing.T) {
+		expectErrors(t, decls+"f(p.xx, 1 + true)",
+			"7:5: Type Error: xx is not a member of struct Point",
+			"7:11: Type Error: invalid operands for +: i32 and bool")
+	})
+	t.Run("in an assignment target", func(t *testing.T) {
+		expectErrors(t, decls+"p.xx = 3", "7:3: Type Error: xx is not a member of struct Point")
+	})
+	t.Run("in a compound assignment target", func(t *testing.T) {
+		expectErrors(t, decls+"p.xx += p.yy",
+			"7:3: Type Error: xx is not a member of struct Point",
+			"7:11: Type Error: yy is not a member of struct Point")
+	})
+}
+
+func TestCheckValue(t *testing.T) {
+	testCases := []struct {
+		src      string
+		expected string
+	}{
+		{"let x: i32 = 1\nx < 2", "bool"},
+		{"func f(): i64 { return (1: i64) }\nf()", "i64"},
+		{"let x: i32 = 1", "<nil>"},
+		{"x + 1", "<nil>"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			valueType, _ := CheckValue(parser.Parse(lexer.Tokenize(tc.src)), Config{})
+			if fmt.Sprint(valueType) != tc.expected {
+				t.Errorf("Expected the type %s, got %v", tc.expected, valueType)
+			}
+		})
+	}
+}
+
+func TestEmptyStruct(t *testing.T) {
+	decls := "struct Marker {}\nfunc mark(m: Marker): Marker { return m }\n"
+	t.Run("constructed and used", func(t *testing.T) {
+		expectErrors(t, decls+"let m: Marker = mark(Marker{})\nlet same: bool = m == Marker{ }")
+	})
+	t.Run("literal with a member", func(t *testing.T) {
+		expectErrors(t, decls+"let m: Marker = Marker{ x: 1 }", "3:25: Type Error: x is not a member of struct Marker")
+	})
+	t.Run("member read", func(t *testing.T) {
+		expectErrors(t, decls+"let m: Marker = Marker{}\nlet x: i32 = m.x", "4:16: Type Error: x is not a member of struct Marker")
+	})
+	t.Run("distinct from another empty struct", func(t *testing.T) {
+		expectErrors(t, decls+"struct Other {}\nlet m: Marker = Other{}",
+			"4:1: Type Error: type mismatch: variable m declared as Marker but initialized with Other")
+	})
+}
+
+func TestArgumentCount(t *testing.T) {
+	add := "func add(x: i32, y: i32): i32 { return x + y }\n"
+	t.Run("too many arguments", func(t *testing.T) {
+		expectErrors(t, add+"let z: i32 = add(1, 2, 3 * 4, 5)",
+			"2:24: Type Error: too many arguments, expected 2, found 4")
+	})
+	t.Run("not enough arguments", func(t *testing.T) {
+		expectErrors(t, add+"let z: i32 = add(1 )",
+			"2:20: Type Error: not enough arguments, expected 2, found 1")
+	})
+	t.Run("no arguments", func(t *testing.T) {
+		expectErrors(t, add+"let z: i32 = add(\n)",
+			"3:1: Type Error: not enough arguments, expected 2, found 0")
+	})
+	t.Run("matching arguments", func(t *testing.T) {
+		expectErrors(t, add+"let z: i32 = add(1, 2)")
+	})
+}
+
+func TestDivisionByZero(t *testing.T) {
+	t.Run("division", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32): i32 {\n  return x / 0\n}", "2:14: Type Error: division by zero")
+	})
+	t.Run("modulo", func(t *testing.T) {
+		expectErrors(t, "func f(x: i64): i64 {\n  return x % (0x0)\n}", "2:14: Type Error: division by zero")
+	})
+	t.Run("binary literal", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = 7 / 0b00", "1:18: Type Error: division by zero")
+	})
+	t.Run("constant expression", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32): i32 {\n  return x % (2 * 3 - 6)\n}", "2:14: Type Error: division by zero")
+	})
+	t.Run("non-zero divisor", func(t *testing.T) {
+		expectErrors(t, "func f(x: i32): i32 {\n  return x / 10 + x % 0x10\n}")
+	})
+	t.Run("floating point division", func(t *testing.T) {
+		expectErrors(t, "func f(x: f64): f64 {\n  return x / (0: f64)\n}")
+	})
+}
+
+func TestAssignmentTarget(t *testing.T) {
+	decls := "struct Point {\n  x: i32,\n}\nfunc foo(): i32 { return 1 }\nlet p: Point = Point{ x: 1, }\nlet arr: i32[]\nlet x: i32 = 0\n"
+	illegal := []struct {
+		name   string
+		target string
+	}{
+		{"number literal", "5 = x"},
+		{"function call", "foo() = 3"},
+		{"binary expression", "x + 1 = 3"},
+		{"group", "(x) = 3"},
+		{"compound assignment to a literal", "5 += x"},
+		{"compound assignment to a call", "foo() -= 1"},
+	}
+	for _, tc := range illegal {
+		t.Run(tc.name, func(t *testing.T) {
+			expectErrors(t, decls+tc.target, "8:1: Type Error: cannot assign to non-lvalue expression")
+		})
+	}
+	legal := []struct {
+		name   string
+		target string
+	}{
+		{"variable", "x = 3"},
+		{"struct member", "p.x = 3"},
+		{"array element", "arr[0] = 3"},
+		{"compound assignment to a variable", "x += 3"},
+		{"compound assignment to a struct member", "p.x -= 3"},
+		{"compound assignment to an array element", "arr[x] += 3"},
+	}
+	for _, tc := range legal {
+		t.Run(tc.name, func(t *testing.T) {
+			expectErrors(t, decls+tc.target)
+		})
+	}
+}
+
+// Assignments through members and elements, nested in any combination, with all the operators
+func TestCompoundAssignmentTargets(t *testing.T) {
+	const decls = `struct C {
+  c: i32,
+  s: string,
+  b: bool,
+}
+struct B {
+  b: C,
+}
+struct A {
+  b: B,
+}
+`
+	t.Run("member", func(t *testing.T) {
+		expectErrors(t, "struct P {\n  x: i32,\n}\nfunc f(p: P) {\n  p.x += 1\n  p.x -= 1\n  p.x *= 2\n  p.x /= 2\n  p.x %= 2\n}")
+	})
+	t.Run("element", func(t *testing.T) {
+		expectErrors(t, "func f(arr: i32[4], i: i32) {\n  arr[0] = 5\n  arr[i] += arr[0]\n  arr[i + 1] %= 2\n}")
+	})
+	t.Run("chained members", func(t *testing.T) {
+		expectErrors(t, decls+"func f(a: A, x: i32) {\n  a.b.b.c = x\n  a.b.b.c += x\n  a.b.b.s += \"x\"\n}")
+	})
+	t.Run("non-numeric member", func(t *testing.T) {
+		expectErrors(t, decls+"func f(a: A) {\n  a.b.b.b += true\n  a.b.b.s -= \"x\"\n}",
+			"13:11: Type Error: invalid operands for +=: bool and bool",
+			"14:11: Type Error: invalid operands for -=: string and string")
+	})
+	t.Run("undefined names in the target", func(t *testing.T) {
+		expectErrors(t, "func f(arr: i32[4]) {\n  arr[i] += 1\n  q.x = 1\n}",
+			"2:7: Resolve Error: undefined identifier: i",
+			"3:3: Resolve Error: undefined identifier: q")
+	})
+	t.Run("member of an immutable variable", func(t *testing.T) {
+		expectErrors(t, decls+"const a: A = A{ b: B{ b: C{ c: 1, s: \"\", b: true, }, }, }\na.b.b.c += 1",
+			"13:1: Type Error: cannot assign to immutable member of variable a")
+	})
+}
+
+func TestStructMemberAssignment(t *testing.T) {
+	decls := "struct Point {\n  x: i32,\n}\nlet y: i32 = 0\n"
+	t.Run("assignment", func(t *testing.T) {
+		expectErrors(t, decls+"let p: Point = Point{ x: y = 5, }",
+			"5:26: Semantic Error: assignment in the value of struct member x")
+	})
+	t.Run("parenthesized assignment", func(t *testing.T) {
+		expectErrors(t, decls+"let p: Point = Point{ x: ((y += 5)), }",
+			"5:28: Semantic Error: assignment in the value of struct member x")
+	})
+	t.Run("pure expression", func(t *testing.T) {
+		expectErrors(t, decls+"let p: Point = Point{ x: (y + 5), }")
+	})
+}
+
+func TestLoopExpr(t *testing.T) {
+	t.Run("consistent break types", func(t *testing.T) {
+		expectErrors(t, "func f(n: i32): i32 {\n  let x: i32 = loop {\n    if n > 0 then { break n }\n    break 0\n  }\n  return x\n}")
+	})
+	t.Run("inconsistent break types", func(t *testing.T) {
+		expectErrors(t, "func f(n: i32): i32 {\n  let x: i32 = loop {\n    if n > 0 then { break n }\n    break true\n  }\n  return x\n}",
+			"4:5: Type Error: break value type mismatch: expected i32, found bool")
+	})
+	t.Run("loop type", func(t *testing.T) {
+		expectErrors(t, "let x: bool = loop { break 1 }",
+			"1:1: Type Error: type mismatch: variable x declared as bool but initialized with i32")
+	})
+	t.Run("nested loops", func(t *testing.T) {
+		expectErrors(t, "let x: bool = loop {\n  let y: i32 = loop { break 1 }\n  break true\n}")
+	})
+	t.Run("break outside of a loop", func(t *testing.T) {
+		expectErrors(t, "func f() {\n  break\n}", "2:3: Type Error: break statement outside of a loop")
+	})
+	t.Run("break out of a function", func(t *testing.T) {
+		expectErrors(t, "let x: i32 = loop {\n  func f() { break 1 }\n  break 2\n}", "2:14: Type Error: break statement outside of a loop")
+	})
+	t.Run("break with a value out of a for- loop", func(t *testing.T) {
+		expectErrors(t, "for (let i: i32 = 0; i < 3; i += 1) {\n  break i\n}",
+			"2:9: Type Error: cannot break with a value out of a for- loop")
+	})
+}
+
+func TestMatchExpr(t *testing.T) {
+	t.Run("common arm type", func(t *testing.T) {
+		expectErrors(t, "func f(n: i32): string {\n  return match n {\n    0 => \"zero\",\n    -1 => \"minus one\",\n    _ => \"other\",\n  }\n}")
+	})
+	t.Run("arm type mismatch", func(t *testing.T) {
+		expectErrors(t, "func f(n: i32): string {\n  return match n {\n    0 => \"zero\",\n    _ => n,\n  }\n}",
+			"4:10: Type Error: match arm type mismatch: expected string, found i32")
+	})
+	t.Run("pattern type mismatch", func(t *testing.T) {
+		expectErrors(t, "func f(s: string): i32 {\n  return match s {\n    \"a\" => 1,\n    true => 2,\n    3 => 3,\n    _ => 0,\n  }\n}",
+			"4:5: Type Error: pattern type mismatch: expected string, found bool",
+			"5:5: Type Error: pattern type mismatch: expected string, found the number literal 3")
+	})
+	t.Run("binding", func(t *testing.T) {
+		expectErrors(t, "func f(n: i64): i64 {\n  return match n {\n    0 => n,\n    m => m * (2: i64),\n  }\n}")
+	})
+	t.Run("binding scope", func(t *testing.T) {
+		expectErrors(t, "func f(n: i32): i32 {\n  let x: i32 = match n {\n    m => m,\n  }\n  return m\n}",
+			"5:10: Resolve Error: undefined identifier: m")
+	})
+	t.Run("exhaustive bool", func(t *testing.T) {
+		expectErrors(t, "func f(b: bool): i32 {\n  return match b {\n    true => 1,\n    false => 0,\n  }\n}")
+	})
+	t.Run("non-exhaustive bool", func(t *testing.T) {
+		expectErrors(t, "func f(b: bool): i32 {\n  return match b {\n    true => 1,\n  }\n}",
+			"2:10: Type Error: non-exhaustive match over bool, missing false")
+	})
+	t.Run("non-exhaustive integer", func(t *testing.T) {
+		expectErrors(t, "func f(n: i32): i32 {\n  return match n {\n    0 => 1,\n    1 => 0,\n  }\n}",
+			"2:10: Type Error: non-exhaustive match over i32, missing a _ arm")
+	})
+}
+
+func TestBuiltinFuncs(t *testing.T) {
+	t.Run("print and println", func(t *testing.T) {
+		expectErrors(t, `let name: string = "World"
+print("Hello, ")
+println(name + "!")`)
+	})
+	t.Run("argument type mismatch", func(t *testing.T) {
+		expectErrors(t, "print(42)", "1:7: Type Error: argument 1 type mismatch: expected string, found i32")
+	})
+	t.Run("no value", func(t *testing.T) {
+		expectErrors(t, `let x: i32 = println("hi")`, "cannot use value of type () as i32")
+	})
+	t.Run("shadowed by a declared function", func(t *testing.T) {
+		expectErrors(t, `func print(n: i32): i32 {
+  return n
+}
+let x: i32 = print(42)`)
+	})
+}
+
+func TestParameterNames(t *testing.T) {
+	t.Run("distinct parameters", func(t *testing.T) {
+		expectErrors(t, `func f(x: i32, y: bool): i32 {
+  return x
+}
+let n: i32 = f(1, true)`)
+	})
+	t.Run("parameter shadowing the function", func(t *testing.T) {
+		expectErrors(t, `func f(f: i32): i32 {
+  return f + 1
+}
+let n: i32 = f(1)`)
+	})
+	t.Run("the first of duplicate parameters is kept", func(t *testing.T) {
+		expectErrors(t, `func f(x: i32, x: bool): i32 {
+  return x
+}`, "1:16: Resolve Error: duplicate parameter name x")
+	})
+}
+
+func TestForStmtScope(t *testing.T) {
+	t.Run("loop variable in the header and the body", func(t *testing.T) {
+		expectErrors(t, `let total: i32 = 0
+for (let i: i32 = 0; i < 3; i += 1) {
+  total += i
+}`)
+	})
+	t.Run("loop variable doesn't leak", func(t *testing.T) {
+		expectErrors(t, `for (let i: i32 = 0; i < 3; i += 1) {
+}
+let last: i32 = i`, "3:17: Resolve Error: undefined identifier: i")
+	})
+	t.Run("loop variable shadows", func(t *testing.T) {
+		expectErrors(t, `let i: bool = true
+for (let i: i32 = 0; i < 3; i += 1) {
+}
+let flag: bool = i`)
+	})
+	t.Run("consecutive loops", func(t *testing.T) {
+		expectErrors(t, `for (let i: i32 = 0; i < 3; i += 1) {
+}
+for (let i: i32 = 0; i < 3; i += 1) {
+}`)
+	})
+}
+
+// The resolver records the scope of each function by its declaration, which the type checker checks the
+// body in, so that the parameters are visible with their declared types
+func TestFuncScope(t *testing.T) {
+	module := parser.Parse(lexer.Tokenize("func f(x: bool): bool {\n  return x\n}"))
+	resolved := Resolve(module, defaultPrimitives(), nil)
+	funcScope, ok := resolved.Scopes[module.Statements[0]]
+	if !ok {
+		t.Fatal("Expected the scope of the function to be recorded")
+	}
+	if paramType, ok := funcScope.vars["x"]; !ok || !IsPrimitive(paramType, "bool") {
+		t.Errorf("Expected the parameter x: bool in the scope of the function, got %v", paramType)
+	}
+	if _, ok := resolved.RootScope.vars["x"]; ok {
+		t.Error("Expected the parameter not to be in the root scope")
+	}
+	t.Run("parameter used in the body", func(t *testing.T) {
+		expectErrors(t, "func f(x: bool): i32 {\n  return x\n}", "2:10: Type Error: return type mismatch: expected i32, found bool")
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	const point = "struct Point {\n  x: i32,\n}\n"
+	t.Run("variable named like a struct in the same scope", func(t *testing.T) {
+		expectErrors(t, point+"let Point: i32 = 1", "4:5: Resolve Error: Point is already declared as a struct in the same scope")
+	})
+	t.Run("function named like a struct in the same scope", func(t *testing.T) {
+		expectErrors(t, point+"func Point() {\n}", "4:1: Resolve Error: Point is already declared as a struct in the same scope")
+	})
+	t.Run("variable shadowing a struct as a value but not as a type", func(t *testing.T) {
+		expectErrors(t, point+`func f(Point: i32, p: Point): i32 {
+  return p.x + Point
+}
+let n: i32 = f(1, Point{ x: 2 })`)
+	})
+	t.Run("shadowed struct can't be constructed", func(t *testing.T) {
+		expectErrors(t, point+`func f(Point: i32): i32 {
+  let p: Point = Point{ x: 1 }
+  return p.x
+}`, "5:18: Type Error: expression of type i32 cannot be used as a struct")
+	})
+	t.Run("inner function shadowing an outer variable", func(t *testing.T) {
+		expectErrors(t, `let f: i32 = 1
+func g(): i32 {
+  func f(): i32 {
+    return 2
+  }
+  return f()
+}`)
+	})
+	t.Run("inner declarations shadowing outer ones", func(t *testing.T) {
+		expectErrors(t, point+`enum Color {
+  Red,
+}
+interface Shape {}
+func f(): i32 {
+  return 1
+}
+func g(): i32 {
+  struct Point {
+    y: i32,
+  }
+  enum Color {
+    Blue,
+  }
+  interface Shape {}
+  func f(): i32 {
+    return 2
+  }
+  let c: Color = Color.Blue
+  let p: Point = Point{ y: f() }
+  return p.y
+}`)
+	})
+	t.Run("variable named like a primitive type", func(t *testing.T) {
+		expectErrors(t, "let string: i32 = 1", "1:5: Resolve Error: cannot declare var string, which is the name of a primitive type")
+	})
+	t.Run("function named like a primitive type", func(t *testing.T) {
+		expectErrors(t, "func bool() {\n}", "1:1: Resolve Error: cannot declare func bool, which is the name of a primitive type")
+	})
+	t.Run(":= variable named like a primitive type", func(t *testing.T) {
+		expectErrors(t, "func f() {\n  i64 := 1\n}", "2:3: Resolve Error: cannot declare var i64, which is the name of a primitive type")
+	})
+}
+
+func TestImmutableVariables(t *testing.T) {
+	decls := "struct Point {\n  x: i32,\n}\nconst p: Point = Point{ x: 1, }\nlet v: i32[]\nconst arr: i32[] = v\nconst c: i32 = 0\n"
+	illegal := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{"constant", "c = 3", "8:1: Type Error: cannot assign to immutable variable c"},
+		{"compound assignment to a constant", "c += 3", "8:1: Type Error: cannot assign to immutable variable c"},
+		{"constant struct member", "p.x = 3", "8:1: Type Error: cannot assign to immutable member of variable p"},
+		{"constant array element", "arr[0] -= 3", "8:1: Type Error: cannot assign to immutable element of variable arr"},
+		{"redeclaration with :=", "c := 3", "8:1: Resolve Error: cannot redeclare immutable variable c with :="},
+	}
+	for _, tc := range illegal {
+		t.Run(tc.name, func(t *testing.T) {
+			expectErrors(t, decls+tc.target, tc.expected)
+		})
+	}
+	legal := []struct {
+		name   string
+		target string
+	}{
+		{"mutable variable", "v = arr"},
+		{"mutable array element", "v[0] += c"},
+		{"shadowing with a mutable variable", "func f() {\n  let c: i32 = 1\n  c = 2\n}"},
+		{"shadowing with :=", "func f() {\n  c := 1\n  c = 2\n}"},
+	}
+	for _, tc := range legal {
+		t.Run(tc.name, func(t *testing.T) {
+			expectErrors(t, decls+tc.target)
+		})
+	}
+	t.Run("immutable loop variable", func(t *testing.T) {
+		expectErrors(t, "for (const i: i32 = 0; i < 3; i += 1) {\n}", "1:31: Type Error: cannot assign to immutable variable i")
+	})
+	t.Run("destructured constants", func(t *testing.T) {
+		expectErrors(t, "const (a: i32, b: bool) = (1, true)\nb = false", "2:1: Type Error: cannot assign to immutable variable b")
+	})
+	t.Run("missing value", func(t *testing.T) {
+		expectErrors(t, "const c: i32", "1:7: Resolve Error: missing value of immutable variable c")
+	})
+}
+
+func TestAllSymbols(t *testing.T) {
+	src := `struct Point {
+  x: i32,
+}
+interface Shape {
+  area(): i32,
+}
+let origin: Point = Point{ x: 0, }
+func scale(p: Point, factor: i32): i32 {
+  let scaled: i32 = p.x * factor
+  if scaled > 10 then {
+    func clamp(n: i32): i32 { return n }
+    let limit: i32 = clamp(10)
+  }
+  return scaled
+}
+const (q: i32, r: i32) = (1, 2)
+func (self: Point) area(): i32 { return self.x }
+count := 1`
+	resolved := Resolve(parser.Parse(lexer.Tokenize(src)), defaultPrimitives(), nil)
+	if len(resolved.Errors) > 0 {
+		t.Fatalf("Resolving failed: %q", resolved.Errors)
+	}
+	expected := []string{
+		"1:1 struct Point",
+		"4:1 interface Shape",
+		"7:5 var origin",
+		"8:1 func scale",
+		"8:12 var p",
+		"8:22 var factor",
+		"9:7 var scaled",
+		"11:5 func clamp",
+		"11:16 var n",
+		"12:9 var limit",
+		"16:8 var q",
+		"16:16 var r",
+		"17:1 method Point.area",
+		"17:7 var self",
+		"18:1 var count",
+	}
+	symbols := []string{}
+	for _, symbol := range resolved.AllSymbols() {
+		symbols = append(symbols, fmt.Sprintf("%s %s %s", symbol.Pos, symbol.Kind, symbol.Name))
+	}
+	if strings.Join(symbols, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the symbols\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(symbols, "\n"))
+	}
+}
+
+// stubModules loads modules from their sources, by module name.
+type stubModules map[string]string
+
+func (modules stubModules) LoadModule(name string) (*Scope, bool) {
+	src, ok := modules[name]
+	if !ok {
+		return nil, false
+	}
+	return Resolve(parser.Parse(lexer.Tokenize(src)), defaultPrimitives(), nil).RootScope, true
+}
+
+func TestUseDecl(t *testing.T) {
+	modules := stubModules{
+		"std/io":   "func print(s: string) {}",
+		"geometry": "struct Point {\n  x: i32,\n}\nlet origin: Point = Point{ x: 0, }\nfunc norm(p: Point): i32 { return p.x }",
+	}
+	check := func(t *testing.T, src string, expected ...string) {
+		t.Helper()
+		errors := CheckWithConfig(parser.Parse(lexer.Tokenize(src)), Config{Modules: modules})
+		if len(errors) != len(expected) {
+			t.Fatalf("Expected %d errors, got %d: %q", len(expected), len(errors), errors)
+		}
+		for i, err := range errors {
+			if !strings.Contains(err.String(), expected[i]) {
+				t.Errorf("Expected error containing %q, got %q", expected[i], err)
+			}
+		}
+	}
+	t.Run("imported declarations", func(t *testing.T) {
+		check(t, "use {\n  geo: geometry,\n}\nfunc f(): i32 {\n  let p: i32 = geo.norm(geo.Point{ x: 1, })\n  return p + geo.origin.x\n}")
+	})
+	t.Run("module path", func(t *testing.T) {
+		check(t, "use {\n  io: \"std/io\",\n}\nio.print(\"hello\")")
+	})
+	t.Run("unknown module", func(t *testing.T) {
+		check(t, "use {\n  io: std,\n}", "2:3: Resolve Error: unknown module: std")
+	})
+	t.Run("unknown declaration", func(t *testing.T) {
+		check(t, "use {\n  geo: geometry,\n}\nlet n: i32 = geo.length", "4:18: Type Error: length is not declared in module geo")
+	})
+	t.Run("built-in function", func(t *testing.T) {
+		check(t, "use {\n  geo: geometry,\n}\ngeo.println(\"hello\")", "4:5: Type Error: println is not declared in module geo")
+	})
+	t.Run("redeclared alias", func(t *testing.T) {
+		check(t, "use {\n  geo: geometry,\n  geo: geometry,\n}", "3:3: Resolve Error: redeclared module alias geo in the same scope")
+	})
+	t.Run("without a module loader", func(t *testing.T) {
+		expectErrors(t, "use {\n  geo: geometry,\n}", "2:3: Resolve Error: unknown module: geometry")
+	})
+}
+
+// stmtKinds collects the types of the statements of an AST.
+type stmtKinds map[string]bool
+
+func (kinds stmtKinds) Visit(node ast.Node) bool {
+	if _, isStmt := node.(ast.Stmt); isStmt {
+		kinds[fmt.Sprintf("%T", node)] = true
+	}
+	return true
+}
+
+func (kinds stmtKinds) Leave(node ast.Node) {}
+
+func TestEveryStatementKind(t *testing.T) {
+	src := `use {
+  m: math,
+}
+struct Point {
+  x: i32,
+}
+enum Color {
+  Red,
+}
+interface Shape {
+  area(): i32,
+}
+func pair(n: i32): (i32, bool) {
+  return (n, true)
+}
+func sum(n: i32): i32 {
+  let total: i32 = 0
+  let (count: i32, ok: bool) = pair(n)
+  for (let i: i32 = 0; i < count; i += 1) {
+    if i > 10 then {
+      break
+    }
+    total += i
+  }
+  {
+    total -= 1
+  }
+  return total
+}`
+	module := parser.Parse(lexer.Tokenize(src))
+	kinds := stmtKinds{}
+	ast.Walk(module, kinds)
+	for _, kind := range []ast.Stmt{
+		&ast.BlockStmt{}, &ast.ExpressionStmt{}, &ast.VarDeclStmt{}, &ast.DestructuringVarDeclStmt{},
+		&ast.FuncDeclStmt{}, &ast.StructDeclStmt{}, &ast.EnumDeclStmt{}, &ast.InterfaceDeclStmt{}, &ast.IfStmt{},
+		&ast.ForStmt{}, &ast.BreakStmt{}, &ast.ReturnStmt{}, &ast.UseDeclStmt{},
+	} {
+		if !kinds[fmt.Sprintf("%T", kind)] {
+			t.Errorf("Expected the program to contain a %T", kind)
+		}
+	}
+	if errors := CheckWithConfig(module, Config{Modules: stubModules{"math": ""}}); len(errors) > 0 {
+		t.Errorf("Expected no errors, got %q", errors)
+	}
+}
@@ -5,6 +5,9 @@ import "fmt"
 // Type represents a type in the Cooper language
 type Type interface {
 	String() string
+	// Equals compares the types structurally, except for the named types (structs, enums and interfaces),
+	// which are compared by name. This makes the comparison terminate on types referring to themselves,
+	// like a struct with a method taking the struct as a parameter.
 	Equals(other Type) bool
 }
 
@@ -38,18 +41,59 @@ func (p PrimitiveType) Equals(other Type) bool {
 	return false
 }
 
-// ArrayType represents array types like i32[]
+// defaultPrimitives returns the primitive types built into the language, by name.
+// Each call returns a new map, so that it can be augmented with host-defined primitive types.
+func defaultPrimitives() map[string]Type {
+	primitives := map[string]Type{}
+	for _, name := range []string{"bool", "string", "i8", "i32", "i64", "f32", "f64"} {
+		primitives[name] = PrimitiveType{Name: name}
+	}
+	return primitives
+}
+
+// builtinScope returns the scope of the functions built into the language, which is the parent of the top
+// scope of a module, so that a module may declare a function by the same name, shadowing the built-in one.
+//
+//	print(s: string)   writes the string to the standard output
+//	println(s: string) writes the string and a newline
+func builtinScope() *Scope {
+	scope := NewScope(nil)
+	for _, name := range []string{"print", "println"} {
+		scope.DefineFunc(name, FuncType{
+			ReturnType: UnitType{},
+			ParamTypes: []Type{PrimitiveType{Name: "string"}},
+		})
+	}
+	return scope
+}
+
+// isBuiltinFunc reports whether the function by the name visible in the scope is a built-in one, rather than
+// declared by the module. The scope of the built-in functions is the only one without a parent.
+func isBuiltinFunc(scope *Scope, name string) bool {
+	for ; scope != nil; scope = scope.parent {
+		if _, ok := scope.funcs[name]; ok {
+			return scope.parent == nil
+		}
+	}
+	return false
+}
+
+// ArrayType represents array types like i32[], or fixed-size ones like i32[4]
 type ArrayType struct {
 	ElemType Type
+	Size     int // The number of elements of a fixed-size array, or 0 for a dynamic array
 }
 
 func (a ArrayType) String() string {
+	if a.Size > 0 {
+		return fmt.Sprintf("%s[%d]", a.ElemType, a.Size)
+	}
 	return fmt.Sprintf("%s[]", a.ElemType)
 }
 
 func (a ArrayType) Equals(other Type) bool {
 	if o, ok := other.(ArrayType); ok {
-		return a.ElemType.Equals(o.ElemType)
+		return a.Size == o.Size && a.ElemType.Equals(o.ElemType)
 	}
 	return false
 }
@@ -58,6 +102,11 @@ func (a ArrayType) Equals(other Type) bool {
 type FuncType struct {
 	ReturnType Type
 	ParamTypes []Type
+	// The default values of the trailing parameters that have one, which a call may omit. Not a part of
+	// the type in comparisons.
+	Defaults []constant
+	// The last parameter is variadic, and its type is an array of the type of the trailing arguments
+	Variadic bool
 }
 
 func (f FuncType) String() string {
@@ -66,14 +115,19 @@ func (f FuncType) String() string {
 		if i > 0 {
 			params += ","
 		}
-		params += param.String()
+		// The variadic parameter is an array of the type of its arguments, which is the one written
+		if f.Variadic && i == len(f.ParamTypes)-1 {
+			params += param.(ArrayType).ElemType.String() + "..."
+		} else {
+			params += param.String()
+		}
 	}
 	return fmt.Sprintf("func(%s):%s", params, f.ReturnType)
 }
 
 func (f FuncType) Equals(other Type) bool {
 	o, ok := other.(FuncType)
-	if !ok || len(f.ParamTypes) != len(o.ParamTypes) {
+	if !ok || len(f.ParamTypes) != len(o.ParamTypes) || f.Variadic != o.Variadic {
 		return false
 	}
 	if !f.ReturnType.Equals(o.ReturnType) {
@@ -87,16 +141,47 @@ func (f FuncType) Equals(other Type) bool {
 	return true
 }
 
+// TupleType represents tuple types like (i32, bool)
+type TupleType struct {
+	ElemTypes []Type
+}
+
+func (t TupleType) String() string {
+	elems := ""
+	for i, elem := range t.ElemTypes {
+		if i > 0 {
+			elems += ", "
+		}
+		elems += elem.String()
+	}
+	return fmt.Sprintf("(%s)", elems)
+}
+
+func (t TupleType) Equals(other Type) bool {
+	o, ok := other.(TupleType)
+	if !ok || len(t.ElemTypes) != len(o.ElemTypes) {
+		return false
+	}
+	for i, elem := range t.ElemTypes {
+		if !elem.Equals(o.ElemTypes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // StructType represents user-defined struct types
 type StructType struct {
 	Name    string
 	Members map[string]Type
+	Methods map[string]FuncType
 }
 
 func (s StructType) String() string {
 	return s.Name
 }
 
+// Equals compares the structs by name only, as their members and methods may refer to the struct itself
 func (s StructType) Equals(other Type) bool {
 	if o, ok := other.(StructType); ok {
 		return s.Name == o.Name
@@ -104,7 +189,100 @@ func (s StructType) Equals(other Type) bool {
 	return false
 }
 
+// EnumType represents user-defined enum types, with the variants in the order of declaration
+type EnumType struct {
+	Name     string
+	Variants []string
+}
+
+func (e EnumType) String() string {
+	return e.Name
+}
+
+func (e EnumType) Equals(other Type) bool {
+	if o, ok := other.(EnumType); ok {
+		return e.Name == o.Name
+	}
+	return false
+}
+
+// InterfaceType represents user-defined interface types
+type InterfaceType struct {
+	Name    string
+	Methods map[string]FuncType
+}
+
+func (i InterfaceType) String() string {
+	return i.Name
+}
+
+func (i InterfaceType) Equals(other Type) bool {
+	if o, ok := other.(InterfaceType); ok {
+		return i.Name == o.Name
+	}
+	return false
+}
+
+// Satisfies reports whether the struct declares all the methods of the interface with matching signatures
+func (s StructType) Satisfies(iface InterfaceType) bool {
+	for name, methodType := range iface.Methods {
+		if structMethod, ok := s.Methods[name]; !ok || !structMethod.Equals(methodType) {
+			return false
+		}
+	}
+	return true
+}
+
 // Type utility functions
+
+// IsAssignable reports whether a value of type `from` can be assigned to a variable of type `to`
+func IsAssignable(from, to Type) bool {
+	if iface, ok := to.(InterfaceType); ok {
+		if structType, ok := from.(StructType); ok {
+			return structType.Satisfies(iface)
+		}
+	}
+	return from.Equals(to)
+}
+
+// isComparable reports whether values of the type can be compared with == and !=: primitives and enums,
+// and structs, tuples and arrays of comparable values, compared member by member. Functions and interfaces
+// are not comparable.
+func isComparable(t Type) bool {
+	return isComparableStruct(t, map[string]bool{})
+}
+
+// isComparableStruct is isComparable, tracking the structs being checked, so that a struct referring to
+// itself is considered comparable if its other members are.
+func isComparableStruct(t Type, visiting map[string]bool) bool {
+	switch t := t.(type) {
+	case PrimitiveType, EnumType:
+		return true
+	case ArrayType:
+		return isComparableStruct(t.ElemType, visiting)
+	case TupleType:
+		for _, elemType := range t.ElemTypes {
+			if !isComparableStruct(elemType, visiting) {
+				return false
+			}
+		}
+		return true
+	case StructType:
+		if visiting[t.Name] {
+			return true
+		}
+		visiting[t.Name] = true
+		defer delete(visiting, t.Name)
+		for _, memberType := range t.Members {
+			if !isComparableStruct(memberType, visiting) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func IsUnit(t Type) bool {
 	if _, ok := t.(UnitType); ok {
 		return true
@@ -119,9 +297,28 @@ func IsPrimitive(t Type, name string) bool {
 	return false
 }
 
+func IsInteger(t Type) bool {
+	if p, ok := t.(PrimitiveType); ok {
+		return p.Name == "i8" || p.Name == "i32" || p.Name == "i64"
+	}
+	return false
+}
+
+// numericWidths are the sizes of the numeric types in bits.
+var numericWidths = map[string]int{"i8": 8, "i32": 32, "i64": 64, "f32": 32, "f64": 64}
+
+// isNarrowing reports whether converting a value between the numeric types may lose some of it:
+// from a floating point type to an integer one, or to a type of fewer bits.
+func isNarrowing(from, to Type) bool {
+	if !IsInteger(from) && IsInteger(to) {
+		return true
+	}
+	return numericWidths[to.String()] < numericWidths[from.String()]
+}
+
 func IsNumeric(t Type) bool {
 	if p, ok := t.(PrimitiveType); ok {
 		return p.Name == "i8" || p.Name == "i32" || p.Name == "i64" || p.Name == "f32" || p.Name == "f64"
 	}
 	return false
-}
\ No newline at end of file
+}
@@ -0,0 +1,47 @@
+package typechecker
+
+import (
+	"slices"
+	"strings"
+)
+
+// The names of the warnings reported by the semantic analyzer
+const (
+	WarnAssignInCondition = "assign-in-condition"
+	WarnConstantCondition = "constant-condition"
+	WarnIndexOutOfBounds  = "index-out-of-bounds"
+	WarnNarrowingCast     = "narrowing-cast"
+	WarnSelfAssign        = "self-assign"
+	WarnUnusedResult      = "unused-result"
+)
+
+// Warning describes a warning-severity check, which can be enabled or disabled by its name.
+type Warning struct {
+	Name        string
+	Description string
+}
+
+var warnings = []Warning{
+	{WarnAssignInCondition, "the condition of an if- statement or expression or a for loop is an assignment"},
+	{WarnConstantCondition, "the condition of an if- statement or expression is a boolean literal"},
+	{WarnIndexOutOfBounds, "a constant index is out of the bounds of a fixed-size array"},
+	{WarnNarrowingCast, "a cast may truncate the value, e.g. from i64 to i32"},
+	{WarnSelfAssign, "a variable is assigned to itself"},
+	{WarnUnusedResult, "the value of an operation or a literal is computed but not used"},
+}
+
+// AvailableWarnings lists all the warnings, sorted by name.
+func AvailableWarnings() []Warning {
+	return slices.SortedFunc(slices.Values(warnings), func(a, b Warning) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// DefaultWarnings returns the warnings enabled by default: all of them.
+func DefaultWarnings() map[string]bool {
+	enabled := map[string]bool{}
+	for _, warning := range warnings {
+		enabled[warning.Name] = true
+	}
+	return enabled
+}